@@ -0,0 +1,328 @@
+// Package acmedns obtains and renews certificates using the ACME DNS-01
+// challenge, which works for instances that aren't reachable on port 80
+package acmedns
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// accountKeyName is the cache key of the ACME account key
+const accountKeyName = "acme_account+key"
+
+// Provider creates and removes the TXT records of DNS-01 challenges
+type Provider interface {
+	// Present creates the TXT record name with the given value
+	Present(ctx context.Context, name, value string) error
+	// CleanUp removes the TXT record created by Present
+	CleanUp(ctx context.Context, name, value string) error
+}
+
+// ExecProvider manages records by running a command with "present" or
+// "cleanup", the record name and its value as arguments
+type ExecProvider struct {
+	// Command is the path of the executable
+	Command string
+}
+
+// Present runs the command to create the record
+func (p ExecProvider) Present(ctx context.Context, name, value string) error {
+	return p.run(ctx, "present", name, value)
+}
+
+// CleanUp runs the command to remove the record
+func (p ExecProvider) CleanUp(ctx context.Context, name, value string) error {
+	return p.run(ctx, "cleanup", name, value)
+}
+
+func (p ExecProvider) run(ctx context.Context, action, name, value string) error {
+	output, err := exec.CommandContext(ctx, p.Command, action, name, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run %s %s: %w: %s", p.Command, action, err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// Config represents the configuration of a Manager
+type Config struct {
+	// Domains the certificate is valid for, the first one names it in the cache
+	Domains []string
+	// Email used to register the account
+	Email string
+	// DirectoryURL of the certificate authority
+	DirectoryURL string
+	// Cache stores the account key and certificate across restarts
+	Cache autocert.Cache
+	// Provider creates the TXT records
+	Provider Provider
+	// PropagationDelay is how long to wait after creating the records
+	PropagationDelay time.Duration
+	// RenewBefore is how long before expiry the certificate is renewed
+	RenewBefore time.Duration
+}
+
+// Manager obtains a certificate for the configured domains and keeps it renewed
+type Manager struct {
+	config Config
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewManager creates a new Manager
+func NewManager(config Config) *Manager {
+	if config.RenewBefore == 0 {
+		config.RenewBefore = 30 * 24 * time.Hour
+	}
+	return &Manager{config: config}
+}
+
+// GetCertificate returns the current certificate, it can be used in tls.Config
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cert == nil {
+		return nil, errors.New("certificate has not been obtained yet")
+	}
+	return m.cert, nil
+}
+
+// Ensure makes sure a valid certificate is available, loading it from the
+// cache or obtaining a new one if it is missing or about to expire
+func (m *Manager) Ensure(ctx context.Context) error {
+	m.mu.RLock()
+	cert := m.cert
+	m.mu.RUnlock()
+
+	if cert == nil {
+		cached, err := m.loadCertificate(ctx)
+		if err != nil {
+			return err
+		}
+		cert = cached
+	}
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > m.config.RenewBefore {
+		m.setCertificate(cert)
+		return nil
+	}
+
+	cert, err := m.obtain(ctx)
+	if err != nil {
+		return err
+	}
+	m.setCertificate(cert)
+	return nil
+}
+
+// NotAfter returns when the current certificate expires
+func (m *Manager) NotAfter() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.cert == nil {
+		return time.Time{}
+	}
+	return m.cert.Leaf.NotAfter
+}
+
+func (m *Manager) setCertificate(cert *tls.Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cert = cert
+}
+
+// obtain orders a new certificate and stores it in the cache
+func (m *Manager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	client, err := m.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.config.Domains...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	for _, url := range order.AuthzURLs {
+		err = m.authorize(ctx, client, url)
+		if err != nil {
+			return nil, err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.config.Domains}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+
+	// Cached in the same format as autocert, the key followed by the chain
+	var buf bytes.Buffer
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate key: %w", err)
+	}
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for _, b := range der {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: b})
+	}
+	err = m.config.Cache.Put(ctx, m.config.Domains[0], buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to cache certificate: %w", err)
+	}
+
+	return parseCertificate(buf.Bytes())
+}
+
+// authorize completes the DNS-01 challenge of a single authorization
+func (m *Manager) authorize(ctx context.Context, client *acme.Client, url string) error {
+	authz, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return fmt.Errorf("failed to compute challenge record: %w", err)
+	}
+	name := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.")
+
+	err = m.config.Provider.Present(ctx, name, value)
+	if err != nil {
+		return fmt.Errorf("failed to create TXT record %s: %w", name, err)
+	}
+	defer m.config.Provider.CleanUp(context.WithoutCancel(ctx), name, value)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(m.config.PropagationDelay):
+	}
+
+	_, err = client.Accept(ctx, challenge)
+	if err != nil {
+		return fmt.Errorf("failed to accept challenge: %w", err)
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	if err != nil {
+		return fmt.Errorf("failed to validate %s: %w", authz.Identifier.Value, err)
+	}
+
+	return nil
+}
+
+// client returns an ACME client with a registered account
+func (m *Manager) client(ctx context.Context) (*acme.Client, error) {
+	key, err := m.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &acme.Client{Key: key, DirectoryURL: m.config.DirectoryURL}
+	account := &acme.Account{}
+	if m.config.Email != "" {
+		account.Contact = []string{"mailto:" + m.config.Email}
+	}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register account: %w", err)
+	}
+
+	return client, nil
+}
+
+// accountKey loads the account key from the cache or generates a new one
+func (m *Manager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	data, err := m.config.Cache.Get(ctx, accountKeyName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("invalid cached account key")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, fmt.Errorf("failed to read account key: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate account key: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode account key: %w", err)
+	}
+	err = m.config.Cache.Put(ctx, accountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to cache account key: %w", err)
+	}
+
+	return key, nil
+}
+
+// loadCertificate returns the cached certificate or nil if there is none
+func (m *Manager) loadCertificate(ctx context.Context) (*tls.Certificate, error) {
+	data, err := m.config.Cache.Get(ctx, m.config.Domains[0])
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached certificate: %w", err)
+	}
+	return parseCertificate(data)
+}
+
+// parseCertificate parses a private key followed by the certificate chain
+func parseCertificate(data []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return &cert, nil
+}
@@ -0,0 +1,288 @@
+// Package amt controls the power of computers with Intel AMT (vPro) through
+// its WS-Management SOAP interface, which works while the computer is off and
+// whether or not Wake-on-LAN is enabled
+package amt
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ports of the WS-Management interface
+const (
+	Port    = 16992
+	PortTLS = 16993
+)
+
+// Power states requested from AMT
+const (
+	PowerOn        = 2
+	PowerCycle     = 5
+	PowerOffHard   = 8
+	PowerOffSoft   = 12
+	PowerResetSoft = 14
+)
+
+// Config describes how to reach AMT
+type Config struct {
+	// Address of the computer, a hostname or IP address
+	Address string
+	// Username of an AMT account, admin if empty
+	Username string
+	// Password of the account
+	Password string
+	// TLS connects to the TLS port, which needs TLS to be set up in AMT
+	TLS bool
+	// InsecureSkipVerify accepts any certificate with TLS
+	InsecureSkipVerify bool
+	// Timeout of a single request
+	Timeout time.Duration
+}
+
+// Client talks to AMT of a computer
+type Client struct {
+	config Config
+	url    string
+	client *http.Client
+}
+
+// New checks the configuration and returns a client
+func New(config Config) (*Client, error) {
+	if config.Address == "" {
+		return nil, errors.New("address is required")
+	}
+	if config.Username == "" {
+		config.Username = "admin"
+	}
+	scheme, port := "http", Port
+	if config.TLS {
+		scheme, port = "https", PortTLS
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	return &Client{
+		config: config,
+		url:    scheme + "://" + net.JoinHostPort(config.Address, strconv.Itoa(port)) + "/wsman",
+		client: &http.Client{Timeout: config.Timeout, Transport: transport},
+	}, nil
+}
+
+// PoweredOn reports whether the computer is on, sleeping and hibernating
+// computers count as off
+func (c *Client) PoweredOn(ctx context.Context) (bool, error) {
+	const resource = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_AssociatedPowerManagementService"
+	response, err := c.send(ctx, envelope("http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate", resource, "",
+		`<Enumerate xmlns="http://schemas.xmlsoap.org/ws/2004/09/enumeration" />`))
+	if err != nil {
+		return false, err
+	}
+	enumeration, ok := element(response, "EnumerationContext")
+	if !ok {
+		return false, errors.New("enumeration context missing from response")
+	}
+
+	response, err = c.send(ctx, envelope("http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull", resource, "",
+		`<Pull xmlns="http://schemas.xmlsoap.org/ws/2004/09/enumeration"><EnumerationContext>`+xmlEscape(enumeration)+
+			`</EnumerationContext><MaxElements>1</MaxElements></Pull>`))
+	if err != nil {
+		return false, err
+	}
+	state, ok := element(response, "PowerState")
+	if !ok {
+		return false, errors.New("power state missing from response")
+	}
+	return state == strconv.Itoa(PowerOn), nil
+}
+
+// SetPower requests the power state, e.g. PowerOn or PowerOffSoft
+func (c *Client) SetPower(ctx context.Context, state int) error {
+	const resource = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_PowerManagementService"
+	body := `<r:RequestPowerStateChange_INPUT xmlns:r="` + resource + `">` +
+		`<r:PowerState>` + strconv.Itoa(state) + `</r:PowerState>` +
+		`<r:ManagedElement>` +
+		`<Address xmlns="http://schemas.xmlsoap.org/ws/2004/08/addressing">http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</Address>` +
+		`<ReferenceParameters xmlns="http://schemas.xmlsoap.org/ws/2004/08/addressing">` +
+		`<ResourceURI xmlns="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ComputerSystem</ResourceURI>` +
+		`<SelectorSet xmlns="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">` +
+		`<Selector Name="CreationClassName">CIM_ComputerSystem</Selector><Selector Name="Name">ManagedSystem</Selector>` +
+		`</SelectorSet></ReferenceParameters></r:ManagedElement></r:RequestPowerStateChange_INPUT>`
+	selector := `<w:SelectorSet><w:Selector Name="Name">Intel(r) AMT Power Management Service</w:Selector></w:SelectorSet>`
+	response, err := c.send(ctx, envelope(resource+"/RequestPowerStateChange", resource, selector, body))
+	if err != nil {
+		return err
+	}
+
+	// e.g. 2 if the computer is already in the requested state
+	value, _ := element(response, "ReturnValue")
+	if value != "0" {
+		return fmt.Errorf("power state change failed with return value %s", value)
+	}
+	return nil
+}
+
+// envelope returns the SOAP envelope of a WS-Management request
+func envelope(action, resource, selectors, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<Envelope xmlns="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">` +
+		`<Header>` +
+		`<a:Action>` + action + `</a:Action>` +
+		`<a:To>/wsman</a:To>` +
+		`<w:ResourceURI>` + resource + `</w:ResourceURI>` +
+		`<a:MessageID>uuid:` + messageID() + `</a:MessageID>` +
+		`<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>` +
+		`<w:OperationTimeout>PT60S</w:OperationTimeout>` +
+		selectors +
+		`</Header>` +
+		`<Body>` + body + `</Body>` +
+		`</Envelope>`
+}
+
+// send posts the envelope, answering the digest authentication challenge AMT
+// sends first, and returns the response
+func (c *Client) send(ctx context.Context, envelope string) ([]byte, error) {
+	resp, err := c.post(ctx, envelope, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		authorization, err := c.digest(challenge)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = c.post(ctx, envelope, authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, errors.New("invalid username or password")
+	case resp.StatusCode != http.StatusOK:
+		if reason, ok := element(body, "Text"); ok {
+			return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, reason)
+		}
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return body, nil
+}
+
+// post sends the envelope with the authorization header if not empty
+func (c *Client) post(ctx context.Context, envelope, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.client.Do(req)
+}
+
+// digest answers a digest authentication challenge with qop auth
+func (c *Client) digest(challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return "", fmt.Errorf("unsupported authentication %q", scheme)
+	}
+	values := make(map[string]string)
+	for _, param := range splitParams(params) {
+		key, value, _ := strings.Cut(param, "=")
+		values[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	const uri = "/wsman"
+	nc, cnonce := "00000001", randomHex(8)
+	ha1 := md5Hex(c.config.Username + ":" + values["realm"] + ":" + c.config.Password)
+	ha2 := md5Hex(http.MethodPost + ":" + uri)
+	response := md5Hex(ha1 + ":" + values["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+
+	authorization := fmt.Sprintf(`Digest username=%q, realm=%q, nonce=%q, uri=%q, qop=auth, nc=%s, cnonce=%q, response=%q`,
+		c.config.Username, values["realm"], values["nonce"], uri, nc, cnonce, response)
+	if opaque := values["opaque"]; opaque != "" {
+		authorization += fmt.Sprintf(`, opaque=%q`, opaque)
+	}
+	return authorization, nil
+}
+
+// splitParams splits the parameters of a challenge at commas outside quotes
+func splitParams(s string) []string {
+	var params []string
+	quoted := false
+	start := 0
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			params = append(params, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(params, s[start:])
+}
+
+// element returns the text of the first element with the local name
+func element(document []byte, name string) (string, bool) {
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", false
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != name {
+			continue
+		}
+		var text string
+		if decoder.DecodeElement(&text, &start) != nil {
+			return "", false
+		}
+		return strings.TrimSpace(text), true
+	}
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// messageID returns a random UUID identifying a request
+func messageID() string {
+	id := randomHex(16)
+	return id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
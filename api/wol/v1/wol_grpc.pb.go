@@ -0,0 +1,211 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: wol/v1/wol.proto
+
+package wolv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	WolService_ListMachines_FullMethodName = "/wol.v1.WolService/ListMachines"
+	WolService_Wake_FullMethodName         = "/wol.v1.WolService/Wake"
+	WolService_StreamStatus_FullMethodName = "/wol.v1.WolService/StreamStatus"
+)
+
+// WolServiceClient is the client API for WolService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// WolService wakes machines and reports whether they are online
+type WolServiceClient interface {
+	// ListMachines returns the machines visible to the caller
+	ListMachines(ctx context.Context, in *ListMachinesRequest, opts ...grpc.CallOption) (*ListMachinesResponse, error)
+	// Wake sends the magic packet to a machine
+	Wake(ctx context.Context, in *WakeRequest, opts ...grpc.CallOption) (*WakeResponse, error)
+	// StreamStatus sends the status of all visible machines periodically
+	StreamStatus(ctx context.Context, in *StreamStatusRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StatusUpdate], error)
+}
+
+type wolServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWolServiceClient(cc grpc.ClientConnInterface) WolServiceClient {
+	return &wolServiceClient{cc}
+}
+
+func (c *wolServiceClient) ListMachines(ctx context.Context, in *ListMachinesRequest, opts ...grpc.CallOption) (*ListMachinesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMachinesResponse)
+	err := c.cc.Invoke(ctx, WolService_ListMachines_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wolServiceClient) Wake(ctx context.Context, in *WakeRequest, opts ...grpc.CallOption) (*WakeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WakeResponse)
+	err := c.cc.Invoke(ctx, WolService_Wake_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *wolServiceClient) StreamStatus(ctx context.Context, in *StreamStatusRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StatusUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &WolService_ServiceDesc.Streams[0], WolService_StreamStatus_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamStatusRequest, StatusUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type WolService_StreamStatusClient = grpc.ServerStreamingClient[StatusUpdate]
+
+// WolServiceServer is the server API for WolService service.
+// All implementations must embed UnimplementedWolServiceServer
+// for forward compatibility.
+//
+// WolService wakes machines and reports whether they are online
+type WolServiceServer interface {
+	// ListMachines returns the machines visible to the caller
+	ListMachines(context.Context, *ListMachinesRequest) (*ListMachinesResponse, error)
+	// Wake sends the magic packet to a machine
+	Wake(context.Context, *WakeRequest) (*WakeResponse, error)
+	// StreamStatus sends the status of all visible machines periodically
+	StreamStatus(*StreamStatusRequest, grpc.ServerStreamingServer[StatusUpdate]) error
+	mustEmbedUnimplementedWolServiceServer()
+}
+
+// UnimplementedWolServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedWolServiceServer struct{}
+
+func (UnimplementedWolServiceServer) ListMachines(context.Context, *ListMachinesRequest) (*ListMachinesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMachines not implemented")
+}
+func (UnimplementedWolServiceServer) Wake(context.Context, *WakeRequest) (*WakeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Wake not implemented")
+}
+func (UnimplementedWolServiceServer) StreamStatus(*StreamStatusRequest, grpc.ServerStreamingServer[StatusUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamStatus not implemented")
+}
+func (UnimplementedWolServiceServer) mustEmbedUnimplementedWolServiceServer() {}
+func (UnimplementedWolServiceServer) testEmbeddedByValue()                    {}
+
+// UnsafeWolServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WolServiceServer will
+// result in compilation errors.
+type UnsafeWolServiceServer interface {
+	mustEmbedUnimplementedWolServiceServer()
+}
+
+func RegisterWolServiceServer(s grpc.ServiceRegistrar, srv WolServiceServer) {
+	// If the following call pancis, it indicates UnimplementedWolServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&WolService_ServiceDesc, srv)
+}
+
+func _WolService_ListMachines_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMachinesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WolServiceServer).ListMachines(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WolService_ListMachines_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WolServiceServer).ListMachines(ctx, req.(*ListMachinesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WolService_Wake_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WakeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WolServiceServer).Wake(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: WolService_Wake_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WolServiceServer).Wake(ctx, req.(*WakeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WolService_StreamStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamStatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WolServiceServer).StreamStatus(m, &grpc.GenericServerStream[StreamStatusRequest, StatusUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type WolService_StreamStatusServer = grpc.ServerStreamingServer[StatusUpdate]
+
+// WolService_ServiceDesc is the grpc.ServiceDesc for WolService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var WolService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "wol.v1.WolService",
+	HandlerType: (*WolServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListMachines",
+			Handler:    _WolService_ListMachines_Handler,
+		},
+		{
+			MethodName: "Wake",
+			Handler:    _WolService_Wake_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamStatus",
+			Handler:       _WolService_StreamStatus_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "wol/v1/wol.proto",
+}
@@ -0,0 +1,151 @@
+// Package arpproxy answers ARP requests for sleeping machines with the MAC
+// address of the server, and wakes the machines as soon as another host
+// tries to reach them. Hosts keep retrying their connection until the woken
+// machine announces itself.
+package arpproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// wakeInterval is how long to wait before waking a machine again that is
+// still being looked for
+const wakeInterval = time.Minute
+
+// EtherType of ARP and the operations of ARP packets
+const (
+	etherTypeARP = 0x0806
+	opRequest    = 1
+	opReply      = 2
+)
+
+// frameLength is the length of an Ethernet frame holding an IPv4 ARP packet
+const frameLength = 14 + 28
+
+// Proxy answers ARP requests on an interface
+type Proxy struct {
+	// Interface the requests are answered on, e.g. eth0
+	Interface string
+	// Sleeping returns the MAC address of the sleeping machine with the IP
+	// address, false if requests for it aren't answered. It may block, e.g.
+	// to probe the machine, as requests are handled concurrently.
+	Sleeping func(ip netip.Addr) (net.HardwareAddr, bool)
+	// Wake is called when a host looks for the sleeping machine
+	Wake func(ip, from netip.Addr)
+
+	mu      sync.Mutex
+	conn    io.ReadWriteCloser
+	iface   *net.Interface
+	wokenAt map[netip.Addr]time.Time
+}
+
+// Serve answers requests until the context is done. It needs to be able to
+// open raw sockets, e.g. with CAP_NET_RAW, and is only supported on Linux.
+func (p *Proxy) Serve(ctx context.Context) error {
+	iface, err := net.InterfaceByName(p.Interface)
+	if err != nil {
+		return err
+	}
+	conn, err := listen(iface)
+	if err != nil {
+		return fmt.Errorf("listening for arp requests on %s: %w", iface.Name, err)
+	}
+	defer conn.Close()
+	p.mu.Lock()
+	p.conn, p.iface = conn, iface
+	p.wokenAt = make(map[netip.Addr]time.Time)
+	p.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go p.handle(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// Announce sends a gratuitous ARP reply on behalf of a machine that woke
+// up, so hosts that were answered by the proxy send to the machine again
+func (p *Proxy) Announce(ip netip.Addr, mac net.HardwareAddr) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	_, err := p.conn.Write(frame(opReply, mac, broadcast, mac, ip, broadcast, ip))
+	return err
+}
+
+// handle answers a request for a sleeping machine and wakes it
+func (p *Proxy) handle(packet []byte) {
+	if len(packet) < frameLength || binary.BigEndian.Uint16(packet[12:14]) != etherTypeARP {
+		return
+	}
+	arp := packet[14:]
+	// Ethernet and IPv4 addresses only
+	if binary.BigEndian.Uint16(arp[0:2]) != 1 || binary.BigEndian.Uint16(arp[2:4]) != 0x0800 || arp[4] != 6 || arp[5] != 4 {
+		return
+	}
+	if binary.BigEndian.Uint16(arp[6:8]) != opRequest {
+		return
+	}
+	senderMAC := net.HardwareAddr(arp[8:14])
+	senderIP := netip.AddrFrom4([4]byte(arp[14:18]))
+	targetIP := netip.AddrFrom4([4]byte(arp[24:28]))
+	// Probes checking whether an address is taken, announcements and the
+	// server's own requests aren't answered
+	if senderIP.IsUnspecified() || senderIP == targetIP || bytes.Equal(senderMAC, p.iface.HardwareAddr) {
+		return
+	}
+	if _, ok := p.Sleeping(targetIP); !ok {
+		return
+	}
+
+	p.mu.Lock()
+	if p.conn != nil {
+		p.conn.Write(frame(opReply, p.iface.HardwareAddr, senderMAC, p.iface.HardwareAddr, targetIP, senderMAC, senderIP))
+	}
+	wake := time.Since(p.wokenAt[targetIP]) > wakeInterval
+	if wake {
+		p.wokenAt[targetIP] = time.Now()
+	}
+	p.mu.Unlock()
+	if wake {
+		go p.Wake(targetIP, senderIP)
+	}
+}
+
+// frame returns an Ethernet frame with an ARP packet
+func frame(op uint16, src, dst, senderMAC net.HardwareAddr, senderIP netip.Addr, targetMAC net.HardwareAddr, targetIP netip.Addr) []byte {
+	f := make([]byte, 0, frameLength)
+	f = append(f, dst...)
+	f = append(f, src...)
+	f = binary.BigEndian.AppendUint16(f, etherTypeARP)
+	f = binary.BigEndian.AppendUint16(f, 1)
+	f = binary.BigEndian.AppendUint16(f, 0x0800)
+	f = append(f, 6, 4)
+	f = binary.BigEndian.AppendUint16(f, op)
+	f = append(f, senderMAC...)
+	f = append(f, senderIP.AsSlice()...)
+	f = append(f, targetMAC...)
+	return append(f, targetIP.AsSlice()...)
+}
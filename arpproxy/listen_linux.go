@@ -0,0 +1,31 @@
+package arpproxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// listen opens a packet socket receiving the ARP packets of the interface
+func listen(iface *net.Interface) (io.ReadWriteCloser, error) {
+	protocol := htons(etherTypeARP)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(protocol))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: protocol, Ifindex: iface.Index})
+	if err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// Non-blocking files are closed while being read from
+	return os.NewFile(uintptr(fd), "arp:"+iface.Name), nil
+}
+
+// htons converts a short to network byte order
+func htons(v uint16) uint16 {
+	return binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, v))
+}
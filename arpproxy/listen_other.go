@@ -0,0 +1,14 @@
+//go:build !linux
+
+package arpproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// listen is only implemented on Linux, which has packet sockets
+func listen(iface *net.Interface) (io.ReadWriteCloser, error) {
+	return nil, errors.ErrUnsupported
+}
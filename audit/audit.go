@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Results of an action
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Entry represents a single recorded action
+type Entry struct {
+	// Time the action happened
+	Time time.Time `json:"time"`
+	// Action performed, e.g. wake or login
+	Action string `json:"action"`
+	// User who performed the action
+	User string `json:"user,omitempty"`
+	// IP address the action came from
+	IP string `json:"ip,omitempty"`
+	// Target of the action, e.g. a machine name
+	Target string `json:"target,omitempty"`
+	// Result of the action, either success or failure
+	Result string `json:"result"`
+	// Message with details, e.g. the error of a failed action
+	Message string `json:"message,omitempty"`
+}
+
+// Filter selects entries when reading the log, empty fields match everything
+type Filter struct {
+	// Action to match
+	Action string
+	// User to match
+	User string
+	// Target to match
+	Target string
+	// Since excludes entries older than this
+	Since time.Time
+	// Limit is the maximum number of most recent entries returned, zero for all
+	Limit int
+}
+
+// matches reports whether the entry passes the filter
+func (f Filter) matches(e Entry) bool {
+	if f.Action != "" && !strings.EqualFold(f.Action, e.Action) {
+		return false
+	}
+	if f.User != "" && !strings.EqualFold(f.User, e.User) {
+		return false
+	}
+	if f.Target != "" && !strings.EqualFold(f.Target, e.Target) {
+		return false
+	}
+	return f.Since.IsZero() || !e.Time.Before(f.Since)
+}
+
+// Log is an append-only audit log stored as JSON lines
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+// New creates a new Log writing to the file at path
+func New(path string) *Log {
+	return &Log{path: path}
+}
+
+// Record appends the entry to the log, the time is set if missing
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(l.path), 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return nil
+}
+
+// Read returns the entries matching the filter, newest first
+func (l *Log) Read(filter Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		// Skip lines that can't be parsed, e.g. a partial write after a crash
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		if filter.matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	// Newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// dummyHash is compared against when a username is unknown so that the time
+	// taken to reject a request doesn't reveal which usernames exist
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// User represents a user allowed to access the server
+type User struct {
+	// Username used to log in
+	Username string
+	// Bcrypt hash of the user's password
+	PasswordHash string
+}
+
+// Users verifies credentials against a fixed set of users
+type Users struct {
+	users map[string]User
+}
+
+// NewUsers creates a new Users instance from the given users
+func NewUsers(users []User) *Users {
+	u := &Users{users: make(map[string]User, len(users))}
+	for _, user := range users {
+		u.users[user.Username] = user
+	}
+	return u
+}
+
+// Len returns the number of configured users
+func (u *Users) Len() int {
+	return len(u.users)
+}
+
+// Authenticate reports whether the username and password match a configured user
+func (u *Users) Authenticate(username, password string) bool {
+	user, ok := u.users[username]
+	if !ok {
+		// Burn the same amount of time as a real comparison would
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+	return err == nil
+}
+
+// HashPassword returns the bcrypt hash of the password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
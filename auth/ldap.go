@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig represents the settings of an LDAP or Active Directory server
+type LDAPConfig struct {
+	// URL of the server, e.g. ldaps://dc.example.com
+	URL string
+	// StartTLS upgrades plain ldap:// connections to TLS
+	StartTLS bool
+	// InsecureSkipVerify disables certificate verification
+	InsecureSkipVerify bool
+	// Timeout for connecting and each operation
+	Timeout time.Duration
+	// BindDNTemplates are tried in order to bind as the user, {username} is
+	// replaced, e.g. uid={username},ou=people,dc=example,dc=com or {username}@example.com
+	BindDNTemplates []string
+	// UserBaseDN is searched for the user entry to resolve its DN and memberOf
+	UserBaseDN string
+	// UserFilter finds the user entry, {username} is replaced
+	UserFilter string
+	// GroupBaseDN is searched for groups the user is a member of
+	GroupBaseDN string
+	// GroupFilter finds the user's groups, {dn} and {username} are replaced
+	GroupFilter string
+	// GroupNameAttribute holds the name of a group
+	GroupNameAttribute string
+	// AllowedGroups restricts login to members of these groups if not empty
+	AllowedGroups []string
+}
+
+// LDAP authenticates users by binding to a directory server as them
+type LDAP struct {
+	config LDAPConfig
+}
+
+// NewLDAP creates a new LDAP instance for the server
+func NewLDAP(config LDAPConfig) *LDAP {
+	return &LDAP{config: config}
+}
+
+// Authenticate binds as the user and returns their identity including groups
+func (l *LDAP) Authenticate(username, password string) (Identity, error) {
+	// An empty password would result in an unauthenticated bind which succeeds
+	if username == "" || password == "" {
+		return Identity{}, errors.New("empty username or password")
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return Identity{}, err
+	}
+	defer conn.Close()
+
+	var dn string
+	for _, template := range l.config.BindDNTemplates {
+		candidate := strings.ReplaceAll(template, "{username}", ldap.EscapeDN(username))
+		err = conn.Bind(candidate, password)
+		if err == nil {
+			dn = candidate
+			break
+		}
+		if !ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Identity{}, fmt.Errorf("failed to bind: %w", err)
+		}
+	}
+	if dn == "" {
+		return Identity{}, fmt.Errorf("invalid credentials for %q", username)
+	}
+
+	groups, err := l.groups(conn, username, dn)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if len(l.config.AllowedGroups) > 0 && !containsAny(groups, l.config.AllowedGroups) {
+		return Identity{}, fmt.Errorf("user %q is not a member of an allowed group", username)
+	}
+
+	return Identity{Username: username, Groups: groups, External: true}, nil
+}
+
+// dial connects to the server and upgrades the connection to TLS if configured
+func (l *LDAP) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: l.config.InsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: l.config.Timeout}
+	conn, err := ldap.DialURL(l.config.URL, ldap.DialWithTLSConfig(tlsConfig), ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ldap server: %w", err)
+	}
+	conn.SetTimeout(l.config.Timeout)
+
+	if l.config.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// groups returns the names of the groups the bound user is a member of
+func (l *LDAP) groups(conn *ldap.Conn, username, dn string) ([]string, error) {
+	var groups []string
+
+	// Resolve the real DN and memberOf, e.g. when binding with a UPN on Active Directory
+	if l.config.UserBaseDN != "" {
+		filter := strings.ReplaceAll(l.config.UserFilter, "{username}", ldap.EscapeFilter(username))
+		result, err := conn.Search(ldap.NewSearchRequest(
+			l.config.UserBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
+			filter, []string{"memberOf"}, nil,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search user: %w", err)
+		}
+		if len(result.Entries) > 0 {
+			dn = result.Entries[0].DN
+			for _, group := range result.Entries[0].GetAttributeValues("memberOf") {
+				groups = append(groups, groupName(group))
+			}
+		}
+	}
+
+	if l.config.GroupBaseDN != "" {
+		filter := strings.ReplaceAll(l.config.GroupFilter, "{dn}", ldap.EscapeFilter(dn))
+		filter = strings.ReplaceAll(filter, "{username}", ldap.EscapeFilter(username))
+		result, err := conn.Search(ldap.NewSearchRequest(
+			l.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			filter, []string{l.config.GroupNameAttribute}, nil,
+		))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search groups: %w", err)
+		}
+		for _, entry := range result.Entries {
+			name := entry.GetAttributeValue(l.config.GroupNameAttribute)
+			if name != "" {
+				groups = append(groups, name)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+// groupName returns the value of the first RDN of a group DN, e.g. the CN
+func groupName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// limiterForget is how long a client without failures is remembered
+const limiterForget = 24 * time.Hour
+
+// LimiterConfig represents the settings of a Limiter
+type LimiterConfig struct {
+	// BaseDelay is the wait after the first failure, doubled on each further failure
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts
+	MaxDelay time.Duration
+	// LockoutAfter is the number of consecutive failures after which the
+	// client is locked out, zero disables lockouts
+	LockoutAfter int
+	// LockoutDuration is how long a locked out client has to wait
+	LockoutDuration time.Duration
+}
+
+// limiterEntry tracks the failures of a single client
+type limiterEntry struct {
+	failures int
+	blocked  time.Time
+	lastSeen time.Time
+}
+
+// Limiter slows down repeated authentication failures per client with
+// exponential backoff and optionally locks clients out temporarily
+type Limiter struct {
+	mu      sync.Mutex
+	config  LimiterConfig
+	entries map[string]*limiterEntry
+}
+
+// NewLimiter creates a new Limiter
+func NewLimiter(config LimiterConfig) *Limiter {
+	return &Limiter{
+		config:  config,
+		entries: make(map[string]*limiterEntry),
+	}
+}
+
+// Allow reports whether the client may attempt to authenticate now and if
+// not, how long it has to wait
+func (l *Limiter) Allow(client string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[client]
+	if !ok {
+		return 0, true
+	}
+
+	wait := time.Until(entry.blocked)
+	if wait > 0 {
+		return wait, false
+	}
+	return 0, true
+}
+
+// Fail records a failed attempt and returns how long the client has to wait
+// before the next one and whether it is now locked out
+func (l *Limiter) Fail(client string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	entry, ok := l.entries[client]
+	if !ok {
+		entry = &limiterEntry{}
+		l.entries[client] = entry
+	}
+	entry.failures++
+	entry.lastSeen = now
+
+	if l.config.LockoutAfter > 0 && entry.failures >= l.config.LockoutAfter {
+		entry.blocked = now.Add(l.config.LockoutDuration)
+		return l.config.LockoutDuration, true
+	}
+
+	delay := l.config.BaseDelay
+	for i := 1; i < entry.failures && delay < l.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, l.config.MaxDelay)
+	entry.blocked = now.Add(delay)
+
+	return delay, false
+}
+
+// Succeed forgets the failures of the client
+func (l *Limiter) Succeed(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, client)
+}
+
+// prune removes clients that haven't failed for a long time, callers must hold the lock
+func (l *Limiter) prune(now time.Time) {
+	for client, entry := range l.entries {
+		if now.Sub(entry.lastSeen) > limiterForget && now.After(entry.blocked) {
+			delete(l.entries, client)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcStateTTL is how long a user has to complete the login at the provider
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCConfig represents the settings of an OpenID Connect provider
+type OIDCConfig struct {
+	// Issuer URL used for discovery
+	Issuer string
+	// ClientID registered at the provider
+	ClientID string
+	// ClientSecret registered at the provider
+	ClientSecret string
+	// RedirectURL of the callback endpoint registered at the provider
+	RedirectURL string
+	// Scopes requested from the provider
+	Scopes []string
+	// UsernameClaim is the ID token claim used as username
+	UsernameClaim string
+	// GroupsClaim is the ID token claim holding the user's groups
+	GroupsClaim string
+	// AllowedGroups restricts login to members of these groups if not empty
+	AllowedGroups []string
+}
+
+// Identity represents a user authenticated by an external provider
+type Identity struct {
+	// Username of the user
+	Username string
+	// Groups the user is a member of
+	Groups []string
+	// External is set for users of a directory or identity provider rather
+	// than local users, which must not get the grants of a local user
+	External bool
+}
+
+// oidcState is kept between redirecting to the provider and the callback
+type oidcState struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+// OIDC logs users in using the OpenID Connect authorization code flow
+type OIDC struct {
+	config OIDCConfig
+
+	// Discovery happens on first use so an unreachable provider doesn't stop the server
+	mu       sync.Mutex
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+
+	statesMu sync.Mutex
+	states   map[string]oidcState
+}
+
+// NewOIDC creates a new OIDC instance for the provider
+func NewOIDC(config OIDCConfig) *OIDC {
+	return &OIDC{
+		config: config,
+		states: make(map[string]oidcState),
+	}
+}
+
+// discover fetches the provider metadata if it hasn't been fetched yet
+func (o *OIDC) discover(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.oauth2 != nil {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, o.config.Issuer)
+	if err != nil {
+		return fmt.Errorf("failed to discover oidc provider: %w", err)
+	}
+
+	scopes := o.config.Scopes
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	o.oauth2 = &oauth2.Config{
+		ClientID:     o.config.ClientID,
+		ClientSecret: o.config.ClientSecret,
+		RedirectURL:  o.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.config.ClientID})
+
+	return nil
+}
+
+// AuthCodeURL returns the provider URL to send the user to and the state
+// value the callback has to present, next is where to go after logging in
+func (o *OIDC) AuthCodeURL(ctx context.Context, next string) (string, string, error) {
+	err := o.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	o.statesMu.Lock()
+	now := time.Now()
+	for key, s := range o.states {
+		if now.After(s.expires) {
+			delete(o.states, key)
+		}
+	}
+	o.states[state] = oidcState{
+		nonce:    nonce,
+		verifier: verifier,
+		next:     next,
+		expires:  now.Add(oidcStateTTL),
+	}
+	o.statesMu.Unlock()
+
+	url := o.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	return url, state, nil
+}
+
+// Exchange completes the login by exchanging the code for an ID token and
+// returns the identity and where to send the user next
+func (o *OIDC) Exchange(ctx context.Context, state, code string) (Identity, string, error) {
+	err := o.discover(ctx)
+	if err != nil {
+		return Identity{}, "", err
+	}
+
+	o.statesMu.Lock()
+	s, ok := o.states[state]
+	delete(o.states, state)
+	o.statesMu.Unlock()
+	if !ok || time.Now().After(s.expires) {
+		return Identity{}, "", errors.New("invalid or expired login state")
+	}
+
+	token, err := o.oauth2.Exchange(ctx, code, oauth2.VerifierOption(s.verifier))
+	if err != nil {
+		return Identity{}, "", fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, "", errors.New("no id_token in token response")
+	}
+	idToken, err := o.verifier.Verify(ctx, raw)
+	if err != nil {
+		return Identity{}, "", fmt.Errorf("failed to verify id token: %w", err)
+	}
+	if idToken.Nonce != s.nonce {
+		return Identity{}, "", errors.New("id token nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	err = idToken.Claims(&claims)
+	if err != nil {
+		return Identity{}, "", fmt.Errorf("failed to parse claims: %w", err)
+	}
+
+	identity := Identity{
+		Username: stringClaim(claims, o.config.UsernameClaim),
+		Groups:   stringsClaim(claims, o.config.GroupsClaim),
+		External: true,
+	}
+	if identity.Username == "" {
+		return Identity{}, "", fmt.Errorf("id token has no %q claim", o.config.UsernameClaim)
+	}
+
+	if len(o.config.AllowedGroups) > 0 && !containsAny(identity.Groups, o.config.AllowedGroups) {
+		return Identity{}, "", fmt.Errorf("user %q is not a member of an allowed group", identity.Username)
+	}
+
+	return identity, s.next, nil
+}
+
+// stringClaim returns the claim as a string or an empty string
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// stringsClaim returns the claim as a list of strings, single strings are
+// accepted as some providers don't use arrays for a single value
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// containsAny reports whether any of the values is in list
+func containsAny(list, values []string) bool {
+	for _, value := range values {
+		if slices.Contains(list, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Role determines what a user is allowed to do
+type Role int
+
+const (
+	// RoleNone grants no access at all
+	RoleNone Role = iota
+	// RoleViewer can see machines and their status
+	RoleViewer
+	// RoleOperator can additionally wake machines
+	RoleOperator
+	// RoleAdmin can do everything on every machine
+	RoleAdmin
+)
+
+// ParseRole parses a role name, an empty name is RoleNone
+func ParseRole(name string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "":
+		return RoleNone, nil
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown role %q, must be one of admin, operator or viewer", name)
+	}
+}
+
+// String returns the name of the role
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// Grant gives a role on a set of machines, an empty set means all machines
+type Grant struct {
+	// Role granted
+	Role Role
+	// Machines the grant applies to by name
+	Machines []string
+	// MachineGroups the grant applies to by group
+	MachineGroups []string
+}
+
+// appliesTo reports whether the grant covers the machine
+func (g Grant) appliesTo(name, group string) bool {
+	if len(g.Machines) == 0 && len(g.MachineGroups) == 0 {
+		return true
+	}
+	for _, machine := range g.Machines {
+		if strings.EqualFold(machine, name) {
+			return true
+		}
+	}
+	return group != "" && slices.ContainsFunc(g.MachineGroups, func(g string) bool {
+		return strings.EqualFold(g, group)
+	})
+}
+
+// Permissions combines all grants of a user
+type Permissions struct {
+	Grants []Grant
+}
+
+// RoleFor returns the highest role the user has on the machine
+func (p Permissions) RoleFor(name, group string) Role {
+	role := RoleNone
+	for _, grant := range p.Grants {
+		if grant.Role > role && grant.appliesTo(name, group) {
+			role = grant.Role
+		}
+	}
+	return role
+}
+
+// CanView reports whether the user may see the machine and its status
+func (p Permissions) CanView(name, group string) bool {
+	return p.RoleFor(name, group) >= RoleViewer
+}
+
+// CanWake reports whether the user may wake the machine
+func (p Permissions) CanWake(name, group string) bool {
+	return p.RoleFor(name, group) >= RoleOperator
+}
+
+// HasRole reports whether the user has at least the role on any machine
+func (p Permissions) HasRole(role Role) bool {
+	for _, grant := range p.Grants {
+		if grant.Role >= role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the user is an admin on all machines
+func (p Permissions) IsAdmin() bool {
+	for _, grant := range p.Grants {
+		if grant.Role == RoleAdmin && len(grant.Machines) == 0 && len(grant.MachineGroups) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Limit returns a copy of the permissions with no role higher than max
+func (p Permissions) Limit(max Role) Permissions {
+	limited := Permissions{Grants: make([]Grant, len(p.Grants))}
+	for i, grant := range p.Grants {
+		grant.Role = min(grant.Role, max)
+		limited.Grants[i] = grant
+	}
+	return limited
+}
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	sessionsBucket = []byte("sessions")
+	metaBucket     = []byte("meta")
+	signingKeyKey  = []byte("signing_key")
+)
+
+// sessionSaveInterval limits how often the last use of a session is written
+// to the database, it is only needed to expire idle sessions
+const sessionSaveInterval = time.Minute
+
+// Session represents a logged in user
+type Session struct {
+	// ID is the signed secret token stored in the session cookie, only its
+	// hash is persisted
+	ID string `json:"-"`
+	// Username of the logged in user
+	Username string `json:"username"`
+	// Groups of the user as reported by an external provider
+	Groups []string `json:"groups,omitempty"`
+	// External is set for users of a directory or identity provider
+	External bool `json:"external,omitempty"`
+	// CreatedAt is when the user logged in
+	CreatedAt time.Time `json:"created_at"`
+	// LastSeen is when the session was last used
+	LastSeen time.Time `json:"last_seen"`
+
+	// savedAt is the LastSeen of the persisted copy
+	savedAt time.Time
+}
+
+// Sessions keeps track of logged in users in memory, optionally backed by a
+// bbolt database so they stay logged in across restarts
+type Sessions struct {
+	mu sync.Mutex
+	// sessions by the hash of their ID
+	sessions    map[string]*Session
+	idleTimeout time.Duration
+	key         []byte
+	db          *bolt.DB
+}
+
+// NewSessions creates a new Sessions instance expiring sessions unused for
+// idleTimeout, sessions are lost when the process exits
+func NewSessions(idleTimeout time.Duration) (*Sessions, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return &Sessions{
+		sessions:    make(map[string]*Session),
+		idleTimeout: idleTimeout,
+		key:         key,
+	}, nil
+}
+
+// OpenSessions opens the sessions persisted in the database at path,
+// creating it if needed, expiring sessions unused for idleTimeout
+func OpenSessions(path string, idleTimeout time.Duration) (*Sessions, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Only one process can have the database open, don't wait forever for it
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session database: %w", err)
+	}
+
+	s := &Sessions{
+		sessions:    make(map[string]*Session),
+		idleTimeout: idleTimeout,
+		db:          db,
+	}
+	now := time.Now()
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		s.key = meta.Get(signingKeyKey)
+		if s.key == nil {
+			s.key = make([]byte, 32)
+			if _, err := rand.Read(s.key); err != nil {
+				return err
+			}
+			if err := meta.Put(signingKeyKey, s.key); err != nil {
+				return err
+			}
+		} else {
+			// Values are only valid during the transaction
+			s.key = append([]byte(nil), s.key...)
+		}
+
+		b, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		if err != nil {
+			return err
+		}
+		var expired [][]byte
+		err = b.ForEach(func(k, v []byte) error {
+			var session Session
+			if json.Unmarshal(v, &session) != nil || s.expired(&session, now) {
+				expired = append(expired, append([]byte(nil), k...))
+				return nil
+			}
+			session.savedAt = session.LastSeen
+			s.sessions[string(k)] = &session
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+	return s, nil
+}
+
+// Close closes the database of persisted sessions
+func (s *Sessions) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// Create starts a new session for the user
+func (s *Sessions) Create(identity Identity) (*Session, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:        s.sign(token),
+		Username:  identity.Username,
+		Groups:    identity.Groups,
+		External:  identity.External,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.prune(now)
+	if err != nil {
+		return nil, err
+	}
+	hash := hashSessionID(session.ID)
+	err = s.save(hash, session)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions[hash] = session
+
+	return session, nil
+}
+
+// Get returns the session with the given id and marks it as used, it returns
+// false if the session doesn't exist, has expired or the id wasn't signed by
+// this instance
+func (s *Sessions) Get(id string) (Session, bool) {
+	if !s.verify(id) {
+		return Session{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashSessionID(id)
+	session, ok := s.sessions[hash]
+	if !ok {
+		return Session{}, false
+	}
+
+	now := time.Now()
+	if s.expired(session, now) {
+		delete(s.sessions, hash)
+		s.remove(hash)
+		return Session{}, false
+	}
+
+	session.LastSeen = now
+	// Failing to save only makes the session expire a bit early after a restart
+	if now.Sub(session.savedAt) >= sessionSaveInterval {
+		s.save(hash, session)
+	}
+
+	result := *session
+	result.ID = id
+	return result, true
+}
+
+// Delete ends the session with the given id
+func (s *Sessions) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashSessionID(id)
+	delete(s.sessions, hash)
+	return s.remove(hash)
+}
+
+// expired reports whether the session has been idle for too long
+func (s *Sessions) expired(session *Session, now time.Time) bool {
+	return s.idleTimeout > 0 && now.Sub(session.LastSeen) > s.idleTimeout
+}
+
+// prune removes all expired sessions, callers must hold the lock
+func (s *Sessions) prune(now time.Time) error {
+	for hash, session := range s.sessions {
+		if s.expired(session, now) {
+			delete(s.sessions, hash)
+			if err := s.remove(hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// save persists the session if there is a database, callers must hold the lock
+func (s *Sessions) save(hash string, session *Session) error {
+	if s.db == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(hash), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	session.savedAt = session.LastSeen
+	return nil
+}
+
+// remove deletes the persisted session if there is a database, callers must hold the lock
+func (s *Sessions) remove(hash string) error {
+	if s.db == nil {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(hash))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// sign appends the signature of the token to it
+func (s *Sessions) sign(token string) string {
+	return token + "." + s.signature(token)
+}
+
+// verify reports whether the id carries a valid signature, so forged
+// cookies are turned away before looking them up
+func (s *Sessions) verify(id string) bool {
+	token, signature, ok := strings.Cut(id, ".")
+	return ok && hmac.Equal([]byte(signature), []byte(s.signature(token)))
+}
+
+// signature returns the HMAC-SHA256 of the token
+func (s *Sessions) signature(token string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hashSessionID returns the key a session is stored under, a leaked
+// database doesn't reveal the IDs of the sessions
+func hashSessionID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomToken returns a random url-safe token with 256 bits of entropy
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
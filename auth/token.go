@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/internal/jsonfile"
+)
+
+// tokenPrefix makes tokens easy to recognize, e.g. by secret scanners
+const tokenPrefix = "wol_"
+
+// Token represents a long-lived API token, only its hash is stored
+type Token struct {
+	// ID identifies the token when listing or revoking it
+	ID string `json:"id"`
+	// Name describes what the token is used for
+	Name string `json:"name"`
+	// Username of the user the token acts as
+	Username string `json:"username"`
+	// Groups of the user from an external provider at the time the token was created
+	Groups []string `json:"groups,omitempty"`
+	// External is set for users of a directory or identity provider
+	External bool `json:"external,omitempty"`
+	// Hash is the hex encoded SHA-256 hash of the token
+	Hash string `json:"hash"`
+	// CreatedAt is when the token was created
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Identity returns the user the token acts as
+func (t Token) Identity() Identity {
+	return Identity{Username: t.Username, Groups: t.Groups, External: t.External}
+}
+
+// Tokens manages API tokens persisted in a JSON file
+type Tokens struct {
+	mu      sync.Mutex
+	path    string
+	tokens  []Token
+	modTime time.Time
+}
+
+// NewTokens creates a new Tokens instance backed by the file at path
+func NewTokens(path string) *Tokens {
+	return &Tokens{path: path}
+}
+
+// Create generates a new token for the user and returns its plaintext value,
+// which is not stored and can't be retrieved later
+func (t *Tokens) Create(name string, identity Identity) (string, Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.reload()
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", Token{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+	id := make([]byte, 4)
+	_, err = rand.Read(id)
+	if err != nil {
+		return "", Token{}, fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	plaintext := tokenPrefix + hex.EncodeToString(secret)
+	token := Token{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Username:  identity.Username,
+		Groups:    identity.Groups,
+		External:  identity.External,
+		Hash:      hashToken(plaintext),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	t.tokens = append(t.tokens, token)
+	err = t.save()
+	if err != nil {
+		t.tokens = t.tokens[:len(t.tokens)-1]
+		return "", Token{}, err
+	}
+
+	return plaintext, token, nil
+}
+
+// List returns all tokens
+func (t *Tokens) List() ([]Token, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]Token(nil), t.tokens...), nil
+}
+
+// Revoke deletes the token with the given id
+func (t *Tokens) Revoke(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.reload()
+	if err != nil {
+		return err
+	}
+
+	for i, token := range t.tokens {
+		if token.ID == id {
+			t.tokens = append(t.tokens[:i:i], t.tokens[i+1:]...)
+			return t.save()
+		}
+	}
+
+	return fmt.Errorf("token %q not found", id)
+}
+
+// Authenticate returns the token matching the plaintext value
+func (t *Tokens) Authenticate(plaintext string) (Token, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Pick up tokens created or revoked by other processes, e.g. the CLI
+	err := t.reload()
+	if err != nil {
+		return Token{}, false
+	}
+
+	hash := []byte(hashToken(plaintext))
+	for _, token := range t.tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(token.Hash)) == 1 {
+			return token, true
+		}
+	}
+
+	return Token{}, false
+}
+
+// reload reads the tokens file if it changed since it was last read, callers must hold the lock
+func (t *Tokens) reload() error {
+	modTime, err := jsonfile.Read(t.path, t.modTime, &t.tokens)
+	if err != nil {
+		return err
+	}
+	t.modTime = modTime
+	return nil
+}
+
+// save writes the tokens file, callers must hold the lock
+func (t *Tokens) save() error {
+	modTime, err := jsonfile.Write(t.path, t.tokens)
+	if err != nil {
+		return err
+	}
+	t.modTime = modTime
+	return nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a plaintext token
+func hashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,390 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/internal/jsonfile"
+)
+
+const (
+	// totpPeriod is the time step of the codes as recommended by RFC 6238
+	totpPeriod = 30
+	// totpDigits is the length of the codes understood by common authenticator apps
+	totpDigits = 6
+	// totpSkew is the number of steps before and after the current one that are accepted
+	totpSkew = 1
+	// recoveryCodeCount is the number of recovery codes generated on enrollment
+	recoveryCodeCount = 10
+	// challengeTTL is how long a user has to enter the second factor after the password
+	challengeTTL = 5 * time.Minute
+	// challengeAttempts is how many wrong codes are accepted per challenge
+	challengeAttempts = 5
+)
+
+// TOTPEnrollment represents the second factor of a user
+type TOTPEnrollment struct {
+	// Secret shared with the authenticator app, base32 encoded
+	Secret string `json:"secret"`
+	// Enabled is set once the user confirmed the setup with a valid code
+	Enabled bool `json:"enabled"`
+	// RecoveryCodes are hashes of the unused one-time recovery codes
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	// CreatedAt is when the enrollment was started
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TOTPStore manages TOTP enrollments persisted in a JSON file
+type TOTPStore struct {
+	mu          sync.Mutex
+	path        string
+	issuer      string
+	enrollments map[string]TOTPEnrollment
+	modTime     time.Time
+	// lastStep remembers the last accepted step per user to prevent code reuse
+	lastStep map[string]int64
+}
+
+// NewTOTPStore creates a new TOTPStore backed by the file at path, issuer is
+// shown as the account name prefix in authenticator apps
+func NewTOTPStore(path, issuer string) *TOTPStore {
+	return &TOTPStore{
+		path:     path,
+		issuer:   issuer,
+		lastStep: make(map[string]int64),
+	}
+}
+
+// Enabled reports whether the user has a confirmed second factor
+func (s *TOTPStore) Enabled(username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return false, err
+	}
+
+	return s.enrollments[username].Enabled, nil
+}
+
+// Begin starts enrolling the user with a new secret and returns it along with
+// the otpauth:// provisioning URI to be shown as a QR code
+func (s *TOTPStore) Begin(username string) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return "", "", err
+	}
+	if s.enrollments[username].Enabled {
+		return "", "", errors.New("two-factor authentication is already enabled")
+	}
+
+	raw := make([]byte, 20)
+	_, err = rand.Read(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	if s.enrollments == nil {
+		s.enrollments = make(map[string]TOTPEnrollment)
+	}
+	s.enrollments[username] = TOTPEnrollment{Secret: secret, CreatedAt: time.Now().UTC()}
+	err = s.save()
+	if err != nil {
+		return "", "", err
+	}
+
+	return secret, s.provisioningURI(username, secret), nil
+}
+
+// Pending returns the secret and provisioning URI of an unconfirmed enrollment
+func (s *TOTPStore) Pending(username string) (string, string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return "", "", false
+	}
+
+	enrollment, ok := s.enrollments[username]
+	if !ok || enrollment.Enabled {
+		return "", "", false
+	}
+	return enrollment.Secret, s.provisioningURI(username, enrollment.Secret), true
+}
+
+// Confirm enables the pending enrollment if the code is valid and returns the
+// recovery codes, which are only stored hashed and can't be retrieved later
+func (s *TOTPStore) Confirm(username, code string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	enrollment, ok := s.enrollments[username]
+	if !ok || enrollment.Enabled {
+		return nil, errors.New("no pending two-factor enrollment")
+	}
+	if !s.validate(username, enrollment.Secret, code, time.Now()) {
+		return nil, errors.New("invalid code")
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	enrollment.RecoveryCodes = make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		enrollment.RecoveryCodes[i] = hashToken(codes[i])
+	}
+	enrollment.Enabled = true
+
+	s.enrollments[username] = enrollment
+	err = s.save()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Verify checks a code from the authenticator app or consumes a recovery code
+func (s *TOTPStore) Verify(username, code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return false
+	}
+
+	enrollment, ok := s.enrollments[username]
+	if !ok || !enrollment.Enabled {
+		return false
+	}
+
+	if s.validate(username, enrollment.Secret, code, time.Now()) {
+		return true
+	}
+
+	// Recovery codes can only be used once
+	hash := []byte(hashToken(strings.ToLower(strings.TrimSpace(code))))
+	for i, recovery := range enrollment.RecoveryCodes {
+		if subtle.ConstantTimeCompare(hash, []byte(recovery)) == 1 {
+			enrollment.RecoveryCodes = append(enrollment.RecoveryCodes[:i:i], enrollment.RecoveryCodes[i+1:]...)
+			s.enrollments[username] = enrollment
+			return s.save() == nil
+		}
+	}
+
+	return false
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes of the user
+func (s *TOTPStore) RecoveryCodesLeft(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return 0
+	}
+	return len(s.enrollments[username].RecoveryCodes)
+}
+
+// Disable removes the second factor of the user
+func (s *TOTPStore) Disable(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return err
+	}
+
+	delete(s.enrollments, username)
+	delete(s.lastStep, username)
+	return s.save()
+}
+
+// validate checks the code against the steps around now, callers must hold the lock
+func (s *TOTPStore) validate(username, secret, code string, now time.Time) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return false
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= s.lastStep[username] {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			s.lastStep[username] = step
+			return true
+		}
+	}
+
+	return false
+}
+
+// provisioningURI returns the otpauth:// URI understood by authenticator apps
+func (s *TOTPStore) provisioningURI(username, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", s.issuer)
+	query.Set("period", fmt.Sprint(totpPeriod))
+	query.Set("digits", fmt.Sprint(totpDigits))
+	label := url.PathEscape(s.issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// reload reads the enrollments file if it changed since it was last read, callers must hold the lock
+func (s *TOTPStore) reload() error {
+	modTime, err := jsonfile.Read(s.path, s.modTime, &s.enrollments)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
+
+// save writes the enrollments file, callers must hold the lock
+func (s *TOTPStore) save() error {
+	modTime, err := jsonfile.Write(s.path, s.enrollments)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
+
+// totpCode computes the code for a time step as described in RFC 6238
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation as described in RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// Challenge represents a login waiting for the second factor
+type Challenge struct {
+	// Identity of the user who entered a valid password
+	Identity Identity
+	// Next is where to send the user after logging in
+	Next string
+	// expires is when the challenge can no longer be completed
+	expires time.Time
+	// attempts counts the wrong codes entered
+	attempts int
+}
+
+// Challenges keeps track of logins waiting for the second factor
+type Challenges struct {
+	mu         sync.Mutex
+	challenges map[string]*Challenge
+}
+
+// NewChallenges creates a new Challenges instance
+func NewChallenges() *Challenges {
+	return &Challenges{challenges: make(map[string]*Challenge)}
+}
+
+// Create starts a challenge for the identity and returns its id
+func (c *Challenges) Create(identity Identity, next string) (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, challenge := range c.challenges {
+		if now.After(challenge.expires) {
+			delete(c.challenges, key)
+		}
+	}
+	c.challenges[id] = &Challenge{Identity: identity, Next: next, expires: now.Add(challengeTTL)}
+
+	return id, nil
+}
+
+// Get returns the challenge with the given id if it is still valid
+func (c *Challenges) Get(id string) (Challenge, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	challenge, ok := c.challenges[id]
+	if !ok || time.Now().After(challenge.expires) {
+		delete(c.challenges, id)
+		return Challenge{}, false
+	}
+	return *challenge, true
+}
+
+// Fail records a wrong code and reports whether the challenge can still be completed
+func (c *Challenges) Fail(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	challenge, ok := c.challenges[id]
+	if !ok {
+		return false
+	}
+	challenge.attempts++
+	if challenge.attempts >= challengeAttempts {
+		delete(c.challenges, id)
+		return false
+	}
+	return true
+}
+
+// Delete removes the challenge with the given id
+func (c *Challenges) Delete(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.challenges, id)
+}
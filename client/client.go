@@ -0,0 +1,419 @@
+// Package client calls the JSON API of a wol server, e.g. to wake machines
+// from other Go programs
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Statuses reported for machines
+const (
+	StatusOnline       = "online"
+	StatusOffline      = "offline"
+	StatusUnknown      = "unknown"
+	StatusWaking       = "waking"
+	StatusShuttingDown = "shutting-down"
+	StatusRebooting    = "rebooting"
+)
+
+// Machine is a machine the user can see
+type Machine struct {
+	Name  string   `json:"name"`
+	Mac   string   `json:"mac"`
+	IP    *string  `json:"ip,omitempty"`
+	Group string   `json:"group,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// ConfirmWake is nil for machines following the server's default
+	ConfirmWake *bool `json:"confirm_wake,omitempty"`
+	// CanWake is true if the user is allowed to wake the machine
+	CanWake bool `json:"can_wake"`
+	// CanPower is true if the user can shut down and reboot the machine
+	CanPower bool `json:"can_power"`
+	// Editable is true for machines added from the web interface or API
+	Editable bool `json:"editable"`
+	// Peer is the name of the peer server the machine belongs to, if any
+	Peer string `json:"peer,omitempty"`
+}
+
+// MachineStatus is the status of a machine
+type MachineStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// CheckedAt is when the machine was last checked, nil if it wasn't yet
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// WakeResult is the outcome of waking one machine of a group or of all machines
+type WakeResult struct {
+	Name  string `json:"name"`
+	Sent  bool   `json:"sent"`
+	Error string `json:"error,omitempty"`
+}
+
+// WakeRequest selects the machines woken by a job
+type WakeRequest struct {
+	Machines []string `json:"machines,omitempty"`
+	// Groups whose machines the user is allowed to wake are added
+	Groups []string `json:"groups,omitempty"`
+	// Offline skips machines that aren't reported offline
+	Offline bool `json:"offline,omitempty"`
+}
+
+// States of the machines of a job
+const (
+	JobTargetPending  = "pending"
+	JobTargetSkipped  = "skipped"
+	JobTargetWaking   = "waking"
+	JobTargetSent     = "sent"
+	JobTargetOnline   = "online"
+	JobTargetFailed   = "failed"
+	JobTargetTimedOut = "timed_out"
+)
+
+// Job wakes many machines in the background
+type Job struct {
+	ID string `json:"id"`
+	// Status is running until every machine was woken and is online or
+	// timed out, then done
+	Status     string      `json:"status"`
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Targets    []JobTarget `json:"targets"`
+}
+
+// Done reports whether the job finished
+func (j Job) Done() bool {
+	return j.Status == "done"
+}
+
+// JobTarget is the progress of waking one machine of a job
+type JobTarget struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Error is returned for requests the server answered with an error
+type Error struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Code is a stable identifier of the error, e.g. machine_not_found
+	Code string
+	// Message describes the error
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server responded with %d", e.StatusCode)
+	}
+	return e.Message
+}
+
+// Client calls the API of a server
+type Client struct {
+	baseURL string
+	token   string
+	// HTTPClient sends the requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// ReconnectDelay is how long StreamStatus waits before reconnecting at
+	// first, doubled up to a minute while the server can't be reached
+	ReconnectDelay time.Duration
+}
+
+// New returns a client for the server at baseURL, e.g.
+// https://wol.example.com or http://router:7777/wol, authenticating with an
+// API token created with "wol token create"
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		token:          token,
+		ReconnectDelay: time.Second,
+	}
+}
+
+// Machines returns the machines visible to the token's user
+func (c *Client) Machines(ctx context.Context) ([]Machine, error) {
+	var machines []Machine
+	err := c.do(ctx, http.MethodGet, "/api/v1/machines", &machines)
+	return machines, err
+}
+
+// Wake sends a magic packet to the machine
+func (c *Client) Wake(ctx context.Context, name string) (Machine, error) {
+	var machine Machine
+	err := c.do(ctx, http.MethodPost, "/api/v1/machines/"+url.PathEscape(name)+"/wake", &machine)
+	return machine, err
+}
+
+// WakeGroup wakes all machines of the group the user is allowed to wake
+func (c *Client) WakeGroup(ctx context.Context, group string) ([]WakeResult, error) {
+	var results []WakeResult
+	err := c.do(ctx, http.MethodPost, "/api/v1/groups/"+url.PathEscape(group)+"/wake", &results)
+	return results, err
+}
+
+// WakeAll wakes all machines the user is allowed to wake, only the ones
+// that aren't online if offlineOnly is set
+func (c *Client) WakeAll(ctx context.Context, offlineOnly bool) ([]WakeResult, error) {
+	path := "/api/v1/wake-all"
+	if offlineOnly {
+		path += "?offline=true"
+	}
+	var results []WakeResult
+	err := c.do(ctx, http.MethodPost, path, &results)
+	return results, err
+}
+
+// StartWake starts a job waking the machines in the background, poll it
+// with Job to follow their progress
+func (c *Client) StartWake(ctx context.Context, req WakeRequest) (Job, error) {
+	var job Job
+	err := c.send(ctx, http.MethodPost, "/api/v1/wake", req, &job)
+	return job, err
+}
+
+// Job returns the progress of a job started with StartWake
+func (c *Client) Job(ctx context.Context, id string) (Job, error) {
+	var job Job
+	err := c.do(ctx, http.MethodGet, "/api/v1/jobs/"+url.PathEscape(id), &job)
+	return job, err
+}
+
+// Shutdown shuts the machine down over SSH
+func (c *Client) Shutdown(ctx context.Context, name string) (Machine, error) {
+	var machine Machine
+	err := c.do(ctx, http.MethodPost, "/api/v1/machines/"+url.PathEscape(name)+"/shutdown", &machine)
+	return machine, err
+}
+
+// Reboot reboots the machine over SSH
+func (c *Client) Reboot(ctx context.Context, name string) (Machine, error) {
+	var machine Machine
+	err := c.do(ctx, http.MethodPost, "/api/v1/machines/"+url.PathEscape(name)+"/reboot", &machine)
+	return machine, err
+}
+
+// Status returns the status of all machines visible to the user
+func (c *Client) Status(ctx context.Context) ([]MachineStatus, error) {
+	var statuses []MachineStatus
+	err := c.do(ctx, http.MethodGet, "/api/v1/status", &statuses)
+	return statuses, err
+}
+
+// MachineStatus returns the status of the machine
+func (c *Client) MachineStatus(ctx context.Context, name string) (MachineStatus, error) {
+	var status MachineStatus
+	err := c.do(ctx, http.MethodGet, "/api/v1/machines/"+url.PathEscape(name)+"/status", &status)
+	return status, err
+}
+
+// StreamStatus calls fn with the statuses of the machines, all of them first
+// and then only the ones that changed, until the context is done or fn
+// returns an error. Only the named machines are watched if any are given.
+// Lost connections and server errors are retried, resuming without missing
+// changes, other errors such as an invalid token are returned.
+func (c *Client) StreamStatus(ctx context.Context, fn func(statuses map[string]string) error, machines ...string) error {
+	query := url.Values{}
+	for _, name := range machines {
+		query.Add("machine", name)
+	}
+	path := "/status"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	// Invalid URLs won't get any better by retrying
+	_, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+
+	delay := c.ReconnectDelay
+	var lastEventID string
+	for {
+		connected, err := c.stream(ctx, path, &lastEventID, fn)
+		var apiErr *Error
+		var fnErr callbackError
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &fnErr):
+			return fnErr.err
+		case errors.As(err, &apiErr) && apiErr.StatusCode < 500:
+			return err
+		}
+
+		if connected {
+			delay = c.ReconnectDelay
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, time.Minute)
+	}
+}
+
+// callbackError wraps errors returned by the function passed to StreamStatus
+type callbackError struct {
+	err error
+}
+
+func (e callbackError) Error() string {
+	return e.err.Error()
+}
+
+// stream reads status events until the connection ends, reporting whether
+// it was established
+func (c *Client) stream(ctx context.Context, path string, lastEventID *string, fn func(map[string]string) error) (bool, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if *lastEventID != "" {
+		req.Header.Set("Last-Event-ID", *lastEventID)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, responseError(resp)
+	}
+	// Requests without a valid token are redirected to the login page
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return false, &Error{StatusCode: resp.StatusCode, Message: "server didn't respond with a status stream, check the token"}
+	}
+
+	// Events are separated by blank lines, only id and data fields are used
+	scanner := bufio.NewScanner(resp.Body)
+	var id string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "id":
+				id = value
+			case "data":
+				data.WriteString(value)
+			}
+			continue
+		}
+
+		if data.Len() > 0 {
+			var statuses map[string]string
+			err := json.Unmarshal(data.Bytes(), &statuses)
+			if err != nil {
+				return true, fmt.Errorf("failed to decode status event: %w", err)
+			}
+			if err := fn(statuses); err != nil {
+				return true, callbackError{err}
+			}
+			*lastEventID = id
+		}
+		id = ""
+		data.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, io.ErrUnexpectedEOF
+}
+
+// do sends a request and decodes the JSON response into v
+func (c *Client) do(ctx context.Context, method, path string, v interface{}) error {
+	return c.send(ctx, method, path, nil, v)
+}
+
+// send sends a request with in encoded as the JSON body if not nil and
+// decodes the JSON response into out
+func (c *Client) send(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := c.newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// newRequest creates a request to the path of the server with the token
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// responseError returns the error of a response with an error status
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var envelope struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err := &Error{StatusCode: resp.StatusCode}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Code != "" {
+		err.Code = envelope.Error.Code
+		err.Message = envelope.Error.Message
+	} else {
+		err.Message = strings.TrimSpace(string(body))
+	}
+	return err
+}
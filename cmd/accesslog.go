@@ -0,0 +1,128 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// accessLogKey is the context key under which the access log fields of a request are stored
+type accessLogKey struct{}
+
+// accessLogFields collects details that are only known to inner handlers
+type accessLogFields struct {
+	// User who made the request, if authenticated
+	User string
+}
+
+// statusRecorder captures the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush is needed by the status event stream
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack is needed by the status WebSocket
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// newAccessLogger creates the logger for the configured format
+func newAccessLogger() (*slog.Logger, error) {
+	switch cfg.Server.AccessLog.Format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stdout, nil)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stdout, nil)), nil
+	default:
+		return nil, fmt.Errorf("unsupported access log format %q", cfg.Server.AccessLog.Format)
+	}
+}
+
+// accessLogMiddleware logs every request once it has been handled
+func accessLogMiddleware(next http.Handler) (http.Handler, error) {
+	if !cfg.Server.AccessLog.Enabled {
+		return next, nil
+	}
+
+	logger, err := newAccessLogger()
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		fields := &accessLogFields{}
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		ctx := context.WithValue(r.Context(), accessLogKey{}, fields)
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("user", fields.User),
+			slog.String("ip", clientIP(r)),
+		)
+	}), nil
+}
+
+// setAccessLogUser records the user shown in the access log for the request
+func setAccessLogUser(r *http.Request, username string) {
+	fields, ok := r.Context().Value(accessLogKey{}).(*accessLogFields)
+	if ok {
+		fields.User = username
+	}
+}
@@ -0,0 +1,96 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/trugamr/wol/acmedns"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeRenewInterval is how often the DNS-01 certificate is checked for renewal
+const acmeRenewInterval = 12 * time.Hour
+
+// acmeTLSConfig returns a TLS config with certificates obtained automatically
+// using the configured challenge
+func acmeTLSConfig() (*tls.Config, error) {
+	config := cfg.Server.TLS.ACME
+	cache := autocert.DirCache(filepath.Join(cfg.DataDir, "acme"))
+
+	switch config.Challenge {
+	case "http-01":
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      cache,
+			HostPolicy: autocert.HostWhitelist(config.Domains...),
+			Email:      config.Email,
+			Client:     &acme.Client{DirectoryURL: config.DirectoryURL},
+		}
+
+		// Answers challenges and sends everything else to HTTPS
+		go func() {
+			log.Printf("Answering ACME challenges on %s", config.HTTPListen)
+			err := http.ListenAndServe(config.HTTPListen, manager.HTTPHandler(nil))
+			if err != nil {
+				log.Printf("Error serving ACME challenges: %v", err)
+			}
+		}()
+
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, nil
+
+	case "dns-01":
+		if config.DNS.Provider != "exec" {
+			return nil, fmt.Errorf("unsupported ACME DNS provider %q", config.DNS.Provider)
+		}
+		if config.DNS.Command == "" {
+			return nil, fmt.Errorf("server.tls.acme.dns.command must be set for the exec provider")
+		}
+
+		manager := acmedns.NewManager(acmedns.Config{
+			Domains:          config.Domains,
+			Email:            config.Email,
+			DirectoryURL:     config.DirectoryURL,
+			Cache:            cache,
+			Provider:         acmedns.ExecProvider{Command: config.DNS.Command},
+			PropagationDelay: config.DNS.PropagationDelay,
+		})
+
+		// The certificate is needed before serving, so the first one is obtained up front
+		log.Printf("Obtaining certificate for %v", config.Domains)
+		err := manager.Ensure(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain certificate: %w", err)
+		}
+		log.Printf("Certificate valid until %s", manager.NotAfter().Format(time.RFC3339))
+
+		go func() {
+			ticker := time.NewTicker(acmeRenewInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				err := manager.Ensure(context.Background())
+				if err != nil {
+					log.Printf("Error renewing certificate: %v", err)
+				}
+			}
+		}()
+
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: manager.GetCertificate,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported ACME challenge %q", config.Challenge)
+	}
+}
@@ -0,0 +1,161 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/magicpacket"
+	"golang.org/x/net/websocket"
+)
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().StringSlice("subnet", nil, "Subnet whose machines this agent wakes, e.g. 192.168.20.0/24, can be repeated")
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Wake machines on this network for a wol server",
+	Long:  "Connect to the server given with --server as a relay agent and wake the machines on the given subnets for it, e.g. on a VLAN the server can't broadcast to. --token is the token of one of the server's agents.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		server, token := remoteServer(cmd)
+		if server == "" || token == "" {
+			cobra.CheckErr(fmt.Errorf("--server and --token are required"))
+		}
+		subnets, _ := cmd.Flags().GetStringSlice("subnet")
+		if len(subnets) == 0 {
+			cobra.CheckErr(fmt.Errorf("at least one --subnet is required"))
+		}
+		for _, subnet := range subnets {
+			_, err := netip.ParsePrefix(subnet)
+			if err != nil {
+				cobra.CheckErr(fmt.Errorf("invalid subnet %q: %w", subnet, err))
+			}
+		}
+
+		// Connections are retried with a growing delay while the server can't be reached
+		delay := time.Second
+		for {
+			connected, err := runAgent(server, token, subnets)
+			if connected {
+				delay = time.Second
+			}
+			log.Printf("Disconnected from server: %v, reconnecting in %s", err, delay)
+			time.Sleep(delay)
+			delay = min(delay*2, time.Minute)
+		}
+	},
+}
+
+// runAgent connects to the server and sends magic packets for it until the
+// connection is lost, reporting whether it was established
+func runAgent(server, token string, subnets []string) (bool, error) {
+	u, err := url.Parse(strings.TrimSuffix(server, "/") + "/agent")
+	if err != nil {
+		return false, fmt.Errorf("failed to parse server URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return false, fmt.Errorf("server URL must start with http:// or https://")
+	}
+
+	config, err := websocket.NewConfig(u.String(), server)
+	if err != nil {
+		return false, fmt.Errorf("failed to create connection config: %w", err)
+	}
+	config.Header.Set("Authorization", "Bearer "+token)
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer ws.Close()
+
+	var sendMu sync.Mutex
+	send := func(msg agentMessage) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return websocket.JSON.Send(ws, msg)
+	}
+	err = send(agentMessage{Type: "register", Subnets: subnets})
+	if err != nil {
+		return false, fmt.Errorf("failed to register: %w", err)
+	}
+	log.Printf("Connected to %s for %s", server, strings.Join(subnets, ", "))
+
+	// The server closes connections that stopped pinging
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(agentPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := send(agentMessage{Type: "ping"}); err != nil {
+					ws.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var msg agentMessage
+		err := websocket.JSON.Receive(ws, &msg)
+		if err != nil {
+			return true, err
+		}
+
+		switch msg.Type {
+		case "error":
+			return true, errors.New(msg.Error)
+		case "wake":
+			result := agentMessage{Type: "result", ID: msg.ID}
+			if err := agentWake(msg.MAC, msg.Address); err != nil {
+				log.Printf("Error waking %s: %v", msg.MAC, err)
+				result.Error = err.Error()
+			}
+			err := send(result)
+			if err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+// agentWake sends a magic packet for the server, unicast to the address if
+// not empty and broadcast on all interfaces
+func agentWake(macAddress, address string) error {
+	mac, err := net.ParseMAC(macAddress)
+	if err != nil {
+		return fmt.Errorf("failed to parse MAC address: %w", err)
+	}
+
+	log.Printf("Sending magic packet to %s", mac)
+	mp := magicpacket.NewMagicPacket(mac)
+	if address != "" {
+		log.Printf("Sending unicast packet to %s", address)
+		if err := mp.Send(address); err != nil {
+			log.Printf("Error sending unicast packet: %v", err)
+		}
+	}
+	return mp.Broadcast()
+}
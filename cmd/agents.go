@@ -0,0 +1,302 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"golang.org/x/net/websocket"
+)
+
+// agentMessage is exchanged in both directions over the connection of a
+// relay agent
+//
+// Agents connect to /agent with their token in the Authorization header and
+// first send {"type": "register", "subnets": ["192.168.20.0/24"]}. The server
+// then sends {"type": "wake", "id": 1, "mac": "...", "address": "..."} for
+// machines on these subnets, answered with {"type": "result", "id": 1} or
+// {"type": "result", "id": 1, "error": "..."}. Agents send {"type": "ping"}
+// every agentPingInterval to keep the connection, rejected registrations
+// are answered with {"type": "error", "error": "..."}.
+type agentMessage struct {
+	Type    string   `json:"type"`
+	ID      uint64   `json:"id,omitempty"`
+	Subnets []string `json:"subnets,omitempty"`
+	MAC     string   `json:"mac,omitempty"`
+	Address string   `json:"address,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+const (
+	// agentPingInterval is how often agents ping, connections are closed
+	// after missing a few pings
+	agentPingInterval = 30 * time.Second
+	// agentWakeTimeout is how long agents have to report sending a magic packet
+	agentWakeTimeout = 10 * time.Second
+)
+
+// errAgentDisconnected is returned for wakes of agents that disconnected
+var errAgentDisconnected = errors.New("relay agent disconnected")
+
+// relayAgent is a connected relay agent
+type relayAgent struct {
+	name    string
+	subnets []netip.Prefix
+	ws      *websocket.Conn
+	sendMu  sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan string
+}
+
+// agentRegistry holds the connected relay agents by name
+type agentRegistry struct {
+	mu     sync.Mutex
+	agents map[string]*relayAgent
+}
+
+var relayAgents = &agentRegistry{agents: make(map[string]*relayAgent)}
+
+// setupAgents validates the relay agents allowed to connect
+func setupAgents() error {
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for i, agent := range cfg.Agents {
+		if agent.Name == "" {
+			return fmt.Errorf("invalid agent %d: name is required", i+1)
+		}
+		if names[agent.Name] {
+			return fmt.Errorf("invalid agent %s: duplicate name", agent.Name)
+		}
+		if len(agent.Token) < 16 {
+			return fmt.Errorf("invalid agent %s: token must be at least 16 characters", agent.Name)
+		}
+		if tokens[agent.Token] {
+			return fmt.Errorf("invalid agent %s: token is used by another agent", agent.Name)
+		}
+		names[agent.Name] = true
+		tokens[agent.Token] = true
+	}
+	return nil
+}
+
+// authenticateAgent returns the configured agent the request was made by
+func authenticateAgent(r *http.Request) (config.Agent, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return config.Agent{}, false
+	}
+	token = strings.TrimSpace(token)
+	for _, agent := range cfg.Agents {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(agent.Token)) == 1 {
+			return agent, true
+		}
+	}
+	return config.Agent{}, false
+}
+
+func handleAgent(w http.ResponseWriter, r *http.Request) {
+	agent, ok := authenticateAgent(r)
+	if !ok {
+		log.Printf("Rejected relay agent from %s", clientIP(r))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Agents aren't browsers, there is no origin to check
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		serveAgent(ws, agent.Name)
+	}}
+	server.ServeHTTP(w, r)
+}
+
+// serveAgent registers the agent and reads its messages until it disconnects
+func serveAgent(ws *websocket.Conn, name string) {
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(agentPingInterval))
+	var msg agentMessage
+	err := websocket.JSON.Receive(ws, &msg)
+	if err != nil {
+		log.Printf("Error reading registration of relay agent %s: %v", name, err)
+		return
+	}
+	subnets, err := parseAgentSubnets(msg)
+	if err != nil {
+		websocket.JSON.Send(ws, agentMessage{Type: "error", Error: err.Error()})
+		log.Printf("Rejected registration of relay agent %s: %v", name, err)
+		return
+	}
+
+	agent := &relayAgent{name: name, subnets: subnets, ws: ws, pending: make(map[uint64]chan string)}
+	relayAgents.register(agent)
+	defer relayAgents.unregister(agent)
+	log.Printf("Relay agent %s connected from %s for %v", name, clientIP(ws.Request()), subnets)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-shuttingDown:
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(3 * agentPingInterval))
+		var msg agentMessage
+		err := websocket.JSON.Receive(ws, &msg)
+		if err != nil {
+			log.Printf("Relay agent %s disconnected: %v", name, err)
+			return
+		}
+		if msg.Type == "result" {
+			agent.resolve(msg.ID, msg.Error)
+		}
+	}
+}
+
+// parseAgentSubnets returns the subnets of a registration
+func parseAgentSubnets(msg agentMessage) ([]netip.Prefix, error) {
+	if msg.Type != "register" {
+		return nil, fmt.Errorf("expected register message, got %q", msg.Type)
+	}
+	if len(msg.Subnets) == 0 {
+		return nil, errors.New("at least one subnet is required")
+	}
+	subnets := make([]netip.Prefix, 0, len(msg.Subnets))
+	for _, s := range msg.Subnets {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q", s)
+		}
+		subnets = append(subnets, prefix.Masked())
+	}
+	return subnets, nil
+}
+
+// register adds the agent, replacing an earlier connection of the same agent
+func (r *agentRegistry) register(agent *relayAgent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if previous, ok := r.agents[agent.name]; ok {
+		previous.ws.Close()
+	}
+	r.agents[agent.name] = agent
+}
+
+// unregister removes the agent unless it connected again, its pending wakes fail
+func (r *agentRegistry) unregister(agent *relayAgent) {
+	r.mu.Lock()
+	if r.agents[agent.name] == agent {
+		delete(r.agents, agent.name)
+	}
+	r.mu.Unlock()
+
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	for id, result := range agent.pending {
+		close(result)
+		delete(agent.pending, id)
+	}
+}
+
+// route returns the agent serving the most specific subnet containing the
+// address of the machine, nil if the server wakes the machine itself
+func (r *agentRegistry) route(ctx context.Context, machine config.Machine) *relayAgent {
+	r.mu.Lock()
+	empty := len(r.agents) == 0
+	r.mu.Unlock()
+	if empty || machine.IP == nil {
+		return nil
+	}
+
+	host := *machine.IP
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil || len(addrs) == 0 {
+			return nil
+		}
+		addr = addrs[0]
+	}
+	addr = addr.Unmap()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var best *relayAgent
+	bits := -1
+	for _, agent := range r.agents {
+		for _, subnet := range agent.subnets {
+			if subnet.Contains(addr) && subnet.Bits() > bits {
+				best = agent
+				bits = subnet.Bits()
+			}
+		}
+	}
+	return best
+}
+
+// wake asks the agent to send a magic packet to the MAC address, unicast to
+// the address if not empty and broadcast on its networks
+func (a *relayAgent) wake(ctx context.Context, mac net.HardwareAddr, address string) error {
+	a.mu.Lock()
+	a.nextID++
+	id := a.nextID
+	result := make(chan string, 1)
+	a.pending[id] = result
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.pending, id)
+		a.mu.Unlock()
+	}()
+
+	a.sendMu.Lock()
+	err := websocket.JSON.Send(a.ws, agentMessage{Type: "wake", ID: id, MAC: mac.String(), Address: address})
+	a.sendMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send wake to relay agent %s: %w", a.name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, agentWakeTimeout)
+	defer cancel()
+	select {
+	case message, ok := <-result:
+		if !ok {
+			return fmt.Errorf("%w: %s", errAgentDisconnected, a.name)
+		}
+		if message != "" {
+			return fmt.Errorf("relay agent %s failed to wake: %s", a.name, message)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("relay agent %s didn't answer: %w", a.name, ctx.Err())
+	}
+}
+
+// resolve passes the result of a wake to the waiting caller
+func (a *relayAgent) resolve(id uint64, message string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if result, ok := a.pending[id]; ok {
+		result <- message
+		delete(a.pending, id)
+	}
+}
@@ -0,0 +1,80 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/trugamr/wol/amt"
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/probe"
+)
+
+// amtTimeout limits how long a request to Intel AMT may take
+const amtTimeout = 10 * time.Second
+
+func init() {
+	registerMachineType(config.MachineTypeAMT, machineType{
+		wake: func(ctx context.Context, machine config.Machine) error {
+			client, err := amtClient(machine)
+			if err != nil {
+				return err
+			}
+			// AMT refuses to power on computers that already are
+			on, err := client.PoweredOn(ctx)
+			if err != nil || on {
+				return err
+			}
+			return client.SetPower(ctx, amt.PowerOn)
+		},
+		prober: func(machine config.Machine) (probe.Prober, error) {
+			client, err := amtClient(machine)
+			if err != nil {
+				return nil, err
+			}
+			return amtProber{client: client}, nil
+		},
+		power: func(ctx context.Context, machine config.Machine, status string) error {
+			client, err := amtClient(machine)
+			if err != nil {
+				return err
+			}
+			if status == statusRebooting {
+				return client.SetPower(ctx, amt.PowerResetSoft)
+			}
+			return client.SetPower(ctx, amt.PowerOffSoft)
+		},
+	})
+}
+
+// amtClient returns the client of Intel AMT of the machine
+func amtClient(machine config.Machine) (*amt.Client, error) {
+	a := machine.AMT
+	address := a.Address
+	if address == "" && machine.IP != nil {
+		address = *machine.IP
+	}
+	return amt.New(amt.Config{
+		Address:            address,
+		Username:           a.Username,
+		Password:           a.Password,
+		TLS:                a.TLS,
+		InsecureSkipVerify: a.InsecureSkipVerify,
+		Timeout:            amtTimeout,
+	})
+}
+
+// amtProber reports computers online while AMT reports them on, sleeping
+// ones count as offline
+type amtProber struct {
+	client *amt.Client
+}
+
+func (p amtProber) Probe(ctx context.Context) (probe.Result, error) {
+	on, err := p.client.PoweredOn(ctx)
+	if err != nil {
+		return probe.Result{}, err
+	}
+	return probe.Result{Online: on}, nil
+}
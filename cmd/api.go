@@ -0,0 +1,370 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/trugamr/wol/config"
+)
+
+// apiPrefix is the path prefix of the JSON API
+const apiPrefix = "/api/"
+
+// apiMachine represents a machine in API responses
+type apiMachine struct {
+	Name        string   `json:"name"`
+	Mac         string   `json:"mac"`
+	IP          *string  `json:"ip,omitempty"`
+	Group       string   `json:"group,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	ConfirmWake *bool    `json:"confirm_wake,omitempty"`
+	CanWake     bool     `json:"can_wake"`
+	CanPower    bool     `json:"can_power"`
+	Editable    bool     `json:"editable"`
+	Peer        string   `json:"peer,omitempty"`
+}
+
+// apiMachineInput is the request body adding or replacing a machine
+type apiMachineInput struct {
+	Name  string             `json:"name"`
+	Mac   string             `json:"mac"`
+	IP    *string            `json:"ip"`
+	Group string             `json:"group"`
+	Tags  []string           `json:"tags"`
+	SSH   *config.MachineSSH `json:"ssh"`
+	// ConfirmWake is kept when replacing a machine if missing
+	ConfirmWake *bool `json:"confirm_wake"`
+}
+
+// apiMachineStatus represents the status of a machine in API responses
+type apiMachineStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// CheckedAt is when the machine was last checked, missing if it wasn't yet
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// newAPIMachineStatus converts a status and when it was checked to its API representation
+func newAPIMachineStatus(name, status string, checkedAt time.Time, checked bool) apiMachineStatus {
+	if status == "" {
+		status = "unknown"
+	}
+	s := apiMachineStatus{Name: name, Status: status}
+	if checked {
+		s.CheckedAt = &checkedAt
+	}
+	return s
+}
+
+// apiWakeResult represents the outcome of waking a machine of a group in API responses
+type apiWakeResult struct {
+	Name  string `json:"name"`
+	Sent  bool   `json:"sent"`
+	Error string `json:"error,omitempty"`
+}
+
+// apiErrorBody is the envelope of all API errors
+type apiErrorBody struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+// apiErrorDetail describes an API error
+type apiErrorDetail struct {
+	// Code is a stable machine readable identifier of the error
+	Code string `json:"code"`
+	// Message is a human readable description of the error
+	Message string `json:"message"`
+}
+
+// registerAPI adds the JSON API routes to the mux
+func registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/machines", handleAPIMachines)
+	mux.HandleFunc("POST /api/v1/machines", handleAPICreateMachine)
+	mux.HandleFunc("PUT /api/v1/machines/{name}", handleAPIUpdateMachine)
+	mux.HandleFunc("DELETE /api/v1/machines/{name}", handleAPIDeleteMachine)
+	mux.HandleFunc("POST /api/v1/machines/{name}/wake", handleAPIWake)
+	mux.HandleFunc("POST /api/v1/machines/{name}/shutdown", handleAPIPower(statusShuttingDown))
+	mux.HandleFunc("POST /api/v1/machines/{name}/reboot", handleAPIPower(statusRebooting))
+	mux.HandleFunc("GET /api/v1/machines/{name}/status", handleAPIMachineStatus)
+	mux.HandleFunc("GET /api/v1/status", handleAPIStatus)
+	mux.HandleFunc("POST /api/v1/groups/{group}/wake", handleAPIWakeGroup)
+	mux.HandleFunc("POST /api/v1/wake-all", handleAPIWakeAll)
+	mux.HandleFunc("POST /api/v1/wake", handleAPIWakeJob)
+	mux.HandleFunc("GET /api/v1/jobs/{id}", handleAPIJob)
+	mux.HandleFunc("GET /api/v1/maintenance", handleAPIMaintenance)
+	mux.HandleFunc("PUT /api/v1/maintenance", handleAPISetMaintenance)
+	registerSwitchAPI(mux)
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", "Endpoint not found")
+	})
+}
+
+// isAPIRequest reports whether the request is made to the JSON API
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiPrefix)
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// writeAPIError writes an error in the API error envelope
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiErrorBody{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// newAPIMachine converts a configured machine to its API representation
+func newAPIMachine(r *http.Request, machine config.Machine) apiMachine {
+	return apiMachine{
+		Name:        machine.Name,
+		Mac:         machine.Mac,
+		IP:          machine.IP,
+		Group:       machine.Group,
+		Tags:        machine.Tags,
+		ConfirmWake: machine.ConfirmWake,
+		CanWake:     requestPermissions(r).CanWake(machine.Name, machine.Group),
+		CanPower:    requestPermissions(r).CanWake(machine.Name, machine.Group) && canPower(machine),
+		Editable:    editable(machine),
+		Peer:        machine.Peer,
+	}
+}
+
+func handleAPIMachines(w http.ResponseWriter, r *http.Request) {
+	machines := machineFilterFromRequest(r).apply(visibleMachines(r))
+
+	response := make([]apiMachine, 0, len(machines))
+	for _, machine := range machines {
+		response = append(response, newAPIMachine(r, machine))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func handleAPIWake(w http.ResponseWriter, r *http.Request) {
+	machine, err := wakeAs(r, r.PathValue("name"))
+	switch {
+	case errors.Is(err, errMachineNotFound):
+		writeAPIError(w, http.StatusNotFound, "machine_not_found", "Machine not found")
+		return
+	case errors.Is(err, errReadOnly):
+		writeAPIError(w, http.StatusForbidden, "read_only", readOnlyReason()+", waking machines is disabled")
+		return
+	case errors.Is(err, errPermission):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Not allowed to wake this machine")
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "wake_failed", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, newAPIMachine(r, machine))
+}
+
+func handleAPIWakeGroup(w http.ResponseWriter, r *http.Request) {
+	results, err := wakeGroupAs(r, r.PathValue("group"))
+	switch {
+	case errors.Is(err, errGroupNotFound):
+		writeAPIError(w, http.StatusNotFound, "group_not_found", "Group not found")
+		return
+	case errors.Is(err, errReadOnly):
+		writeAPIError(w, http.StatusForbidden, "read_only", readOnlyReason()+", waking machines is disabled")
+		return
+	case errors.Is(err, errPermission):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Not allowed to wake any machine of this group")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, newAPIWakeResults(results))
+}
+
+func handleAPIWakeAll(w http.ResponseWriter, r *http.Request) {
+	offlineOnly := r.URL.Query().Get("offline") == "true"
+
+	results, err := wakeAllAs(r, offlineOnly)
+	switch {
+	case errors.Is(err, errReadOnly):
+		writeAPIError(w, http.StatusForbidden, "read_only", readOnlyReason()+", waking machines is disabled")
+		return
+	case errors.Is(err, errPermission):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Not allowed to wake all machines")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, newAPIWakeResults(results))
+}
+
+// newAPIWakeResults converts the results of waking many machines to their API representation
+func newAPIWakeResults(results []wakeResult) []apiWakeResult {
+	response := make([]apiWakeResult, 0, len(results))
+	for _, result := range results {
+		item := apiWakeResult{Name: result.Machine.Name, Sent: result.Err == nil}
+		if result.Err != nil {
+			item.Error = result.Err.Error()
+		}
+		response = append(response, item)
+	}
+	return response
+}
+
+// handleAPIPower returns a handler shutting down or rebooting a machine
+func handleAPIPower(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		machine, err := powerAs(r, r.PathValue("name"), status)
+		switch {
+		case errors.Is(err, errMachineNotFound):
+			writeAPIError(w, http.StatusNotFound, "machine_not_found", "Machine not found")
+			return
+		case errors.Is(err, errReadOnly):
+			writeAPIError(w, http.StatusForbidden, "read_only", readOnlyReason()+", changing machines is disabled")
+			return
+		case errors.Is(err, errPermission):
+			writeAPIError(w, http.StatusForbidden, "forbidden", "Not allowed to shut down or reboot this machine")
+			return
+		case errors.Is(err, errNoRemoteAccess):
+			writeAPIError(w, http.StatusConflict, "not_configured", "Shutdown and reboot are not configured for this machine")
+			return
+		case err != nil:
+			writeAPIError(w, http.StatusBadGateway, "command_failed", err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, newAPIMachine(r, machine))
+	}
+}
+
+func handleAPIMachineStatus(w http.ResponseWriter, r *http.Request) {
+	machine, ok := findVisibleMachine(r, r.PathValue("name"))
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "machine_not_found", "Machine not found")
+		return
+	}
+
+	machines := []config.Machine{machine}
+	status := poller.current(machines)[machine.Name]
+	checkedAt, checked := poller.checked(machines)[machine.Name]
+	writeJSON(w, http.StatusOK, newAPIMachineStatus(machine.Name, status, checkedAt, checked))
+}
+
+func handleAPIStatus(w http.ResponseWriter, r *http.Request) {
+	machines := visibleMachines(r)
+	statuses := poller.current(machines)
+	checked := poller.checked(machines)
+
+	response := make([]apiMachineStatus, 0, len(machines))
+	for _, machine := range machines {
+		checkedAt, ok := checked[machine.Name]
+		response = append(response, newAPIMachineStatus(machine.Name, statuses[machine.Name], checkedAt, ok))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// decodeAPIMachine reads the machine from the request body, writing an error
+// response if it can't be decoded
+func decodeAPIMachine(w http.ResponseWriter, r *http.Request) (config.Machine, bool) {
+	var input apiMachineInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&input)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid request body: %v", err))
+		return config.Machine{}, false
+	}
+
+	machine := config.Machine{
+		Name:        strings.TrimSpace(input.Name),
+		Mac:         strings.TrimSpace(input.Mac),
+		Group:       strings.TrimSpace(input.Group),
+		Tags:        parseTags(strings.Join(input.Tags, ",")),
+		SSH:         input.SSH,
+		ConfirmWake: input.ConfirmWake,
+	}
+	if input.IP != nil && strings.TrimSpace(*input.IP) != "" {
+		ip := strings.TrimSpace(*input.IP)
+		machine.IP = &ip
+	}
+	return machine, true
+}
+
+// writeAPIMachineError writes the response of a failed attempt to change a machine
+func writeAPIMachineError(w http.ResponseWriter, err error) {
+	var invalid invalidMachineError
+	switch {
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusBadRequest, "invalid_machine", "Invalid machine: "+invalid.Error())
+	case errors.Is(err, errMachineNotFound):
+		writeAPIError(w, http.StatusNotFound, "machine_not_found", "Machine not found")
+	case errors.Is(err, errMachineExists):
+		writeAPIError(w, http.StatusConflict, "machine_exists", "A machine with this name already exists")
+	case errors.Is(err, errMachineInConfig):
+		writeAPIError(w, http.StatusConflict, "machine_in_config", "Machine is defined in the config file and can't be changed")
+	case errors.Is(err, errReadOnly):
+		writeAPIError(w, http.StatusForbidden, "read_only", readOnlyReason()+", changing machines is disabled")
+	case errors.Is(err, errPermission):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Only admins can change machines")
+	default:
+		log.Printf("Error saving machine: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to save machine")
+	}
+}
+
+func handleAPICreateMachine(w http.ResponseWriter, r *http.Request) {
+	machine, ok := decodeAPIMachine(w, r)
+	if !ok {
+		return
+	}
+
+	err := createMachine(r, machine)
+	if err != nil {
+		writeAPIMachineError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newAPIMachine(r, machine))
+}
+
+func handleAPIUpdateMachine(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	machine, ok := decodeAPIMachine(w, r)
+	if !ok {
+		return
+	}
+
+	// Checks can't be set through the API and confirming wakes may be left
+	// out, so they are kept
+	if existing, ok := findMachine(name); ok {
+		machine.Check = existing.Check
+		if machine.ConfirmWake == nil {
+			machine.ConfirmWake = existing.ConfirmWake
+		}
+	}
+
+	err := updateMachine(r, name, machine)
+	if err != nil {
+		writeAPIMachineError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIMachine(r, machine))
+}
+
+func handleAPIDeleteMachine(w http.ResponseWriter, r *http.Request) {
+	err := deleteMachine(r, r.PathValue("name"))
+	if err != nil {
+		writeAPIMachineError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
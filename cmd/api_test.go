@@ -0,0 +1,66 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trugamr/wol/config"
+)
+
+// apiMachineRequest runs the handler with the body and returns the machine of the response
+func apiMachineRequest(t *testing.T, handler http.HandlerFunc, method, name, body string) apiMachine {
+	t.Helper()
+	req := httptest.NewRequest(method, "/api/v1/machines", strings.NewReader(body))
+	req.SetPathValue("name", name)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+		t.Fatalf("%s of %s returned %d: %s", method, body, rec.Code, rec.Body)
+	}
+	var machine apiMachine
+	if err := json.NewDecoder(rec.Body).Decode(&machine); err != nil {
+		t.Fatal(err)
+	}
+	return machine
+}
+
+func TestAPIMachineRoundTripKeepsConfirmWake(t *testing.T) {
+	oldCfg, oldStore, oldPoller := cfg, machineStore, poller
+	t.Cleanup(func() { cfg, machineStore, poller = oldCfg, oldStore, oldPoller })
+	cfg = config.NewConfig()
+	cfg.Auth.Disabled = true
+	cfg.DataDir = t.TempDir()
+	machineStore = newMachineStore()
+	poller = newStatusPoller(time.Minute, time.Minute, time.Minute)
+
+	created := apiMachineRequest(t, handleAPICreateMachine, http.MethodPost, "",
+		`{"name": "desktop", "mac": "00:11:22:33:44:55", "confirm_wake": true}`)
+	if created.ConfirmWake == nil || !*created.ConfirmWake {
+		t.Fatalf("created machine has confirm_wake %v, want true", created.ConfirmWake)
+	}
+
+	// Replacing the machine without confirm_wake keeps it
+	updated := apiMachineRequest(t, handleAPIUpdateMachine, http.MethodPut, "desktop",
+		`{"name": "desktop", "mac": "00:11:22:33:44:55", "group": "office"}`)
+	if updated.ConfirmWake == nil || !*updated.ConfirmWake {
+		t.Errorf("replaced machine has confirm_wake %v, want it kept", updated.ConfirmWake)
+	}
+	if machine, _ := findMachine("desktop"); machine.ConfirmWake == nil || !*machine.ConfirmWake || machine.Group != "office" {
+		t.Errorf("stored machine is %+v, want group office with confirm_wake kept", machine)
+	}
+
+	updated = apiMachineRequest(t, handleAPIUpdateMachine, http.MethodPut, "desktop",
+		`{"name": "desktop", "mac": "00:11:22:33:44:55", "confirm_wake": false}`)
+	if updated.ConfirmWake == nil || *updated.ConfirmWake {
+		t.Errorf("replaced machine has confirm_wake %v, want false", updated.ConfirmWake)
+	}
+	if machine, _ := findMachine("desktop"); machine.ConfirmWake == nil || *machine.ConfirmWake {
+		t.Errorf("stored machine has confirm_wake %v, want false", machine.ConfirmWake)
+	}
+}
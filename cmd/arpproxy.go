@@ -0,0 +1,121 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+
+	"github.com/trugamr/wol/arpproxy"
+	"github.com/trugamr/wol/config"
+)
+
+// arpProxy answers ARP requests for sleeping machines, nil if disabled
+var arpProxy *arpproxy.Proxy
+
+// setupARPProxy validates the ARP proxy settings if enabled
+func setupARPProxy() error {
+	c := cfg.ARPProxy
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interface == "" {
+		return errors.New("invalid arp_proxy settings: interface is required")
+	}
+	if _, err := net.InterfaceByName(c.Interface); err != nil {
+		return errors.New("invalid arp_proxy settings: unknown interface " + c.Interface)
+	}
+	arpProxy = &arpproxy.Proxy{Interface: c.Interface, Sleeping: sleepingMachineMAC, Wake: wakeOnDemand}
+	return nil
+}
+
+// runARPProxy answers ARP requests in the background until stop is closed
+func runARPProxy(stop <-chan struct{}) {
+	if arpProxy == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		log.Printf("Answering ARP requests for sleeping machines on %s", cfg.ARPProxy.Interface)
+		if err := arpProxy.Serve(ctx); err != nil {
+			log.Printf("Error serving ARP proxy: %v", err)
+		}
+	}()
+}
+
+// arpProxyMachine returns the machine with the IP address that ARP requests
+// are answered for, and its MAC address
+func arpProxyMachine(ip netip.Addr) (config.Machine, net.HardwareAddr, bool) {
+	for _, machine := range allMachines() {
+		if !machine.ARPProxy || machine.Peer != "" || machine.IP == nil {
+			continue
+		}
+		addr, err := netip.ParseAddr(*machine.IP)
+		if err != nil || addr != ip {
+			continue
+		}
+		mac, err := net.ParseMAC(machine.Mac)
+		if err != nil {
+			continue
+		}
+		return machine, mac, true
+	}
+	return config.Machine{}, nil, false
+}
+
+// sleepingMachineMAC returns the MAC address of the machine with the IP
+// address if it is offline or being woken
+func sleepingMachineMAC(ip netip.Addr) (net.HardwareAddr, bool) {
+	machine, mac, ok := arpProxyMachine(ip)
+	if !ok {
+		return nil, false
+	}
+	// Machines nobody watches are only probed every background interval and
+	// may have gone to sleep since, so stale statuses are probed first
+	statuses := poller.current([]config.Machine{machine})
+	status := statuses[machine.Name]
+	return mac, status == "offline" || status == statusWaking
+}
+
+// wakeOnDemand wakes the machine another host is trying to reach
+func wakeOnDemand(ip, from netip.Addr) {
+	machine, _, ok := arpProxyMachine(ip)
+	if !ok {
+		return
+	}
+	log.Printf("Waking machine %s, %s is trying to reach it", machine.Name, from)
+	wakeAsService(context.Background(), "arp proxy", machine)
+}
+
+// announceOnlineMachines points hosts the ARP proxy answered back at the
+// machines that came online
+func announceOnlineMachines(changes []statusChange) {
+	if arpProxy == nil {
+		return
+	}
+	for _, change := range changes {
+		if change.Status != "online" {
+			continue
+		}
+		machine, ok := findMachine(change.Name)
+		if !ok || !machine.ARPProxy || machine.IP == nil {
+			continue
+		}
+		ip, err := netip.ParseAddr(*machine.IP)
+		if err != nil {
+			continue
+		}
+		if _, mac, ok := arpProxyMachine(ip); ok {
+			if err := arpProxy.Announce(ip, mac); err != nil {
+				log.Printf("Error announcing machine %s: %v", machine.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// assetVersionLength is the number of hex digits of a file's hash used as its version
+const assetVersionLength = 16
+
+// assetVersion is the hash of a static file as of its modification time and
+// size, so changed files in the static directory get a new version
+type assetVersion struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// assetHasher remembers the versions of static files
+type assetHasher struct {
+	mu       sync.Mutex
+	versions map[string]assetVersion
+}
+
+var assets = &assetHasher{versions: make(map[string]assetVersion)}
+
+// version returns the hash of the file's content, recomputed only when the
+// file changed
+func (a *assetHasher) version(fsys fs.FS, name string) (string, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	v, ok := a.versions[name]
+	if ok && v.modTime.Equal(info.ModTime()) && v.size == info.Size() {
+		return v.hash, nil
+	}
+
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	v = assetVersion{modTime: info.ModTime(), size: info.Size(), hash: hex.EncodeToString(sum[:])[:assetVersionLength]}
+	a.versions[name] = v
+	return v.hash, nil
+}
+
+// assetURL returns the URL of a static file including its version, so
+// browsers can keep it until it changes
+func assetURL(name string) string {
+	url := basePath + "/static/" + name
+	version, err := assets.version(staticFS(), name)
+	if err != nil {
+		log.Printf("Error reading static file %s: %v", name, err)
+		return url
+	}
+	return url + "?v=" + version
+}
+
+// handleStatic serves the bundled static files and the ones of the static
+// directory. Files requested with their current version are cached for a
+// year, others are revalidated with their hash as ETag.
+func handleStatic() http.Handler {
+	fsys := staticFS()
+	files := http.StripPrefix("/static/", http.FileServerFS(fsys))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		version, err := assets.version(fsys, strings.TrimPrefix(r.URL.Path, "/static/"))
+		if err == nil {
+			w.Header().Set("ETag", `"`+version+`"`)
+			if r.URL.Query().Get("v") == version {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
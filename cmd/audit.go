@@ -0,0 +1,78 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/trugamr/wol/audit"
+)
+
+const auditFilename = "audit.log"
+
+// auditLog records actions performed via the web interface
+var auditLog *audit.Log
+
+// newAuditLog returns the audit log located in the data directory
+func newAuditLog() *audit.Log {
+	return audit.New(filepath.Join(cfg.DataDir, auditFilename))
+}
+
+// recordAudit records an action performed by the user making the request
+func recordAudit(r *http.Request, action, target string, err error) {
+	p, _ := requestPrincipal(r)
+	recordAuditAs(r, p.Username, action, target, err)
+}
+
+// recordAuditAs records an action performed by the given user, e.g. for logins
+// where the request isn't authenticated yet
+func recordAuditAs(r *http.Request, user, action, target string, err error) {
+	entry := audit.Entry{
+		Action: action,
+		User:   user,
+		IP:     clientIP(r),
+		Target: target,
+		Result: audit.ResultSuccess,
+	}
+	recordAuditEntry(entry, err)
+}
+
+// recordAuditEntry records the entry, marking it as failed with the error if not nil
+func recordAuditEntry(entry audit.Entry, err error) {
+	if err != nil {
+		entry.Result = audit.ResultFailure
+		entry.Message = err.Error()
+	}
+
+	if auditLog == nil {
+		return
+	}
+	if err := auditLog.Record(entry); err != nil {
+		log.Printf("Error recording audit entry: %v", err)
+	}
+}
+
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Action: query.Get("action"),
+		User:   query.Get("user"),
+		Target: query.Get("target"),
+		Limit:  200,
+	}
+
+	entries, err := auditLog.Read(filter)
+	if err != nil {
+		log.Printf("Error reading audit log: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Entries": entries,
+		"Filter":  filter,
+	}
+	renderTemplate(w, r, "audit.html", data)
+}
@@ -0,0 +1,631 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trugamr/wol/auth"
+)
+
+const (
+	sessionCookieName = "session"
+	oidcCookieName    = "oidc_state"
+	sessionsFilename  = "sessions.db"
+)
+
+var (
+	// users verifies credentials of configured users
+	users *auth.Users
+	// sessions keeps track of users logged in via the login page
+	sessions *auth.Sessions
+	// tokens verifies API tokens sent as bearer tokens
+	tokens *auth.Tokens
+	// sso logs users in with an OpenID Connect provider, nil if not configured
+	sso *auth.OIDC
+	// directory verifies credentials against an LDAP server, nil if not configured
+	directory *auth.LDAP
+	// authProxies are allowed to pass the user's identity in headers
+	authProxies []netip.Prefix
+)
+
+// principalKey is the context key under which the authenticated principal is stored
+type principalKey struct{}
+
+// principal describes who made a request
+type principal struct {
+	// Username of the authenticated user
+	Username string
+	// Groups of the user as reported by an external provider
+	Groups []string
+	// SessionID is set when the user logged in via the login page
+	SessionID string
+	// TokenID is set when the request was made with an API token
+	TokenID string
+	// Proxy is set when the identity was passed by a trusted reverse proxy
+	Proxy bool
+	// External is set for users authenticated by a directory, identity
+	// provider or reverse proxy rather than as one of auth.users
+	External bool
+}
+
+// identity returns who the principal is, e.g. for tokens acting as them
+func (p principal) identity() auth.Identity {
+	return auth.Identity{Username: p.Username, Groups: p.Groups, External: p.External}
+}
+
+// closeSessions closes the database of persisted sessions
+func closeSessions() {
+	if sessions == nil {
+		return
+	}
+	err := sessions.Close()
+	if err != nil {
+		log.Printf("Error closing sessions: %v", err)
+	}
+}
+
+// setupAuth prepares authentication state from the config
+func setupAuth() error {
+	if cfg.Auth.Disabled {
+		log.Printf("Authentication is disabled, anyone who can reach the server can wake machines")
+		return nil
+	}
+
+	// Refuse to start without credentials unless auth is explicitly disabled
+	if len(cfg.Auth.Users) == 0 && cfg.Auth.OIDC.Issuer == "" && cfg.Auth.LDAP.URL == "" && len(cfg.Auth.ProxyAuth.TrustedProxies) == 0 {
+		return fmt.Errorf("no users configured, add auth.users or set auth.disabled to true")
+	}
+
+	list := make([]auth.User, 0, len(cfg.Auth.Users))
+	for _, user := range cfg.Auth.Users {
+		list = append(list, auth.User{Username: user.Username, PasswordHash: user.PasswordHash})
+	}
+	users = auth.NewUsers(list)
+
+	var err error
+	authProxies, err = parsePrefixes(cfg.Auth.ProxyAuth.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid auth.proxy_auth.trusted_proxies: %w", err)
+	}
+	if cfg.Auth.Session.Persistent {
+		sessions, err = auth.OpenSessions(filepath.Join(cfg.DataDir, sessionsFilename), cfg.Auth.Session.IdleTimeout)
+	} else {
+		sessions, err = auth.NewSessions(cfg.Auth.Session.IdleTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	tokens = newTokenStore()
+	secondFactor = auth.NewTOTPStore(filepath.Join(cfg.DataDir, totpFilename), "wol")
+	challenges = auth.NewChallenges()
+
+	if cfg.Auth.OIDC.Issuer != "" {
+		sso = auth.NewOIDC(auth.OIDCConfig{
+			Issuer:        cfg.Auth.OIDC.Issuer,
+			ClientID:      cfg.Auth.OIDC.ClientID,
+			ClientSecret:  cfg.Auth.OIDC.ClientSecret,
+			RedirectURL:   cfg.Auth.OIDC.RedirectURL,
+			Scopes:        cfg.Auth.OIDC.Scopes,
+			UsernameClaim: cfg.Auth.OIDC.UsernameClaim,
+			GroupsClaim:   cfg.Auth.OIDC.GroupsClaim,
+			AllowedGroups: cfg.Auth.OIDC.AllowedGroups,
+		})
+	}
+
+	if cfg.Auth.LDAP.URL != "" {
+		if len(cfg.Auth.LDAP.BindDN) == 0 {
+			return fmt.Errorf("auth.ldap.bind_dn must contain at least one template")
+		}
+		directory = auth.NewLDAP(auth.LDAPConfig{
+			URL:                cfg.Auth.LDAP.URL,
+			StartTLS:           cfg.Auth.LDAP.StartTLS,
+			InsecureSkipVerify: cfg.Auth.LDAP.InsecureSkipVerify,
+			Timeout:            cfg.Auth.LDAP.Timeout,
+			BindDNTemplates:    cfg.Auth.LDAP.BindDN,
+			UserBaseDN:         cfg.Auth.LDAP.UserBaseDN,
+			UserFilter:         cfg.Auth.LDAP.UserFilter,
+			GroupBaseDN:        cfg.Auth.LDAP.GroupBaseDN,
+			GroupFilter:        cfg.Auth.LDAP.GroupFilter,
+			GroupNameAttribute: cfg.Auth.LDAP.GroupNameAttribute,
+			AllowedGroups:      cfg.Auth.LDAP.AllowedGroups,
+		})
+	}
+
+	return nil
+}
+
+// authMiddleware requires requests to carry a valid session or basic auth credentials
+func authMiddleware(next http.Handler) http.Handler {
+	if cfg.Auth.Disabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only requests carrying credentials count as attempts, not expired sessions
+		credentials := r.Header.Get("Authorization") != ""
+		if credentials && rateLimited(w, r) {
+			if isAPIRequest(r) {
+				writeAPIError(w, http.StatusTooManyRequests, "rate_limited", "Too many failed attempts, try again later")
+				return
+			}
+			http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		p, ok := authenticate(r)
+		if ok {
+			if credentials {
+				authSucceeded(r)
+			}
+			setAccessLogUser(r, p.Username)
+			ctx := context.WithValue(r.Context(), principalKey{}, p)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if credentials {
+			authFailed(r, "Failed authentication to %s %s", r.Method, r.URL.Path)
+		}
+
+		// Browsers navigating to a page are sent to the login form
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, appURL("/login?next=")+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		if cfg.Auth.Basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		}
+		if isAPIRequest(r) {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized", "Missing or invalid credentials")
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// authenticate identifies the principal of a request using the session cookie or basic auth
+func authenticate(r *http.Request) (principal, bool) {
+	// Identity headers are only trusted when set by a known proxy
+	if isAuthProxy(r) {
+		p, ok := proxyPrincipal(r)
+		if ok {
+			return p, true
+		}
+	}
+
+	// API tokens take precedence as scripts never have a session
+	header := r.Header.Get("Authorization")
+	if plaintext, ok := strings.CutPrefix(header, "Bearer "); ok {
+		token, ok := authenticateToken(plaintext)
+		if !ok {
+			return principal{}, false
+		}
+		return principal{Username: token.Username, Groups: token.Groups, TokenID: token.ID, External: token.External}, true
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err == nil {
+		session, ok := sessions.Get(cookie.Value)
+		// Sessions survive restarts, so those of local users removed from
+		// the config in the meantime are ended
+		if ok && !session.External && !userExists(session.Username) {
+			if err := sessions.Delete(cookie.Value); err != nil {
+				log.Printf("Error deleting session of removed user %s: %v", session.Username, err)
+			}
+			ok = false
+		}
+		if ok {
+			return principal{Username: session.Username, Groups: session.Groups, SessionID: session.ID, External: session.External}, true
+		}
+	}
+
+	if cfg.Auth.Basic {
+		username, password, ok := r.BasicAuth()
+		if ok {
+			identity, ok := checkPassword(username, password)
+			// Basic auth can't carry a second factor, those users need the login page or a token
+			if ok && !requiresSecondFactor(identity.Username) {
+				return principal{Username: identity.Username, Groups: identity.Groups, External: identity.External}, true
+			}
+		}
+	}
+
+	return principal{}, false
+}
+
+// authenticateToken returns the token with the plaintext value if it's valid.
+// Tokens of local users removed from the config stop working, users from an
+// external provider can't be checked.
+func authenticateToken(plaintext string) (auth.Token, bool) {
+	token, ok := tokens.Authenticate(strings.TrimSpace(plaintext))
+	if !ok || (!token.External && !userExists(token.Username)) {
+		return auth.Token{}, false
+	}
+	return token, true
+}
+
+// isAuthProxy reports whether the directly connected peer may pass the user's
+// identity in headers. Like for forwarding headers, peers connected via a Unix
+// domain socket are local and trusted once proxy auth is enabled.
+func isAuthProxy(r *http.Request) bool {
+	if len(authProxies) == 0 {
+		return false
+	}
+	addr := peerAddr(r)
+	if !addr.IsValid() {
+		return isUnixPeer(r)
+	}
+	return prefixesContain(authProxies, addr)
+}
+
+// proxyPrincipal returns the principal from the identity headers set by a reverse proxy
+func proxyPrincipal(r *http.Request) (principal, bool) {
+	for _, header := range cfg.Auth.ProxyAuth.UserHeaders {
+		username := strings.TrimSpace(r.Header.Get(header))
+		if username == "" {
+			continue
+		}
+
+		var groups []string
+		if cfg.Auth.ProxyAuth.GroupsHeader != "" {
+			for _, group := range strings.Split(r.Header.Get(cfg.Auth.ProxyAuth.GroupsHeader), ",") {
+				group = strings.TrimSpace(group)
+				if group != "" {
+					groups = append(groups, group)
+				}
+			}
+		}
+
+		return principal{Username: username, Groups: groups, Proxy: true, External: true}, true
+	}
+
+	return principal{}, false
+}
+
+// checkPassword verifies credentials against local users and then the LDAP server
+func checkPassword(username, password string) (auth.Identity, bool) {
+	if users.Authenticate(username, password) {
+		return auth.Identity{Username: username}, true
+	}
+
+	// Local users can't be taken over by a directory account of the same name
+	if directory == nil || userExists(username) {
+		return auth.Identity{}, false
+	}
+
+	identity, err := directory.Authenticate(username, password)
+	if err != nil {
+		log.Printf("LDAP authentication failed: %v", err)
+		return auth.Identity{}, false
+	}
+
+	return identity, true
+}
+
+// requestPrincipal returns the authenticated principal of the request if any
+func requestPrincipal(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// requireInteractive only allows requests made by a user in a browser, e.g.
+// for pages that manage credentials and shouldn't be reachable with a token
+func requireInteractive(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if interactiveUser(r) == "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// interactiveUser returns the username if the request was made with a login
+// session or passed by a reverse proxy, both of which mean a user in a browser
+func interactiveUser(r *http.Request) string {
+	p, ok := requestPrincipal(r)
+	if !ok || (p.SessionID == "" && !p.Proxy) {
+		return ""
+	}
+	return p.Username
+}
+
+func handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	if cfg.Auth.Disabled {
+		http.Redirect(w, r, appURL("/"), http.StatusSeeOther)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Next":     safeRedirect(r.URL.Query().Get("next")),
+		"SSO":      sso != nil,
+		"Password": passwordLogin(),
+	}
+	renderTemplate(w, r, "login.html", data)
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.Auth.Disabled {
+		http.Redirect(w, r, appURL("/"), http.StatusSeeOther)
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	next := safeRedirect(r.FormValue("next"))
+
+	if rateLimited(w, r) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		data := map[string]interface{}{
+			"Error":    "Too many failed attempts, wait a moment and try again",
+			"Username": username,
+			"Next":     next,
+			"SSO":      sso != nil,
+			"Password": passwordLogin(),
+		}
+		renderTemplate(w, r, "login.html", data)
+		return
+	}
+
+	identity, ok := checkPassword(username, password)
+	if !ok {
+		authFailed(r, "Failed login for user %q", username)
+		recordAuditAs(r, username, "login", "", errors.New("invalid username or password"))
+		w.WriteHeader(http.StatusUnauthorized)
+		data := map[string]interface{}{
+			"Error":    "Invalid username or password",
+			"Username": username,
+			"Next":     next,
+			"SSO":      sso != nil,
+			"Password": passwordLogin(),
+		}
+		renderTemplate(w, r, "login.html", data)
+		return
+	}
+
+	authSucceeded(r)
+
+	// Users with a second factor have to enter a code before getting a session
+	enabled, err := secondFactor.Enabled(identity.Username)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		startChallenge(w, r, identity, next)
+		return
+	}
+
+	recordAuditAs(r, identity.Username, "login", "", nil)
+	startSession(w, r, identity, next)
+}
+
+// passwordLogin reports whether users can log in with a username and password
+func passwordLogin() bool {
+	return len(cfg.Auth.Users) > 0 || directory != nil
+}
+
+// startSession logs the user in by setting a session cookie and redirects to next
+func startSession(w http.ResponseWriter, r *http.Request, identity auth.Identity, next string) {
+	session, err := sessions.Create(identity)
+	if err != nil {
+		log.Printf("Error creating session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	setAccessLogUser(r, identity.Username)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session.ID,
+		Path:     appURL("/"),
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, appURL(next), http.StatusSeeOther)
+}
+
+// secureCookies reports whether cookies should only be sent over HTTPS
+func secureCookies(r *http.Request) bool {
+	return cfg.Auth.Session.SecureCookie || r.TLS != nil
+}
+
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if sso == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	target, state, err := sso.AuthCodeURL(r.Context(), safeRedirect(r.URL.Query().Get("next")))
+	if err != nil {
+		log.Printf("Error starting single sign-on: %v", err)
+		http.Error(w, "Single sign-on is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Bind the login to this browser so a callback can't be replayed elsewhere
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    state,
+		Path:     appURL("/oidc"),
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   600,
+	})
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if sso == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil || cookie.Value != state {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Value: "", Path: appURL("/oidc"), MaxAge: -1})
+
+	if message := r.URL.Query().Get("error"); message != "" {
+		log.Printf("Single sign-on failed: %s %s", message, r.URL.Query().Get("error_description"))
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+
+	identity, next, err := sso.Exchange(r.Context(), state, r.URL.Query().Get("code"))
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		recordAuditAs(r, "", "login.sso", "", err)
+		http.Error(w, "Single sign-on failed", http.StatusUnauthorized)
+		return
+	}
+	// Local users can't be taken over by an account of the same name at the
+	// provider, whose users can often choose their username themselves
+	if userExists(identity.Username) {
+		err := fmt.Errorf("user %q of the identity provider has the name of a local user", identity.Username)
+		log.Printf("Single sign-on failed: %v", err)
+		recordAuditAs(r, identity.Username, "login.sso", "", err)
+		http.Error(w, "Single sign-on failed", http.StatusForbidden)
+		return
+	}
+	log.Printf("User %q logged in via single sign-on", identity.Username)
+	recordAuditAs(r, identity.Username, "login.sso", "", nil)
+
+	startSession(w, r, identity, next)
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err == nil && sessions != nil {
+		if session, ok := sessions.Get(cookie.Value); ok {
+			recordAuditAs(r, session.Username, "logout", "", nil)
+		}
+		err := sessions.Delete(cookie.Value)
+		if err != nil {
+			log.Printf("Error ending session: %v", err)
+		}
+	}
+
+	// Clear the cookie
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     appURL("/"),
+		HttpOnly: true,
+		Expires:  time.Now().Add(-1 * time.Hour),
+	})
+
+	http.Redirect(w, r, appURL("/login"), http.StatusSeeOther)
+}
+
+// safeRedirect returns target if it is a local path, otherwise the index page
+func safeRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+func handleTokens(w http.ResponseWriter, r *http.Request) {
+	renderTokens(w, r, "")
+}
+
+// renderTokens shows the tokens of the logged in user, newToken is shown once after creation
+func renderTokens(w http.ResponseWriter, r *http.Request, newToken string) {
+	username := interactiveUser(r)
+
+	list, err := tokens.List()
+	if err != nil {
+		log.Printf("Error listing tokens: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	owned := make([]auth.Token, 0, len(list))
+	for _, token := range list {
+		if token.Username == username {
+			owned = append(owned, token)
+		}
+	}
+
+	data := map[string]interface{}{
+		"Tokens":       owned,
+		"NewToken":     newToken,
+		"FlashMessage": consumeFlashMessage(w, r),
+	}
+	renderTemplate(w, r, "tokens.html", data)
+}
+
+func handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Token name is required", http.StatusBadRequest)
+		return
+	}
+
+	p, _ := requestPrincipal(r)
+	plaintext, token, err := tokens.Create(name, p.identity())
+	if err != nil {
+		log.Printf("Error creating token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %q created token %s (%s)", token.Username, token.ID, token.Name)
+	recordAudit(r, "token.create", token.ID, nil)
+
+	renderTokens(w, r, plaintext)
+}
+
+func handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	username := interactiveUser(r)
+
+	// Users can only revoke their own tokens
+	list, err := tokens.List()
+	if err != nil {
+		log.Printf("Error listing tokens: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, token := range list {
+		if token.ID == id && token.Username == username {
+			found = true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	err = tokens.Revoke(id)
+	if err != nil {
+		log.Printf("Error revoking token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %q revoked token %s", username, id)
+	recordAudit(r, "token.revoke", id, nil)
+
+	setFlashMessage(w, "Token revoked.")
+	http.Redirect(w, r, appURL("/tokens"), http.StatusSeeOther)
+}
@@ -0,0 +1,271 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trugamr/wol/auth"
+	"github.com/trugamr/wol/config"
+)
+
+// fakeIdP is an OpenID Connect provider issuing ID tokens for a username
+type fakeIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	username string
+	nonce    string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idp.idToken(t),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// idToken returns a signed ID token for the username
+func (idp *fakeIdP) idToken(t *testing.T) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":                idp.server.URL,
+		"aud":                "wol",
+		"sub":                "1234",
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              idp.nonce,
+		"preferred_username": idp.username,
+	})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login runs the single sign-on flow of the user and returns the callback's response
+func (idp *fakeIdP) login(t *testing.T, username string) *http.Response {
+	t.Helper()
+	idp.username = username
+
+	rec := httptest.NewRecorder()
+	handleOIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+	target, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(target.String(), idp.server.URL) {
+		t.Fatalf("login redirected to %q, want the provider", rec.Header().Get("Location"))
+	}
+	idp.nonce = target.Query().Get("nonce")
+	state := target.Query().Get("state")
+
+	req := httptest.NewRequest(http.MethodGet, "/oidc/callback?code=code&state="+url.QueryEscape(state), nil)
+	req.AddCookie(&http.Cookie{Name: oidcCookieName, Value: state})
+	rec = httptest.NewRecorder()
+	handleOIDCCallback(rec, req)
+	return rec.Result()
+}
+
+// sessionCookie returns the session cookie set by the response, nil if none
+func sessionCookie(resp *http.Response) *http.Cookie {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == sessionCookieName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestOIDCCallbackRejectsLocalUsername(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	oldCfg, oldSSO, oldSessions := cfg, sso, sessions
+	t.Cleanup(func() { cfg, sso, sessions = oldCfg, oldSSO, oldSessions })
+	cfg = config.NewConfig()
+	cfg.Auth.Users = []config.User{{Username: "admin", PasswordHash: "$2a$10$unused", Role: "admin"}}
+	var err error
+	sessions, err = auth.NewSessions(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sso = auth.NewOIDC(auth.OIDCConfig{
+		Issuer:        idp.server.URL,
+		ClientID:      "wol",
+		RedirectURL:   "http://wol.example.com/oidc/callback",
+		UsernameClaim: "preferred_username",
+	})
+
+	resp := idp.login(t, "admin")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("login of provider user named like a local admin returned %s, want 403", resp.Status)
+	}
+	if sessionCookie(resp) != nil {
+		t.Error("login of provider user named like a local admin started a session")
+	}
+
+	resp = idp.login(t, "bob")
+	if resp.StatusCode != http.StatusSeeOther || sessionCookie(resp) == nil {
+		t.Errorf("login of provider user bob returned %s without a session, want a redirect with one", resp.Status)
+	}
+}
+
+func TestTokensOfRemovedLocalUsersStopWorking(t *testing.T) {
+	oldCfg, oldTokens, oldSSO := cfg, tokens, sso
+	t.Cleanup(func() { cfg, tokens, sso = oldCfg, oldTokens, oldSSO })
+	cfg = config.NewConfig()
+	cfg.Auth.Users = []config.User{{Username: "alice", PasswordHash: "$2a$10$unused"}}
+	tokens = auth.NewTokens(filepath.Join(t.TempDir(), tokensFilename))
+	// Users from a provider don't make tokens of removed local users valid
+	sso = auth.NewOIDC(auth.OIDCConfig{Issuer: "https://idp.example.com", ClientID: "wol"})
+
+	local, _, err := tokens.Create("script", auth.Identity{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	external, _, err := tokens.Create("script", auth.Identity{Username: "bob", External: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearer := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/machines", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+	if _, ok := authenticate(bearer(local)); !ok {
+		t.Error("token of local user alice was rejected")
+	}
+	cfg.Auth.Users = nil
+	if p, ok := authenticate(bearer(local)); ok {
+		t.Errorf("token of removed local user alice authenticated %+v", p)
+	}
+	if _, ok := authenticate(bearer(external)); !ok {
+		t.Error("token of external user bob was rejected")
+	}
+}
+
+func TestProxyAuthOverUnixSocket(t *testing.T) {
+	oldCfg, oldProxies := cfg, authProxies
+	t.Cleanup(func() { cfg, authProxies = oldCfg, oldProxies })
+	cfg = config.NewConfig()
+	cfg.Auth.ProxyAuth.UserHeaders = []string{"Remote-User"}
+	authProxies = nil
+
+	unixReq := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "@"
+		local := &net.UnixAddr{Name: "/run/wol/wol.sock", Net: "unix"}
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+		req.Header.Set("Remote-User", "alice")
+		return req
+	}
+	if _, ok := authenticate(unixReq()); ok {
+		t.Error("identity headers were trusted without proxy auth enabled")
+	}
+
+	authProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}
+	p, ok := authenticate(unixReq())
+	if !ok || p.Username != "alice" || !p.Proxy {
+		t.Errorf("identity headers over a Unix socket gave %+v, want proxy user alice", p)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Remote-User", "alice")
+	if p, ok := authenticate(req); ok {
+		t.Errorf("identity headers from untrusted %s gave %+v", req.RemoteAddr, p)
+	}
+}
+
+func TestSessionsOfRemovedLocalUsersEnd(t *testing.T) {
+	oldCfg, oldSessions := cfg, sessions
+	t.Cleanup(func() { cfg, sessions = oldCfg, oldSessions })
+	cfg = config.NewConfig()
+	cfg.Auth.Users = []config.User{{Username: "alice", PasswordHash: "$2a$10$unused"}}
+	var err error
+	sessions, err = auth.OpenSessions(filepath.Join(t.TempDir(), sessionsFilename), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessions.Close() })
+
+	local, err := sessions.Create(auth.Identity{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	external, err := sessions.Create(auth.Identity{Username: "bob", External: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withSession := func(id string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: id})
+		return req
+	}
+	if _, ok := authenticate(withSession(local.ID)); !ok {
+		t.Error("session of local user alice was rejected")
+	}
+	cfg.Auth.Users = nil
+	if p, ok := authenticate(withSession(local.ID)); ok {
+		t.Errorf("session of removed local user alice authenticated %+v", p)
+	}
+	if _, ok := sessions.Get(local.ID); ok {
+		t.Error("session of removed local user alice was kept")
+	}
+	if _, ok := authenticate(withSession(external.ID)); !ok {
+		t.Error("session of external user bob was rejected")
+	}
+}
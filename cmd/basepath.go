@@ -0,0 +1,56 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// basePath is the normalized path prefix the web interface is served under,
+// empty when served at the root
+var basePath string
+
+// setupBasePath normalizes the configured base path to start with a slash and
+// not end with one
+func setupBasePath() {
+	basePath = strings.Trim(cfg.Server.BasePath, "/")
+	if basePath != "" {
+		basePath = "/" + basePath
+	}
+}
+
+// appURL returns the URL of a path of the web interface, taking the base path into account
+func appURL(path string) string {
+	return basePath + path
+}
+
+// basePathMiddleware removes the base path from requests, requests without it
+// are passed on as they are to support proxies that strip the prefix themselves
+func basePathMiddleware(next http.Handler) http.Handler {
+	if basePath == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == basePath {
+			http.Redirect(w, r, basePath+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		path, ok := strings.CutPrefix(r.URL.Path, basePath+"/")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/" + path
+		r2.URL.RawPath = ""
+		next.ServeHTTP(w, r2)
+	})
+}
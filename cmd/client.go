@@ -0,0 +1,81 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/client"
+	"github.com/trugamr/wol/config"
+)
+
+func init() {
+	rootCmd.PersistentFlags().String("server", "", "Use the API of this wol server instead of the local config, e.g. https://wol.example.com (or WOL_SERVER)")
+	rootCmd.PersistentFlags().String("token", "", "API or agent token for --server, preferably set in WOL_TOKEN")
+}
+
+// remoteClient returns a client for the server given with --server or
+// WOL_SERVER, nil if commands work with the local config
+func remoteClient(cmd *cobra.Command) *client.Client {
+	server, token := remoteServer(cmd)
+	if server == "" {
+		return nil
+	}
+	return client.New(server, token)
+}
+
+// remoteServer returns the server and token given with the flags or
+// environment variables, the server is empty if none was given
+func remoteServer(cmd *cobra.Command) (server, token string) {
+	server, _ = cmd.Flags().GetString("server")
+	if server == "" {
+		server = os.Getenv("WOL_SERVER")
+	}
+	token, _ = cmd.Flags().GetString("token")
+	if token == "" {
+		token = os.Getenv("WOL_TOKEN")
+	}
+	return server, token
+}
+
+// wakeRemote asks the server given with --server to wake the machine given
+// with --name, it returns false if commands work with the local config
+func wakeRemote(cmd *cobra.Command) bool {
+	c := remoteClient(cmd)
+	if c == nil {
+		return false
+	}
+
+	name, _ := cmd.Flags().GetString("name")
+	if name == "" || cmd.Flags().Changed("ip") || cmd.Flags().Changed("port") {
+		cobra.CheckErr(fmt.Errorf("only --name can be used with --server"))
+	}
+	machine, err := c.Wake(cmd.Context(), name)
+	if err != nil {
+		cobra.CheckErr(fmt.Errorf("failed to wake %s: %w", name, err))
+	}
+	log.Printf("Magic packet sent to %s by the server", machine.Name)
+	return true
+}
+
+// remoteMachines returns the machines of the server given with --server,
+// false if commands work with the local config
+func remoteMachines(cmd *cobra.Command) ([]config.Machine, bool) {
+	c := remoteClient(cmd)
+	if c == nil {
+		return nil, false
+	}
+
+	remote, err := c.Machines(cmd.Context())
+	if err != nil {
+		cobra.CheckErr(fmt.Errorf("failed to list machines: %w", err))
+	}
+	machines := make([]config.Machine, 0, len(remote))
+	for _, machine := range remote {
+		machines = append(machines, config.Machine{Name: machine.Name, Mac: machine.Mac, IP: machine.IP, Group: machine.Group, Tags: machine.Tags})
+	}
+	return machines, true
+}
@@ -0,0 +1,134 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parsePrefixes parses CIDR ranges, single addresses are treated as a range of one
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", value, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: %w", value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// prefixesContain reports whether any of the prefixes contains the address
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerAddr returns the address of the directly connected peer
+func peerAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// trustedProxies are allowed to pass the client address in forwarding headers
+var trustedProxies []netip.Prefix
+
+// setupTrustedProxies parses the configured trusted proxies
+func setupTrustedProxies() error {
+	var err error
+	trustedProxies, err = parsePrefixes(cfg.Server.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid server.trusted_proxies: %w", err)
+	}
+	return nil
+}
+
+// isTrustedProxy reports whether the directly connected peer is a trusted proxy,
+// peers connected via a Unix domain socket are local and always trusted
+func isTrustedProxy(r *http.Request) bool {
+	addr := peerAddr(r)
+	if !addr.IsValid() {
+		return isUnixPeer(r)
+	}
+	return prefixesContain(trustedProxies, addr)
+}
+
+// isUnixPeer reports whether the request was received on a Unix domain socket
+func isUnixPeer(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && local.Network() == "unix"
+}
+
+// forwardedAddr returns the client address from the forwarding headers, going
+// through X-Forwarded-For from the right and skipping trusted proxies so
+// clients can't spoof their address by sending the header themselves
+func forwardedAddr(r *http.Request) (netip.Addr, bool) {
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addr.Unmap()
+		if i == 0 || !prefixesContain(trustedProxies, addr) {
+			return addr, true
+		}
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientAddr returns the address of the client making the request, taken from
+// the forwarding headers if the request was passed on by a trusted proxy
+func clientAddr(r *http.Request) netip.Addr {
+	if isTrustedProxy(r) {
+		addr, ok := forwardedAddr(r)
+		if ok {
+			return addr
+		}
+	}
+	return peerAddr(r)
+}
+
+// clientIP returns the address of the client making the request
+func clientIP(r *http.Request) string {
+	addr := clientAddr(r)
+	if !addr.IsValid() {
+		return r.RemoteAddr
+	}
+	return addr.String()
+}
@@ -0,0 +1,173 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// compressibleTypes are the content types worth compressing
+var compressibleTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/event-stream",
+	"application/json",
+	"application/javascript",
+	"text/javascript",
+	"image/svg+xml",
+}
+
+// compressor is implemented by gzip.Writer and zlib.Writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter compresses the response if its content type is compressible
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	writer   compressor
+	decided  bool
+}
+
+// decide checks once the headers are final whether to compress the response
+func (c *compressWriter) decide(status int) {
+	c.decided = true
+
+	h := c.Header()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	if h.Get("Content-Encoding") != "" || !isCompressible(h.Get("Content-Type")) {
+		return
+	}
+
+	h.Set("Content-Encoding", c.encoding)
+	h.Del("Content-Length")
+	// The compressed bytes differ, so only a weak ETag still holds
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	if c.encoding == "gzip" {
+		c.writer = gzip.NewWriter(c.ResponseWriter)
+	} else {
+		// HTTP's deflate is the zlib format, not raw DEFLATE
+		c.writer = zlib.NewWriter(c.ResponseWriter)
+	}
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if !c.decided {
+		c.decide(status)
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.decided {
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.writer == nil {
+		return c.ResponseWriter.Write(b)
+	}
+	return c.writer.Write(b)
+}
+
+// Flush sends the data compressed so far, needed by the status event stream
+func (c *compressWriter) Flush() {
+	if c.writer != nil {
+		c.writer.Flush()
+	}
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack is needed by the status WebSocket, the connection is never compressed
+func (c *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := c.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	c.decided = true
+	return hijacker.Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// close writes the remaining compressed data
+func (c *compressWriter) close() error {
+	if c.writer == nil {
+		return nil
+	}
+	return c.writer.Close()
+}
+
+// isCompressible reports whether responses with the content type should be compressed
+func isCompressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	for _, t := range compressibleTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedEncoding returns the preferred compression supported by the client
+func acceptedEncoding(r *http.Request) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(params, " ", "") == "q=0" {
+			continue
+		}
+		accepted[strings.ToLower(name)] = true
+	}
+
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// compressMiddleware compresses responses with gzip or deflate when the client accepts it
+func compressMiddleware(next http.Handler) http.Handler {
+	if !cfg.Server.Compression {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := acceptedEncoding(r)
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,54 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/trugamr/wol/config"
+)
+
+func TestCompressMiddlewareEncodings(t *testing.T) {
+	oldCfg := cfg
+	t.Cleanup(func() { cfg = oldCfg })
+	cfg = config.NewConfig()
+	cfg.Server.Compression = true
+
+	const body = `{"machines": ["desktop", "nas", "printer"]}`
+	handler := compressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	for _, test := range []struct {
+		encoding string
+		reader   func(io.Reader) (io.ReadCloser, error)
+	}{
+		{"gzip", func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }},
+		{"deflate", zlib.NewReader},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/machines", nil)
+		req.Header.Set("Accept-Encoding", test.encoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("Content-Encoding is %q, want %q", got, test.encoding)
+			continue
+		}
+		reader, err := test.reader(rec.Body)
+		if err != nil {
+			t.Errorf("%s response can't be decoded: %v", test.encoding, err)
+			continue
+		}
+		decoded, err := io.ReadAll(reader)
+		if err != nil || string(decoded) != body {
+			t.Errorf("%s response decoded to %q (%v), want %q", test.encoding, decoded, err, body)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/container"
+	"github.com/trugamr/wol/probe"
+)
+
+// containerTimeout limits how long a request to Docker or Podman may take,
+// long enough for containers to stop
+const containerTimeout = time.Minute
+
+func init() {
+	registerMachineType(config.MachineTypeContainer, machineType{
+		wake: func(ctx context.Context, machine config.Machine) error {
+			c, err := machineContainer(machine)
+			if err != nil {
+				return err
+			}
+			return c.Start(ctx)
+		},
+		prober: func(machine config.Machine) (probe.Prober, error) {
+			c, err := machineContainer(machine)
+			if err != nil {
+				return nil, err
+			}
+			return containerProber{container: c}, nil
+		},
+		power: func(ctx context.Context, machine config.Machine, status string) error {
+			c, err := machineContainer(machine)
+			if err != nil {
+				return err
+			}
+			if status == statusRebooting {
+				return c.Restart(ctx)
+			}
+			return c.Stop(ctx)
+		},
+	})
+}
+
+// machineContainer returns the container of the machine
+func machineContainer(machine config.Machine) (*container.Container, error) {
+	return container.New(container.Config{
+		Endpoint: machine.Container.Endpoint,
+		Name:     machine.Container.Name,
+		Timeout:  containerTimeout,
+	})
+}
+
+// containerProber reports containers online while they are running, paused
+// ones count as offline
+type containerProber struct {
+	container *container.Container
+}
+
+func (p containerProber) Probe(ctx context.Context) (probe.Result, error) {
+	state, err := p.container.State(ctx)
+	if err != nil {
+		return probe.Result{}, err
+	}
+	return probe.Result{Online: state.Running}, nil
+}
@@ -0,0 +1,92 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsPaths are the API endpoints that can be called from other origins
+var corsPaths = []string{"/wake", "/status", apiPrefix}
+
+// corsOrigin returns the value of Access-Control-Allow-Origin for the origin
+// or an empty string if the origin isn't allowed
+func corsOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	wildcard := false
+	for _, allowed := range cfg.Server.CORS.AllowedOrigins {
+		if allowed == "*" {
+			wildcard = true
+			continue
+		}
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	if wildcard {
+		return "*"
+	}
+	return ""
+}
+
+// isCORSPath reports whether the path is an API endpoint that allows cross-origin requests
+func isCORSPath(path string) bool {
+	for _, p := range corsPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMiddleware answers preflight requests and adds CORS headers to
+// responses of the API endpoints for the configured origins
+func corsMiddleware(next http.Handler) http.Handler {
+	if len(cfg.Server.CORS.AllowedOrigins) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isCORSPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		origin := corsOrigin(r.Header.Get("Origin"))
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		// Credentials are never shared with any origin, only with listed ones
+		if cfg.Server.CORS.AllowCredentials && origin != "*" {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// Preflight requests carry no credentials so they are answered before authentication
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !slices.Contains(cfg.Server.CORS.AllowedMethods, method) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(cfg.Server.CORS.AllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.Server.CORS.AllowedHeaders, ", "))
+			if cfg.Server.CORS.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.Server.CORS.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,105 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfCookieName = "csrf"
+	csrfFieldName  = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// csrfKey is the context key under which the CSRF token of a request is stored
+type csrfKey struct{}
+
+// csrfMiddleware protects state changing requests against cross-site request
+// forgery using a token stored in a cookie that forms have to echo back
+func csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		cookie, err := r.Cookie(csrfCookieName)
+		if err == nil && cookie.Value != "" {
+			token = cookie.Value
+		} else {
+			token = newCSRFToken()
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     appURL("/"),
+				HttpOnly: true,
+				Secure:   secureCookies(r),
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		if !isSafeMethod(r.Method) && !validCSRF(r, token) {
+			log.Printf("Rejected cross-site request to %s %s", r.Method, r.URL.Path)
+			if isAPIRequest(r) {
+				writeAPIError(w, http.StatusForbidden, "csrf", "Invalid or missing CSRF token")
+				return
+			}
+			http.Error(w, "Forbidden: invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), csrfKey{}, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validCSRF reports whether a state changing request may proceed
+func validCSRF(r *http.Request, token string) bool {
+	// Bearer tokens are never attached by browsers on their own
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+
+	// Origins explicitly allowed to call the API are trusted to make requests
+	if origin := corsOrigin(r.Header.Get("Origin")); origin != "" && origin != "*" && isCORSPath(r.URL.Path) {
+		return true
+	}
+
+	submitted := r.Header.Get(csrfHeaderName)
+	if submitted == "" {
+		submitted = r.PostFormValue(csrfFieldName)
+	}
+	if submitted != "" {
+		return subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) == 1
+	}
+
+	// Browsers always send these headers, their absence means a script like
+	// curl which can't be tricked into making a request by another website
+	return r.Header.Get("Origin") == "" && r.Header.Get("Sec-Fetch-Site") == ""
+}
+
+// isSafeMethod reports whether the method doesn't change state
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// csrfToken returns the CSRF token forms in the response have to include
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// newCSRFToken returns a new random CSRF token
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,89 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"sync"
+
+	"github.com/trugamr/wol/config"
+)
+
+// debounceState is the status shown for a machine and the different result
+// waiting to be confirmed
+type debounceState struct {
+	shown   string
+	pending string
+	count   int
+}
+
+// statusDebouncer keeps showing the previous status of a machine until the
+// check returned a different one enough times in a row, so a single dropped
+// ping doesn't make the machine flicker offline and send notifications
+type statusDebouncer struct {
+	mu     sync.Mutex
+	states map[string]*debounceState
+}
+
+var statusDebounce = &statusDebouncer{states: make(map[string]*debounceState)}
+
+// statusThresholds returns how many results in a row it takes for the machine
+// to be shown online and offline
+func statusThresholds(machine config.Machine) (online, offline int) {
+	online, offline = cfg.Server.OnlineAfter, cfg.Server.OfflineAfter
+	if check := machine.Check; check != nil {
+		if check.OnlineAfter > 0 {
+			online = check.OnlineAfter
+		}
+		if check.OfflineAfter > 0 {
+			offline = check.OfflineAfter
+		}
+	}
+	return max(online, 1), max(offline, 1)
+}
+
+// apply replaces the probed statuses of the machines with the ones to show.
+// Only changes between online and offline are delayed, other statuses such as
+// unknown or shutting-down are shown right away and the first result of a
+// machine is trusted.
+func (d *statusDebouncer) apply(machines []config.Machine, statuses map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, machine := range machines {
+		status, ok := statuses[machine.Name]
+		if !ok {
+			// Machines that couldn't be probed keep their state
+			continue
+		}
+
+		state := d.states[machine.Name]
+		if state == nil || !debounced(status) || !debounced(state.shown) {
+			d.states[machine.Name] = &debounceState{shown: status}
+			continue
+		}
+		if status == state.shown {
+			state.pending, state.count = "", 0
+			continue
+		}
+
+		if status != state.pending {
+			state.pending, state.count = status, 0
+		}
+		state.count++
+		online, offline := statusThresholds(machine)
+		required := online
+		if status == "offline" {
+			required = offline
+		}
+		if state.count >= required {
+			state.shown, state.pending, state.count = status, "", 0
+			continue
+		}
+		statuses[machine.Name] = state.shown
+	}
+}
+
+// debounced reports whether changes to and from the status are delayed
+func debounced(status string) bool {
+	return status == "online" || status == "offline"
+}
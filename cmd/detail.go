@@ -0,0 +1,136 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/history"
+)
+
+// wakeHistoryLimit is the number of wakes and status changes shown on the machine page
+const wakeHistoryLimit = 10
+
+// statusChangesPeriod is how far back status changes are read for the machine
+// page, it covers the longest availability period
+const statusChangesPeriod = 7 * 24 * time.Hour
+
+// machineObservation is what the status probe last saw of a machine
+type machineObservation struct {
+	// CheckedAt is when the machine was last probed successfully
+	CheckedAt time.Time
+	// LastSeen is when the machine was last online
+	LastSeen time.Time
+	// Latency is the round trip time of the last probe that found it online
+	Latency time.Duration
+}
+
+// observationTracker keeps the latest observation of each machine
+type observationTracker struct {
+	mu           sync.Mutex
+	observations map[string]machineObservation
+}
+
+var observations = &observationTracker{observations: make(map[string]machineObservation)}
+
+// record stores the result of probing the machine
+func (t *observationTracker) record(name, status string, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	o := t.observations[name]
+	o.CheckedAt = now
+	if status == "online" {
+		o.LastSeen = now
+		o.Latency = latency
+	}
+	t.observations[name] = o
+}
+
+// get returns the latest observation of the machine
+func (t *observationTracker) get(name string) machineObservation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.observations[name]
+}
+
+func handleMachine(w http.ResponseWriter, r *http.Request) {
+	machine, ok := findVisibleMachine(r, r.PathValue("name"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	permissions := requestPermissions(r)
+
+	status, ok := poller.current([]config.Machine{machine})[machine.Name]
+	if !ok {
+		status = "unknown"
+	}
+
+	// Wakes and status changes come from the history, which also knows when
+	// the machine was last seen before a restart
+	observation := observations.get(machine.Name)
+	var wakes []history.Wake
+	var changes []history.Transition
+	var lastWoken time.Time
+	var stats uptimeView
+	if historyStore != nil {
+		var err error
+		wakes, err = historyStore.Wakes(machine.Name, 0)
+		if err != nil {
+			log.Printf("Error reading wake history: %v", err)
+		}
+		for _, wake := range wakes {
+			if wake.Result == history.ResultSuccess {
+				lastWoken = wake.Time
+				break
+			}
+		}
+
+		now := time.Now()
+		transitions, err := historyStore.Transitions(machine.Name, now.Add(-statusChangesPeriod))
+		if err != nil {
+			log.Printf("Error reading status history: %v", err)
+		}
+		stats = uptimeStats(wakes, transitions, now)
+
+		wakes = wakes[:min(len(wakes), wakeHistoryLimit)]
+		changes = slices.Clone(transitions)
+		slices.Reverse(changes)
+		changes = changes[:min(len(changes), wakeHistoryLimit)]
+
+		if observation.LastSeen.IsZero() {
+			observation.LastSeen, err = historyStore.LastSeen(machine.Name)
+			if err != nil {
+				log.Printf("Error reading last seen: %v", err)
+			}
+		}
+	}
+
+	switchPort, located := locateMachine(machine)
+
+	data := map[string]interface{}{
+		"Machine":      machine,
+		"Status":       status,
+		"Observation":  observation,
+		"LastWoken":    lastWoken,
+		"History":      wakes,
+		"Changes":      changes,
+		"Stats":        stats,
+		"Switches":     len(switches) > 0,
+		"SwitchPort":   switchPort,
+		"Located":      located,
+		"CanWake":      permissions.CanWake(machine.Name, machine.Group),
+		"CanPower":     permissions.CanWake(machine.Name, machine.Group) && canPower(machine),
+		"ConfirmWake":  confirmWake(machine),
+		"Editable":     editable(machine),
+		"FlashMessage": consumeFlashMessage(w, r),
+	}
+	renderTemplate(w, r, "machine.html", data)
+}
@@ -0,0 +1,209 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/probe"
+)
+
+// deviceSyncTimeout limits how long a sync waits for a controller or firewall
+const deviceSyncTimeout = 30 * time.Second
+
+// networkDevice is a device seen on the network by a DHCP server, a UniFi
+// controller, a firewall or mDNS
+type networkDevice struct {
+	MAC string
+	IP  string
+	// Hostname is the name given in the controller or sent by the device
+	Hostname string
+	// Services are the mDNS services the device advertises, e.g. _smb._tcp
+	Services []string
+	// Online is true if the device is connected right now
+	Online bool
+}
+
+// deviceSource holds the devices seen by a controller or firewall as of the
+// last sync
+type deviceSource struct {
+	// name is shown in logs, e.g. "UniFi controller"
+	name     string
+	interval time.Duration
+	fetch    func(ctx context.Context) ([]networkDevice, error)
+
+	mu       sync.Mutex
+	devices  map[string]networkDevice
+	syncedAt time.Time
+}
+
+// deviceSources are synced while serving, later sources win over earlier ones
+var deviceSources []*deviceSource
+
+// addDeviceSource adds a source synced every interval while serving
+func addDeviceSource(name string, interval time.Duration, fetch func(ctx context.Context) ([]networkDevice, error)) (*deviceSource, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	source := &deviceSource{name: name, interval: interval, fetch: fetch}
+	deviceSources = append(deviceSources, source)
+	return source, nil
+}
+
+// runDeviceSources syncs all sources in the background until stop is closed
+func runDeviceSources(stop <-chan struct{}) {
+	for _, source := range deviceSources {
+		go source.run(stop)
+	}
+}
+
+// run syncs the devices every interval until stop is closed
+func (s *deviceSource) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.sync()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync fetches the devices, keeping the previous ones if that fails
+func (s *deviceSource) sync() {
+	ctx, cancel := context.WithTimeout(context.Background(), deviceSyncTimeout)
+	defer cancel()
+
+	list, err := s.fetch(ctx)
+	if err != nil {
+		log.Printf("Error syncing devices from %s: %v", s.name, err)
+		return
+	}
+	devices := make(map[string]networkDevice, len(list))
+	for _, device := range list {
+		devices[device.MAC] = device
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices = devices
+	s.syncedAt = time.Now()
+}
+
+// current returns the devices by MAC address, false if they weren't synced
+// recently enough to tell whether a machine is connected
+func (s *deviceSource) current() (map[string]networkDevice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.devices, time.Since(s.syncedAt) < 3*s.interval
+}
+
+// sourceProber checks whether the machine is connected according to a
+// controller or firewall instead of contacting it
+type sourceProber struct {
+	source *deviceSource
+	mac    string
+}
+
+// newSourceProber returns a prober using the source, the setting named
+// required has to be configured for it to exist
+func newSourceProber(source *deviceSource, required string, config probe.Config) (probe.Prober, error) {
+	if source == nil {
+		return nil, fmt.Errorf("%s is required", required)
+	}
+	mac, err := net.ParseMAC(config.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC address %q", config.MAC)
+	}
+	return &sourceProber{source: source, mac: mac.String()}, nil
+}
+
+func (p *sourceProber) Probe(ctx context.Context) (probe.Result, error) {
+	devices, fresh := p.source.current()
+	if !fresh {
+		return probe.Result{}, errors.New("no recent devices from the " + p.source.name)
+	}
+	return probe.Result{Online: devices[p.mac].Online}, nil
+}
+
+// networkDevices returns the devices seen on the network by MAC address,
+// devices of controllers and firewalls win over DHCP leases
+func networkDevices() map[string]networkDevice {
+	devices := make(map[string]networkDevice)
+	for mac, lease := range currentLeases() {
+		devices[mac] = networkDevice{MAC: mac, IP: lease.IP, Hostname: lease.Hostname}
+	}
+	for _, source := range deviceSources {
+		synced, _ := source.current()
+		for mac, device := range synced {
+			merged := devices[mac]
+			merged.MAC = mac
+			if device.IP != "" {
+				merged.IP = device.IP
+			}
+			if device.Hostname != "" {
+				merged.Hostname = device.Hostname
+			}
+			if len(device.Services) > 0 {
+				merged.Services = device.Services
+			}
+			merged.Online = merged.Online || device.Online
+			devices[mac] = merged
+		}
+	}
+	return devices
+}
+
+// machineAddress returns the address the machine was last seen at on the
+// network, false if it wasn't seen
+func machineAddress(machine config.Machine) (string, bool) {
+	if len(leaseFiles) == 0 && len(deviceSources) == 0 {
+		return "", false
+	}
+	mac, err := net.ParseMAC(machine.Mac)
+	if err != nil {
+		return "", false
+	}
+	device, ok := networkDevices()[mac.String()]
+	if !ok || device.IP == "" {
+		return "", false
+	}
+	return device.IP, true
+}
+
+// unconfiguredDevices returns the devices seen on the network that aren't
+// configured as machines, sorted by hostname
+func unconfiguredDevices() []networkDevice {
+	if len(leaseFiles) == 0 && len(deviceSources) == 0 {
+		return nil
+	}
+	devices := networkDevices()
+	for _, machine := range allMachines() {
+		if mac, err := net.ParseMAC(machine.Mac); err == nil {
+			delete(devices, mac.String())
+		}
+	}
+
+	unconfigured := make([]networkDevice, 0, len(devices))
+	for _, device := range devices {
+		unconfigured = append(unconfigured, device)
+	}
+	slices.SortFunc(unconfigured, func(a, b networkDevice) int {
+		if c := strings.Compare(strings.ToLower(a.Hostname), strings.ToLower(b.Hostname)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.MAC, b.MAC)
+	})
+	return unconfigured
+}
@@ -0,0 +1,159 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/dnsforward"
+)
+
+// dnsWakeInterval is how long to wait before waking a machine again that is
+// still being looked up
+const dnsWakeInterval = time.Minute
+
+// dnsForwarder forwards DNS queries and wakes the machines looked up, nil if
+// disabled
+var dnsForwarder *dnsforward.Forwarder
+
+// dnsAllowedIPs are the clients allowed to send queries, server.allowed_ips
+// apply if nil
+var dnsAllowedIPs []netip.Prefix
+
+var (
+	dnsWokenMu sync.Mutex
+	// dnsWokenAt is when machines were last woken by a query, by name
+	dnsWokenAt = make(map[string]time.Time)
+)
+
+// setupDNSForwarder validates the DNS forwarder settings if enabled
+func setupDNSForwarder() error {
+	c := cfg.DNSForwarder
+	if !c.Enabled {
+		return nil
+	}
+	if c.Upstream == "" {
+		return errors.New("invalid dns_forwarder settings: upstream is required")
+	}
+	upstream := c.Upstream
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+	if c.Timeout <= 0 {
+		return errors.New("invalid dns_forwarder settings: timeout must be positive")
+	}
+	var err error
+	dnsAllowedIPs, err = parsePrefixes(c.AllowedIPs)
+	if err != nil {
+		return fmt.Errorf("invalid dns_forwarder.allowed_ips: %w", err)
+	}
+	dnsForwarder = &dnsforward.Forwarder{
+		Listen:   c.Listen,
+		Upstream: upstream,
+		Timeout:  c.Timeout,
+		Queried:  machineQueried,
+		Allowed:  dnsClientAllowed,
+	}
+	return nil
+}
+
+// dnsClientAllowed reports whether the client may send queries, denied
+// addresses win over allowed ones. Without allowed addresses only private
+// ones are, so the forwarder can't be used as an open resolver.
+func dnsClientAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if prefixesContain(deniedIPs, addr) {
+		return false
+	}
+	switch {
+	case len(dnsAllowedIPs) > 0:
+		return prefixesContain(dnsAllowedIPs, addr)
+	case len(allowedIPs) > 0:
+		return prefixesContain(allowedIPs, addr)
+	default:
+		return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast()
+	}
+}
+
+// runDNSForwarder answers queries in the background until stop is closed
+func runDNSForwarder(stop <-chan struct{}) {
+	if dnsForwarder == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		log.Printf("Forwarding DNS queries from %s to %s", dnsForwarder.Listen, dnsForwarder.Upstream)
+		if err := dnsForwarder.Serve(ctx); err != nil {
+			log.Printf("Error serving DNS forwarder: %v", err)
+		}
+	}()
+}
+
+// machineQueried wakes the machine with the name in the background if it is
+// offline, and returns its configured address
+func machineQueried(name string) (netip.Addr, bool) {
+	for _, machine := range allMachines() {
+		if machine.Peer != "" || !hasDNSName(machine, name) {
+			continue
+		}
+		go wakeQueriedMachine(machine)
+		var addr netip.Addr
+		if machine.IP != nil {
+			addr, _ = netip.ParseAddr(*machine.IP)
+		}
+		return addr, true
+	}
+	return netip.Addr{}, false
+}
+
+// hasDNSName reports whether looking the name up wakes the machine, which
+// its DNS names and a hostname configured as its IP do
+func hasDNSName(machine config.Machine, name string) bool {
+	for _, dnsName := range machine.DNSNames {
+		if strings.EqualFold(strings.TrimSuffix(dnsName, "."), name) {
+			return true
+		}
+	}
+	if machine.IP == nil {
+		return false
+	}
+	if _, err := netip.ParseAddr(*machine.IP); err == nil {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSuffix(*machine.IP, "."), name)
+}
+
+// wakeQueriedMachine wakes the machine if it is offline unless it was woken
+// by a query recently, as clients ask for several record types and retry.
+// Its status is probed unless it is recent, as machines nobody watches are
+// only probed every background interval and may have gone to sleep since.
+func wakeQueriedMachine(machine config.Machine) {
+	statuses := poller.current([]config.Machine{machine})
+	if statuses[machine.Name] != "offline" {
+		return
+	}
+
+	dnsWokenMu.Lock()
+	if time.Since(dnsWokenAt[machine.Name]) < dnsWakeInterval {
+		dnsWokenMu.Unlock()
+		return
+	}
+	dnsWokenAt[machine.Name] = time.Now()
+	dnsWokenMu.Unlock()
+
+	log.Printf("Waking machine %s, its name was looked up", machine.Name)
+	wakeAsService(context.Background(), "dns", machine)
+}
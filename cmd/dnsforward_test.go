@@ -0,0 +1,41 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestDNSClientAllowed(t *testing.T) {
+	oldAllowed, oldDenied, oldDNSAllowed := allowedIPs, deniedIPs, dnsAllowedIPs
+	t.Cleanup(func() { allowedIPs, deniedIPs, dnsAllowedIPs = oldAllowed, oldDenied, oldDNSAllowed })
+
+	tests := []struct {
+		name       string
+		server     []string
+		denied     []string
+		dnsAllowed []string
+		client     string
+		want       bool
+	}{
+		{name: "private client by default", client: "192.168.1.10", want: true},
+		{name: "loopback client by default", client: "127.0.0.1", want: true},
+		{name: "public client by default", client: "203.0.113.7", want: false},
+		{name: "client in server.allowed_ips", server: []string{"203.0.113.0/24"}, client: "203.0.113.7", want: true},
+		{name: "client outside server.allowed_ips", server: []string{"10.0.0.0/8"}, client: "192.168.1.10", want: false},
+		{name: "dns allowed_ips win over the server's", server: []string{"10.0.0.0/8"}, dnsAllowed: []string{"192.168.1.0/24"}, client: "192.168.1.10", want: true},
+		{name: "client outside dns allowed_ips", dnsAllowed: []string{"192.168.1.0/24"}, client: "10.1.2.3", want: false},
+		{name: "denied client", denied: []string{"192.168.1.10"}, dnsAllowed: []string{"192.168.1.0/24"}, client: "192.168.1.10", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowedIPs, _ = parsePrefixes(tt.server)
+			deniedIPs, _ = parsePrefixes(tt.denied)
+			dnsAllowedIPs, _ = parsePrefixes(tt.dnsAllowed)
+			if got := dnsClientAllowed(netip.MustParseAddr(tt.client)); got != tt.want {
+				t.Errorf("dnsClientAllowed(%s) = %v, want %v", tt.client, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/trugamr/wol/config"
+)
+
+// machineFilter narrows down the machines listed, empty fields match all machines
+type machineFilter struct {
+	// Query is matched against the name, MAC address, IP, group and tags
+	Query string
+	// Tag the machines must have
+	Tag string
+	// Group the machines must belong to
+	Group string
+	// Status the machines must have, e.g. online
+	Status string
+}
+
+// machineFilterFromRequest reads the filter from the q, tag, group and status query parameters
+func machineFilterFromRequest(r *http.Request) machineFilter {
+	query := r.URL.Query()
+	return machineFilter{
+		Query:  strings.TrimSpace(query.Get("q")),
+		Tag:    strings.TrimSpace(query.Get("tag")),
+		Group:  strings.TrimSpace(query.Get("group")),
+		Status: strings.TrimSpace(query.Get("status")),
+	}
+}
+
+// Active reports whether the filter excludes any machines
+func (f machineFilter) Active() bool {
+	return f != machineFilter{}
+}
+
+// matches reports whether the machine matches the filter, status is ignored
+func (f machineFilter) matches(machine config.Machine) bool {
+	if f.Tag != "" && !slices.ContainsFunc(machine.Tags, func(tag string) bool { return strings.EqualFold(tag, f.Tag) }) {
+		return false
+	}
+	if f.Group != "" && !strings.EqualFold(machine.Group, f.Group) {
+		return false
+	}
+	if f.Query == "" {
+		return true
+	}
+
+	fields := append([]string{machine.Name, machine.Mac, machine.Group}, machine.Tags...)
+	if machine.IP != nil {
+		fields = append(fields, *machine.IP)
+	}
+	query := strings.ToLower(f.Query)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the machines matching the filter, machines are only probed
+// if filtering by status
+func (f machineFilter) apply(machines []config.Machine) []config.Machine {
+	var matching []config.Machine
+	for _, machine := range machines {
+		if f.matches(machine) {
+			matching = append(matching, machine)
+		}
+	}
+	if f.Status == "" || len(matching) == 0 {
+		return matching
+	}
+
+	statuses := poller.current(matching)
+	return slices.DeleteFunc(matching, func(machine config.Machine) bool {
+		status, ok := statuses[machine.Name]
+		if !ok {
+			status = "unknown"
+		}
+		return !strings.EqualFold(status, f.Status)
+	})
+}
+
+// machineTags returns the distinct tags of the machines, sorted
+func machineTags(machines []config.Machine) []string {
+	var tags []string
+	for _, machine := range machines {
+		for _, tag := range machine.Tags {
+			if !slices.ContainsFunc(tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// machineGroups returns the distinct groups of the machines, sorted
+func machineGroups(machines []config.Machine) []string {
+	var groups []string
+	for _, machine := range machines {
+		if machine.Group != "" && !slices.ContainsFunc(groups, func(g string) bool { return strings.EqualFold(g, machine.Group) }) {
+			groups = append(groups, machine.Group)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
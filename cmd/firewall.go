@@ -0,0 +1,58 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trugamr/wol/firewall"
+	"github.com/trugamr/wol/probe"
+)
+
+// firewallSource syncs the ARP table and DHCP leases of the firewall while
+// serving, nil if none is configured
+var firewallSource *deviceSource
+
+func init() {
+	probe.Register("firewall", func(config probe.Config) (probe.Prober, error) {
+		return newSourceProber(firewallSource, "firewall.url", config)
+	})
+}
+
+// setupFirewall adds the pfSense or OPNsense firewall as a device source if
+// one is configured
+func setupFirewall() error {
+	c := cfg.Firewall
+	if c.URL == "" {
+		return nil
+	}
+	client, err := firewall.New(firewall.Config{
+		Type:               c.Type,
+		URL:                c.URL,
+		Key:                c.APIKey,
+		Secret:             c.APISecret,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		Timeout:            deviceSyncTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid firewall settings: %w", err)
+	}
+
+	// Devices with only a lease are listed for their address but aren't online
+	firewallSource, err = addDeviceSource("firewall", c.Interval, func(ctx context.Context) ([]networkDevice, error) {
+		neighbors, err := client.Neighbors(ctx)
+		if err != nil {
+			return nil, err
+		}
+		devices := make([]networkDevice, 0, len(neighbors))
+		for _, n := range neighbors {
+			devices = append(devices, networkDevice{MAC: n.MAC, IP: n.IP, Hostname: n.Hostname, Online: n.Present})
+		}
+		return devices, nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid firewall settings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/gateway"
+)
+
+// defaultGatewayBootTimeout is how long connections are held while a machine
+// boots unless configured otherwise
+const defaultGatewayBootTimeout = 3 * time.Minute
+
+// gateways are the configured gateways
+var gateways []*gateway.Gateway
+
+// setupGateways validates the configured gateways
+func setupGateways() error {
+	for i, c := range cfg.Gateways {
+		machine, ok := findMachine(c.Machine)
+		if !ok || machine.Peer != "" {
+			return fmt.Errorf("invalid gateway %d: unknown machine %q", i+1, c.Machine)
+		}
+		_, listenPort, err := net.SplitHostPort(c.Listen)
+		if err != nil {
+			return fmt.Errorf("invalid gateway %d: listen must be an address with a port, e.g. :445", i+1)
+		}
+		port := strconv.Itoa(c.Port)
+		if c.Port == 0 {
+			port = listenPort
+		}
+		if c.Port < 0 || c.Port > 65535 || c.BootTimeout < 0 {
+			return fmt.Errorf("invalid gateway %d: port must be between 1 and 65535 and boot_timeout must not be negative", i+1)
+		}
+
+		name := machine.Name
+		g := &gateway.Gateway{
+			Name:        name + ":" + port,
+			Listen:      c.Listen,
+			BootTimeout: c.BootTimeout,
+			Target: func() (string, error) {
+				machine, ok := findMachine(name)
+				if !ok {
+					return "", errMachineNotFound
+				}
+				address, ok := gatewayAddress(machine)
+				if !ok {
+					return "", errors.New("the machine's address is unknown")
+				}
+				return net.JoinHostPort(address, port), nil
+			},
+			Wake: func(ctx context.Context) error {
+				machine, ok := findMachine(name)
+				if !ok {
+					return errMachineNotFound
+				}
+				log.Printf("Waking machine %s for a connection to port %s", machine.Name, port)
+				return wakeAsService(ctx, "gateway", machine)
+			},
+		}
+		if g.BootTimeout == 0 {
+			g.BootTimeout = defaultGatewayBootTimeout
+		}
+		gateways = append(gateways, g)
+	}
+	return nil
+}
+
+// runGateways accepts connections in the background until stop is closed
+func runGateways(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for _, g := range gateways {
+		go func() {
+			log.Printf("Gateway %s listening on %s", g.Name, g.Listen)
+			if err := g.Serve(ctx); err != nil {
+				log.Printf("Error serving gateway %s: %v", g.Name, err)
+			}
+		}()
+	}
+}
+
+// gatewayAddress returns the address connections to the machine are
+// forwarded to, the same the status checks use
+func gatewayAddress(machine config.Machine) (string, bool) {
+	if address, ok := preferTailscaleAddress(machine); ok {
+		return address, true
+	}
+	if machine.IP != nil && *machine.IP != "" {
+		// The IP may have the port magic packets are sent to
+		if host, _, err := net.SplitHostPort(*machine.IP); err == nil {
+			return host, true
+		}
+		return *machine.IP, true
+	}
+	return machineAddress(machine)
+}
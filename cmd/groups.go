@@ -0,0 +1,117 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/trugamr/wol/config"
+)
+
+// errGroupNotFound is returned when no visible machine belongs to the group
+var errGroupNotFound = errors.New("group not found")
+
+// groupView represents a group of machines as shown in the web interface
+type groupView struct {
+	// Name of the group, empty for machines without one
+	Name string
+	// Machines in the group visible to the user
+	Machines []machineView
+	// CanWake determines if the wake group button is shown
+	CanWake bool
+	// ConfirmWake determines if waking the group asks for confirmation first
+	ConfirmWake bool
+}
+
+// groupMachines groups the machines in the order their groups first appear,
+// machines without a group come last
+func groupMachines(machines []machineView) []groupView {
+	var groups []groupView
+	var ungrouped []machineView
+	index := make(map[string]int)
+	for _, machine := range machines {
+		if machine.Group == "" {
+			ungrouped = append(ungrouped, machine)
+			continue
+		}
+
+		key := strings.ToLower(machine.Group)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, groupView{Name: machine.Group, ConfirmWake: confirmGroupWake(machine.Group)})
+		}
+		groups[i].Machines = append(groups[i].Machines, machine)
+		groups[i].CanWake = groups[i].CanWake || machine.CanWake
+	}
+
+	if len(ungrouped) > 0 {
+		groups = append(groups, groupView{Machines: ungrouped})
+	}
+	return groups
+}
+
+// confirmGroupWake reports whether any machine of the group asks for
+// confirmation before waking, including machines on other pages
+func confirmGroupWake(group string) bool {
+	for _, machine := range allMachines() {
+		if strings.EqualFold(machine.Group, group) && confirmWake(machine) {
+			return true
+		}
+	}
+	return false
+}
+
+// wakeGroupAs wakes all machines of the group the user making the request is
+// allowed to wake, an error is only returned if none of them could be tried
+func wakeGroupAs(r *http.Request, group string) ([]wakeResult, error) {
+	var machines []config.Machine
+	for _, machine := range visibleMachines(r) {
+		if machine.Group != "" && strings.EqualFold(machine.Group, group) {
+			machines = append(machines, machine)
+		}
+	}
+	if len(machines) == 0 {
+		return nil, errGroupNotFound
+	}
+	if readOnly() {
+		return nil, errReadOnly
+	}
+
+	permissions := requestPermissions(r)
+	wakeable := machines[:0]
+	for _, machine := range machines {
+		if permissions.CanWake(machine.Name, machine.Group) {
+			wakeable = append(wakeable, machine)
+		}
+	}
+	if len(wakeable) == 0 {
+		return nil, errPermission
+	}
+
+	return wakeMachinesAs(r, wakeable), nil
+}
+
+func handleWakeGroup(w http.ResponseWriter, r *http.Request) {
+	group := r.FormValue("group")
+
+	results, err := wakeGroupAs(r, group)
+	switch {
+	case errors.Is(err, errGroupNotFound):
+		http.Error(w, "Group not found", http.StatusBadRequest)
+		return
+	case errors.Is(err, errReadOnly):
+		http.Error(w, readOnlyReason()+", waking machines is disabled", http.StatusForbidden)
+		return
+	case errors.Is(err, errPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	setFlashMessage(w, wakeResultsMessage(requestTranslator(r), results))
+
+	http.Redirect(w, r, appURL("/"), http.StatusSeeOther)
+}
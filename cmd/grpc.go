@@ -0,0 +1,210 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+
+	wolv1 "github.com/trugamr/wol/api/wol/v1"
+	"github.com/trugamr/wol/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcStatuses maps machine statuses to their protobuf representation
+var grpcStatuses = map[string]wolv1.Status{
+	"unknown": wolv1.Status_STATUS_UNKNOWN,
+	"online":  wolv1.Status_STATUS_ONLINE,
+	"offline": wolv1.Status_STATUS_OFFLINE,
+
+	statusShuttingDown: wolv1.Status_STATUS_SHUTTING_DOWN,
+	statusRebooting:    wolv1.Status_STATUS_REBOOTING,
+	// Woken machines are offline until they come online
+	statusWaking: wolv1.Status_STATUS_OFFLINE,
+}
+
+// newGRPCServer creates the gRPC server, served with TLS if tlsConfig is set
+func newGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpcUnaryAuth),
+		grpc.StreamInterceptor(grpcStreamAuth),
+	}
+	if tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	server := grpc.NewServer(options...)
+	wolv1.RegisterWolServiceServer(server, &wolService{})
+	return server
+}
+
+// grpcRequest builds an HTTP request carrying the metadata of the call so the
+// authentication and permission checks of the web interface can be reused
+func grpcRequest(ctx context.Context, method string) *http.Request {
+	r := (&http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: method},
+		Header: http.Header{},
+	}).WithContext(ctx)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	return r
+}
+
+// grpcAuthenticate adds the principal authenticated from the call metadata to
+// the context, calls from addresses that aren't allowed are rejected first
+func grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	r := grpcRequest(ctx, method)
+	if !ipAllowed(r) {
+		return nil, status.Error(codes.PermissionDenied, "address not allowed")
+	}
+	if cfg.Auth.Disabled {
+		return ctx, nil
+	}
+
+	credentials := r.Header.Get("Authorization") != ""
+	if credentials && limiter != nil {
+		if _, ok := limiter.Allow(clientIP(r)); !ok {
+			return nil, status.Error(codes.ResourceExhausted, "too many failed attempts, try again later")
+		}
+	}
+
+	p, ok := authenticate(r)
+	if !ok {
+		if credentials {
+			authFailed(r, "Failed gRPC authentication to %s", method)
+		}
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid credentials")
+	}
+	if credentials {
+		authSucceeded(r)
+	}
+
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+func grpcUnaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := grpcAuthenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamAuth(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcAuthenticate(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authenticatedStream replaces the context of a stream with the authenticated one
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// wolService implements the gRPC API on top of the same checks as the web interface
+type wolService struct {
+	wolv1.UnimplementedWolServiceServer
+}
+
+// newGRPCMachine converts a configured machine to its protobuf representation
+func newGRPCMachine(r *http.Request, machine config.Machine) *wolv1.Machine {
+	m := &wolv1.Machine{
+		Name:    machine.Name,
+		Mac:     machine.Mac,
+		Group:   machine.Group,
+		CanWake: requestPermissions(r).CanWake(machine.Name, machine.Group),
+		Tags:    machine.Tags,
+	}
+	if machine.IP != nil {
+		m.Ip = *machine.IP
+	}
+	return m
+}
+
+func (s *wolService) ListMachines(ctx context.Context, req *wolv1.ListMachinesRequest) (*wolv1.ListMachinesResponse, error) {
+	r := grpcRequest(ctx, wolv1.WolService_ListMachines_FullMethodName)
+
+	response := &wolv1.ListMachinesResponse{}
+	for _, machine := range visibleMachines(r) {
+		response.Machines = append(response.Machines, newGRPCMachine(r, machine))
+	}
+	return response, nil
+}
+
+func (s *wolService) Wake(ctx context.Context, req *wolv1.WakeRequest) (*wolv1.WakeResponse, error) {
+	r := grpcRequest(ctx, wolv1.WolService_Wake_FullMethodName)
+
+	machine, err := wakeAs(r, req.GetName())
+	switch {
+	case errors.Is(err, errMachineNotFound):
+		return nil, status.Error(codes.NotFound, "machine not found")
+	case errors.Is(err, errReadOnly):
+		return nil, status.Error(codes.FailedPrecondition, readOnlyReason()+", waking machines is disabled")
+	case errors.Is(err, errPermission):
+		return nil, status.Error(codes.PermissionDenied, "not allowed to wake this machine")
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &wolv1.WakeResponse{Machine: newGRPCMachine(r, machine)}, nil
+}
+
+func (s *wolService) StreamStatus(req *wolv1.StreamStatusRequest, stream wolv1.WolService_StreamStatusServer) error {
+	r := grpcRequest(stream.Context(), wolv1.WolService_StreamStatus_FullMethodName)
+
+	// Only machines visible to the user are reported
+	machines := visibleMachines(r)
+
+	// Sends the current status of all machines
+	sendMachinesStatus := func() error {
+		current, _ := poller.snapshot(machines)
+		update := &wolv1.StatusUpdate{Statuses: make(map[string]wolv1.Status)}
+		for name, s := range current {
+			update.Statuses[name] = grpcStatuses[s]
+		}
+		return stream.Send(update)
+	}
+
+	// The shared poller notifies about every sweep
+	updates, unsubscribe := poller.subscribe(machines)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-shuttingDown:
+			return nil
+		case <-updates:
+			err := sendMachinesStatus()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/auth"
+	"golang.org/x/term"
+)
+
+func init() {
+	rootCmd.AddCommand(hashPasswordCmd)
+}
+
+var hashPasswordCmd = &cobra.Command{
+	Use:   "hash-password",
+	Short: "Generate a password hash for the config file",
+	Long:  "Read a password from the terminal or stdin and print its bcrypt hash for use in auth.users",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		password, err := readPassword()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		if password == "" {
+			cobra.CheckErr(fmt.Errorf("password must not be empty"))
+		}
+
+		hash, err := auth.HashPassword(password)
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+
+		fmt.Println(hash)
+	},
+}
+
+// readPassword prompts for a password without echo or reads a line from piped stdin
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return string(password), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
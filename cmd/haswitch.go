@@ -0,0 +1,106 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/trugamr/wol/config"
+)
+
+// States of machines as Home Assistant switches
+const (
+	switchOn      = "on"
+	switchOff     = "off"
+	switchUnknown = "unknown"
+)
+
+// registerSwitchAPI adds the endpoints shaped for the RESTful switch of Home
+// Assistant, which compares plain text bodies with on and off
+func registerSwitchAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/machines/{name}/state", handleAPISwitchState)
+	mux.HandleFunc("POST /api/v1/machines/{name}/state", handleAPISetSwitchState)
+	mux.HandleFunc("POST /api/v1/machines/{name}/turn_on", handleAPISwitch(switchOn))
+	mux.HandleFunc("POST /api/v1/machines/{name}/turn_off", handleAPISwitch(switchOff))
+}
+
+// switchState returns the state of a machine as a switch. Machines being
+// woken or rebooted are on, so the switch doesn't flip back while they boot.
+func switchState(status string) string {
+	switch status {
+	case "online", statusWaking, statusRebooting:
+		return switchOn
+	case "offline", statusShuttingDown:
+		return switchOff
+	}
+	return switchUnknown
+}
+
+// writeSwitchState writes the state as the plain text response body
+func writeSwitchState(w http.ResponseWriter, state string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, state)
+}
+
+func handleAPISwitchState(w http.ResponseWriter, r *http.Request) {
+	machine, ok := findVisibleMachine(r, r.PathValue("name"))
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "machine_not_found", "Machine not found")
+		return
+	}
+	status := poller.current([]config.Machine{machine})[machine.Name]
+	writeSwitchState(w, switchState(status))
+}
+
+// handleAPISetSwitchState turns the machine on or off depending on the body,
+// for switches with a single resource sending body_on and body_off
+func handleAPISetSwitchState(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Failed to read the body")
+		return
+	}
+	state := strings.ToLower(strings.TrimSpace(string(body)))
+	if state != switchOn && state != switchOff {
+		writeAPIError(w, http.StatusBadRequest, "invalid_state", "The body must be on or off")
+		return
+	}
+	handleAPISwitch(state)(w, r)
+}
+
+// handleAPISwitch returns a handler waking the machine for on and shutting it
+// down for off, responding with the state the machine is going to be in
+func handleAPISwitch(state string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if state == switchOn {
+			_, err = wakeAs(r, r.PathValue("name"))
+		} else {
+			_, err = powerAs(r, r.PathValue("name"), statusShuttingDown)
+		}
+		switch {
+		case errors.Is(err, errMachineNotFound):
+			writeAPIError(w, http.StatusNotFound, "machine_not_found", "Machine not found")
+			return
+		case errors.Is(err, errReadOnly):
+			writeAPIError(w, http.StatusForbidden, "read_only", readOnlyReason()+", changing machines is disabled")
+			return
+		case errors.Is(err, errPermission):
+			writeAPIError(w, http.StatusForbidden, "forbidden", "Not allowed to turn this machine on or off")
+			return
+		case errors.Is(err, errNoRemoteAccess):
+			writeAPIError(w, http.StatusConflict, "not_configured", "Shutdown is not configured for this machine")
+			return
+		case err != nil && state == switchOn:
+			writeAPIError(w, http.StatusInternalServerError, "wake_failed", err.Error())
+			return
+		case err != nil:
+			writeAPIError(w, http.StatusBadGateway, "command_failed", err.Error())
+			return
+		}
+		writeSwitchState(w, state)
+	}
+}
@@ -0,0 +1,59 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/audit"
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().IntP("limit", "l", 50, "Maximum number of entries to show, 0 for all")
+	historyCmd.Flags().StringP("action", "a", "", "Only show entries with this action, e.g. wake or login")
+	historyCmd.Flags().StringP("user", "u", "", "Only show entries of this user")
+	historyCmd.Flags().StringP("target", "t", "", "Only show entries for this target, e.g. a machine name")
+	historyCmd.Flags().Duration("since", 0, "Only show entries newer than this, e.g. 24h")
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the audit log",
+	Long:  "Show wakes, logins and other actions recorded by the web server, newest first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+		action, _ := cmd.Flags().GetString("action")
+		user, _ := cmd.Flags().GetString("user")
+		target, _ := cmd.Flags().GetString("target")
+		since, _ := cmd.Flags().GetDuration("since")
+
+		filter := audit.Filter{Action: action, User: user, Target: target, Limit: limit}
+		if since > 0 {
+			filter.Since = time.Now().Add(-since)
+		}
+
+		entries, err := newAuditLog().Read(filter)
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		if len(entries) == 0 {
+			fmt.Println("No entries recorded")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Time\tAction\tUser\tIP\tTarget\tResult\tMessage")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				e.Time.Local().Format("2006-01-02 15:04:05"), e.Action, e.User, e.IP, e.Target, e.Result, e.Message)
+		}
+		w.Flush()
+	},
+}
@@ -0,0 +1,153 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/trugamr/wol/config"
+)
+
+// haNodeInvalid matches characters not allowed in Home Assistant discovery topics
+var haNodeInvalid = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// haDevice groups the entities of a machine in Home Assistant
+type haDevice struct {
+	Identifiers  []string    `json:"identifiers"`
+	Connections  [][2]string `json:"connections,omitempty"`
+	Name         string      `json:"name"`
+	Manufacturer string      `json:"manufacturer"`
+	SWVersion    string      `json:"sw_version,omitempty"`
+}
+
+// haEntity is the discovery payload of an entity, only the fields used by
+// the switch and binary sensor are included
+type haEntity struct {
+	Name                string   `json:"name"`
+	UniqueID            string   `json:"unique_id"`
+	ObjectID            string   `json:"object_id"`
+	Device              haDevice `json:"device"`
+	DeviceClass         string   `json:"device_class,omitempty"`
+	Icon                string   `json:"icon,omitempty"`
+	StateTopic          string   `json:"state_topic"`
+	ValueTemplate       string   `json:"value_template,omitempty"`
+	PayloadOn           string   `json:"payload_on,omitempty"`
+	PayloadOff          string   `json:"payload_off,omitempty"`
+	StateOn             string   `json:"state_on,omitempty"`
+	StateOff            string   `json:"state_off,omitempty"`
+	CommandTopic        string   `json:"command_topic,omitempty"`
+	AvailabilityTopic   string   `json:"availability_topic"`
+	PayloadAvailable    string   `json:"payload_available"`
+	PayloadNotAvailable string   `json:"payload_not_available"`
+	QoS                 byte     `json:"qos"`
+}
+
+// haNodeID returns the id of the machine in discovery topics and unique ids
+func haNodeID(machine string) string {
+	return "wol_" + haNodeInvalid.ReplaceAllString(mqttMachineID(machine), "_")
+}
+
+// haDiscoveryTopic returns the topic of the discovery message of an entity
+func haDiscoveryTopic(component, node, object string) string {
+	return strings.Join([]string{cfg.MQTT.HomeAssistant.DiscoveryPrefix, component, node, object, "config"}, "/")
+}
+
+// haEntities returns the discovery payloads of a machine by topic: a switch
+// waking and shutting down the machine and a sensor telling whether it's online
+func haEntities(machine config.Machine) map[string]haEntity {
+	node := haNodeID(machine.Name)
+	id := mqttMachineID(machine.Name)
+	device := haDevice{
+		Identifiers:  []string{node},
+		Name:         machine.Name,
+		Manufacturer: "wol",
+		SWVersion:    version,
+	}
+	if machine.Mac != "" {
+		device.Connections = [][2]string{{"mac", strings.ToLower(machine.Mac)}}
+	}
+	base := haEntity{
+		Device:              device,
+		StateTopic:          mqttTopic("machines", id, "status"),
+		AvailabilityTopic:   mqttAvailabilityTopic(),
+		PayloadAvailable:    cfg.MQTT.Availability.PayloadOnline,
+		PayloadNotAvailable: cfg.MQTT.Availability.PayloadOffline,
+		QoS:                 cfg.MQTT.QoS,
+	}
+
+	sensor := base
+	sensor.Name = "Online"
+	sensor.UniqueID = node + "_online"
+	sensor.ObjectID = node + "_online"
+	sensor.DeviceClass = "connectivity"
+	// Statuses other than online and offline make the state unknown
+	sensor.ValueTemplate = "{{ {'online': 'ON', 'offline': 'OFF'}.get(value, 'None') }}"
+	sensor.PayloadOn = "ON"
+	sensor.PayloadOff = "OFF"
+
+	power := base
+	power.Name = "Power"
+	power.UniqueID = node + "_power"
+	power.ObjectID = node + "_power"
+	power.Icon = "mdi:power"
+	power.CommandTopic = mqttTopic("machines", id, "set")
+	power.PayloadOn = "wake"
+	power.PayloadOff = "shutdown"
+	power.StateOn = "online"
+	power.StateOff = "offline"
+
+	return map[string]haEntity{
+		haDiscoveryTopic("binary_sensor", node, "online"): sensor,
+		haDiscoveryTopic("switch", node, "power"):         power,
+	}
+}
+
+// syncDiscovery publishes the discovery messages of new machines and removes
+// the ones of machines that are gone, callers must hold the lock
+func (b *mqttBridge) syncDiscovery() {
+	if !cfg.MQTT.HomeAssistant.Discovery {
+		return
+	}
+
+	current := make(map[string]bool)
+	for _, machine := range allMachines() {
+		for topic, entity := range haEntities(machine) {
+			current[topic] = true
+			if b.discovered[topic] {
+				continue
+			}
+			payload, err := json.Marshal(entity)
+			if err != nil {
+				log.Printf("Error encoding Home Assistant discovery message: %v", err)
+				continue
+			}
+			b.client.Publish(topic, cfg.MQTT.QoS, true, payload)
+			b.discovered[topic] = true
+		}
+	}
+
+	// An empty retained message removes the entity
+	for topic := range b.discovered {
+		if !current[topic] {
+			b.client.Publish(topic, cfg.MQTT.QoS, true, "")
+			delete(b.discovered, topic)
+		}
+	}
+}
+
+// onHomeAssistantStatus publishes the discovery messages again when Home
+// Assistant comes online, it may have lost them if they weren't retained
+func (b *mqttBridge) onHomeAssistantStatus(_ mqtt.Client, msg mqtt.Message) {
+	if string(msg.Payload()) != "online" {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.discovered)
+	b.syncDiscovery()
+}
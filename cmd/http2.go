@@ -0,0 +1,39 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// setupHTTP2 configures HTTP/2 over TLS and h2c on the server, it has to be
+// called once the handler and TLS config of the server are set
+func setupHTTP2(server *http.Server) error {
+	c := cfg.Server.HTTP2
+	if !c.Enabled {
+		if c.H2C {
+			return fmt.Errorf("server.http2.h2c needs server.http2.enabled")
+		}
+		// An empty map turns off the HTTP/2 support built into net/http
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil
+	}
+
+	h2 := &http2.Server{MaxConcurrentStreams: c.MaxConcurrentStreams}
+	if server.TLSConfig != nil {
+		err := http2.ConfigureServer(server, h2)
+		if err != nil {
+			return fmt.Errorf("failed to configure HTTP/2: %w", err)
+		}
+	}
+	// Connections switching to h2c are taken over before any other handler
+	if c.H2C {
+		server.Handler = h2c.NewHandler(server.Handler, h2)
+	}
+	return nil
+}
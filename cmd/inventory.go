@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/inventory"
+)
+
+const machinesFilename = "machines.json"
+
+// machineStore holds the machines managed from the web interface and API
+var machineStore *inventory.Store
+
+// newMachineStore returns the machine store located in the data directory
+func newMachineStore() *inventory.Store {
+	return inventory.NewStore(filepath.Join(cfg.DataDir, machinesFilename))
+}
+
+// allMachines returns the machines of the config file followed by the ones
+// managed from the web interface and the ones of peers, callers may modify
+// the slice
+func allMachines() []config.Machine {
+	machines := slices.Clone(cfg.Machines)
+	if machineStore != nil {
+		stored, err := machineStore.List()
+		if err != nil {
+			log.Printf("Error loading machines: %v", err)
+		}
+		machines = append(machines, stored...)
+	}
+
+	// Local machines win over machines of peers with the same name
+	for _, machine := range peerMachines() {
+		if !slices.ContainsFunc(machines, func(m config.Machine) bool { return strings.EqualFold(m.Name, machine.Name) }) {
+			machines = append(machines, machine)
+		}
+	}
+	return machines
+}
@@ -0,0 +1,59 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+)
+
+// Client address ranges allowed and denied to use the server, nil if unrestricted
+var (
+	allowedIPs []netip.Prefix
+	deniedIPs  []netip.Prefix
+)
+
+// setupIPFilter parses the configured allowed and denied client addresses
+func setupIPFilter() error {
+	var err error
+	allowedIPs, err = parsePrefixes(cfg.Server.AllowedIPs)
+	if err != nil {
+		return fmt.Errorf("invalid server.allowed_ips: %w", err)
+	}
+	deniedIPs, err = parsePrefixes(cfg.Server.DeniedIPs)
+	if err != nil {
+		return fmt.Errorf("invalid server.denied_ips: %w", err)
+	}
+	return nil
+}
+
+// ipAllowed reports whether the client may use the server, denied addresses
+// win over allowed ones. Clients connected via a Unix domain socket without
+// forwarding headers are local and always allowed.
+func ipAllowed(r *http.Request) bool {
+	addr := clientAddr(r)
+	if !addr.IsValid() {
+		return isUnixPeer(r)
+	}
+	if prefixesContain(deniedIPs, addr) {
+		return false
+	}
+	return len(allowedIPs) == 0 || prefixesContain(allowedIPs, addr)
+}
+
+// ipFilterMiddleware rejects clients that aren't allowed before anything else,
+// including authentication, looks at the request
+func ipFilterMiddleware(next http.Handler) http.Handler {
+	if len(allowedIPs) == 0 && len(deniedIPs) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ipAllowed(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,56 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/ipmi"
+	"github.com/trugamr/wol/probe"
+)
+
+// ipmiTimeout limits how long an ipmitool command may take, BMCs are slow
+// to answer at times
+const ipmiTimeout = 20 * time.Second
+
+func init() {
+	registerMachineType(config.MachineTypeIPMI, machineType{
+		wake: func(ctx context.Context, machine config.Machine) error {
+			return machineBMC(machine).Power(ctx, ipmi.PowerOn)
+		},
+		prober: func(machine config.Machine) (probe.Prober, error) {
+			return ipmiProber{bmc: machineBMC(machine)}, nil
+		},
+		power: func(ctx context.Context, machine config.Machine, status string) error {
+			if status == statusRebooting {
+				return machineBMC(machine).Power(ctx, ipmi.PowerCycle)
+			}
+			return machineBMC(machine).Power(ctx, ipmi.PowerSoft)
+		},
+	})
+}
+
+// machineBMC returns the BMC of the machine
+func machineBMC(machine config.Machine) ipmi.BMC {
+	return ipmi.BMC{
+		Address:  machine.IPMI.Address,
+		Username: machine.IPMI.Username,
+		Password: machine.IPMI.Password,
+		Timeout:  ipmiTimeout,
+	}
+}
+
+// ipmiProber reports servers online while their chassis is powered on
+type ipmiProber struct {
+	bmc ipmi.BMC
+}
+
+func (p ipmiProber) Probe(ctx context.Context) (probe.Result, error) {
+	on, err := p.bmc.PoweredOn(ctx)
+	if err != nil {
+		return probe.Result{}, err
+	}
+	return probe.Result{Online: on}, nil
+}
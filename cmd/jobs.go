@@ -0,0 +1,380 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+)
+
+const (
+	// wakeJobConcurrency is how many machines of a job are woken at the same time
+	wakeJobConcurrency = 8
+	// wakeJobRetention is how long finished jobs can be looked up
+	wakeJobRetention = time.Hour
+)
+
+// States of the machines of a wake job
+const (
+	jobTargetPending  = "pending"
+	jobTargetSkipped  = "skipped"
+	jobTargetWaking   = "waking"
+	jobTargetSent     = "sent"
+	jobTargetOnline   = "online"
+	jobTargetFailed   = "failed"
+	jobTargetTimedOut = "timed_out"
+)
+
+// wakeJobs holds the wake jobs started through the API
+var wakeJobs = &jobStore{jobs: make(map[string]*wakeJob)}
+
+// jobStore keeps wake jobs until a while after they are done
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*wakeJob
+}
+
+// wakeJob wakes many machines in the background, clients poll it for the
+// progress of each machine
+type wakeJob struct {
+	ID string
+	// User who started the job, only they and admins can look it up
+	User       string
+	CreatedAt  time.Time
+	FinishedAt time.Time
+	Targets    []jobTarget
+}
+
+// jobTarget is the progress of waking one machine of a job
+type jobTarget struct {
+	Machine config.Machine
+	State   string
+	Error   string
+	// UpdatedAt is when the state last changed
+	UpdatedAt time.Time
+}
+
+// apiWakeRequest is the body of a bulk wake request
+type apiWakeRequest struct {
+	Machines []string `json:"machines"`
+	Groups   []string `json:"groups"`
+	// Offline skips machines that aren't reported offline
+	Offline bool `json:"offline"`
+}
+
+// apiJob represents a wake job in API responses
+type apiJob struct {
+	ID         string         `json:"id"`
+	Status     string         `json:"status"`
+	CreatedAt  time.Time      `json:"created_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Targets    []apiJobTarget `json:"targets"`
+}
+
+// apiJobTarget represents the progress of one machine of a wake job in API responses
+type apiJobTarget struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// add stores a new job, forgetting the ones finished a while ago
+func (s *jobStore) add(job *wakeJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, j := range s.jobs {
+		if !j.FinishedAt.IsZero() && time.Since(j.FinishedAt) > wakeJobRetention {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.ID] = job
+}
+
+// get returns a copy of the job
+func (s *jobStore) get(id string) (wakeJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return wakeJob{}, false
+	}
+	result := *job
+	result.Targets = append([]jobTarget(nil), job.Targets...)
+	return result, true
+}
+
+// update changes the state of a machine of the job
+func (s *jobStore) update(job *wakeJob, i int, state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.Targets[i].State = state
+	job.Targets[i].UpdatedAt = time.Now()
+	if err != nil {
+		job.Targets[i].Error = err.Error()
+	}
+}
+
+// finish marks the job as done
+func (s *jobStore) finish(job *wakeJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.FinishedAt = time.Now()
+}
+
+// unknownTargetError is returned for machines or groups of a bulk wake
+// request that don't exist or aren't visible to the user
+type unknownTargetError struct {
+	err  error
+	name string
+}
+
+func (e unknownTargetError) Error() string {
+	return fmt.Sprintf("%v: %s", e.err, e.name)
+}
+
+func (e unknownTargetError) Unwrap() error {
+	return e.err
+}
+
+// jobMachines resolves the machines and groups of a bulk wake request to
+// the machines visible to the user, explicitly named machines first. Only
+// the machines of groups the user is allowed to wake are included.
+func jobMachines(r *http.Request, input apiWakeRequest) ([]config.Machine, error) {
+	var machines []config.Machine
+	seen := make(map[string]bool)
+	add := func(machine config.Machine) {
+		if !seen[strings.ToLower(machine.Name)] {
+			seen[strings.ToLower(machine.Name)] = true
+			machines = append(machines, machine)
+		}
+	}
+
+	for _, name := range input.Machines {
+		machine, ok := findVisibleMachine(r, name)
+		if !ok {
+			return nil, unknownTargetError{errMachineNotFound, name}
+		}
+		add(machine)
+	}
+
+	permissions := requestPermissions(r)
+	visible := visibleMachines(r)
+	for _, group := range input.Groups {
+		found := false
+		for _, machine := range visible {
+			if machine.Group == "" || !strings.EqualFold(machine.Group, group) {
+				continue
+			}
+			found = true
+			if permissions.CanWake(machine.Name, machine.Group) {
+				add(machine)
+			}
+		}
+		if !found {
+			return nil, unknownTargetError{errGroupNotFound, group}
+		}
+	}
+	return machines, nil
+}
+
+// startWakeJob wakes the machines in the background on behalf of the user
+// making the request and returns the job tracking it
+func startWakeJob(r *http.Request, machines []config.Machine, offlineOnly bool) (*wakeJob, error) {
+	if readOnly() {
+		return nil, errReadOnly
+	}
+	if len(machines) == 0 {
+		return nil, errPermission
+	}
+
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate job id: %w", err)
+	}
+
+	p, _ := requestPrincipal(r)
+	now := time.Now()
+	job := &wakeJob{
+		ID:        hex.EncodeToString(b),
+		User:      p.Username,
+		CreatedAt: now,
+		Targets:   make([]jobTarget, len(machines)),
+	}
+	var statuses map[string]string
+	if offlineOnly {
+		statuses = poller.current(machines)
+	}
+	for i, machine := range machines {
+		job.Targets[i] = jobTarget{Machine: machine, State: jobTargetPending, UpdatedAt: now}
+		if offlineOnly && statuses[machine.Name] != "offline" {
+			job.Targets[i].State = jobTargetSkipped
+		}
+	}
+	wakeJobs.add(job)
+
+	// The wakes outlive the request, they are still made on behalf of its user
+	r = r.WithContext(context.WithoutCancel(r.Context()))
+	go runWakeJob(r, job)
+	return job, nil
+}
+
+// runWakeJob wakes the pending machines of the job and follows them until
+// they are online or the wake timed out
+func runWakeJob(r *http.Request, job *wakeJob) {
+	defer wakeJobs.finish(job)
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, wakeJobConcurrency)
+	for i, target := range job.Targets {
+		if target.State != jobTargetPending {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, machine config.Machine) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			_, err := wakeAs(r, machine.Name)
+			switch {
+			case err != nil:
+				wakeJobs.update(job, i, jobTargetFailed, err)
+			case checkable(machine):
+				wakeJobs.update(job, i, jobTargetWaking, nil)
+			default:
+				wakeJobs.update(job, i, jobTargetSent, nil)
+			}
+		}(i, target.Machine)
+	}
+	wg.Wait()
+
+	// Machines whose status can be checked are followed until they are online
+	waking := make(map[int]config.Machine)
+	var machines []config.Machine
+	for i, target := range job.Targets {
+		if target.State == jobTargetWaking {
+			waking[i] = target.Machine
+			machines = append(machines, target.Machine)
+		}
+	}
+	if len(waking) == 0 {
+		return
+	}
+
+	updates, unsubscribe := poller.subscribe(machines)
+	defer unsubscribe()
+	timeout := time.NewTimer(cfg.Server.WakeTimeout)
+	defer timeout.Stop()
+	for len(waking) > 0 {
+		select {
+		case <-updates:
+			statuses, _ := poller.snapshot(machines)
+			for i, machine := range waking {
+				if statuses[machine.Name] == "online" {
+					wakeJobs.update(job, i, jobTargetOnline, nil)
+					delete(waking, i)
+				}
+			}
+		case <-timeout.C:
+			for i := range waking {
+				wakeJobs.update(job, i, jobTargetTimedOut, fmt.Errorf("not online after %s", cfg.Server.WakeTimeout))
+			}
+			return
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// newAPIJob converts a wake job to its API representation
+func newAPIJob(job wakeJob) apiJob {
+	response := apiJob{
+		ID:        job.ID,
+		Status:    "running",
+		CreatedAt: job.CreatedAt,
+		Targets:   make([]apiJobTarget, 0, len(job.Targets)),
+	}
+	if !job.FinishedAt.IsZero() {
+		response.Status = "done"
+		response.FinishedAt = &job.FinishedAt
+	}
+	for _, target := range job.Targets {
+		response.Targets = append(response.Targets, apiJobTarget{
+			Name:      target.Machine.Name,
+			State:     target.State,
+			Error:     target.Error,
+			UpdatedAt: target.UpdatedAt,
+		})
+	}
+	return response
+}
+
+func handleAPIWakeJob(w http.ResponseWriter, r *http.Request) {
+	var input apiWakeRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&input)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if len(input.Machines) == 0 && len(input.Groups) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", "Invalid request body: no machines or groups given")
+		return
+	}
+
+	machines, err := jobMachines(r, input)
+	var unknown unknownTargetError
+	if errors.As(err, &unknown) {
+		if errors.Is(err, errGroupNotFound) {
+			writeAPIError(w, http.StatusNotFound, "group_not_found", "Group not found: "+unknown.name)
+		} else {
+			writeAPIError(w, http.StatusNotFound, "machine_not_found", "Machine not found: "+unknown.name)
+		}
+		return
+	}
+
+	job, err := startWakeJob(r, machines, input.Offline)
+	switch {
+	case errors.Is(err, errReadOnly):
+		writeAPIError(w, http.StatusForbidden, "read_only", readOnlyReason()+", waking machines is disabled")
+		return
+	case errors.Is(err, errPermission):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Not allowed to wake any of the machines")
+		return
+	case err != nil:
+		log.Printf("Error starting wake job: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to start wake job")
+		return
+	}
+
+	created, _ := wakeJobs.get(job.ID)
+	w.Header().Set("Location", appURL("/api/v1/jobs/"+job.ID))
+	writeJSON(w, http.StatusAccepted, newAPIJob(created))
+}
+
+func handleAPIJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := wakeJobs.get(r.PathValue("id"))
+	p, _ := requestPrincipal(r)
+	if !ok || (job.User != p.Username && !userPermissions(r).IsAdmin()) {
+		writeAPIError(w, http.StatusNotFound, "job_not_found", "Job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIJob(job))
+}
@@ -0,0 +1,28 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/trugamr/wol/i18n"
+)
+
+// checkLanguage makes sure the configured language is bundled
+func checkLanguage() error {
+	if cfg.Server.Language == "" || i18n.Supported(cfg.Server.Language) {
+		return nil
+	}
+	return fmt.Errorf("unknown server.language %q, must be one of %s", cfg.Server.Language, strings.Join(i18n.Languages(), ", "))
+}
+
+// requestTranslator returns the translator of the configured language or
+// else of the language preferred by the browser
+func requestTranslator(r *http.Request) i18n.Translator {
+	if cfg.Server.Language != "" {
+		return i18n.New(cfg.Server.Language)
+	}
+	return i18n.New(i18n.Match(r.Header.Get("Accept-Language")))
+}
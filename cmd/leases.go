@@ -0,0 +1,41 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/trugamr/wol/leases"
+)
+
+// leaseFiles are the DHCP lease files read for the addresses of devices
+var leaseFiles []*leases.File
+
+// setupLeases opens the configured lease files, they are read when needed
+func setupLeases() error {
+	for i, c := range cfg.DHCPLeases {
+		file, err := leases.NewFile(c.Path, c.Format)
+		if err != nil {
+			return fmt.Errorf("invalid DHCP lease file %d: %w", i+1, err)
+		}
+		leaseFiles = append(leaseFiles, file)
+	}
+	return nil
+}
+
+// currentLeases returns the leases of all lease files by MAC address, later
+// files win over earlier ones
+func currentLeases() map[string]leases.Lease {
+	current := make(map[string]leases.Lease)
+	for _, file := range leaseFiles {
+		list, err := file.Leases()
+		if err != nil {
+			log.Printf("Error reading DHCP leases from %s: %v", file.Path(), err)
+		}
+		for _, lease := range list {
+			current[lease.MAC] = lease
+		}
+	}
+	return current
+}
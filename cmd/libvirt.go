@@ -0,0 +1,52 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/libvirt"
+	"github.com/trugamr/wol/probe"
+)
+
+// libvirtTimeout limits how long a virsh command may take, connecting to
+// remote hosts over SSH included
+const libvirtTimeout = 30 * time.Second
+
+func init() {
+	registerMachineType(config.MachineTypeLibvirt, machineType{
+		wake: func(ctx context.Context, machine config.Machine) error {
+			return libvirtDomain(machine).Start(ctx)
+		},
+		prober: func(machine config.Machine) (probe.Prober, error) {
+			return libvirtProber{domain: libvirtDomain(machine)}, nil
+		},
+		power: func(ctx context.Context, machine config.Machine, status string) error {
+			if status == statusRebooting {
+				return libvirtDomain(machine).Reboot(ctx)
+			}
+			return libvirtDomain(machine).Shutdown(ctx)
+		},
+	})
+}
+
+// libvirtDomain returns the domain of the machine
+func libvirtDomain(machine config.Machine) libvirt.Domain {
+	return libvirt.Domain{URI: machine.Libvirt.URI, Name: machine.Libvirt.Domain, Timeout: libvirtTimeout}
+}
+
+// libvirtProber reports domains online while they are running, paused and
+// suspended ones count as offline
+type libvirtProber struct {
+	domain libvirt.Domain
+}
+
+func (p libvirtProber) Probe(ctx context.Context) (probe.Result, error) {
+	state, err := p.domain.State(ctx)
+	if err != nil {
+		return probe.Result{}, err
+	}
+	return probe.Result{Online: state == libvirt.StateRunning || state == libvirt.StateBlocked}, nil
+}
@@ -0,0 +1,99 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/netutil"
+)
+
+// checkServerLimits makes sure the timeouts and limits of the server are valid
+func checkServerLimits() error {
+	s := cfg.Server
+	if s.ReadHeaderTimeout < 0 || s.ReadTimeout < 0 || s.IdleTimeout < 0 {
+		return fmt.Errorf("server.read_header_timeout, server.read_timeout and server.idle_timeout must not be negative")
+	}
+	if s.MaxBodySize <= 0 {
+		return fmt.Errorf("server.max_body_size must be positive")
+	}
+	if s.MaxConnections < 0 {
+		return fmt.Errorf("server.max_connections must not be negative")
+	}
+	if s.MaxStatusClients < 0 {
+		return fmt.Errorf("server.max_status_clients must not be negative")
+	}
+	if s.StatusWriteTimeout <= 0 {
+		return fmt.Errorf("server.status_write_timeout must be positive")
+	}
+	return nil
+}
+
+// limitListener limits the number of connections accepted at the same time
+func limitListener(listener net.Listener) net.Listener {
+	if cfg.Server.MaxConnections == 0 {
+		return listener
+	}
+	return netutil.LimitListener(listener, cfg.Server.MaxConnections)
+}
+
+// limitBodyMiddleware rejects request bodies larger than the configured size
+func limitBodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.Server.MaxBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// keepReading lifts the read timeout of the server for responses that stay
+// open, such as the status stream, which would be cancelled otherwise
+func keepReading(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetReadDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error clearing read deadline: %v", err)
+	}
+}
+
+// statusClients counts the connected status stream clients
+var statusClients = &clientCounter{}
+
+// clientCounter keeps the number of clients below a limit
+type clientCounter struct {
+	mu    sync.Mutex
+	count int
+}
+
+// acquire counts a new client, it returns false if there are already max
+// clients, 0 for no limit
+func (c *clientCounter) acquire(max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if max > 0 && c.count >= max {
+		return false
+	}
+	c.count++
+	return true
+}
+
+// release forgets a client counted by acquire
+func (c *clientCounter) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count--
+}
+
+// setWriteDeadline gives the client until the timeout to accept what is
+// written next, so a stalled connection fails the write instead of blocking
+// the handler forever
+func setWriteDeadline(w http.ResponseWriter, timeout time.Duration) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Error setting write deadline: %v", err)
+	}
+}
@@ -1,30 +1,65 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/config"
 )
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().Bool("zabbix-lld", false, "Print the machines as Zabbix low-level discovery data")
 }
 
 var listCmd = &cobra.Command{
 	Use:   "list",
-	Short: "List machines from config file",
-	Long:  "Show a list of all the configured machines",
+	Short: "List configured machines",
+	Long:  "Show a list of all the configured machines, including the ones added from the web interface",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(cfg.Machines) == 0 {
+		machines, ok := remoteMachines(cmd)
+		if !ok {
+			machines = allMachines()
+		}
+		if lld, _ := cmd.Flags().GetBool("zabbix-lld"); lld {
+			err := printZabbixLLD(machines)
+			if err != nil {
+				cobra.CheckErr(fmt.Errorf("failed to print discovery data: %w", err))
+			}
+			return
+		}
+		if len(machines) == 0 {
 			fmt.Println("No machines configured")
 			return
 		}
 
 		// Render the list of machines
 		fmt.Println("Name\tMAC")
-		for _, machine := range cfg.Machines {
+		for _, machine := range machines {
 			fmt.Printf("%s\t%s\n", machine.Name, machine.Mac)
 		}
 	},
 }
+
+// printZabbixLLD prints the machines as low-level discovery data, with the
+// {#NAME}, {#MAC}, {#IP} and {#GROUP} macros
+func printZabbixLLD(machines []config.Machine) error {
+	data := make([]map[string]string, 0, len(machines))
+	for _, machine := range machines {
+		ip := ""
+		if machine.IP != nil {
+			ip = *machine.IP
+		}
+		data = append(data, map[string]string{
+			"{#NAME}":  machine.Name,
+			"{#MAC}":   machine.Mac,
+			"{#IP}":    ip,
+			"{#GROUP}": machine.Group,
+		})
+	}
+	return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{"data": data})
+}
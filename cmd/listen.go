@@ -0,0 +1,150 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix marks listen addresses that are Unix domain sockets
+const unixPrefix = "unix:"
+
+// systemdFirstFD is the first file descriptor passed by systemd socket activation
+const systemdFirstFD = 3
+
+// systemdListeners returns the listeners passed by systemd socket activation
+// as described in sd_listen_fds(3), or none if the process wasn't activated
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// Child processes must not think the sockets were passed to them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("systemd:%d", i)
+		if i < len(names) && names[i] != "" {
+			name = "systemd:" + names[i]
+		}
+
+		// FileListener duplicates the descriptor so the original one is closed
+		file := os.NewFile(uintptr(systemdFirstFD+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to use socket %s: %w", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// serverListeners returns the sockets passed by systemd, or otherwise opens
+// a listener for each configured address
+func serverListeners() ([]net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil || len(listeners) > 0 {
+		return listeners, err
+	}
+
+	if len(cfg.Server.Listen) == 0 {
+		return nil, errors.New("no listen address configured")
+	}
+	for _, address := range cfg.Server.Listen {
+		listener, err := listen(address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// listen opens a listener for the address, which is either a TCP address or
+// a Unix domain socket path prefixed with unix:
+func listen(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	return listenUnix(path)
+}
+
+// listenUnix listens on the Unix domain socket at path with the configured
+// permissions, replacing a socket left behind by a previous run
+func listenUnix(path string) (net.Listener, error) {
+	mode, err := strconv.ParseUint(cfg.Server.SocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode %q: %w", cfg.Server.SocketMode, err)
+	}
+
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to check socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(path, fs.FileMode(mode))
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket mode: %w", err)
+	}
+
+	if cfg.Server.SocketGroup != "" {
+		group, err := user.LookupGroup(cfg.Server.SocketGroup)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to look up socket group: %w", err)
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("invalid group id %q: %w", group.Gid, err)
+		}
+		err = os.Chown(path, -1, gid)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set socket group: %w", err)
+		}
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,72 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/trugamr/wol/history"
+)
+
+const historyFilename = "history.db"
+
+// historyPruneInterval is how often old history is deleted
+const historyPruneInterval = time.Hour
+
+// historyStore records status changes and wakes while serving
+var historyStore *history.Store
+
+// openHistoryStore opens the history database located in the data directory
+func openHistoryStore() (*history.Store, error) {
+	return history.Open(filepath.Join(cfg.DataDir, historyFilename))
+}
+
+// recordStatusHistory records the statuses of a sweep
+func recordStatusHistory(statuses map[string]string) {
+	if historyStore == nil {
+		return
+	}
+	if err := historyStore.RecordStatuses(statuses, time.Now()); err != nil {
+		log.Printf("Error recording status history: %v", err)
+	}
+}
+
+// recordWakeHistoryAs records an attempt to wake the machine by the given user
+func recordWakeHistoryAs(user, name string, err error) {
+	if historyStore == nil {
+		return
+	}
+
+	wake := history.Wake{User: user, Result: history.ResultSuccess}
+	if err != nil {
+		wake.Result = history.ResultFailure
+		wake.Message = err.Error()
+	}
+	if err := historyStore.RecordWake(name, wake); err != nil {
+		log.Printf("Error recording wake history: %v", err)
+	}
+}
+
+// pruneHistory deletes history older than the retention periodically, until stop is closed
+func pruneHistory(stop <-chan struct{}) {
+	if historyStore == nil || cfg.History.Retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := historyStore.Prune(time.Now().Add(-cfg.History.Retention)); err != nil {
+			log.Printf("Error pruning history: %v", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,320 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/inventory"
+)
+
+// Errors returned when managing machines, in addition to the ones of wakeAs
+var (
+	errMachineExists   = errors.New("machine already exists")
+	errMachineInConfig = errors.New("machine is defined in the config file")
+)
+
+// invalidMachineError describes why a submitted machine was rejected
+type invalidMachineError struct {
+	err error
+}
+
+func (e invalidMachineError) Error() string {
+	return e.err.Error()
+}
+
+// isConfigMachine reports whether the machine is defined in the config file,
+// those can't be changed from the web interface
+func isConfigMachine(name string) bool {
+	for _, machine := range cfg.Machines {
+		if strings.EqualFold(machine.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// editable reports whether the machine can be changed from the web
+// interface, which isn't the case for machines of the config file and peers
+func editable(machine config.Machine) bool {
+	return machine.Peer == "" && !isConfigMachine(machine.Name)
+}
+
+// checkManageMachines returns an error if the user making the request isn't
+// allowed to add, edit or delete machines
+func checkManageMachines(r *http.Request) error {
+	if readOnly() {
+		return errReadOnly
+	}
+	if !requestPermissions(r).IsAdmin() {
+		return errPermission
+	}
+	return nil
+}
+
+// createMachine adds a machine on behalf of the user making the request
+func createMachine(r *http.Request, machine config.Machine) error {
+	err := checkManageMachines(r)
+	if err != nil {
+		return err
+	}
+	err = machine.Validate()
+	if err != nil {
+		return invalidMachineError{err}
+	}
+	if isConfigMachine(machine.Name) {
+		return errMachineExists
+	}
+
+	err = machineStore.Add(machine)
+	err = storeError(err)
+	recordAudit(r, "machine.create", machine.Name, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Machine %q added", machine.Name)
+	poller.triggerRefresh()
+	return nil
+}
+
+// updateMachine replaces the machine with the given name on behalf of the user making the request
+func updateMachine(r *http.Request, name string, machine config.Machine) error {
+	err := checkManageMachines(r)
+	if err != nil {
+		return err
+	}
+	if isConfigMachine(name) {
+		return errMachineInConfig
+	}
+	err = machine.Validate()
+	if err != nil {
+		return invalidMachineError{err}
+	}
+	if isConfigMachine(machine.Name) {
+		return errMachineExists
+	}
+
+	err = machineStore.Update(name, machine)
+	err = storeError(err)
+	recordAudit(r, "machine.update", name, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Machine %q updated", name)
+	poller.triggerRefresh()
+	return nil
+}
+
+// deleteMachine removes the machine with the given name on behalf of the user making the request
+func deleteMachine(r *http.Request, name string) error {
+	err := checkManageMachines(r)
+	if err != nil {
+		return err
+	}
+	if isConfigMachine(name) {
+		return errMachineInConfig
+	}
+
+	err = machineStore.Delete(name)
+	err = storeError(err)
+	recordAudit(r, "machine.delete", name, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Machine %q deleted", name)
+	poller.triggerRefresh()
+	return nil
+}
+
+// storeError maps errors of the machine store to the ones handled by callers
+func storeError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, inventory.ErrNotFound):
+		return errMachineNotFound
+	case errors.Is(err, inventory.ErrExists):
+		return errMachineExists
+	}
+
+	return err
+}
+
+// machineFromForm returns the machine submitted with a form
+func machineFromForm(r *http.Request) config.Machine {
+	machine := config.Machine{
+		Name:  strings.TrimSpace(r.FormValue("name")),
+		Mac:   strings.TrimSpace(r.FormValue("mac")),
+		Group: strings.TrimSpace(r.FormValue("group")),
+		Tags:  parseTags(r.FormValue("tags")),
+	}
+	if ip := strings.TrimSpace(r.FormValue("ip")); ip != "" {
+		machine.IP = &ip
+	}
+	// Machines follow server.confirm_wake unless yes or no is picked
+	if value := r.FormValue("confirm_wake"); value == "yes" || value == "no" {
+		confirm := value == "yes"
+		machine.ConfirmWake = &confirm
+	}
+	return machine
+}
+
+// parseTags splits comma separated tags, dropping empty and duplicate ones
+func parseTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !slices.ContainsFunc(tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// manageMachineView represents a machine on the machine management page
+type manageMachineView struct {
+	config.Machine
+	// Editable is false for machines defined in the config file or of peers
+	Editable bool
+}
+
+func handleMachines(w http.ResponseWriter, r *http.Request) {
+	renderMachines(w, r, config.Machine{}, "")
+}
+
+// renderMachines shows all machines along with the form adding one, form
+// holds the submitted values when adding failed with formError
+func renderMachines(w http.ResponseWriter, r *http.Request, form config.Machine, formError string) {
+	machines := allMachines()
+	views := make([]manageMachineView, 0, len(machines))
+	for _, machine := range machines {
+		views = append(views, manageMachineView{
+			Machine:  machine,
+			Editable: editable(machine),
+		})
+	}
+
+	data := map[string]interface{}{
+		"Machines":     views,
+		"Discovered":   unconfiguredDevices(),
+		"Form":         form,
+		"FormError":    formError,
+		"FlashMessage": consumeFlashMessage(w, r),
+	}
+	renderTemplate(w, r, "machines.html", data)
+}
+
+func handleCreateMachine(w http.ResponseWriter, r *http.Request) {
+	machine := machineFromForm(r)
+
+	err := createMachine(r, machine)
+	if message, ok := machineFormError(w, err); !ok {
+		return
+	} else if message != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		renderMachines(w, r, machine, message)
+		return
+	}
+
+	setFlashMessage(w, fmt.Sprintf("Added %s", machine.Name))
+	http.Redirect(w, r, appURL("/admin/machines"), http.StatusSeeOther)
+}
+
+func handleEditMachine(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	machine, ok := findMachine(name)
+	if !ok || !editable(machine) {
+		http.NotFound(w, r)
+		return
+	}
+	renderEditMachine(w, r, name, machine, "")
+}
+
+// renderEditMachine shows the form editing the machine with the given name
+func renderEditMachine(w http.ResponseWriter, r *http.Request, name string, form config.Machine, formError string) {
+	data := map[string]interface{}{
+		"Name":      name,
+		"Form":      form,
+		"FormError": formError,
+		// The select picks yes, no or the server default
+		"ConfirmWake": "",
+	}
+	if form.ConfirmWake != nil {
+		data["ConfirmWake"] = map[bool]string{true: "yes", false: "no"}[*form.ConfirmWake]
+	}
+	renderTemplate(w, r, "machine_edit.html", data)
+}
+
+func handleUpdateMachine(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	machine := machineFromForm(r)
+
+	// SSH settings and checks can't be edited in the form so they are kept
+	if existing, ok := findMachine(name); ok {
+		machine.SSH = existing.SSH
+		machine.Check = existing.Check
+	}
+
+	err := updateMachine(r, name, machine)
+	if errors.Is(err, errMachineNotFound) || errors.Is(err, errMachineInConfig) {
+		http.NotFound(w, r)
+		return
+	}
+	if message, ok := machineFormError(w, err); !ok {
+		return
+	} else if message != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		renderEditMachine(w, r, name, machine, message)
+		return
+	}
+
+	setFlashMessage(w, fmt.Sprintf("Saved %s", machine.Name))
+	http.Redirect(w, r, appURL("/admin/machines"), http.StatusSeeOther)
+}
+
+func handleDeleteMachine(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	err := deleteMachine(r, name)
+	if errors.Is(err, errMachineNotFound) || errors.Is(err, errMachineInConfig) {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := machineFormError(w, err); !ok {
+		return
+	}
+
+	setFlashMessage(w, fmt.Sprintf("Deleted %s", name))
+	http.Redirect(w, r, appURL("/admin/machines"), http.StatusSeeOther)
+}
+
+// machineFormError returns the message shown next to the form for errors the
+// user can fix, other errors are written as the response and ok is false
+func machineFormError(w http.ResponseWriter, err error) (message string, ok bool) {
+	var invalid invalidMachineError
+	switch {
+	case err == nil:
+		return "", true
+	case errors.As(err, &invalid):
+		return "Invalid machine: " + invalid.Error(), true
+	case errors.Is(err, errMachineExists):
+		return "A machine with this name already exists", true
+	case errors.Is(err, errReadOnly):
+		http.Error(w, readOnlyReason(), http.StatusForbidden)
+	case errors.Is(err, errPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		log.Printf("Error saving machine: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return "", false
+}
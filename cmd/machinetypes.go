@@ -0,0 +1,42 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/probe"
+)
+
+// machineType wakes and checks machines that aren't woken by magic packets,
+// such as virtual machines, registered by the type of the machine
+type machineType struct {
+	// wake starts the machine
+	wake func(ctx context.Context, machine config.Machine) error
+	// prober checks the machine unless it has a check configured
+	prober func(machine config.Machine) (probe.Prober, error)
+	// power shuts down or reboots the machine, status is either
+	// statusShuttingDown or statusRebooting. Nil if the type can't.
+	power func(ctx context.Context, machine config.Machine, status string) error
+}
+
+var machineTypes = make(map[string]machineType)
+
+// registerMachineType makes the machine type usable by machines
+func registerMachineType(name string, t machineType) {
+	if _, ok := machineTypes[name]; ok {
+		panic("machine type registered twice: " + name)
+	}
+	machineTypes[name] = t
+}
+
+// typeOf returns the type of the machine, false for machines woken by magic
+// packets and machines of peers, which wake them themselves
+func typeOf(machine config.Machine) (machineType, bool) {
+	if machine.Type == "" || machine.Peer != "" {
+		return machineType{}, false
+	}
+	t, ok := machineTypes[machine.Type]
+	return t, ok
+}
@@ -0,0 +1,166 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trugamr/wol/maintenance"
+)
+
+const maintenanceFilename = "maintenance.json"
+
+// maintenanceStore holds whether an admin put the server into maintenance mode
+var maintenanceStore *maintenance.Store
+
+// apiMaintenance represents the maintenance mode in API requests and responses
+type apiMaintenance struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+	// ReadOnly is true if the config file makes the server read-only regardless
+	ReadOnly bool       `json:"read_only"`
+	By       string     `json:"by,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+// setupMaintenance opens the maintenance state of the data directory
+func setupMaintenance() {
+	maintenanceStore = maintenance.NewStore(filepath.Join(cfg.DataDir, maintenanceFilename))
+}
+
+// currentMaintenance returns the maintenance state, not enabled if it can't be read
+func currentMaintenance() maintenance.State {
+	if maintenanceStore == nil {
+		return maintenance.State{}
+	}
+	state, err := maintenanceStore.Get()
+	if err != nil {
+		log.Printf("Error loading maintenance state: %v", err)
+		return maintenance.State{}
+	}
+	return state
+}
+
+// readOnly reports whether machines can't be woken, shut down or changed,
+// either because of the config file or because of maintenance
+func readOnly() bool {
+	return cfg.Server.ReadOnly || currentMaintenance().Enabled
+}
+
+// readOnlyReason tells users why actions are rejected in read-only mode
+func readOnlyReason() string {
+	if cfg.Server.ReadOnly {
+		return "Server is in read-only mode"
+	}
+	state := currentMaintenance()
+	if state.Message != "" {
+		return "Server is in maintenance mode (" + state.Message + ")"
+	}
+	return "Server is in maintenance mode"
+}
+
+// setMaintenance turns the maintenance mode on or off on behalf of the user
+// making the request, the change is recorded in the audit log
+func setMaintenance(r *http.Request, enabled bool, message string) (maintenance.State, error) {
+	if !userPermissions(r).IsAdmin() {
+		return maintenance.State{}, errPermission
+	}
+
+	p, _ := requestPrincipal(r)
+	state := maintenance.State{
+		Enabled: enabled,
+		Message: strings.TrimSpace(message),
+		By:      p.Username,
+		Since:   time.Now(),
+	}
+	if !enabled {
+		state.Message = ""
+	}
+	err := maintenanceStore.Set(state)
+
+	action := "maintenance_end"
+	if enabled {
+		action = "maintenance_start"
+	}
+	recordAudit(r, action, "", err)
+	if err != nil {
+		return maintenance.State{}, fmt.Errorf("failed to save maintenance state: %w", err)
+	}
+
+	if enabled {
+		log.Printf("Maintenance mode started, waking machines is disabled")
+	} else {
+		log.Printf("Maintenance mode ended")
+	}
+	return state, nil
+}
+
+// handleMaintenance starts or ends the maintenance mode from the web interface
+func handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	enabled := r.FormValue("enabled") == "true"
+	_, err := setMaintenance(r, enabled, r.FormValue("message"))
+	if err != nil {
+		log.Printf("Error changing maintenance mode: %v", err)
+		http.Error(w, "Failed to change maintenance mode", http.StatusInternalServerError)
+		return
+	}
+
+	message := "Maintenance ended, machines can be woken again"
+	if enabled {
+		message = "Maintenance started, machines can't be woken until it ends"
+	}
+	setFlashMessage(w, message)
+	http.Redirect(w, r, appURL("/admin/machines"), http.StatusSeeOther)
+}
+
+func handleAPIMaintenance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, newAPIMaintenance(currentMaintenance()))
+}
+
+func handleAPISetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Enabled bool   `json:"enabled"`
+		Message string `json:"message"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&input)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	state, err := setMaintenance(r, input.Enabled, input.Message)
+	switch {
+	case errors.Is(err, errPermission):
+		writeAPIError(w, http.StatusForbidden, "forbidden", "Only admins can change the maintenance mode")
+		return
+	case err != nil:
+		log.Printf("Error changing maintenance mode: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to change maintenance mode")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIMaintenance(state))
+}
+
+// newAPIMaintenance converts the maintenance state to its API representation
+func newAPIMaintenance(state maintenance.State) apiMaintenance {
+	response := apiMaintenance{
+		Enabled:  state.Enabled,
+		Message:  state.Message,
+		ReadOnly: cfg.Server.ReadOnly,
+		By:       state.By,
+	}
+	if !state.Since.IsZero() {
+		response.Since = &state.Since
+	}
+	return response
+}
@@ -0,0 +1,38 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/maintenance"
+)
+
+func TestWakeAsServiceRefusedDuringMaintenance(t *testing.T) {
+	oldCfg, oldStore := cfg, maintenanceStore
+	t.Cleanup(func() { cfg, maintenanceStore = oldCfg, oldStore })
+	cfg = config.NewConfig()
+	maintenanceStore = maintenance.NewStore(filepath.Join(t.TempDir(), maintenanceFilename))
+	err := maintenanceStore.Set(maintenance.State{Enabled: true, Message: "Electrical work"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid MAC makes sending fail, so any error other than
+	// errReadOnly means a magic packet would have been sent
+	machine := config.Machine{Name: "desktop", Mac: "invalid"}
+	for _, user := range []string{"arp proxy", "dns", "gateway", "sleep proxy"} {
+		err := wakeAsService(context.Background(), user, machine)
+		if !errors.Is(err, errReadOnly) {
+			t.Errorf("wake by %s during maintenance returned %v, want %v", user, err, errReadOnly)
+		}
+	}
+	err = powerAsService(context.Background(), "schedule:night", machine, statusShuttingDown)
+	if !errors.Is(err, errReadOnly) {
+		t.Errorf("shutdown during maintenance returned %v, want %v", err, errReadOnly)
+	}
+}
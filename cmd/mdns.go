@@ -0,0 +1,84 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/trugamr/wol/mdns"
+	"github.com/trugamr/wol/probe"
+)
+
+// mdnsBrowseTimeout is how long answers to a browse are waited for
+const mdnsBrowseTimeout = 3 * time.Second
+
+// mdnsResolveDelay is how long the kernel gets to resolve the hardware
+// addresses of devices that didn't announce theirs
+const mdnsResolveDelay = 500 * time.Millisecond
+
+// setupMDNS adds browsing with mDNS as a device source if enabled, before
+// controllers and firewalls so the names given there win
+func setupMDNS() error {
+	c := cfg.MDNS
+	if !c.Enabled {
+		return nil
+	}
+	_, err := addDeviceSource("mDNS", c.Interval, func(ctx context.Context) ([]networkDevice, error) {
+		hosts, err := mdns.Browse(ctx, c.Services, mdnsBrowseTimeout)
+		if err != nil {
+			return nil, err
+		}
+		resolveMACs(ctx, hosts)
+
+		devices := make([]networkDevice, 0, len(hosts))
+		for _, host := range hosts {
+			// Devices can only be added as machines with a MAC address
+			if host.MAC == "" {
+				continue
+			}
+			devices = append(devices, networkDevice{MAC: host.MAC, IP: host.IP, Hostname: host.Name, Services: host.Services, Online: true})
+		}
+		return devices, nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid mdns settings: %w", err)
+	}
+	return nil
+}
+
+// resolveMACs looks up the MAC addresses of hosts that didn't announce theirs
+// in the neighbor table, sending them a packet first so the kernel resolves them
+func resolveMACs(ctx context.Context, hosts []mdns.Host) {
+	var pending []int
+	for i, host := range hosts {
+		if host.MAC != "" {
+			continue
+		}
+		conn, err := net.Dial("udp", net.JoinHostPort(host.IP, "9"))
+		if err != nil {
+			continue
+		}
+		conn.Write([]byte{0})
+		conn.Close()
+		pending = append(pending, i)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	time.Sleep(mdnsResolveDelay)
+	for _, i := range pending {
+		addr, err := netip.ParseAddr(hosts[i].IP)
+		if err != nil {
+			continue
+		}
+		hw, err := probe.Neighbor(ctx, addr)
+		if err == nil && hw != nil {
+			hosts[i].MAC = hw.String()
+		}
+	}
+}
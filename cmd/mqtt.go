@@ -0,0 +1,230 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/trugamr/wol/config"
+)
+
+// mqttUser is who wakes and shutdowns requested over MQTT are recorded as
+const mqttUser = "mqtt"
+
+// mqttBridge publishes the status of machines to an MQTT broker and runs the
+// commands sent to the machines' command topics
+type mqttBridge struct {
+	client mqtt.Client
+
+	mu sync.Mutex
+	// published holds the last status published per machine
+	published map[string]string
+	// discovered holds the topics of the Home Assistant discovery messages published
+	discovered map[string]bool
+}
+
+// mqttClient is connected to the broker while serving, if configured
+var mqttClient *mqttBridge
+
+// mqttTopic joins the parts to a topic below the prefix
+func mqttTopic(parts ...string) string {
+	return strings.Join(append([]string{cfg.MQTT.TopicPrefix}, parts...), "/")
+}
+
+// mqttAvailabilityTopic returns the topic telling whether wol is running
+func mqttAvailabilityTopic() string {
+	return mqttTopic(cfg.MQTT.Availability.Topic)
+}
+
+// mqttMachineID returns the name of the machine as used in topics, which
+// can't contain wildcards
+func mqttMachineID(name string) string {
+	return strings.NewReplacer(" ", "_", "+", "_").Replace(strings.ToLower(name))
+}
+
+// mqttTLSConfig returns the TLS configuration of the connection to the broker
+func mqttTLSConfig() (*tls.Config, error) {
+	c := cfg.MQTT.TLS
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// setupMQTT connects to the broker in the background if one is configured,
+// connecting is retried until it succeeds
+func setupMQTT() error {
+	if cfg.MQTT.Broker == "" {
+		return nil
+	}
+	if cfg.MQTT.QoS > 2 {
+		return fmt.Errorf("mqtt.qos must be 0, 1 or 2")
+	}
+	availability := cfg.MQTT.Availability
+	if availability.Topic == "" || strings.ContainsAny(availability.Topic, "+#") {
+		return fmt.Errorf("mqtt.availability.topic must be set and can't contain wildcards")
+	}
+	if availability.PayloadOnline == "" || availability.PayloadOffline == "" || availability.PayloadOnline == availability.PayloadOffline {
+		return fmt.Errorf("mqtt.availability.payload_online and payload_offline must be set and differ")
+	}
+
+	tlsConfig, err := mqttTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	bridge := &mqttBridge{
+		published:  make(map[string]string),
+		discovered: make(map[string]bool),
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.MQTT.Broker).
+		SetClientID(cfg.MQTT.ClientID).
+		SetUsername(cfg.MQTT.Username).
+		SetPassword(cfg.MQTT.Password).
+		SetTLSConfig(tlsConfig).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10*time.Second).
+		// The broker announces we're gone if the connection drops
+		SetWill(mqttAvailabilityTopic(), availability.PayloadOffline, cfg.MQTT.QoS, true).
+		SetOnConnectHandler(bridge.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("Lost connection to MQTT broker: %v", err)
+		})
+	bridge.client = mqtt.NewClient(opts)
+	bridge.client.Connect()
+	mqttClient = bridge
+	return nil
+}
+
+// onConnect announces availability, publishes all statuses as retained
+// messages may be gone and subscribes to the command topics
+func (b *mqttBridge) onConnect(client mqtt.Client) {
+	log.Printf("Connected to MQTT broker %s", cfg.MQTT.Broker)
+	client.Publish(mqttAvailabilityTopic(), cfg.MQTT.QoS, true, cfg.MQTT.Availability.PayloadOnline)
+
+	b.mu.Lock()
+	clear(b.published)
+	clear(b.discovered)
+	b.mu.Unlock()
+	statuses, _ := poller.snapshot(allMachines())
+	b.publish(statuses)
+
+	subscriptions := map[string]mqtt.MessageHandler{
+		mqttTopic("machines", "+", "set"): b.onCommand,
+	}
+	if cfg.MQTT.HomeAssistant.Discovery {
+		subscriptions[cfg.MQTT.HomeAssistant.DiscoveryPrefix+"/status"] = b.onHomeAssistantStatus
+	}
+	for topic, handler := range subscriptions {
+		token := client.Subscribe(topic, cfg.MQTT.QoS, handler)
+		go func() {
+			if token.Wait() && token.Error() != nil {
+				log.Printf("Error subscribing to %s: %v", topic, token.Error())
+			}
+		}()
+	}
+}
+
+// publish sends the statuses that changed since they were last published,
+// along with discovery messages of machines added in the meantime
+func (b *mqttBridge) publish(statuses map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.client.IsConnectionOpen() {
+		return
+	}
+	b.syncDiscovery()
+	for name, status := range statuses {
+		if b.published[name] == status {
+			continue
+		}
+		b.published[name] = status
+		b.client.Publish(mqttTopic("machines", mqttMachineID(name), "status"), cfg.MQTT.QoS, true, status)
+	}
+}
+
+// onCommand runs a command sent to a machine: wake, shutdown or reboot
+func (b *mqttBridge) onCommand(_ mqtt.Client, msg mqtt.Message) {
+	id := strings.TrimSuffix(strings.TrimPrefix(msg.Topic(), mqttTopic("machines")+"/"), "/set")
+	command := strings.ToLower(strings.TrimSpace(string(msg.Payload())))
+
+	var machine config.Machine
+	found := false
+	for _, m := range allMachines() {
+		if mqttMachineID(m.Name) == id {
+			machine, found = m, true
+			break
+		}
+	}
+	if !found {
+		log.Printf("Ignoring MQTT command for unknown machine %q", id)
+		return
+	}
+
+	// Commands may take a while, e.g. connecting over SSH
+	go func() {
+		ctx, span := tracer.Start(context.Background(), "mqtt."+command)
+		defer span.End()
+
+		var err error
+		switch command {
+		case "wake":
+			err = wakeAsService(ctx, mqttUser, machine)
+		case "shutdown":
+			err = powerAsService(ctx, mqttUser, machine, statusShuttingDown)
+		case "reboot":
+			err = powerAsService(ctx, mqttUser, machine, statusRebooting)
+		default:
+			err = fmt.Errorf("unknown command %q, must be wake, shutdown or reboot", command)
+		}
+		if err != nil {
+			log.Printf("Error running MQTT command for %s: %v", machine.Name, err)
+		}
+	}()
+}
+
+// publishMQTTStatuses publishes the statuses of a sweep if connected to a broker
+func publishMQTTStatuses(statuses map[string]string) {
+	if mqttClient == nil {
+		return
+	}
+	mqttClient.publish(statuses)
+}
+
+// closeMQTT announces that the server is going away and disconnects
+func closeMQTT() {
+	if mqttClient == nil {
+		return
+	}
+	token := mqttClient.client.Publish(mqttAvailabilityTopic(), cfg.MQTT.QoS, true, cfg.MQTT.Availability.PayloadOffline)
+	token.WaitTimeout(time.Second)
+	mqttClient.client.Disconnect(250)
+}
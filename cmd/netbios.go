@@ -0,0 +1,81 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/netbios"
+)
+
+// netbiosTimeout is how long a device gets to answer with its names
+const netbiosTimeout = 2 * time.Second
+
+// netbiosConcurrency limits how many devices are asked for their names at once
+const netbiosConcurrency = 16
+
+// netbiosSource names the devices seen on the network that have no hostname,
+// nil if disabled
+var netbiosSource *deviceSource
+
+// setupNetBIOS adds naming devices with NetBIOS as a device source if
+// enabled, after all others as it only names their devices
+func setupNetBIOS() error {
+	c := cfg.NetBIOS
+	if !c.Enabled {
+		return nil
+	}
+	var err error
+	netbiosSource, err = addDeviceSource("NetBIOS", c.Interval, nameDevices)
+	if err != nil {
+		return fmt.Errorf("invalid netbios settings: %w", err)
+	}
+	return nil
+}
+
+// nameDevices asks the devices without a hostname, and the ones named
+// before, for their NetBIOS names
+func nameDevices(ctx context.Context) ([]networkDevice, error) {
+	named, _ := netbiosSource.current()
+	var pending []networkDevice
+	for mac, device := range networkDevices() {
+		if _, ok := named[mac]; device.IP != "" && (ok || device.Hostname == "") {
+			pending = append(pending, device)
+		}
+	}
+
+	var mu sync.Mutex
+	var devices []networkDevice
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, netbiosConcurrency)
+	for _, device := range pending {
+		addr, err := netip.ParseAddr(device.IP)
+		if err != nil || !addr.Is4() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			status, err := netbios.NodeStatus(ctx, addr, netbiosTimeout)
+			if err != nil || status.Name == "" {
+				return
+			}
+			// Addresses may have moved to another device since
+			if status.MAC != nil && status.MAC.String() != device.MAC {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			devices = append(devices, networkDevice{MAC: device.MAC, IP: device.IP, Hostname: status.Name, Online: true})
+		}()
+	}
+	wg.Wait()
+	return devices, nil
+}
@@ -0,0 +1,27 @@
+//go:build noserve
+
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/config"
+)
+
+// Binaries built with the noserve tag only send magic packets and list the
+// machines of the local config, leaving out the web interface, the server,
+// the status checks and the API client for routers with little flash
+
+// peerMachines returns no machines as peers are only followed while serving
+func peerMachines() []config.Machine {
+	return nil
+}
+
+// wakeRemote returns false as there is no API client to ask a server
+func wakeRemote(cmd *cobra.Command) bool {
+	return false
+}
+
+// remoteMachines returns false as there is no API client to ask a server
+func remoteMachines(cmd *cobra.Command) ([]config.Machine, bool) {
+	return nil, false
+}
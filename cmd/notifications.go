@@ -0,0 +1,82 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/trugamr/wol/notify"
+	"github.com/trugamr/wol/webhook"
+)
+
+// notifiers are told when machines come online, go offline or fail to wake while serving
+var notifiers []*notify.Notifier
+
+// setupNotifications checks the notification services of the config file
+func setupNotifications() error {
+	for i, c := range cfg.Notifications {
+		notifier, err := notify.New(notify.Config{
+			Provider: c.Provider,
+			Events:   c.Events,
+			URL:      c.URL,
+			Token:    c.Token,
+			ChatID:   c.ChatID,
+			URLs:     c.URLs,
+			Timeout:  c.Timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid notification %d: %w", i+1, err)
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return nil
+}
+
+// notificationMessage returns the notification of a webhook event, false if
+// the event isn't worth one
+func notificationMessage(event webhook.Event) (notify.Message, bool) {
+	switch {
+	case event.Event == webhook.EventStatus && event.Status == "online":
+		return notify.Message{
+			Event: notify.EventOnline,
+			Title: fmt.Sprintf("%s is online", event.Machine),
+			Text:  fmt.Sprintf("Came online at %s.", event.Time.Format("15:04")),
+		}, true
+	// Machines being shut down or rebooted pass through other statuses
+	case event.Event == webhook.EventStatus && event.Status == "offline" && event.PreviousStatus == "online":
+		return notify.Message{
+			Event: notify.EventOffline,
+			Title: fmt.Sprintf("%s went offline", event.Machine),
+			Text:  fmt.Sprintf("Went offline unexpectedly at %s.", event.Time.Format("15:04")),
+		}, true
+	case event.Event == webhook.EventWakeFailed, event.Event == webhook.EventWake && event.Error != "":
+		return notify.Message{
+			Event: notify.EventWakeFailed,
+			Title: fmt.Sprintf("Waking %s failed", event.Machine),
+			Text:  fmt.Sprintf("Error: %s.", event.Error),
+		}, true
+	}
+	return notify.Message{}, false
+}
+
+// sendNotifications sends the notification of the event, if any, to the
+// services that want it in the background
+func sendNotifications(event webhook.Event) {
+	msg, ok := notificationMessage(event)
+	if !ok {
+		return
+	}
+	for _, notifier := range notifiers {
+		if !notifier.Wants(msg.Event) {
+			continue
+		}
+		go func() {
+			err := notifier.Send(context.Background(), msg)
+			if err != nil {
+				log.Printf("Error sending %s notification of %s to %s: %v", msg.Event, event.Machine, notifier.Provider(), err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+)
+
+// swaggerUIDir is where a copy of swagger-ui-dist can be put in the static
+// directory for networks without internet access
+const swaggerUIDir = "swagger-ui"
+
+// swaggerUICDN serves Swagger UI unless a copy is in the static directory
+const swaggerUICDN = "https://unpkg.com/swagger-ui-dist@5"
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the OpenAPI document of the JSON API with the server
+// URL adjusted to the base path
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	var spec map[string]interface{}
+	err := json.Unmarshal(openAPISpec, &spec)
+	if err != nil {
+		log.Printf("Error parsing OpenAPI document: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	spec["servers"] = []map[string]string{{"url": appURL("/api/v1")}}
+
+	writeJSON(w, http.StatusOK, spec)
+}
+
+// swaggerUIURL returns where the files of Swagger UI are loaded from
+func swaggerUIURL() string {
+	if _, err := fs.Stat(staticFS(), swaggerUIDir+"/swagger-ui-bundle.js"); err == nil {
+		return basePath + "/static/" + swaggerUIDir
+	}
+	return swaggerUICDN
+}
+
+func handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	swaggerUI := swaggerUIURL()
+	if swaggerUI == swaggerUICDN {
+		allowCSPSource(w, "https://unpkg.com", "script-src", "style-src")
+	}
+	renderTemplate(w, r, "api_docs.html", map[string]interface{}{
+		"SwaggerUI": swaggerUI,
+	})
+}
@@ -0,0 +1,58 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/trugamr/wol/internal/overlayfs"
+)
+
+// overrideFS returns the embedded directory, with the files of dir taking
+// precedence if set
+func overrideFS(embedded fs.FS, sub, dir string) fs.FS {
+	bundled, err := fs.Sub(embedded, sub)
+	if err != nil {
+		// The embedded directories always exist
+		panic(err)
+	}
+	if dir == "" {
+		return bundled
+	}
+	return overlayfs.New(os.DirFS(dir), bundled)
+}
+
+// templateFS returns the templates, read for every page so changes to
+// overridden templates show up without restarting
+func templateFS() fs.FS {
+	return overrideFS(templates, "templates", cfg.Server.TemplatesDir)
+}
+
+// staticFS returns the static files, with the ones of the static directory
+// taking precedence
+func staticFS() fs.FS {
+	return overrideFS(static, "static", cfg.Server.StaticDir)
+}
+
+// checkOverrideDirs makes sure the configured override directories exist
+func checkOverrideDirs() error {
+	dirs := map[string]string{
+		"server.templates_dir": cfg.Server.TemplatesDir,
+		"server.static_dir":    cfg.Server.StaticDir,
+	}
+	for key, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid %s: %s is not a directory", key, dir)
+		}
+	}
+	return nil
+}
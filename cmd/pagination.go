@@ -0,0 +1,51 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// pageView is the page of machines shown on the dashboard
+type pageView struct {
+	// Number of the page, starting at 1
+	Number int
+	// Count of pages
+	Count int
+	// Previous and Next are the query strings of the adjacent pages, empty if there is none
+	Previous string
+	Next     string
+}
+
+// paginate returns the bounds of the requested page of total items, size
+// items per page, all of them if size is zero. Pages out of range show the
+// closest page.
+func paginate(total, size int, query url.Values) (int, int, pageView) {
+	if size <= 0 || total <= size {
+		return 0, total, pageView{Number: 1, Count: 1}
+	}
+
+	count := (total + size - 1) / size
+	number, _ := strconv.Atoi(query.Get("page"))
+	number = min(max(number, 1), count)
+
+	link := func(n int) string {
+		q := url.Values{}
+		for key, values := range query {
+			q[key] = values
+		}
+		q.Set("page", strconv.Itoa(n))
+		return "?" + q.Encode()
+	}
+	page := pageView{Number: number, Count: count}
+	if number > 1 {
+		page.Previous = link(number - 1)
+	}
+	if number < count {
+		page.Next = link(number + 1)
+	}
+
+	start := (number - 1) * size
+	return start, min(start+size, total), page
+}
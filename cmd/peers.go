@@ -0,0 +1,237 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/client"
+	"github.com/trugamr/wol/config"
+)
+
+// Defaults of the peer settings
+const (
+	defaultPeerInterval = time.Minute
+	defaultPeerTimeout  = 10 * time.Second
+)
+
+// errPeerNotFound is returned for machines of peers that aren't configured anymore
+var errPeerNotFound = errors.New("peer not found")
+
+// peerServer is another wol server whose machines are shown and woken through
+// this one
+type peerServer struct {
+	name     string
+	interval time.Duration
+	timeout  time.Duration
+	client   *client.Client
+
+	mu       sync.Mutex
+	machines []config.Machine
+	// statuses of the machines by their name on the peer
+	statuses map[string]string
+	// err is the error of the last update of the machines, statuses aren't
+	// trusted while the peer can't be reached
+	err error
+}
+
+// peers are the configured peers
+var peers []*peerServer
+
+// setupPeers validates the configured peers
+func setupPeers() error {
+	seen := make(map[string]bool)
+	for i, c := range cfg.Peers {
+		if c.Name == "" || strings.ContainsAny(c.Name, "/?#@") {
+			return fmt.Errorf("invalid peer %d: name is required and must not contain /, ?, # or @", i+1)
+		}
+		if seen[strings.ToLower(c.Name)] {
+			return fmt.Errorf("invalid peer %d: duplicate name %q", i+1, c.Name)
+		}
+		seen[strings.ToLower(c.Name)] = true
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid peer %s: url must be an http or https URL", c.Name)
+		}
+		if c.Interval < 0 || c.Timeout < 0 {
+			return fmt.Errorf("invalid peer %s: interval and timeout must not be negative", c.Name)
+		}
+
+		p := &peerServer{
+			name:     c.Name,
+			interval: c.Interval,
+			timeout:  c.Timeout,
+			client:   client.New(c.URL, c.Token),
+			statuses: make(map[string]string),
+		}
+		if p.interval == 0 {
+			p.interval = defaultPeerInterval
+		}
+		if p.timeout == 0 {
+			p.timeout = defaultPeerTimeout
+		}
+		peers = append(peers, p)
+	}
+	return nil
+}
+
+// runPeers keeps the machines and statuses of the peers up to date until stop is closed
+func runPeers(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for _, p := range peers {
+		go p.run(ctx)
+	}
+}
+
+// run updates the machines of the peer every interval and follows their
+// statuses until the context is done
+func (p *peerServer) run(ctx context.Context) {
+	go p.stream(ctx)
+	for {
+		p.update(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// update fetches the machines of the peer
+func (p *peerServer) update(ctx context.Context) {
+	reqCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	remote, err := p.client.Machines(reqCtx)
+	// Shutting down
+	if ctx.Err() != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		if p.err == nil {
+			log.Printf("Error updating machines of peer %s: %v", p.name, err)
+		}
+		p.err = err
+		return
+	}
+	if p.err != nil {
+		log.Printf("Peer %s is reachable again", p.name)
+	}
+	p.err = nil
+
+	machines := make([]config.Machine, 0, len(remote))
+	for _, machine := range remote {
+		// Machines of the peer's own peers would loop between servers
+		// federating each other
+		if machine.Peer != "" {
+			continue
+		}
+		machines = append(machines, config.Machine{
+			Name:  machine.Name + "@" + p.name,
+			Mac:   machine.Mac,
+			IP:    machine.IP,
+			Group: p.name,
+			Tags:  machine.Tags,
+			Peer:  p.name,
+		})
+	}
+	p.machines = machines
+}
+
+// stream follows the statuses of the peer's machines, the dashboard is
+// refreshed whenever they change
+func (p *peerServer) stream(ctx context.Context) {
+	for {
+		err := p.client.StreamStatus(ctx, func(statuses map[string]string) error {
+			p.mu.Lock()
+			for name, status := range statuses {
+				p.statuses[name] = status
+			}
+			p.mu.Unlock()
+			poller.triggerRefresh()
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		// Errors such as an invalid token are retried less often
+		log.Printf("Error following statuses of peer %s: %v", p.name, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// findPeer returns the peer with the given name
+func findPeer(name string) (*peerServer, bool) {
+	for _, p := range peers {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// peerMachines returns the machines of all peers
+func peerMachines() []config.Machine {
+	var machines []config.Machine
+	for _, p := range peers {
+		p.mu.Lock()
+		machines = append(machines, p.machines...)
+		p.mu.Unlock()
+	}
+	return machines
+}
+
+// peerMachineName returns the name of a machine of a peer on the peer
+func peerMachineName(machine config.Machine) string {
+	return strings.TrimSuffix(machine.Name, "@"+machine.Peer)
+}
+
+// peerMachineStatus returns the status of a machine of a peer as last
+// reported by the peer, unknown while the peer can't be reached
+func peerMachineStatus(machine config.Machine) string {
+	p, ok := findPeer(machine.Peer)
+	if !ok {
+		return "unknown"
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status, ok := p.statuses[peerMachineName(machine)]
+	if !ok || p.err != nil {
+		return "unknown"
+	}
+	return status
+}
+
+// wakePeerMachine asks the peer a machine belongs to to wake it
+func wakePeerMachine(ctx context.Context, machine config.Machine) error {
+	p, ok := findPeer(machine.Peer)
+	if !ok {
+		return errPeerNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	_, err := p.client.Wake(ctx, peerMachineName(machine))
+	if err != nil {
+		return fmt.Errorf("failed to wake through peer %s: %w", p.name, err)
+	}
+	return nil
+}
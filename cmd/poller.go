@@ -0,0 +1,260 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+)
+
+// poller probes the machines for all status streams and API requests
+var poller *statusPoller
+
+// statusPoller probes the machines in the background and shares the results
+// with every subscriber, so the number of open dashboards doesn't multiply
+// the number of probes. Only the machines watched by subscribers are probed
+// every interval, all of them are probed every background interval.
+type statusPoller struct {
+	interval time.Duration
+	// ttl is how old statuses can be before requests probe the machine again
+	ttl time.Duration
+	// background is how often all machines are probed, never if zero
+	background time.Duration
+	refresh    chan struct{}
+	// always probes all machines every interval, e.g. to notice status changes for webhooks
+	always bool
+	// sweepMu makes sure only one sweep runs at a time
+	sweepMu sync.Mutex
+
+	mu       sync.Mutex
+	statuses map[string]string
+	version  uint64
+	// probedAt is when each machine was last probed
+	probedAt map[string]time.Time
+	// sweptAt is when all machines were last probed
+	sweptAt time.Time
+	// subscribers are notified after every sweep, along with the names of the machines they watch
+	subscribers map[chan struct{}]map[string]bool
+}
+
+// newStatusPoller creates a poller probing watched machines every interval
+// and all of them every background interval, requests use statuses up to ttl
+// old
+func newStatusPoller(interval, background, ttl time.Duration) *statusPoller {
+	return &statusPoller{
+		interval:    interval,
+		ttl:         ttl,
+		background:  background,
+		refresh:     make(chan struct{}, 1),
+		statuses:    make(map[string]string),
+		probedAt:    make(map[string]time.Time),
+		subscribers: make(map[chan struct{}]map[string]bool),
+	}
+}
+
+// run probes the machines periodically until stop is closed
+func (p *statusPoller) run(stop <-chan struct{}) {
+	timer := time.NewTimer(p.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		case <-p.refresh:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		if machines := p.due(); len(machines) > 0 {
+			p.sweep(machines)
+		}
+		timer.Reset(p.interval)
+	}
+}
+
+// due returns the machines to probe in the next sweep: all of them if
+// always is set or the last background sweep is long enough ago, otherwise
+// the ones watched by subscribers
+func (p *statusPoller) due() []config.Machine {
+	machines := allMachines()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.always || (p.background > 0 && time.Since(p.sweptAt) >= p.background) {
+		return machines
+	}
+	return slices.DeleteFunc(machines, func(machine config.Machine) bool {
+		return !p.watched(machine.Name)
+	})
+}
+
+// watched reports whether any subscriber watches the machine, callers must hold the lock
+func (p *statusPoller) watched(name string) bool {
+	for _, names := range p.subscribers {
+		if names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep probes the machines and notifies the subscribers
+func (p *statusPoller) sweep(machines []config.Machine) {
+	p.sweepMu.Lock()
+	defer p.sweepMu.Unlock()
+	p.probe(machines)
+}
+
+// probe does the work of sweep, callers must hold sweepMu
+func (p *statusPoller) probe(machines []config.Machine) {
+	all := allMachines()
+	ctx, span := tracer.Start(context.Background(), "status.sweep")
+	current := getMachinesStatus(ctx, machines)
+	span.End()
+
+	// Machines being woken, shut down or rebooted report that until it's done
+	wakeProgress.apply(current)
+	powerActions.apply(current)
+	statusDebounce.apply(machines, current)
+	recordStatusHistory(current)
+
+	version, changes := statusChanges.update(current)
+	notifyStatusChanges(current, changes)
+	announceOnlineMachines(changes)
+	publishMQTTStatuses(current)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if len(machines) >= len(all) {
+		// Machines that are gone are forgotten
+		p.statuses = current
+		p.probedAt = make(map[string]time.Time, len(machines))
+		p.sweptAt = now
+	} else {
+		// Machines that failed to be probed have no status
+		for _, machine := range machines {
+			delete(p.statuses, machine.Name)
+		}
+		for name, status := range current {
+			p.statuses[name] = status
+		}
+	}
+	for _, machine := range machines {
+		p.probedAt[machine.Name] = now
+	}
+	p.version = version
+	for ch := range p.subscribers {
+		// Subscribers that haven't caught up yet get the latest statuses next time
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stale returns the machines probed longer than the ttl ago, callers must hold the lock
+func (p *statusPoller) stale(machines []config.Machine) []config.Machine {
+	var stale []config.Machine
+	for _, machine := range machines {
+		probedAt, ok := p.probedAt[machine.Name]
+		if !ok || time.Since(probedAt) >= p.ttl {
+			stale = append(stale, machine)
+		}
+	}
+	return stale
+}
+
+// triggerRefresh asks for a sweep right away
+func (p *statusPoller) triggerRefresh() {
+	select {
+	case p.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// subscribe starts probing the machines every interval and returns a channel
+// receiving a value whenever new statuses are available, including right away
+// if they are recent, and a function to unsubscribe
+func (p *statusPoller) subscribe(machines []config.Machine) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	names := make(map[string]bool, len(machines))
+	for _, machine := range machines {
+		names[machine.Name] = true
+	}
+
+	p.mu.Lock()
+	p.subscribers[ch] = names
+	fresh := len(p.stale(machines)) == 0
+	p.mu.Unlock()
+
+	if fresh {
+		ch <- struct{}{}
+	} else {
+		p.triggerRefresh()
+	}
+
+	return ch, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.subscribers, ch)
+	}
+}
+
+// snapshot returns the statuses of the machines and their version
+func (p *statusPoller) snapshot(machines []config.Machine) (map[string]string, uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make(map[string]string, len(machines))
+	for _, machine := range machines {
+		if status, ok := p.statuses[machine.Name]; ok {
+			statuses[machine.Name] = status
+		}
+	}
+	return statuses, p.version
+}
+
+// checked returns when the machines were last probed, machines that weren't
+// probed yet are missing
+func (p *statusPoller) checked(machines []config.Machine) map[string]time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	checked := make(map[string]time.Time, len(machines))
+	for _, machine := range machines {
+		if probedAt, ok := p.probedAt[machine.Name]; ok {
+			checked[machine.Name] = probedAt
+		}
+	}
+	return checked
+}
+
+// current returns the statuses of the machines, probing the ones probed
+// longer than the ttl ago first, e.g. because nobody watches them
+func (p *statusPoller) current(machines []config.Machine) map[string]string {
+	// Concurrent callers wait for a single sweep
+	p.sweepMu.Lock()
+	p.mu.Lock()
+	stale := p.stale(machines)
+	p.mu.Unlock()
+	if len(stale) > 0 {
+		p.probe(stale)
+	}
+	p.sweepMu.Unlock()
+
+	statuses, _ := p.snapshot(machines)
+	return statuses
+}
@@ -0,0 +1,260 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/audit"
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/remote"
+)
+
+// Statuses reported while a shutdown or reboot hasn't been observed yet
+const (
+	statusShuttingDown = "shutting-down"
+	statusRebooting    = "rebooting"
+)
+
+// powerActionTimeout stops tracking actions the status probe never confirmed
+const powerActionTimeout = 10 * time.Minute
+
+// errNoRemoteAccess is returned for machines without SSH configured
+var errNoRemoteAccess = errors.New("shutdown and reboot are not configured for this machine")
+
+// powerAction is a shutdown or reboot waiting to be confirmed by the status probe
+type powerAction struct {
+	status      string
+	startedAt   time.Time
+	seenOffline bool
+}
+
+// powerTracker overrides the probed status of machines being shut down or rebooted
+type powerTracker struct {
+	mu      sync.Mutex
+	actions map[string]*powerAction
+}
+
+var powerActions = &powerTracker{actions: make(map[string]*powerAction)}
+
+// start tracks a shutdown or reboot of the machine
+func (t *powerTracker) start(name, status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.actions[name] = &powerAction{status: status, startedAt: time.Now()}
+}
+
+// apply replaces the probed statuses of machines with a pending action, a
+// shutdown is done once the machine is offline and a reboot once it is back
+// online after having been offline
+func (t *powerTracker) apply(statuses map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, action := range t.actions {
+		if time.Since(action.startedAt) > powerActionTimeout {
+			delete(t.actions, name)
+			continue
+		}
+
+		// Machines that couldn't be probed keep their pending status
+		probed := statuses[name]
+
+		if probed == "offline" {
+			action.seenOffline = true
+		}
+		done := action.seenOffline
+		if action.status == statusRebooting {
+			done = action.seenOffline && probed == "online"
+		}
+		if done {
+			delete(t.actions, name)
+			continue
+		}
+		statuses[name] = action.status
+	}
+}
+
+// canPower reports whether the machine can be shut down and rebooted, over
+// SSH or by its machine type
+func canPower(machine config.Machine) bool {
+	if t, ok := typeOf(machine); ok && t.power != nil {
+		return true
+	}
+	return machine.SSH != nil && machine.IP != nil
+}
+
+// machineSSH returns the SSH client of the machine, shared by everything
+// logging in to it. The address is only set for machines with an IP address.
+func machineSSH(machine config.Machine) remote.SSH {
+	var address string
+	if machine.IP != nil {
+		port := machine.SSH.Port
+		if port == 0 {
+			port = 22
+		}
+		address = net.JoinHostPort(*machine.IP, strconv.Itoa(port))
+	}
+	return remote.SSH{
+		Address:        address,
+		User:           machine.SSH.User,
+		KeyFile:        machine.SSH.KeyFile,
+		Agent:          machine.SSH.Agent,
+		Become:         machine.SSH.Become,
+		KnownHostsFile: cfg.SSH.KnownHostsFile,
+		HostKeyPolicy:  cfg.SSH.HostKeyPolicy,
+		Timeout:        cfg.SSH.Timeout,
+	}
+}
+
+// powerAs shuts down or reboots the machine on behalf of the user making the
+// request, status is either statusShuttingDown or statusRebooting
+func powerAs(r *http.Request, name, status string) (config.Machine, error) {
+	action := "shutdown"
+	if status == statusRebooting {
+		action = "reboot"
+	}
+
+	machine, ok := findVisibleMachine(r, name)
+	if !ok {
+		return config.Machine{}, errMachineNotFound
+	}
+	if readOnly() {
+		recordAudit(r, action, machine.Name, errReadOnly)
+		return machine, errReadOnly
+	}
+	if !requestPermissions(r).CanWake(machine.Name, machine.Group) {
+		recordAudit(r, action, machine.Name, errPermission)
+		return machine, errPermission
+	}
+	if !canPower(machine) {
+		return machine, errNoRemoteAccess
+	}
+
+	err := powerMachine(r.Context(), machine, status)
+	recordAudit(r, action, machine.Name, err)
+	if err != nil {
+		log.Printf("Error running %s on %s: %v", action, machine.Name, err)
+		return machine, err
+	}
+	return machine, nil
+}
+
+// powerAsService shuts down or reboots the machine on behalf of something
+// other than a user making a request, recorded like powerAs under the given name
+func powerAsService(ctx context.Context, user string, machine config.Machine, status string) error {
+	action := "shutdown"
+	if status == statusRebooting {
+		action = "reboot"
+	}
+	if readOnly() {
+		recordAuditEntry(audit.Entry{Action: action, User: user, Target: machine.Name}, errReadOnly)
+		return errReadOnly
+	}
+
+	err := powerMachine(ctx, machine, status)
+	recordAuditEntry(audit.Entry{
+		Action: action,
+		User:   user,
+		Target: machine.Name,
+		Result: audit.ResultSuccess,
+	}, err)
+	return err
+}
+
+// powerMachine runs the shutdown or reboot command on the machine and reports
+// its status accordingly until done, status is either statusShuttingDown or statusRebooting
+func powerMachine(ctx context.Context, machine config.Machine, status string) error {
+	if !canPower(machine) {
+		return errNoRemoteAccess
+	}
+	// Machine types shut down and reboot their machines unless SSH is configured
+	if machine.SSH == nil || machine.IP == nil {
+		return powerTypedMachine(ctx, machine, status)
+	}
+
+	action := "shutdown"
+	command := machine.SSH.ShutdownCommand
+	if command == "" {
+		command = "shutdown -h now"
+	}
+	if status == statusRebooting {
+		action = "reboot"
+		command = machine.SSH.RebootCommand
+		if command == "" {
+			command = "reboot"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.SSH.Timeout+30*time.Second)
+	defer cancel()
+	err := machineSSH(machine).Run(ctx, command)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sent %s to %s", action, machine.Name)
+	powerActions.start(machine.Name, status)
+	poller.triggerRefresh()
+	return nil
+}
+
+// powerTypedMachine shuts down or reboots the machine through its machine type
+func powerTypedMachine(ctx context.Context, machine config.Machine, status string) error {
+	action := "shutdown"
+	if status == statusRebooting {
+		action = "reboot"
+	}
+
+	t, _ := typeOf(machine)
+	err := t.power(ctx, machine, status)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sent %s to %s", action, machine.Name)
+	powerActions.start(machine.Name, status)
+	poller.triggerRefresh()
+	return nil
+}
+
+// handlePower returns a handler shutting down or rebooting the machine named in the form
+func handlePower(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		machineName := r.FormValue("name")
+
+		_, err := powerAs(r, machineName, status)
+		switch {
+		case errors.Is(err, errMachineNotFound):
+			http.Error(w, "Machine not found", http.StatusBadRequest)
+			return
+		case errors.Is(err, errReadOnly):
+			http.Error(w, readOnlyReason()+", changing machines is disabled", http.StatusForbidden)
+			return
+		case errors.Is(err, errPermission):
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		case errors.Is(err, errNoRemoteAccess):
+			http.Error(w, "Shutdown and reboot are not configured for this machine", http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		tr := requestTranslator(r)
+		if status == statusRebooting {
+			setFlashMessage(w, tr.T("Rebooting %s.", machineName))
+		} else {
+			setFlashMessage(w, tr.T("Shutting down %s.", machineName))
+		}
+		http.Redirect(w, r, appURL(safeRedirect(r.FormValue("next"))), http.StatusSeeOther)
+	}
+}
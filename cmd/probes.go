@@ -0,0 +1,99 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/probe"
+)
+
+// defaultCheckType is how machines without a check configured are checked
+const defaultCheckType = "ping"
+
+// machineProber returns how the status of the machine is checked, nil if it
+// can't be checked
+func machineProber(machine config.Machine) (probe.Prober, error) {
+	if !checkable(machine) {
+		return nil, nil
+	}
+	// Machine types know the status of their machines unless a check is configured
+	if t, ok := typeOf(machine); ok && machine.Check == nil {
+		return t.prober(machine)
+	}
+
+	c := probe.Config{Type: defaultCheckType, Name: machine.Name, MAC: machine.Mac, Privileged: cfg.Ping.Privileged}
+	if address, ok := preferTailscaleAddress(machine); ok {
+		c.Address = address
+	} else if machine.IP != nil {
+		c.Address = *machine.IP
+	} else if address, ok := machineAddress(machine); ok {
+		c.Address = address
+	}
+	if check := machine.Check; check != nil {
+		if check.Type != "" {
+			c.Type = check.Type
+		}
+		if check.Address != "" {
+			c.Address = check.Address
+		}
+		c.Port = check.Port
+		c.Timeout = check.Timeout
+		c.URL = check.URL
+		c.Status = check.Status
+		c.Contains = check.Contains
+		c.InsecureSkipVerify = check.InsecureSkipVerify
+		c.Refresh = check.Refresh
+		c.Command = check.Command
+		if check.SNMP != nil {
+			c.SNMP = probe.SNMP(*check.SNMP)
+		}
+		if check.Login {
+			if machine.SSH == nil {
+				return nil, fmt.Errorf("logging in needs the machine's ssh settings")
+			}
+			// The check's address and timeout apply, ssh.timeout unless it has one
+			login := machineSSH(machine)
+			c.Login = &login
+			if c.Port == 0 {
+				c.Port = machine.SSH.Port
+			}
+			if c.Timeout == 0 {
+				c.Timeout = cfg.SSH.Timeout
+			}
+		}
+	}
+	return probe.New(c)
+}
+
+// checkable reports whether the status of the machine can be checked, which
+// needs an address to ping unless a check is configured. Machines without an
+// address are pinged at the address they were seen at on the network, e.g.
+// by the DHCP server, or at their Tailscale IP. Peers report the status of
+// their machines and machine types the status of theirs.
+func checkable(machine config.Machine) bool {
+	if machine.IP != nil || machine.Check != nil || machine.Peer != "" || machine.Type != "" {
+		return true
+	}
+	if _, ok := tailscaleAddress(machine); ok {
+		return true
+	}
+	_, ok := machineAddress(machine)
+	return ok
+}
+
+// checkMachineProbes makes sure the checks of the configured machines are
+// valid, mistakes would otherwise only show up as errors while probing
+func checkMachineProbes() error {
+	for _, machine := range allMachines() {
+		_, err := machineProber(machine)
+		if err != nil {
+			return fmt.Errorf("invalid check of machine %s: %w", machine.Name, err)
+		}
+		if check := machine.Check; check != nil && (check.OnlineAfter < 0 || check.OfflineAfter < 0) {
+			return fmt.Errorf("invalid check of machine %s: online_after and offline_after must not be negative", machine.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/probe"
+	"github.com/trugamr/wol/proxmox"
+)
+
+// proxmoxTimeout limits how long a request to the Proxmox API may take
+const proxmoxTimeout = 10 * time.Second
+
+func init() {
+	registerMachineType(config.MachineTypeProxmox, machineType{
+		wake: func(ctx context.Context, machine config.Machine) error {
+			vm, err := proxmoxVM(machine)
+			if err != nil {
+				return err
+			}
+			return vm.Start(ctx)
+		},
+		prober: func(machine config.Machine) (probe.Prober, error) {
+			vm, err := proxmoxVM(machine)
+			if err != nil {
+				return nil, err
+			}
+			return proxmoxProber{vm: vm}, nil
+		},
+		power: func(ctx context.Context, machine config.Machine, status string) error {
+			vm, err := proxmoxVM(machine)
+			if err != nil {
+				return err
+			}
+			if status == statusRebooting {
+				return vm.Reboot(ctx)
+			}
+			return vm.Shutdown(ctx)
+		},
+	})
+}
+
+// proxmoxVM returns the virtual machine of the machine
+func proxmoxVM(machine config.Machine) (*proxmox.VM, error) {
+	p := machine.Proxmox
+	return proxmox.New(proxmox.Config{
+		URL:                p.URL,
+		Node:               p.Node,
+		VMID:               p.VMID,
+		TokenID:            p.TokenID,
+		TokenSecret:        p.TokenSecret,
+		InsecureSkipVerify: p.InsecureSkipVerify,
+		Timeout:            proxmoxTimeout,
+	})
+}
+
+// proxmoxProber reports virtual machines online while they are running,
+// paused ones count as offline
+type proxmoxProber struct {
+	vm *proxmox.VM
+}
+
+func (p proxmoxProber) Probe(ctx context.Context) (probe.Result, error) {
+	status, err := p.vm.Status(ctx)
+	if err != nil {
+		return probe.Result{}, err
+	}
+	return probe.Result{Online: status.Running}, nil
+}
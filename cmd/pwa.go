@@ -0,0 +1,73 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// defaultThemeColor is the accent color of the light theme
+const defaultThemeColor = "#2563eb"
+
+// manifestIcon is an icon of the web app manifest
+type manifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// manifest is the web app manifest allowing the dashboard to be installed
+type manifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	Description     string         `json:"description"`
+	ID              string         `json:"id"`
+	StartURL        string         `json:"start_url"`
+	Scope           string         `json:"scope"`
+	Display         string         `json:"display"`
+	BackgroundColor string         `json:"background_color"`
+	ThemeColor      string         `json:"theme_color"`
+	Icons           []manifestIcon `json:"icons"`
+}
+
+// themeColor returns the color of the browser's toolbar for the app
+func themeColor() string {
+	if cfg.Server.AccentColor != "" {
+		return cfg.Server.AccentColor
+	}
+	return defaultThemeColor
+}
+
+// handleManifest serves the web app manifest, it's public as browsers fetch
+// it without cookies
+func handleManifest(w http.ResponseWriter, r *http.Request) {
+	icons := []manifestIcon{
+		{Src: appURL("/static/icon-192.png"), Sizes: "192x192", Type: "image/png"},
+		{Src: appURL("/static/icon-512.png"), Sizes: "512x512", Type: "image/png"},
+		{Src: appURL("/static/icon-512.png"), Sizes: "512x512", Type: "image/png", Purpose: "maskable"},
+	}
+	w.Header().Set("Content-Type", "application/manifest+json")
+	json.NewEncoder(w).Encode(manifest{
+		Name:            "Wake on LAN",
+		ShortName:       "wol",
+		Description:     "Wake up and check on the machines of your network",
+		ID:              appURL("/"),
+		StartURL:        appURL("/"),
+		Scope:           appURL("/"),
+		Display:         "standalone",
+		BackgroundColor: "#ffffff",
+		ThemeColor:      themeColor(),
+		Icons:           icons,
+	})
+}
+
+// handleServiceWorker serves the service worker from the root of the web
+// interface, the scope of a worker is limited to the path it's served from
+func handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	// Updates to the worker have to reach browsers right away
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFileFS(w, r, overrideFS(static, "static", cfg.Server.StaticDir), "sw.js")
+}
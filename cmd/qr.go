@@ -0,0 +1,241 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// qrTokenName names the API tokens created to sign in by scanning a QR code
+const qrTokenName = "QR code sign-in"
+
+// checkPublicURL makes sure the configured public URL is absolute
+func checkPublicURL() error {
+	if cfg.Server.PublicURL == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.Server.PublicURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid server.public_url %q, must be an http or https URL", cfg.Server.PublicURL)
+	}
+	return nil
+}
+
+// publicURL returns the configured public URL with a trailing slash, or an
+// empty string if none is configured
+func publicURL() string {
+	if cfg.Server.PublicURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(cfg.Server.PublicURL, "/") + "/"
+}
+
+// dashboardURL returns the address of the dashboard as seen by the client
+// making the request, unless a public URL is configured
+func dashboardURL(r *http.Request) string {
+	if u := publicURL(); u != "" {
+		return u
+	}
+
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	// Proxies tell how they were reached, only trusted ones are believed
+	if isTrustedProxy(r) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+	}
+	return scheme + "://" + host + appURL("/")
+}
+
+// listenURL guesses the address other devices reach the dashboard at from
+// the listeners, preferring addresses that aren't loopback
+func listenURL(listeners []net.Listener, secure bool) string {
+	if u := publicURL(); u != "" {
+		return u
+	}
+
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	fallback := ""
+	for _, listener := range listeners {
+		addr, ok := listener.Addr().(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+		ip := addr.IP
+		if ip.IsUnspecified() {
+			ip = lanIP()
+		}
+		if ip == nil {
+			continue
+		}
+		u := scheme + "://" + net.JoinHostPort(ip.String(), fmt.Sprint(addr.Port)) + appURL("/")
+		if !ip.IsLoopback() {
+			return u
+		}
+		if fallback == "" {
+			fallback = u
+		}
+	}
+	return fallback
+}
+
+// lanIP returns the first IPv4 address of the host that isn't loopback
+func lanIP() net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP
+		}
+	}
+	return nil
+}
+
+// printQRCode draws text as a QR code with block characters for a terminal
+// with a dark background, two rows of modules per line
+func printQRCode(w io.Writer, text string) error {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return err
+	}
+
+	// The quiet zone around the code is part of the drawing
+	const border = 2
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true
+		}
+		return !code.Black(x, y)
+	}
+	var b strings.Builder
+	for y := -border; y < code.Size+border; y += 2 {
+		for x := -border; x < code.Size+border; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// canSignInByQR reports whether the user of the request can create a QR code
+// that signs in on another device
+func canSignInByQR(r *http.Request) bool {
+	return !cfg.Auth.Disabled && interactiveUser(r) != ""
+}
+
+func handleQR(w http.ResponseWriter, r *http.Request) {
+	renderQR(w, r, "")
+}
+
+// renderQR shows a QR code opening the dashboard, signing in with the token
+// if one is given
+func renderQR(w http.ResponseWriter, r *http.Request, token string) {
+	target := dashboardURL(r)
+	if token != "" {
+		// Fragments aren't sent to servers, so the token doesn't end up in logs
+		target += "login#token=" + token
+	}
+	image, err := qrDataURL(target)
+	if err != nil {
+		log.Printf("Error rendering QR code: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"QRCode":    image,
+		"URL":       dashboardURL(r),
+		"Token":     token != "",
+		"CanSignIn": canSignInByQR(r),
+	}
+	renderTemplate(w, r, "qr.html", data)
+}
+
+// handleQRToken creates an API token for the user and shows a QR code that
+// signs in with it
+func handleQRToken(w http.ResponseWriter, r *http.Request) {
+	if !canSignInByQR(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	p, _ := requestPrincipal(r)
+	plaintext, token, err := tokens.Create(qrTokenName, p.identity())
+	if err != nil {
+		log.Printf("Error creating token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %q created token %s (%s)", token.Username, token.ID, token.Name)
+	recordAudit(r, "token.create", token.ID, nil)
+
+	renderQR(w, r, plaintext)
+}
+
+// handleTokenLogin starts a session for the user of an API token, which the
+// login page submits when opened from a QR code
+func handleTokenLogin(w http.ResponseWriter, r *http.Request) {
+	if cfg.Auth.Disabled {
+		http.Redirect(w, r, appURL("/"), http.StatusSeeOther)
+		return
+	}
+
+	next := safeRedirect(r.FormValue("next"))
+	data := map[string]interface{}{
+		"Next":     next,
+		"SSO":      sso != nil,
+		"Password": passwordLogin(),
+	}
+	if rateLimited(w, r) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		data["Error"] = "Too many failed attempts, wait a moment and try again"
+		renderTemplate(w, r, "login.html", data)
+		return
+	}
+
+	token, ok := authenticateToken(r.FormValue("token"))
+	if !ok {
+		authFailed(r, "Failed sign-in with a QR code")
+		recordAuditAs(r, "", "login", "", errors.New("invalid token"))
+		w.WriteHeader(http.StatusUnauthorized)
+		data["Error"] = "The QR code is invalid or was revoked"
+		renderTemplate(w, r, "login.html", data)
+		return
+	}
+
+	authSucceeded(r)
+	recordAuditAs(r, token.Username, "login", "", nil)
+	startSession(w, r, token.Identity(), next)
+}
@@ -0,0 +1,48 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trugamr/wol/auth"
+	"github.com/trugamr/wol/config"
+)
+
+func TestTokenLoginRejectsRemovedLocalUsers(t *testing.T) {
+	oldCfg, oldTokens, oldSessions, oldSSO := cfg, tokens, sessions, sso
+	t.Cleanup(func() { cfg, tokens, sessions, sso = oldCfg, oldTokens, oldSessions, oldSSO })
+	cfg = config.NewConfig()
+	tokens = auth.NewTokens(filepath.Join(t.TempDir(), tokensFilename))
+	sso = auth.NewOIDC(auth.OIDCConfig{Issuer: "https://idp.example.com", ClientID: "wol"})
+	var err error
+	sessions, err = auth.NewSessions(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, _, err := tokens.Create("phone", auth.Identity{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// alice was removed from auth.users after creating the token
+	form := url.Values{"token": {token}}
+	req := httptest.NewRequest(http.MethodPost, "/login/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handleTokenLogin(rec, req)
+
+	resp := rec.Result()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("token login of removed user returned %s, want 401", resp.Status)
+	}
+	if sessionCookie(resp) != nil {
+		t.Error("token login of removed user started a session")
+	}
+}
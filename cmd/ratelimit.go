@@ -0,0 +1,68 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/trugamr/wol/auth"
+)
+
+// limiter slows down failed authentication attempts, nil if disabled
+var limiter *auth.Limiter
+
+// setupRateLimit prepares the login rate limiter from the config
+func setupRateLimit() {
+	if !cfg.Auth.RateLimit.Enabled {
+		return
+	}
+
+	limiter = auth.NewLimiter(auth.LimiterConfig{
+		BaseDelay:       cfg.Auth.RateLimit.BaseDelay,
+		MaxDelay:        cfg.Auth.RateLimit.MaxDelay,
+		LockoutAfter:    cfg.Auth.RateLimit.LockoutAfter,
+		LockoutDuration: cfg.Auth.RateLimit.LockoutDuration,
+	})
+}
+
+// rateLimited reports whether the client has to wait before authenticating
+// again and sets the Retry-After header if so
+func rateLimited(w http.ResponseWriter, r *http.Request) bool {
+	if limiter == nil {
+		return false
+	}
+
+	wait, ok := limiter.Allow(clientIP(r))
+	if ok {
+		return false
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	return true
+}
+
+// authFailed logs a failed authentication attempt and slows the client down
+func authFailed(r *http.Request, format string, args ...interface{}) {
+	ip := clientIP(r)
+	log.Printf("%s from %s", fmt.Sprintf(format, args...), ip)
+
+	if limiter == nil {
+		return
+	}
+
+	wait, locked := limiter.Fail(ip)
+	if locked {
+		log.Printf("Locked out %s for %s after too many failed attempts", ip, wait.Round(time.Second))
+	}
+}
+
+// authSucceeded forgets previous failures of the client
+func authSucceeded(r *http.Request) {
+	if limiter != nil {
+		limiter.Succeed(clientIP(r))
+	}
+}
@@ -0,0 +1,154 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/trugamr/wol/auth"
+	"github.com/trugamr/wol/config"
+)
+
+// roleMapping grants a role to members of an external group
+type roleMapping struct {
+	group string
+	grant auth.Grant
+}
+
+var (
+	// userGrants holds the grant of each configured user
+	userGrants map[string]auth.Grant
+	// roleMappings hold the grants of external groups
+	roleMappings []roleMapping
+	// defaultRole is given to users without an explicit role
+	defaultRole auth.Role
+	// wakeAllRole is the minimum role allowed to wake all machines at once
+	wakeAllRole auth.Role
+)
+
+// adminPermissions allow everything, used when authentication is disabled
+var adminPermissions = auth.Permissions{Grants: []auth.Grant{{Role: auth.RoleAdmin}}}
+
+// setupRBAC parses the roles and grants from the config
+func setupRBAC() error {
+	var err error
+	defaultRole, err = auth.ParseRole(cfg.Auth.DefaultRole)
+	if err != nil {
+		return fmt.Errorf("invalid auth.default_role: %w", err)
+	}
+
+	wakeAllRole, err = auth.ParseRole(cfg.Auth.WakeAllRole)
+	if err != nil {
+		return fmt.Errorf("invalid auth.wake_all_role: %w", err)
+	}
+	if wakeAllRole < auth.RoleOperator {
+		return fmt.Errorf("auth.wake_all_role must be operator or admin")
+	}
+
+	userGrants = make(map[string]auth.Grant, len(cfg.Auth.Users))
+	for _, user := range cfg.Auth.Users {
+		role, err := auth.ParseRole(user.Role)
+		if err != nil {
+			return fmt.Errorf("invalid role of user %q: %w", user.Username, err)
+		}
+		if user.Role == "" {
+			role = defaultRole
+		}
+		userGrants[user.Username] = auth.Grant{Role: role, Machines: user.Machines, MachineGroups: user.MachineGroups}
+	}
+
+	roleMappings = nil
+	for _, mapping := range cfg.Auth.RoleMappings {
+		role, err := auth.ParseRole(mapping.Role)
+		if err != nil {
+			return fmt.Errorf("invalid role for group %q: %w", mapping.Group, err)
+		}
+		roleMappings = append(roleMappings, roleMapping{
+			group: mapping.Group,
+			grant: auth.Grant{Role: role, Machines: mapping.Machines, MachineGroups: mapping.MachineGroups},
+		})
+	}
+
+	return nil
+}
+
+// permissionsFor returns what the principal is allowed to do
+func permissionsFor(p principal) auth.Permissions {
+	// Configured users have exactly the role given to them, external users
+	// of the same name don't, e.g. an account named admin at the provider.
+	// Local users removed from the config, e.g. with a stale session, get nothing.
+	if !p.External {
+		grant, ok := userGrants[p.Username]
+		if !ok {
+			return auth.Permissions{}
+		}
+		return auth.Permissions{Grants: []auth.Grant{grant}}
+	}
+
+	// External users get the roles of their groups, or the default role if no mappings are configured
+	if len(roleMappings) == 0 {
+		return auth.Permissions{Grants: []auth.Grant{{Role: defaultRole}}}
+	}
+
+	var permissions auth.Permissions
+	for _, mapping := range roleMappings {
+		if slices.ContainsFunc(p.Groups, func(group string) bool { return strings.EqualFold(group, mapping.group) }) {
+			permissions.Grants = append(permissions.Grants, mapping.grant)
+		}
+	}
+	return permissions
+}
+
+// requestPermissions returns what the user making the request is allowed to
+// do with machines
+func requestPermissions(r *http.Request) auth.Permissions {
+	permissions := userPermissions(r)
+
+	// Everyone is a viewer in read-only mode
+	if readOnly() {
+		permissions = permissions.Limit(auth.RoleViewer)
+	}
+	return permissions
+}
+
+// userPermissions returns the permissions given to the user making the
+// request, regardless of the server being in read-only mode
+func userPermissions(r *http.Request) auth.Permissions {
+	if cfg.Auth.Disabled {
+		return adminPermissions
+	}
+
+	p, ok := requestPrincipal(r)
+	if !ok {
+		return auth.Permissions{}
+	}
+	return permissionsFor(p)
+}
+
+// visibleMachines returns the machines the user making the request may see
+func visibleMachines(r *http.Request) []config.Machine {
+	permissions := requestPermissions(r)
+
+	all := allMachines()
+	machines := make([]config.Machine, 0, len(all))
+	for _, machine := range all {
+		if permissions.CanView(machine.Name, machine.Group) {
+			machines = append(machines, machine)
+		}
+	}
+	return machines
+}
+
+// requireAdmin only allows requests made by an admin
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !userPermissions(r).IsAdmin() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/trugamr/wol/config"
+)
+
+func TestPermissionsForExternalUserNamedLikeLocalUser(t *testing.T) {
+	oldCfg, oldGrants, oldMappings, oldDefault, oldWakeAll := cfg, userGrants, roleMappings, defaultRole, wakeAllRole
+	t.Cleanup(func() {
+		cfg, userGrants, roleMappings, defaultRole, wakeAllRole = oldCfg, oldGrants, oldMappings, oldDefault, oldWakeAll
+	})
+	cfg = config.NewConfig()
+	cfg.Auth.DefaultRole = "viewer"
+	cfg.Auth.WakeAllRole = "admin"
+	cfg.Auth.Users = []config.User{{Username: "admin", Role: "admin"}}
+	if err := setupRBAC(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !permissionsFor(principal{Username: "admin"}).IsAdmin() {
+		t.Error("local admin isn't an admin")
+	}
+	for _, p := range []principal{
+		{Username: "admin", External: true},
+		{Username: "admin", Proxy: true, External: true},
+	} {
+		if permissionsFor(p).IsAdmin() {
+			t.Errorf("external principal %+v got the grant of the local admin", p)
+		}
+	}
+
+	// A local user removed from the config doesn't fall back to the default role
+	if permissions := permissionsFor(principal{Username: "carol"}); len(permissions.Grants) > 0 {
+		t.Errorf("removed local user carol got %+v", permissions)
+	}
+	if !permissionsFor(principal{Username: "carol", External: true}).CanView("desktop", "") {
+		t.Error("external user carol didn't get the default role")
+	}
+}
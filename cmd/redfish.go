@@ -0,0 +1,71 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/probe"
+	"github.com/trugamr/wol/redfish"
+)
+
+// redfishTimeout limits how long a request to a Redfish BMC may take
+const redfishTimeout = 20 * time.Second
+
+func init() {
+	registerMachineType(config.MachineTypeRedfish, machineType{
+		wake: func(ctx context.Context, machine config.Machine) error {
+			bmc, err := redfishBMC(machine)
+			if err != nil {
+				return err
+			}
+			return bmc.PowerOn(ctx)
+		},
+		prober: func(machine config.Machine) (probe.Prober, error) {
+			bmc, err := redfishBMC(machine)
+			if err != nil {
+				return nil, err
+			}
+			return redfishProber{bmc: bmc}, nil
+		},
+		power: func(ctx context.Context, machine config.Machine, status string) error {
+			bmc, err := redfishBMC(machine)
+			if err != nil {
+				return err
+			}
+			// Not every BMC restarts gracefully, e.g. iLO
+			if status == statusRebooting {
+				return bmc.Reset(ctx, redfish.ResetGracefulRestart, redfish.ResetForceRestart)
+			}
+			return bmc.Reset(ctx, redfish.ResetGracefulShutdown)
+		},
+	})
+}
+
+// redfishBMC returns the BMC of the machine
+func redfishBMC(machine config.Machine) (*redfish.BMC, error) {
+	r := machine.Redfish
+	return redfish.New(redfish.Config{
+		URL:                r.URL,
+		System:             r.System,
+		Username:           r.Username,
+		Password:           r.Password,
+		InsecureSkipVerify: r.InsecureSkipVerify,
+		Timeout:            redfishTimeout,
+	})
+}
+
+// redfishProber reports servers online while their power state is on
+type redfishProber struct {
+	bmc *redfish.BMC
+}
+
+func (p redfishProber) Probe(ctx context.Context) (probe.Result, error) {
+	on, err := p.bmc.PoweredOn(ctx)
+	if err != nil {
+		return probe.Result{}, err
+	}
+	return probe.Result{Online: on}, nil
+}
@@ -14,7 +14,12 @@ var rootCmd = &cobra.Command{
 	Short: "Discover and wake up devices on the network",
 	Long:  "Discover devices on the network and wake them by sending magic Wake-On-LAN packets",
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		return cfg.Load()
+		err := cfg.Load()
+		if err != nil {
+			return err
+		}
+		machineStore = newMachineStore()
+		return nil
 	},
 }
 
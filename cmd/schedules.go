@@ -0,0 +1,470 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/schedule"
+)
+
+const schedulesFilename = "schedules.json"
+
+// scheduleStore holds the schedules managed from the web interface and which schedules are enabled
+var scheduleStore *schedule.Store
+
+// scheduler runs the schedules while serving
+var scheduler *wakeScheduler
+
+// Errors returned when managing schedules
+var (
+	errScheduleNotFound = errors.New("schedule not found")
+	errScheduleExists   = errors.New("schedule already exists")
+	errScheduleInConfig = errors.New("schedule is defined in the config file")
+)
+
+// invalidScheduleError describes why a submitted schedule was rejected
+type invalidScheduleError struct {
+	err error
+}
+
+func (e invalidScheduleError) Error() string {
+	return e.err.Error()
+}
+
+// setupSchedules checks the schedules of the config file and opens the schedule store
+func setupSchedules() error {
+	seen := make(map[string]bool)
+	for _, s := range cfg.Schedules {
+		err := s.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid schedule %q: %w", s.Name, err)
+		}
+		if seen[strings.ToLower(s.Name)] {
+			return fmt.Errorf("duplicate schedule %q", s.Name)
+		}
+		seen[strings.ToLower(s.Name)] = true
+	}
+
+	scheduleStore = schedule.NewStore(filepath.Join(cfg.DataDir, schedulesFilename))
+	return nil
+}
+
+// allSchedules returns the schedules of the config file followed by the ones
+// managed from the web interface
+func allSchedules() []config.Schedule {
+	schedules := cfg.Schedules[:len(cfg.Schedules):len(cfg.Schedules)]
+	if scheduleStore == nil {
+		return schedules
+	}
+
+	stored, err := scheduleStore.List()
+	if err != nil {
+		log.Printf("Error loading schedules: %v", err)
+		return schedules
+	}
+	return append(schedules, stored...)
+}
+
+// isConfigSchedule reports whether the schedule is defined in the config file
+func isConfigSchedule(name string) bool {
+	return slices.ContainsFunc(cfg.Schedules, func(s config.Schedule) bool {
+		return strings.EqualFold(s.Name, name)
+	})
+}
+
+// findSchedule returns the schedule with the given name
+func findSchedule(name string) (config.Schedule, bool) {
+	for _, s := range allSchedules() {
+		if strings.EqualFold(s.Name, name) {
+			return s, true
+		}
+	}
+	return config.Schedule{}, false
+}
+
+// scheduleEnabled reports whether the schedule runs
+func scheduleEnabled(s config.Schedule) bool {
+	if scheduleStore == nil {
+		return !s.Disabled
+	}
+
+	enabled, err := scheduleStore.Enabled(s)
+	if err != nil {
+		log.Printf("Error loading schedules: %v", err)
+		return !s.Disabled
+	}
+	return enabled
+}
+
+// scheduleAction returns the action of the schedule, wake if not set
+func scheduleAction(s config.Schedule) string {
+	if s.Action == "" {
+		return config.ScheduleWake
+	}
+	return s.Action
+}
+
+// nextRun returns when the schedule runs next after the given time, schedules
+// are validated when added so the expression can't be invalid
+func nextRun(s config.Schedule, after time.Time) time.Time {
+	expr, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	return expr.Next(after)
+}
+
+// scheduleMachines returns the machines the schedule runs on
+func scheduleMachines(s config.Schedule) []config.Machine {
+	var machines []config.Machine
+	for _, machine := range allMachines() {
+		byName := slices.ContainsFunc(s.Machines, func(name string) bool {
+			return strings.EqualFold(name, machine.Name)
+		})
+		byGroup := machine.Group != "" && slices.ContainsFunc(s.Groups, func(group string) bool {
+			return strings.EqualFold(group, machine.Group)
+		})
+		if byName || byGroup {
+			machines = append(machines, machine)
+		}
+	}
+	return machines
+}
+
+// runSchedule runs the action of the schedule on its machines concurrently,
+// the attempts are recorded in the audit log as the user schedule:<name>
+func runSchedule(s config.Schedule) {
+	action := scheduleAction(s)
+	user := "schedule:" + s.Name
+	machines := scheduleMachines(s)
+	log.Printf("Running schedule %q, %s %d machines", s.Name, action, len(machines))
+
+	var wg sync.WaitGroup
+	for _, machine := range machines {
+		wg.Add(1)
+		go func(machine config.Machine) {
+			defer wg.Done()
+
+			ctx, span := tracer.Start(context.Background(), "schedule."+action)
+			defer span.End()
+
+			var err error
+			if action == config.ScheduleShutdown {
+				err = powerAsService(ctx, user, machine, statusShuttingDown)
+			} else {
+				err = wakeAsService(ctx, user, machine)
+			}
+			if err != nil {
+				log.Printf("Error running schedule %q on %s: %v", s.Name, machine.Name, err)
+			}
+		}(machine)
+	}
+	wg.Wait()
+}
+
+// wakeScheduler runs the enabled schedules when they are due
+type wakeScheduler struct {
+	reload chan struct{}
+}
+
+// newWakeScheduler creates a scheduler, it does nothing until run
+func newWakeScheduler() *wakeScheduler {
+	return &wakeScheduler{reload: make(chan struct{}, 1)}
+}
+
+// run starts the schedules when they are due until stop is closed
+func (s *wakeScheduler) run(stop <-chan struct{}) {
+	last := time.Now()
+	for {
+		// Wake up at least every minute so time changes are noticed
+		wait := time.Minute
+		for _, sc := range allSchedules() {
+			if !scheduleEnabled(sc) {
+				continue
+			}
+			wait = min(wait, time.Until(nextRun(sc, last)))
+		}
+
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-s.reload:
+			// Schedules changed, only the ones due from now on run
+			timer.Stop()
+			last = time.Now()
+			continue
+		case <-timer.C:
+		}
+
+		now := time.Now()
+		for _, sc := range allSchedules() {
+			if scheduleEnabled(sc) && !nextRun(sc, last).After(now) {
+				go runSchedule(sc)
+			}
+		}
+		last = now
+	}
+}
+
+// triggerReload makes the scheduler pick up changed schedules right away
+func (s *wakeScheduler) triggerReload() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.reload <- struct{}{}:
+	default:
+	}
+}
+
+// checkManageSchedules returns an error if the user making the request isn't
+// allowed to change schedules
+func checkManageSchedules(r *http.Request) error {
+	if readOnly() {
+		return errReadOnly
+	}
+	if !requestPermissions(r).IsAdmin() {
+		return errPermission
+	}
+	return nil
+}
+
+// createSchedule adds a schedule on behalf of the user making the request
+func createSchedule(r *http.Request, s config.Schedule) error {
+	err := checkManageSchedules(r)
+	if err != nil {
+		return err
+	}
+	err = s.Validate()
+	if err != nil {
+		return invalidScheduleError{err}
+	}
+	if isConfigSchedule(s.Name) {
+		return errScheduleExists
+	}
+
+	err = scheduleStore.Add(s)
+	err = scheduleStoreError(err)
+	recordAudit(r, "schedule.create", s.Name, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Schedule %q added", s.Name)
+	scheduler.triggerReload()
+	return nil
+}
+
+// deleteSchedule removes the schedule with the given name on behalf of the user making the request
+func deleteSchedule(r *http.Request, name string) error {
+	err := checkManageSchedules(r)
+	if err != nil {
+		return err
+	}
+	if isConfigSchedule(name) {
+		return errScheduleInConfig
+	}
+
+	err = scheduleStore.Delete(name)
+	err = scheduleStoreError(err)
+	recordAudit(r, "schedule.delete", name, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Schedule %q deleted", name)
+	scheduler.triggerReload()
+	return nil
+}
+
+// setScheduleEnabled enables or disables the schedule with the given name on
+// behalf of the user making the request
+func setScheduleEnabled(r *http.Request, name string, enabled bool) error {
+	err := checkManageSchedules(r)
+	if err != nil {
+		return err
+	}
+	s, ok := findSchedule(name)
+	if !ok {
+		return errScheduleNotFound
+	}
+
+	action := "schedule.disable"
+	if enabled {
+		action = "schedule.enable"
+	}
+	err = scheduleStore.SetEnabled(s.Name, enabled)
+	recordAudit(r, action, s.Name, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Schedule %q %sd", s.Name, strings.TrimPrefix(action, "schedule."))
+	scheduler.triggerReload()
+	return nil
+}
+
+// scheduleStoreError maps errors of the schedule store to the ones handled by callers
+func scheduleStoreError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, schedule.ErrNotFound):
+		return errScheduleNotFound
+	case errors.Is(err, schedule.ErrExists):
+		return errScheduleExists
+	}
+
+	return err
+}
+
+// splitList splits a comma separated form value, leaving out empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// scheduleFromForm returns the schedule submitted with a form
+func scheduleFromForm(r *http.Request) config.Schedule {
+	return config.Schedule{
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		Cron:     strings.TrimSpace(r.FormValue("cron")),
+		Action:   r.FormValue("action"),
+		Machines: splitList(r.FormValue("machines")),
+		Groups:   splitList(r.FormValue("groups")),
+	}
+}
+
+// scheduleView represents a schedule on the schedules page
+type scheduleView struct {
+	config.Schedule
+	Enabled  bool
+	NextRun  time.Time
+	Editable bool
+}
+
+func handleSchedules(w http.ResponseWriter, r *http.Request) {
+	renderSchedules(w, r, config.Schedule{}, "")
+}
+
+// renderSchedules shows all schedules along with the form adding one, form
+// holds the submitted values when adding failed with formError
+func renderSchedules(w http.ResponseWriter, r *http.Request, form config.Schedule, formError string) {
+	now := time.Now()
+	schedules := allSchedules()
+	views := make([]scheduleView, 0, len(schedules))
+	for _, s := range schedules {
+		s.Action = scheduleAction(s)
+		view := scheduleView{
+			Schedule: s,
+			Enabled:  scheduleEnabled(s),
+			Editable: !isConfigSchedule(s.Name),
+		}
+		if view.Enabled {
+			view.NextRun = nextRun(s, now)
+		}
+		views = append(views, view)
+	}
+
+	data := map[string]interface{}{
+		"Schedules":    views,
+		"Form":         form,
+		"FormError":    formError,
+		"FlashMessage": consumeFlashMessage(w, r),
+	}
+	renderTemplate(w, r, "schedules.html", data)
+}
+
+func handleCreateSchedule(w http.ResponseWriter, r *http.Request) {
+	s := scheduleFromForm(r)
+
+	err := createSchedule(r, s)
+	if message, ok := scheduleFormError(w, err); !ok {
+		return
+	} else if message != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		renderSchedules(w, r, s, message)
+		return
+	}
+
+	setFlashMessage(w, fmt.Sprintf("Added schedule %s", s.Name))
+	http.Redirect(w, r, appURL("/admin/schedules"), http.StatusSeeOther)
+}
+
+func handleToggleSchedule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	enabled := r.FormValue("enabled") == "true"
+
+	err := setScheduleEnabled(r, name, enabled)
+	if errors.Is(err, errScheduleNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := scheduleFormError(w, err); !ok {
+		return
+	}
+
+	state := "Disabled"
+	if enabled {
+		state = "Enabled"
+	}
+	setFlashMessage(w, fmt.Sprintf("%s schedule %s", state, name))
+	http.Redirect(w, r, appURL("/admin/schedules"), http.StatusSeeOther)
+}
+
+func handleDeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	err := deleteSchedule(r, name)
+	if errors.Is(err, errScheduleNotFound) || errors.Is(err, errScheduleInConfig) {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := scheduleFormError(w, err); !ok {
+		return
+	}
+
+	setFlashMessage(w, fmt.Sprintf("Deleted schedule %s", name))
+	http.Redirect(w, r, appURL("/admin/schedules"), http.StatusSeeOther)
+}
+
+// scheduleFormError returns the message shown next to the form for errors the
+// user can fix, other errors are written as the response and ok is false
+func scheduleFormError(w http.ResponseWriter, err error) (message string, ok bool) {
+	var invalid invalidScheduleError
+	switch {
+	case err == nil:
+		return "", true
+	case errors.As(err, &invalid):
+		return "Invalid schedule: " + invalid.Error(), true
+	case errors.Is(err, errScheduleExists):
+		return "A schedule with this name already exists", true
+	case errors.Is(err, errReadOnly):
+		http.Error(w, readOnlyReason(), http.StatusForbidden)
+	case errors.Is(err, errPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		log.Printf("Error saving schedule: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return "", false
+}
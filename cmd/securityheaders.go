@@ -0,0 +1,88 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// securityHeadersMiddleware adds the configured security headers to all
+// responses, handlers may adjust them before writing the response
+func securityHeadersMiddleware(next http.Handler) http.Handler {
+	c := cfg.Server.SecurityHeaders
+	if !c.Enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if c.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", c.ContentSecurityPolicy)
+		}
+		if c.FrameOptions != "" {
+			h.Set("X-Frame-Options", c.FrameOptions)
+		}
+		if c.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", c.ReferrerPolicy)
+		}
+		// Browsers ignore the header on plain HTTP responses
+		if c.HSTSMaxAge > 0 && secureRequest(r) {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", int(c.HSTSMaxAge.Seconds())))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// secureRequest reports whether the client connected over HTTPS, to the
+// server or to a trusted proxy
+func secureRequest(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return isTrustedProxy(r) && r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// allowCSPSource adds the source to the directives of the response's
+// Content-Security-Policy, e.g. for a page loading scripts from a CDN.
+// Directives missing from the policy are added with the sources of
+// default-src, which they would fall back to otherwise, if there is one.
+func allowCSPSource(w http.ResponseWriter, source string, directives ...string) {
+	policy := w.Header().Get("Content-Security-Policy")
+	if policy == "" {
+		return
+	}
+
+	parts := strings.Split(policy, ";")
+	for _, directive := range directives {
+		found := false
+		for i, part := range parts {
+			fields := strings.Fields(part)
+			if len(fields) > 0 && strings.EqualFold(fields[0], directive) {
+				parts[i] = strings.TrimSpace(part) + " " + source
+				found = true
+			}
+		}
+		// Without default-src the directive isn't restricted at all
+		if sources, ok := defaultSources(parts); !found && ok {
+			parts = append(parts, directive+" "+sources+" "+source)
+		}
+	}
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	w.Header().Set("Content-Security-Policy", strings.Join(parts, "; "))
+}
+
+// defaultSources returns the sources of the default-src directive of a policy
+func defaultSources(parts []string) (string, bool) {
+	for _, part := range parts {
+		fields := strings.Fields(part)
+		if len(fields) > 1 && strings.EqualFold(fields[0], "default-src") {
+			return strings.Join(fields[1:], " "), true
+		}
+	}
+	return "", false
+}
@@ -32,6 +32,11 @@ var sendCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		// The server sends the packet from its network
+		if wakeRemote(cmd) {
+			return
+		}
+
 		var mac net.HardwareAddr
 
 		// Retrieve mac address using one of the flags
@@ -86,8 +91,11 @@ var sendCmd = &cobra.Command{
 
 // getMacByName returns the MAC address of the machine with the specified name
 func getMacByName(name string) (net.HardwareAddr, error) {
-	for _, machine := range cfg.Machines {
+	for _, machine := range allMachines() {
 		if strings.EqualFold(machine.Name, name) {
+			if machine.Type != "" {
+				return nil, fmt.Errorf("machine %q is of type %s and can only be woken through a server, use --server", machine.Name, machine.Type)
+			}
 			mac, err := net.ParseMAC(machine.Mac)
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse MAC address: %w", err)
@@ -1,28 +1,55 @@
+//go:build !noserve
+
 package cmd
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	probing "github.com/prometheus-community/pro-bing"
 	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/audit"
 	"github.com/trugamr/wol/config"
 	"github.com/trugamr/wol/magicpacket"
+	"github.com/trugamr/wol/remote"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
 )
 
 //go:embed templates/*
 var templates embed.FS
 
+//go:embed static/*
+var static embed.FS
+
+// templateFuncs are the functions available in templates
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"asset": assetURL,
+}
+
+// shuttingDown is closed when the server starts shutting down
+var shuttingDown = make(chan struct{})
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().Bool("qr", false, "Print a QR code of the dashboard's address when starting, e.g. to open it on a phone")
 }
 
 var serveCmd = &cobra.Command{
@@ -31,38 +58,364 @@ var serveCmd = &cobra.Command{
 	Long:  "Serve a web interface that lists all the configured machines and allows you to wake them up",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		err := setupAuth()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		defer closeSessions()
+		err = setupRBAC()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupTrustedProxies()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupIPFilter()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		setupRateLimit()
+		setupBasePath()
+		auditLog = newAuditLog()
+		historyStore, err = openHistoryStore()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		defer historyStore.Close()
+		go pruneHistory(shuttingDown)
+		if cfg.Server.StatusInterval <= 0 {
+			cobra.CheckErr(fmt.Errorf("server.status_interval must be positive"))
+		}
+		err = checkOverrideDirs()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = checkLanguage()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupTheme()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = checkPublicURL()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupLeases()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupMDNS()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupUniFi()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupFirewall()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupTailscale()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupNetBIOS()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupSleepProxy()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupARPProxy()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupGateways()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupDNSForwarder()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = checkMachineProbes()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupWebhooks()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupNotifications()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupPeers()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupSwitches()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		err = setupAgents()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		if cfg.Server.BackgroundInterval < 0 {
+			cobra.CheckErr(fmt.Errorf("server.background_interval must not be negative"))
+		}
+		if cfg.Server.OnlineAfter < 1 || cfg.Server.OfflineAfter < 1 {
+			cobra.CheckErr(fmt.Errorf("server.online_after and server.offline_after must be at least 1"))
+		}
+		err = checkServerLimits()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		if cfg.Server.StatusTTL < 0 {
+			cobra.CheckErr(fmt.Errorf("server.status_ttl must not be negative"))
+		}
+		if !slices.Contains(remote.HostKeyPolicies, cfg.SSH.HostKeyPolicy) {
+			cobra.CheckErr(fmt.Errorf("ssh.host_key_policy must be one of %s", strings.Join(remote.HostKeyPolicies, ", ")))
+		}
+		statusTTL := cfg.Server.StatusTTL
+		if statusTTL == 0 {
+			statusTTL = cfg.Server.StatusInterval
+		}
+		poller = newStatusPoller(cfg.Server.StatusInterval, cfg.Server.BackgroundInterval, statusTTL)
+		// Status changes are only noticed while probing
+		poller.always = len(webhooks) > 0 || len(notifiers) > 0 || cfg.MQTT.Broker != ""
+		go poller.run(shuttingDown)
+		runPeers(shuttingDown)
+		runSwitches(shuttingDown)
+		runDeviceSources(shuttingDown)
+		runTailscale(shuttingDown)
+		runSleepProxy(shuttingDown)
+		runARPProxy(shuttingDown)
+		runGateways(shuttingDown)
+		runDNSForwarder(shuttingDown)
+		err = setupMQTT()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		defer closeMQTT()
+		setupUbus(shuttingDown)
+		err = setupSchedules()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		setupMaintenance()
+		scheduler = newWakeScheduler()
+		go scheduler.run(shuttingDown)
+
+		protected := http.NewServeMux()
+		protected.HandleFunc("GET /{$}", handleIndex)
+		protected.HandleFunc("GET /machines/{name}", handleMachine)
+		protected.HandleFunc("POST /wake", handleWake)
+		protected.HandleFunc("POST /wake/group", handleWakeGroup)
+		protected.HandleFunc("POST /wake/all", handleWakeAll)
+		protected.HandleFunc("POST /shutdown", handlePower(statusShuttingDown))
+		protected.HandleFunc("POST /reboot", handlePower(statusRebooting))
+		protected.HandleFunc("GET /status", handleStatus)
+		protected.HandleFunc("GET /ws/status", handleWebSocketStatus)
+		protected.HandleFunc("GET /qr", handleQR)
+		protected.HandleFunc("POST /qr", requireInteractive(handleQRToken))
+		protected.HandleFunc("GET /tokens", requireInteractive(handleTokens))
+		protected.HandleFunc("POST /tokens", requireInteractive(handleCreateToken))
+		protected.HandleFunc("POST /tokens/{id}/revoke", requireInteractive(handleRevokeToken))
+		protected.HandleFunc("GET /account/2fa", requireInteractive(handleTOTP))
+		protected.HandleFunc("POST /account/2fa/setup", requireInteractive(handleTOTPSetup))
+		protected.HandleFunc("POST /account/2fa/confirm", requireInteractive(handleTOTPConfirm))
+		protected.HandleFunc("POST /account/2fa/disable", requireInteractive(handleTOTPDisable))
+		protected.HandleFunc("GET /admin/audit", requireAdmin(handleAudit))
+		protected.HandleFunc("POST /admin/maintenance", requireAdmin(handleMaintenance))
+		protected.HandleFunc("GET /admin/machines", requireAdmin(handleMachines))
+		protected.HandleFunc("POST /admin/machines", requireAdmin(handleCreateMachine))
+		protected.HandleFunc("GET /admin/machines/{name}/edit", requireAdmin(handleEditMachine))
+		protected.HandleFunc("POST /admin/machines/{name}", requireAdmin(handleUpdateMachine))
+		protected.HandleFunc("POST /admin/machines/{name}/delete", requireAdmin(handleDeleteMachine))
+		protected.HandleFunc("GET /admin/schedules", requireAdmin(handleSchedules))
+		protected.HandleFunc("POST /admin/schedules", requireAdmin(handleCreateSchedule))
+		protected.HandleFunc("POST /admin/schedules/{name}/toggle", requireAdmin(handleToggleSchedule))
+		protected.HandleFunc("POST /admin/schedules/{name}/delete", requireAdmin(handleDeleteSchedule))
+		registerAPI(protected)
+
 		mux := http.NewServeMux()
+		mux.HandleFunc("GET /login", handleLoginPage)
+		mux.HandleFunc("POST /login", handleLogin)
+		mux.HandleFunc("POST /login/totp", handleLoginTOTP)
+		mux.HandleFunc("POST /login/token", handleTokenLogin)
+		mux.HandleFunc("POST /logout", handleLogout)
+		mux.HandleFunc("POST /theme", handleTheme)
+		mux.HandleFunc("GET /manifest.webmanifest", handleManifest)
+		mux.HandleFunc("GET /sw.js", handleServiceWorker)
+		mux.HandleFunc("GET /oidc/login", handleOIDCLogin)
+		mux.HandleFunc("GET /oidc/callback", handleOIDCCallback)
+		mux.HandleFunc("GET /api/v1/openapi.json", handleOpenAPI)
+		mux.Handle("GET /static/", handleStatic())
+		if len(cfg.Agents) > 0 {
+			mux.HandleFunc("GET /agent", handleAgent)
+		}
+		if cfg.Server.APIDocs {
+			mux.HandleFunc("GET /api/docs", handleAPIDocs)
+		}
+		mux.Handle("/", authMiddleware(protected))
+
+		tlsConfig, err := serverTLSConfig()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
 
-		mux.HandleFunc("GET /{$}", handleIndex)
-		mux.HandleFunc("POST /wake", handleWake)
-		mux.HandleFunc("GET /status", handleStatus)
+		shutdownTracing, err := setupTracing(context.Background())
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		defer shutdownTracing(context.Background())
 
-		log.Printf("Listening on %s", cfg.Server.Listen)
-		err := http.ListenAndServe(cfg.Server.Listen, authMiddleware(mux))
+		handler, err := accessLogMiddleware(ipFilterMiddleware(securityHeadersMiddleware(limitBodyMiddleware(compressMiddleware(basePathMiddleware(corsMiddleware(csrfMiddleware(mux))))))))
 		if err != nil {
 			cobra.CheckErr(err)
 		}
+
+		server := &http.Server{
+			Handler:           tracingMiddleware(handler),
+			TLSConfig:         tlsConfig,
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			ReadTimeout:       cfg.Server.ReadTimeout,
+			IdleTimeout:       cfg.Server.IdleTimeout,
+		}
+		err = setupHTTP2(server)
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+
+		listeners, err := serverListeners()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+
+		// Status streams never finish on their own so they are told to stop
+		server.RegisterOnShutdown(func() { close(shuttingDown) })
+
+		showQR, _ := cmd.Flags().GetBool("qr")
+		if showQR {
+			target := listenURL(listeners, tlsConfig != nil)
+			if target == "" {
+				log.Printf("Not printing a QR code, no TCP listener and no server.public_url")
+			} else {
+				log.Printf("Scan the QR code to open %s", target)
+				err = printQRCode(os.Stderr, target)
+				if err != nil {
+					log.Printf("Error rendering QR code: %v", err)
+				}
+			}
+		}
+
+		// All listeners share the handler, the first one to fail stops the server
+		errs := make(chan error, len(listeners)+1)
+		for _, listener := range listeners {
+			listener = limitListener(listener)
+			go func(listener net.Listener) {
+				if tlsConfig != nil {
+					log.Printf("Listening on https://%s", listener.Addr())
+					errs <- server.ServeTLS(listener, "", "")
+				} else {
+					log.Printf("Listening on %s", listener.Addr())
+					errs <- server.Serve(listener)
+				}
+			}(listener)
+		}
+
+		var grpcServer *grpc.Server
+		if cfg.Server.GRPC.Listen != "" {
+			grpcListener, err := listen(cfg.Server.GRPC.Listen)
+			if err != nil {
+				cobra.CheckErr(err)
+			}
+			grpcServer = newGRPCServer(tlsConfig)
+			go func() {
+				log.Printf("Serving gRPC on %s", grpcListener.Addr())
+				errs <- grpcServer.Serve(grpcListener)
+			}()
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		// The Windows service manager stops the server like a signal does
+		ctx, stopped := serviceContext(ctx)
+		defer stopped()
+
+		select {
+		case err = <-errs:
+			cobra.CheckErr(err)
+		case <-ctx.Done():
+		}
+		// A second signal stops the process immediately
+		stop()
+
+		log.Printf("Shutting down, waiting up to %s for requests to finish", cfg.Server.ShutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if grpcServer != nil {
+			// Calls still running when the grace period ends are cancelled
+			go func() {
+				<-shutdownCtx.Done()
+				grpcServer.Stop()
+			}()
+		}
+		err = server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("Error shutting down gracefully: %v", err)
+			server.Close()
+		}
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
 	},
 }
 
-func handleIndex(w http.ResponseWriter, r *http.Request) {
-	// Parse the template
-	index, err := template.ParseFS(templates, "templates/index.html")
+// renderTemplate executes the named template along with the shared partials
+func renderTemplate(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	// Parse the templates, t translates text to the language of the request
+	tr := requestTranslator(r)
+	tmpl, err := template.New("").Funcs(templateFuncs).Funcs(template.FuncMap{"t": tr.T}).ParseFS(templateFS(), "*.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	// Every page renders the footer which needs version information
+	data["Version"] = version
+	data["Commit"] = commit
+	data["Date"] = date
+
+	// Links and forms are relative to the base path
+	data["BasePath"] = basePath
+	data["Lang"] = tr.Lang()
+
+	// The theme is rendered into the page so it never flashes in the wrong colors
+	data["Theme"] = requestTheme(r)
+	data["AccentColor"] = cfg.Server.AccentColor
+	data["ThemeColor"] = themeColor()
+	data["Path"] = r.URL.RequestURI()
+
+	// The header shows who is logged in and whether they can log out
+	p, _ := requestPrincipal(r)
+	data["User"] = interactiveUser(r)
+	data["Logout"] = p.SessionID != ""
+	data["ReadOnly"] = readOnly()
+	data["Maintenance"] = currentMaintenance()
+	data["Admin"] = userPermissions(r).IsAdmin()
+	data["CSRFToken"] = csrfToken(r)
+
 	// Execute the template
-	data := map[string]interface{}{
-		"Machines":     cfg.Machines,
-		"Version":      version,
-		"Commit":       commit,
-		"Date":         date,
-		"FlashMessage": consumeFlashMessage(w, r), // Get flash message from cookie
-	}
-	err = index.Execute(w, data)
+	err = tmpl.ExecuteTemplate(w, name, data)
 	if err != nil {
 		log.Printf("Error executing template: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -70,12 +423,84 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// setFlashMessage sets a flash message in a cookie
+// machineView represents a machine as shown in the web interface
+type machineView struct {
+	config.Machine
+	// CanWake determines if the wake button is shown
+	CanWake bool
+	// CanPower determines if the shutdown and reboot buttons are shown
+	CanPower bool
+	// ConfirmWake determines if waking asks for confirmation first
+	ConfirmWake bool
+	// Status is the last known status, updated live by the page
+	Status string
+	// LastSeen is when the machine was last online, zero if never
+	LastSeen time.Time
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	permissions := requestPermissions(r)
+
+	visible := visibleMachines(r)
+	filter := machineFilterFromRequest(r)
+	filtered := filter.apply(visible)
+	view := dashboardViewFromRequest(w, r)
+	statuses, _ := poller.snapshot(filtered)
+	machines := make([]machineView, 0, len(filtered))
+	for _, machine := range filtered {
+		status, ok := statuses[machine.Name]
+		if !ok {
+			status = "unknown"
+		}
+		machines = append(machines, machineView{
+			Machine:     machine,
+			CanWake:     permissions.CanWake(machine.Name, machine.Group),
+			CanPower:    permissions.CanWake(machine.Name, machine.Group) && canPower(machine),
+			ConfirmWake: confirmWake(machine),
+			Status:      status,
+			LastSeen:    machineLastSeen(machine.Name),
+		})
+	}
+	sortMachines(machines, view.Sort)
+
+	// Only the machines of the page are sent status updates
+	start, end, page := paginate(len(machines), cfg.Server.PageSize, r.URL.Query())
+	machines = machines[start:end]
+
+	// Headings are only shown if groups are used
+	groups := groupMachines(machines)
+	grouped := len(groups) > 1 || (len(groups) == 1 && groups[0].Name != "")
+
+	data := map[string]interface{}{
+		"Machines":     machines,
+		"Total":        len(visible),
+		"Filter":       filter,
+		"View":         view,
+		"Page":         page,
+		"SortOrders":   sortOrders,
+		"SortLabels":   sortLabels,
+		"Layouts":      layouts,
+		"LayoutLabels": layoutLabels,
+		"Tags":         machineTags(visible),
+		"GroupNames":   machineGroups(visible),
+		"Statuses":     []string{"online", "offline", "unknown"},
+		"Groups":       groups,
+		"Grouped":      grouped,
+		"CanWakeAll":   canWakeAll(r),
+		"FlashMessage": consumeFlashMessage(w, r), // Get flash message from cookie
+	}
+	renderTemplate(w, r, "index.html", data)
+}
+
+// setFlashMessage sets a flash message in a cookie, escaped as translated
+// messages may contain characters not allowed in cookies
 func setFlashMessage(w http.ResponseWriter, message string) {
 	http.SetCookie(w, &http.Cookie{
-		Name:  "flash",
-		Value: message,
-		Path:  "/",
+		Name:     "flash",
+		Value:    url.QueryEscape(message),
+		Path:     appURL("/"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
 }
 
@@ -87,94 +512,257 @@ func consumeFlashMessage(w http.ResponseWriter, r *http.Request) string {
 		http.SetCookie(w, &http.Cookie{
 			Name:    "flash",
 			Value:   "",
-			Path:    "/",
+			Path:    appURL("/"),
 			Expires: time.Now().Add(-1 * time.Hour),
 		})
 
-		return cookie.Value
+		message, err := url.QueryUnescape(cookie.Value)
+		if err != nil {
+			return cookie.Value
+		}
+		return message
 	}
 	return ""
 }
 
-func handleWake(w http.ResponseWriter, r *http.Request) {
-	machineName := r.FormValue("name")
+// Errors returned by authorizeWake
+var (
+	errMachineNotFound = errors.New("machine not found")
+	errReadOnly        = errors.New("read-only mode")
+	errPermission      = errors.New("permission denied")
+)
 
-	// Find machine config to get IP
-	var machine *config.Machine
-	for _, m := range cfg.Machines {
-		if strings.EqualFold(m.Name, machineName) {
-			machine = &m
-			break
+// findMachine returns the configured machine with the given name
+func findMachine(name string) (config.Machine, bool) {
+	for _, m := range allMachines() {
+		if strings.EqualFold(m.Name, name) {
+			return m, true
 		}
 	}
+	return config.Machine{}, false
+}
+
+// findVisibleMachine returns the machine with the given name if the user can
+// see it, machines the user can't see are treated as not existing
+func findVisibleMachine(r *http.Request, name string) (config.Machine, bool) {
+	machine, ok := findMachine(name)
+	if !ok || !requestPermissions(r).CanView(machine.Name, machine.Group) {
+		return config.Machine{}, false
+	}
+	return machine, true
+}
+
+// wakeAs wakes the machine on behalf of the user making the request after
+// checking they are allowed to, the attempt is recorded in the audit log
+func wakeAs(r *http.Request, name string) (config.Machine, error) {
+	machine, ok := findVisibleMachine(r, name)
+	if !ok {
+		return config.Machine{}, errMachineNotFound
+	}
+	if readOnly() {
+		recordAudit(r, "wake", machine.Name, errReadOnly)
+		return machine, errReadOnly
+	}
+	if !requestPermissions(r).CanWake(machine.Name, machine.Group) {
+		recordAudit(r, "wake", machine.Name, errPermission)
+		return machine, errPermission
+	}
+
+	err := wakeMachine(r.Context(), machine)
+	if err == nil {
+		wakeProgress.start(machine)
+	}
+	recordAudit(r, "wake", machine.Name, err)
+	p, _ := requestPrincipal(r)
+	recordWakeHistoryAs(p.Username, machine.Name, err)
+	notifyWake(p.Username, machine, err)
+	if err != nil {
+		log.Printf("Error waking machine %s: %v", machine.Name, err)
+	}
+	return machine, err
+}
+
+// wakeAsService wakes the machine on behalf of something other than a user
+// making a request, e.g. a schedule, recorded like wakeAs under the given name
+func wakeAsService(ctx context.Context, user string, machine config.Machine) error {
+	if readOnly() {
+		recordAuditEntry(audit.Entry{Action: "wake", User: user, Target: machine.Name}, errReadOnly)
+		return errReadOnly
+	}
+
+	err := wakeMachine(ctx, machine)
+	if err == nil {
+		wakeProgress.start(machine)
+	}
+	recordAuditEntry(audit.Entry{
+		Action: "wake",
+		User:   user,
+		Target: machine.Name,
+		Result: audit.ResultSuccess,
+	}, err)
+	recordWakeHistoryAs(user, machine.Name, err)
+	notifyWake(user, machine, err)
+	return err
+}
+
+// confirmWake reports whether waking the machine from the web interface asks
+// for confirmation first, machines may override the server's setting
+func confirmWake(machine config.Machine) bool {
+	if machine.ConfirmWake != nil {
+		return *machine.ConfirmWake
+	}
+	return cfg.Server.ConfirmWake
+}
+
+func handleWake(w http.ResponseWriter, r *http.Request) {
+	machineName := r.FormValue("name")
 
-	if machine == nil {
+	_, err := wakeAs(r, machineName)
+	switch {
+	case errors.Is(err, errMachineNotFound):
 		http.Error(w, "Machine not found", http.StatusBadRequest)
 		return
+	case errors.Is(err, errReadOnly):
+		http.Error(w, readOnlyReason()+", waking machines is disabled", http.StatusForbidden)
+		return
+	case errors.Is(err, errPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	mac, err := net.ParseMAC(machine.Mac)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// The dashboard shows the progress of wakes it sent itself
+	if r.Header.Get("Accept") == "application/json" {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	log.Printf("Sending magic packet to %s", mac)
-	mp := magicpacket.NewMagicPacket(mac)
+	// Set flash message cookie
+	setFlashMessage(w, requestTranslator(r).T("Wake-up signal sent to %s. The machine should wake up shortly.", machineName))
+
+	// Forms on the machine page return there
+	http.Redirect(w, r, appURL(safeRedirect(r.FormValue("next"))), http.StatusSeeOther)
+}
+
+// wakeMachine sends the magic packet to the machine, unicast to its IP if
+// configured (Wake on WAN) and broadcast on all interfaces, or asks the relay
+// agent serving the machine's subnet to do so
+func wakeMachine(ctx context.Context, machine config.Machine) (err error) {
+	_, span := tracer.Start(ctx, "wake", trace.WithAttributes(
+		attribute.String("machine.name", machine.Name),
+		attribute.String("machine.mac", machine.Mac),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
 
-	// If IP is configured, try Unicast (Wake on WAN)
+	// Peers wake their machines from their own network
+	if machine.Peer != "" {
+		return wakePeerMachine(ctx, machine)
+	}
+	if t, ok := typeOf(machine); ok {
+		log.Printf("Starting %s machine %s", machine.Type, machine.Name)
+		return t.wake(ctx, machine)
+	}
+
+	mac, err := net.ParseMAC(machine.Mac)
+	if err != nil {
+		return fmt.Errorf("failed to parse MAC address: %w", err)
+	}
+
+	// If IP is configured or the machine has a preferred Tailscale IP, try
+	// Unicast (Wake on WAN)
+	var addr string
 	if machine.IP != nil && *machine.IP != "" {
-		addr := *machine.IP
+		addr = *machine.IP
+	}
+	if address, ok := preferTailscaleAddress(machine); ok {
+		addr = address
+	}
+	if addr != "" {
 		// If the address doesn't contain a port, default to 9
 		if _, _, err := net.SplitHostPort(addr); err != nil {
 			addr = net.JoinHostPort(addr, "9")
 		}
+	}
+
+	// Machines on subnets of relay agents are woken from there
+	if agent := relayAgents.route(ctx, machine); agent != nil {
+		log.Printf("Sending magic packet to %s through relay agent %s", mac, agent.name)
+		return agent.wake(ctx, mac, addr)
+	}
+
+	log.Printf("Sending magic packet to %s", mac)
+	mp := magicpacket.NewMagicPacket(mac)
+
+	if addr != "" {
 		log.Printf("Sending unicast packet to %s", addr)
 		if err := mp.Send(addr); err != nil {
 			log.Printf("Error sending unicast packet: %v", err)
 		}
 	}
 
-	if err := mp.Broadcast(); err != nil {
-		log.Printf("Error sending magic packet: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Set flash message cookie
-	setFlashMessage(w, fmt.Sprintf("Wake-up signal sent to %s. The machine should wake up shortly.", machineName))
-
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	return mp.Broadcast()
 }
 
 // getMachineStatus returns the status of a machine
-func getMachineStatus(machine config.Machine) (string, error) {
-	if machine.IP == nil {
+func getMachineStatus(ctx context.Context, machine config.Machine) (status string, err error) {
+	_, span := tracer.Start(ctx, "probe", trace.WithAttributes(attribute.String("machine.name", machine.Name)))
+	defer func() {
+		span.SetAttributes(attribute.String("machine.status", status))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if machine.Peer != "" {
+		return peerMachineStatus(machine), nil
+	}
+
+	prober, err := machineProber(machine)
+	if err != nil {
+		return "unknown", err
+	}
+	if prober == nil {
 		return "unknown", nil
 	}
 
-	reachable, err := isAddressReachable(*machine.IP)
+	result, err := prober.Probe(ctx)
 	if err != nil {
 		return "unknown", err
 	}
-	if reachable {
+	if result.Online {
+		observations.record(machine.Name, "online", result.Latency)
 		return "online", nil
 	}
 
+	observations.record(machine.Name, "offline", 0)
 	return "offline", nil
 }
 
-// getMachinesStatus returns a map of machine names to their statuses concurrently
-func getMachinesStatus() map[string]string {
+// getMachinesStatus returns a map of machine names to their statuses, checking
+// up to the configured number of machines concurrently
+func getMachinesStatus(ctx context.Context, machines []config.Machine) map[string]string {
 	var mu sync.Mutex
 	statuses := make(map[string]string)
 	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, max(cfg.Server.ProbeConcurrency, 1))
 
-	for _, machine := range cfg.Machines {
+	for _, machine := range machines {
 		wg.Add(1)
+		semaphore <- struct{}{}
 		go func(machine config.Machine) {
 			defer wg.Done()
-			status, err := getMachineStatus(machine)
+			defer func() { <-semaphore }()
+			status, err := getMachineStatus(ctx, machine)
 			if err != nil {
 				log.Printf("Error getting status for machine %s: %v", machine.Name, err)
 				return
@@ -191,80 +779,95 @@ func getMachinesStatus() map[string]string {
 	return statuses
 }
 
+// handleStatus streams the status of the machines, the first event contains
+// all of them and later events only the ones that changed. Clients resuming
+// with Last-Event-ID only get the machines that changed since that event.
+// Clients showing only some machines name them with machine parameters so
+// the others aren't probed as often.
 func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !statusClients.acquire(cfg.Server.MaxStatusClients) {
+		log.Printf("Rejecting status stream of %s, %d clients are connected", clientIP(r), cfg.Server.MaxStatusClients)
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Too many status clients", http.StatusServiceUnavailable)
+		return
+	}
+	defer statusClients.release()
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	keepReading(w)
 
-	// Sends the current status of all machines
-	sendMachinesStatus := func() {
-		statuses := getMachinesStatus()
-		data, err := json.Marshal(statuses)
+	// Only machines visible to the user are reported
+	machines := visibleMachines(r)
+	if names := r.URL.Query()["machine"]; len(names) > 0 {
+		machines = slices.DeleteFunc(machines, func(machine config.Machine) bool {
+			return !slices.ContainsFunc(names, func(name string) bool { return strings.EqualFold(name, machine.Name) })
+		})
+	}
+
+	// Writes an event and flushes it to the client, clients that can't keep
+	// up are dropped. The poller never waits for this handler, updates
+	// arriving in the meantime are coalesced into the next event.
+	writeEvent := func(event string) bool {
+		setWriteDeadline(w, cfg.Server.StatusWriteTimeout)
+		_, err := fmt.Fprint(w, event)
+		if err == nil {
+			err = http.NewResponseController(w).Flush()
+		}
 		if err != nil {
-			log.Printf("Error marshaling status: %v", err)
-			return
+			log.Printf("Error writing status to %s: %v", clientIP(r), err)
+			return false
 		}
+		return true
+	}
 
-		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
-		if err != nil {
-			log.Printf("Error writing status: %v", err)
-			return
+	// The statuses the client knows about
+	var sent map[string]string
+	lastVersion, resumed := parseStatusEventID(r.Header.Get("Last-Event-ID"))
+
+	// Sends the statuses that changed since the last event
+	sendMachinesStatus := func() bool {
+		current, version := poller.snapshot(machines)
+
+		var delta map[string]string
+		switch {
+		case sent != nil:
+			delta = statusDelta(sent, current)
+		case resumed && lastVersion <= version:
+			delta = statusChanges.changedSince(lastVersion, current)
+		default:
+			delta = current
 		}
+		sent = current
 
-		w.(http.Flusher).Flush()
-	}
+		// Comments keep the connection from being closed by proxies when nothing changed
+		if len(delta) == 0 {
+			return writeEvent(": no changes\n\n")
+		}
 
-	// Sends initial status
-	sendMachinesStatus()
+		data, err := json.Marshal(delta)
+		if err != nil {
+			log.Printf("Error marshaling status: %v", err)
+			return false
+		}
+		return writeEvent(fmt.Sprintf("id: %s\ndata: %s\n\n", statusEventID(version), data))
+	}
 
-	// Send status updates every few seconds
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	// The shared poller notifies about every sweep
+	updates, unsubscribe := poller.subscribe(machines)
+	defer unsubscribe()
 
 	for {
 		select {
 		case <-r.Context().Done():
 			return
-		case <-ticker.C:
-			sendMachinesStatus()
-		}
-	}
-}
-
-func isAddressReachable(addr string) (bool, error) {
-	pinger, err := probing.NewPinger(addr)
-	if err != nil {
-		return false, fmt.Errorf("error creating pinger: %v", err)
-	}
-	// Set privileged mode based on config
-	pinger.SetPrivileged(cfg.Ping.Privileged)
-
-	// We only want to ping once and wait 2 seconds for a response
-	pinger.Timeout = 2 * time.Second
-	pinger.Count = 1
-
-	err = pinger.Run()
-	if err != nil {
-		return false, fmt.Errorf("error pinging: %v", err)
-	}
-
-	// If we receive even a single packet, the address is reachable
-	stats := pinger.Statistics()
-	if stats.PacketsRecv == 0 {
-		return false, nil
-	}
-
-	return true, nil
-}
-
-func authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, password, ok := r.BasicAuth()
-		if !ok || password != "4056063" {
-			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		case <-shuttingDown:
 			return
+		case <-updates:
+			if !sendMachinesStatus() {
+				return
+			}
 		}
-		next.ServeHTTP(w, r)
-	})
+	}
 }
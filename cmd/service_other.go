@@ -0,0 +1,11 @@
+//go:build !windows && !noserve
+
+package cmd
+
+import "context"
+
+// serviceContext returns ctx as is, only Windows has a service manager
+// stopping the server
+func serviceContext(ctx context.Context) (context.Context, func()) {
+	return ctx, func() {}
+}
@@ -0,0 +1,253 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name of the Windows service and its event log source
+const serviceName = "wol"
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceInstallCmd, serviceUninstallCmd, serviceStartCmd, serviceStopCmd)
+
+	// Services start in the system directory, the config file next to the
+	// executable is used instead of the one there
+	if isService, _ := svc.IsWindowsService(); isService {
+		exe, err := os.Executable()
+		if err == nil {
+			os.Chdir(filepath.Dir(exe))
+		}
+	}
+}
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage the Windows service running the server",
+	Long:  "Install, uninstall, start and stop the Windows service running wol serve unattended",
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install [-- serve flags...]",
+	Short: "Install the Windows service",
+	Long:  "Install a Windows service starting wol serve with the given flags when Windows starts",
+	Run: func(cmd *cobra.Command, args []string) {
+		exe, err := os.Executable()
+		if err != nil {
+			cobra.CheckErr(fmt.Errorf("failed to find executable: %w", err))
+		}
+
+		m, err := mgr.Connect()
+		if err != nil {
+			cobra.CheckErr(fmt.Errorf("failed to connect to service manager: %w", err))
+		}
+		defer m.Disconnect()
+
+		s, err := m.OpenService(serviceName)
+		if err == nil {
+			s.Close()
+			cobra.CheckErr(fmt.Errorf("service %s already exists", serviceName))
+		}
+		s, err = m.CreateService(serviceName, exe, mgr.Config{
+			DisplayName: "Wake-on-LAN",
+			Description: "Web interface to wake up machines on the network",
+			StartType:   mgr.StartAutomatic,
+		}, append([]string{"serve"}, args...)...)
+		if err != nil {
+			cobra.CheckErr(fmt.Errorf("failed to create service: %w", err))
+		}
+		defer s.Close()
+
+		err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+		if err != nil {
+			s.Delete()
+			cobra.CheckErr(fmt.Errorf("failed to register event log source: %w", err))
+		}
+		log.Printf("Installed service %s, start it with wol service start", serviceName)
+	},
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Uninstall the Windows service",
+	Long:  "Remove the Windows service, stopping it first if it's running",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		m, s := openService()
+		defer m.Disconnect()
+		defer s.Close()
+
+		status, err := s.Query()
+		if err == nil && status.State != svc.Stopped {
+			err = stopService(s)
+			if err != nil {
+				cobra.CheckErr(err)
+			}
+		}
+		err = s.Delete()
+		if err != nil {
+			cobra.CheckErr(fmt.Errorf("failed to delete service: %w", err))
+		}
+		err = eventlog.Remove(serviceName)
+		if err != nil {
+			log.Printf("Error removing event log source: %v", err)
+		}
+		log.Printf("Uninstalled service %s", serviceName)
+	},
+}
+
+var serviceStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the Windows service",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		m, s := openService()
+		defer m.Disconnect()
+		defer s.Close()
+
+		err := s.Start()
+		if err != nil {
+			cobra.CheckErr(fmt.Errorf("failed to start service: %w", err))
+		}
+		log.Printf("Started service %s", serviceName)
+	},
+}
+
+var serviceStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the Windows service",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		m, s := openService()
+		defer m.Disconnect()
+		defer s.Close()
+
+		err := stopService(s)
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		log.Printf("Stopped service %s", serviceName)
+	},
+}
+
+// openService connects to the service manager and opens the service
+func openService() (*mgr.Mgr, *mgr.Service) {
+	m, err := mgr.Connect()
+	if err != nil {
+		cobra.CheckErr(fmt.Errorf("failed to connect to service manager: %w", err))
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		m.Disconnect()
+		cobra.CheckErr(fmt.Errorf("failed to open service %s: %w", serviceName, err))
+	}
+	return m, s
+}
+
+// stopService asks the service to stop and waits until it did, at most as
+// long as the server waits for requests to finish
+func stopService(s *mgr.Service) error {
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("failed to stop service: %w", err)
+	}
+	deadline := time.Now().Add(cfg.Server.ShutdownTimeout + 10*time.Second)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service didn't stop in time")
+		}
+		time.Sleep(300 * time.Millisecond)
+		status, err = s.Query()
+		if err != nil {
+			return fmt.Errorf("failed to query service: %w", err)
+		}
+	}
+	return nil
+}
+
+// serviceHandler reports the state of the server to the service manager
+type serviceHandler struct {
+	// stop is called when the service manager stops the service
+	stop context.CancelFunc
+	// stopped is closed once the server shut down
+	stopped chan struct{}
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-h.stopped:
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				changes <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32((cfg.Server.ShutdownTimeout + 5*time.Second) / time.Millisecond)}
+				h.stop()
+				<-h.stopped
+				return false, 0
+			}
+		}
+	}
+}
+
+// eventLogWriter writes every log line to the Windows event log, lines
+// mentioning errors are logged as errors
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSpace(string(p))
+	var err error
+	if strings.Contains(strings.ToLower(line), "error") {
+		err = w.log.Error(1, line)
+	} else {
+		err = w.log.Info(1, line)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// serviceContext returns a context cancelled when the Windows service
+// manager stops the service and a function to call once the server shut
+// down, outside of a service ctx is returned as is
+func serviceContext(ctx context.Context) (context.Context, func()) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return ctx, func() {}
+	}
+
+	if elog, err := eventlog.Open(serviceName); err == nil {
+		log.SetFlags(0)
+		log.SetOutput(eventLogWriter{log: elog})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	handler := &serviceHandler{stop: cancel, stopped: make(chan struct{})}
+	go func() {
+		err := svc.Run(serviceName, handler)
+		if err != nil {
+			log.Printf("Error running as a service: %v", err)
+			cancel()
+		}
+	}()
+	return ctx, func() { close(handler.stopped) }
+}
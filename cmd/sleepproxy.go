@@ -0,0 +1,78 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/magicpacket"
+	"github.com/trugamr/wol/sleepproxy"
+)
+
+// sleepProxy answers for sleeping devices, nil if disabled
+var sleepProxy *sleepproxy.Server
+
+// setupSleepProxy validates the sleep proxy settings if enabled
+func setupSleepProxy() error {
+	c := cfg.SleepProxy
+	if !c.Enabled {
+		return nil
+	}
+	if strings.TrimSpace(c.Name) == "" {
+		return errors.New("invalid sleep_proxy settings: name is required")
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return errors.New("invalid sleep_proxy settings: port must be between 1 and 65535")
+	}
+	sleepProxy = &sleepproxy.Server{Name: c.Name, Port: c.Port, Wake: wakeSleepingDevice}
+	return nil
+}
+
+// runSleepProxy serves the sleep proxy in the background until stop is closed
+func runSleepProxy(stop <-chan struct{}) {
+	if sleepProxy == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		log.Printf("Sleep proxy %q accepting registrations on port %d", cfg.SleepProxy.Name, cfg.SleepProxy.Port)
+		if err := sleepProxy.Serve(ctx); err != nil {
+			log.Printf("Error serving sleep proxy: %v", err)
+		}
+	}()
+}
+
+// wakeSleepingDevice wakes a device whose services were requested, like any
+// other wake if it is configured as a machine
+func wakeSleepingDevice(reg sleepproxy.Registration) {
+	if machine, ok := findMachineByMAC(reg.MAC); ok {
+		log.Printf("Waking machine %s, its services were requested from the sleep proxy", machine.Name)
+		wakeAsService(context.Background(), "sleep proxy", machine)
+		return
+	}
+
+	log.Printf("Sending magic packet to %s (%s), its services were requested from the sleep proxy", reg.Hostname, reg.MAC)
+	if err := magicpacket.NewMagicPacket(reg.MAC).Broadcast(); err != nil {
+		log.Printf("Error waking %s: %v", reg.Hostname, err)
+	}
+}
+
+// findMachineByMAC returns the machine with the MAC address
+func findMachineByMAC(mac net.HardwareAddr) (config.Machine, bool) {
+	for _, machine := range allMachines() {
+		if m, err := net.ParseMAC(machine.Mac); err == nil && bytes.Equal(m, mac) {
+			return machine, true
+		}
+	}
+	return config.Machine{}, false
+}
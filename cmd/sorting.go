@@ -0,0 +1,141 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// viewCookieName is the cookie remembering how the dashboard lists machines
+const viewCookieName = "view"
+
+// Orders machines can be sorted in and layouts they can be shown in, the
+// first of each is the default
+var (
+	sortOrders = []string{"name", "status", "group", "last-seen"}
+	layouts    = []string{"grid", "table"}
+)
+
+// Labels of the sort orders and layouts, translated when shown
+var (
+	sortLabels = map[string]string{
+		"name":      "Sort by name",
+		"status":    "Sort by status",
+		"group":     "Sort by group",
+		"last-seen": "Sort by last seen",
+	}
+	layoutLabels = map[string]string{
+		"grid":  "Grid view",
+		"table": "Table view",
+	}
+)
+
+// statusRanks orders statuses when sorting by status, machines that are up come first
+var statusRanks = map[string]int{
+	"online":        0,
+	"waking":        1,
+	"rebooting":     2,
+	"shutting-down": 3,
+	"offline":       4,
+	"unknown":       5,
+}
+
+// dashboardView is how the dashboard lists machines
+type dashboardView struct {
+	// Sort is one of sortOrders
+	Sort string
+	// Layout is one of layouts
+	Layout string
+}
+
+// dashboardViewFromRequest returns the view chosen with the sort and view
+// query parameters, which are remembered in a cookie, or the one remembered
+func dashboardViewFromRequest(w http.ResponseWriter, r *http.Request) dashboardView {
+	view := dashboardView{Sort: sortOrders[0], Layout: layouts[0]}
+	if cookie, err := r.Cookie(viewCookieName); err == nil {
+		values, _ := url.ParseQuery(cookie.Value)
+		view = view.with(values)
+	}
+
+	query := r.URL.Query()
+	if !query.Has("sort") && !query.Has("view") {
+		return view
+	}
+	view = view.with(query)
+	http.SetCookie(w, &http.Cookie{
+		Name:     viewCookieName,
+		Value:    url.Values{"sort": {view.Sort}, "view": {view.Layout}}.Encode(),
+		Path:     appURL("/"),
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return view
+}
+
+// with returns the view with the valid sort order and layout of the values
+func (v dashboardView) with(values url.Values) dashboardView {
+	if s := values.Get("sort"); slices.Contains(sortOrders, s) {
+		v.Sort = s
+	}
+	if l := values.Get("view"); slices.Contains(layouts, l) {
+		v.Layout = l
+	}
+	return v
+}
+
+// machineLastSeen returns when the machine was last online, falling back to
+// the history for machines not seen since the server started
+func machineLastSeen(name string) time.Time {
+	seen := observations.get(name).LastSeen
+	if seen.IsZero() && historyStore != nil {
+		var err error
+		seen, err = historyStore.LastSeen(name)
+		if err != nil {
+			log.Printf("Error reading history of %s: %v", name, err)
+		}
+	}
+	return seen
+}
+
+// sortMachines sorts the machines in the order, ties are broken by name
+func sortMachines(machines []machineView, order string) {
+	byName := func(a, b machineView) bool {
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	}
+	less := byName
+	switch order {
+	case "status":
+		less = func(a, b machineView) bool {
+			if statusRanks[a.Status] != statusRanks[b.Status] {
+				return statusRanks[a.Status] < statusRanks[b.Status]
+			}
+			return byName(a, b)
+		}
+	case "group":
+		// Machines without a group come last, like on the grouped dashboard
+		less = func(a, b machineView) bool {
+			ga, gb := strings.ToLower(a.Group), strings.ToLower(b.Group)
+			if ga != gb {
+				return gb == "" || (ga != "" && ga < gb)
+			}
+			return byName(a, b)
+		}
+	case "last-seen":
+		// Most recently seen first, never seen last
+		less = func(a, b machineView) bool {
+			if !a.LastSeen.Equal(b.LastSeen) {
+				return a.LastSeen.After(b.LastSeen)
+			}
+			return byName(a, b)
+		}
+	}
+	sort.SliceStable(machines, func(i, j int) bool { return less(machines[i], machines[j]) })
+}
@@ -0,0 +1,8 @@
+// Shows the OpenAPI document of the page's Swagger UI element
+window.addEventListener('load', () => {
+    const element = document.getElementById('swagger-ui');
+    SwaggerUIBundle({
+        url: element.dataset.url,
+        dom_id: '#swagger-ui',
+    });
+});
@@ -0,0 +1,57 @@
+// Keeps a copy of the pages and files last loaded so the dashboard still
+// opens when the connection drops for a moment, the network always wins
+const CACHE = 'wol-v1';
+const BASE = new URL(self.registration.scope).pathname.replace(/\/$/, '');
+
+// Live statuses, the API and anything changing state are never cached
+const UNCACHED = ['/status', '/ws/', '/api/', '/login', '/logout', '/oidc/'];
+
+self.addEventListener('install', (event) => {
+    event.waitUntil(
+        caches.open(CACHE)
+            .then((cache) => cache.addAll([
+                BASE + '/manifest.webmanifest',
+                BASE + '/static/favicon.svg',
+                BASE + '/static/icon-192.png',
+            ]))
+            .then(() => self.skipWaiting())
+    );
+});
+
+self.addEventListener('activate', (event) => {
+    event.waitUntil(
+        caches.keys()
+            .then((keys) => Promise.all(keys.filter((key) => key !== CACHE).map((key) => caches.delete(key))))
+            .then(() => self.clients.claim())
+    );
+});
+
+self.addEventListener('fetch', (event) => {
+    const request = event.request;
+    const url = new URL(request.url);
+    if (request.method !== 'GET' || url.origin !== self.location.origin || !url.pathname.startsWith(BASE + '/')) {
+        return;
+    }
+    const path = url.pathname.slice(BASE.length);
+    if (UNCACHED.some((prefix) => path.startsWith(prefix))) {
+        return;
+    }
+
+    event.respondWith(
+        fetch(request)
+            .then((response) => {
+                // Redirects lead to the login page, which isn't worth keeping
+                if (response.ok && !response.redirected && response.type === 'basic') {
+                    const copy = response.clone();
+                    caches.open(CACHE).then((cache) => cache.put(request, copy));
+                }
+                return response;
+            })
+            .catch(() => caches.match(request)
+                .then((cached) => cached || (request.mode === 'navigate' && caches.match(BASE + '/')))
+                .then((cached) => cached || new Response('Offline, try again once the connection is back.', {
+                    status: 503,
+                    headers: { 'Content-Type': 'text/plain; charset=utf-8' },
+                })))
+    );
+});
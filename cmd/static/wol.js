@@ -0,0 +1,197 @@
+// Scripts of the web interface. Pages pass translated texts in a JSON script
+// with the id "texts" and mark elements with data attributes instead of
+// running scripts of their own.
+(() => {
+    const basePath = document.currentScript.dataset.basePath || '';
+    const textsElement = document.getElementById('texts');
+    const texts = textsElement ? JSON.parse(textsElement.textContent) : {};
+
+    // How long the outcome of a successful wake stays visible
+    const wokeDisplay = 10000;
+
+    if ('serviceWorker' in navigator) {
+        navigator.serviceWorker.register(basePath + '/sw.js').catch((err) => console.warn('Failed to register service worker:', err));
+    }
+
+    // Forms asking for confirmation first, registered before any other
+    // submit handler so those see cancelled submissions
+    for (const form of document.querySelectorAll('form[data-confirm]')) {
+        form.addEventListener('submit', (event) => {
+            if (!confirm(form.dataset.confirm)) {
+                event.preventDefault();
+            }
+        });
+    }
+
+    // Selects applying their choice right away
+    for (const select of document.querySelectorAll('select[data-autosubmit]')) {
+        select.addEventListener('change', () => select.form.submit());
+    }
+
+    // Buttons opening a dialog
+    for (const button of document.querySelectorAll('[data-dialog]')) {
+        button.addEventListener('click', () => document.getElementById(button.dataset.dialog).showModal());
+    }
+
+    // Shows the status of a machine and the progress of a wake, shutdown or
+    // reboot below it
+    function showStatus(machine, status) {
+        const statusElement = machine.querySelector('.machine__status');
+        const stateElement = machine.querySelector('.machine__state');
+        const previous = statusElement.dataset.status;
+        statusElement.dataset.status = status;
+        stateElement.classList.remove('machine__state--done', 'machine__state--failed');
+
+        const nameElement = machine.querySelector('.details__status');
+        if (nameElement) {
+            nameElement.textContent = (texts.statuses || {})[status] || status;
+        }
+
+        if (previous === 'waking' && status === 'online') {
+            stateElement.textContent = texts.woke;
+            stateElement.classList.add('machine__state--done');
+            setTimeout(() => {
+                if (stateElement.classList.contains('machine__state--done')) {
+                    stateElement.textContent = '';
+                    stateElement.classList.remove('machine__state--done');
+                }
+            }, wokeDisplay);
+            return;
+        }
+        if (previous === 'waking' && (status === 'offline' || status === 'unknown')) {
+            stateElement.textContent = texts.timedOut;
+            stateElement.classList.add('machine__state--failed');
+            return;
+        }
+        stateElement.textContent = (texts.states || {})[status] || '';
+    }
+
+    // Only the machines on the page are watched
+    const machines = document.querySelectorAll('[data-watch]');
+    if (machines.length > 0) {
+        const watched = new URLSearchParams();
+        for (const machine of machines) {
+            watched.append('machine', machine.dataset.name);
+        }
+        const source = new EventSource(basePath + '/status?' + watched);
+        source.onmessage = (event) => {
+            const statuses = JSON.parse(event.data);
+            for (const machine of machines) {
+                if (machine.dataset.name in statuses) {
+                    showStatus(machine, statuses[machine.dataset.name]);
+                }
+            }
+        };
+
+        // Cleanup EventSource when page is unloaded
+        window.addEventListener('unload', () => {
+            source.close();
+        });
+    }
+
+    // Wakes machines without leaving the page, the status stream then
+    // follows them until they are online or the wake timed out
+    for (const form of document.querySelectorAll('form.wake')) {
+        form.addEventListener('submit', async (event) => {
+            // Forms asking for confirmation may have been cancelled
+            if (event.defaultPrevented) {
+                return;
+            }
+            event.preventDefault();
+
+            const stateElement = form.closest('[data-watch]').querySelector('.machine__state');
+            const button = form.querySelector('button');
+            stateElement.classList.remove('machine__state--done', 'machine__state--failed');
+            stateElement.textContent = texts.sending;
+            button.disabled = true;
+            try {
+                const response = await fetch(form.action, {
+                    method: 'POST',
+                    body: new FormData(form),
+                    headers: {'Accept': 'application/json'},
+                });
+                if (!response.ok) {
+                    throw new Error((await response.text()).trim() || response.statusText);
+                }
+                // The status stream may already have reported the machine as waking
+                if (stateElement.textContent === texts.sending) {
+                    stateElement.textContent = texts.sent;
+                }
+            } catch (err) {
+                stateElement.textContent = texts.failed.replace('%s', err.message);
+                stateElement.classList.add('machine__state--failed');
+            } finally {
+                button.disabled = false;
+            }
+        });
+    }
+
+    // Narrow down the listed machines while typing, the server is asked
+    // again if the search no longer narrows down its own results or other
+    // pages may match
+    const filter = document.getElementById('filter');
+    if (filter) {
+        const query = filter.elements.q;
+        const serverQuery = query.value.trim().toLowerCase();
+        const paged = filter.dataset.paged === 'true';
+        let timer;
+        query.addEventListener('input', () => {
+            const value = query.value.trim().toLowerCase();
+            if (paged || !value.includes(serverQuery)) {
+                clearTimeout(timer);
+                timer = setTimeout(() => filter.submit(), 300);
+                return;
+            }
+
+            let shown = 0;
+            for (const machine of document.querySelectorAll('.machine')) {
+                machine.hidden = !machine.dataset.search.toLowerCase().includes(value);
+                shown += machine.hidden ? 0 : 1;
+            }
+            for (const group of document.querySelectorAll('.group')) {
+                group.hidden = !group.querySelector('.machine:not([hidden])');
+            }
+            document.getElementById('filter-empty').hidden = shown > 0;
+
+            const url = new URL(location.href);
+            if (value) {
+                url.searchParams.set('q', query.value.trim());
+            } else {
+                url.searchParams.delete('q');
+            }
+            history.replaceState(null, '', url);
+        });
+    }
+
+    // Remember which groups were collapsed
+    const collapsed = new Set(JSON.parse(localStorage.getItem('wol.collapsedGroups') || '[]'));
+    for (const group of document.querySelectorAll('.group')) {
+        if (collapsed.has(group.dataset.group)) {
+            group.open = false;
+        }
+        group.addEventListener('toggle', () => {
+            if (group.open) {
+                collapsed.delete(group.dataset.group);
+            } else {
+                collapsed.add(group.dataset.group);
+            }
+            localStorage.setItem('wol.collapsedGroups', JSON.stringify([...collapsed]));
+        });
+    }
+
+    const tokenLogin = document.getElementById('token-login');
+    if (tokenLogin) {
+        // QR codes pass a token in the fragment so it never reaches the server's logs
+        const token = new URLSearchParams(location.hash.slice(1)).get('token');
+        if (token) {
+            history.replaceState(null, '', location.pathname + location.search);
+            tokenLogin.elements.token.value = token;
+            tokenLogin.submit();
+        }
+
+        // Pages kept for offline use belong to whoever was logged in before
+        if ('caches' in window) {
+            caches.keys().then((keys) => keys.forEach((key) => caches.delete(key)));
+        }
+    }
+})();
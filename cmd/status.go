@@ -0,0 +1,182 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/client"
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().BoolP("watch", "w", false, "Keep printing status changes, needs --server")
+	statusCmd.Flags().Bool("nagios", false, "Check a single machine like a Nagios or Icinga plugin, with its output and exit code")
+	statusCmd.Flags().Duration("warning", 0, "Round trip time above which an online machine is a warning, with --nagios and without --server")
+	statusCmd.Flags().Duration("critical", 0, "Round trip time above which an online machine is critical, with --nagios and without --server")
+}
+
+// Exit codes and states of Nagios plugins
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosStates = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+var statusCmd = &cobra.Command{
+	Use:   "status [name...]",
+	Short: "Show whether machines are online",
+	Long:  "Check the configured machines and show whether they are online, or ask the server given with --server",
+	Run: func(cmd *cobra.Command, args []string) {
+		watch, _ := cmd.Flags().GetBool("watch")
+		c := remoteClient(cmd)
+		if nagios, _ := cmd.Flags().GetBool("nagios"); nagios {
+			if len(args) != 1 {
+				cobra.CheckErr(fmt.Errorf("--nagios needs exactly one machine"))
+			}
+			warning, _ := cmd.Flags().GetDuration("warning")
+			critical, _ := cmd.Flags().GetDuration("critical")
+			code, output := nagiosCheck(cmd.Context(), c, args[0], warning, critical)
+			fmt.Printf("%s - %s\n", nagiosStates[code], output)
+			os.Exit(code)
+		}
+		if watch {
+			if c == nil {
+				cobra.CheckErr(fmt.Errorf("--watch needs --server"))
+			}
+			err := watchStatus(cmd.Context(), c, args)
+			if err != nil {
+				cobra.CheckErr(fmt.Errorf("failed to watch status: %w", err))
+			}
+			return
+		}
+
+		var statuses []client.MachineStatus
+		if c != nil {
+			var err error
+			statuses, err = c.Status(cmd.Context())
+			if err != nil {
+				cobra.CheckErr(fmt.Errorf("failed to get status: %w", err))
+			}
+		} else {
+			machines := allMachines()
+			probed := getMachinesStatus(cmd.Context(), machines)
+			for _, machine := range machines {
+				status, ok := probed[machine.Name]
+				if !ok {
+					status = client.StatusUnknown
+				}
+				statuses = append(statuses, client.MachineStatus{Name: machine.Name, Status: status})
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Name\tStatus")
+		for _, status := range statuses {
+			if len(args) > 0 && !slices.ContainsFunc(args, func(name string) bool { return strings.EqualFold(name, status.Name) }) {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\n", status.Name, status.Status)
+		}
+		w.Flush()
+	},
+}
+
+// nagiosCheck checks the machine and returns the exit code and output of a
+// Nagios plugin, with the round trip time as performance data when known
+func nagiosCheck(ctx context.Context, c *client.Client, name string, warning, critical time.Duration) (int, string) {
+	var status string
+	var latency time.Duration
+	if c != nil {
+		// The server only tells the status, thresholds would never be exceeded
+		if warning > 0 || critical > 0 {
+			return nagiosUnknown, "--warning and --critical need the round trip time, which isn't known with --server"
+		}
+		statuses, err := c.Status(ctx)
+		if err != nil {
+			return nagiosUnknown, fmt.Sprintf("failed to get status: %v", err)
+		}
+		i := slices.IndexFunc(statuses, func(s client.MachineStatus) bool { return strings.EqualFold(s.Name, name) })
+		if i < 0 {
+			return nagiosUnknown, fmt.Sprintf("machine %s not found", name)
+		}
+		name, status = statuses[i].Name, statuses[i].Status
+	} else {
+		machine, ok := findMachine(name)
+		if !ok {
+			return nagiosUnknown, fmt.Sprintf("machine %s not found", name)
+		}
+		name = machine.Name
+		prober, err := machineProber(machine)
+		if err != nil {
+			return nagiosUnknown, fmt.Sprintf("%s can't be checked: %v", name, err)
+		}
+		if prober == nil {
+			return nagiosUnknown, fmt.Sprintf("%s can't be checked, it has no address", name)
+		}
+		result, err := prober.Probe(ctx)
+		if err != nil {
+			return nagiosUnknown, fmt.Sprintf("%s status unknown: %v", name, err)
+		}
+		status = client.StatusOffline
+		if result.Online {
+			status, latency = client.StatusOnline, result.Latency
+		}
+	}
+
+	code := nagiosOK
+	switch {
+	case status == client.StatusOffline:
+		code = nagiosCritical
+	case status == client.StatusUnknown:
+		code = nagiosUnknown
+	case status != client.StatusOnline:
+		// Being woken, shut down or rebooted
+		code = nagiosWarning
+	case critical > 0 && latency > critical:
+		code = nagiosCritical
+	case warning > 0 && latency > warning:
+		code = nagiosWarning
+	}
+
+	output := fmt.Sprintf("%s is %s", name, status)
+	if latency > 0 {
+		ms := func(d time.Duration) string {
+			if d <= 0 {
+				return ""
+			}
+			return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+		}
+		output += fmt.Sprintf(", rtt %s ms | rtt=%sms;%s;%s;0;", ms(latency), ms(latency), ms(warning), ms(critical))
+	}
+	return code, output
+}
+
+// watchStatus prints the status of the machines and every change until interrupted
+func watchStatus(ctx context.Context, c *client.Client, names []string) error {
+	return c.StreamStatus(ctx, func(statuses map[string]string) error {
+		changed := make([]string, 0, len(statuses))
+		for name := range statuses {
+			changed = append(changed, name)
+		}
+		sort.Strings(changed)
+		now := time.Now().Format("15:04:05")
+		for _, name := range changed {
+			fmt.Printf("%s\t%s\t%s\n", now, name, statuses[name])
+		}
+		return nil
+	}, names...)
+}
@@ -0,0 +1,31 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/trugamr/wol/client"
+)
+
+func TestNagiosCheckThresholdsWithServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]client.MachineStatus{{Name: "desktop", Status: client.StatusOnline}})
+	}))
+	t.Cleanup(server.Close)
+	c := client.New(server.URL, "")
+
+	code, output := nagiosCheck(context.Background(), c, "desktop", 0, 0)
+	if code != nagiosOK {
+		t.Errorf("check without thresholds returned %s (%s), want OK", nagiosStates[code], output)
+	}
+	code, output = nagiosCheck(context.Background(), c, "desktop", 100*time.Millisecond, 500*time.Millisecond)
+	if code != nagiosUnknown {
+		t.Errorf("check with thresholds but no round trip time returned %s (%s), want UNKNOWN", nagiosStates[code], output)
+	}
+}
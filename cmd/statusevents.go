@@ -0,0 +1,104 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusEpoch identifies the current process in event ids, versions of a
+// previous run can't be compared with the current ones
+var statusEpoch = strconv.FormatInt(time.Now().Unix(), 36)
+
+// statusChanges keeps track of when the status of each machine last changed
+var statusChanges = &statusTracker{machines: make(map[string]trackedStatus)}
+
+// trackedStatus is the last known status of a machine
+type trackedStatus struct {
+	Status string
+	// Version is the tracker version at which the status last changed
+	Version uint64
+}
+
+// statusTracker assigns increasing versions to status changes so streams can
+// be resumed with only the machines that changed in the meantime
+type statusTracker struct {
+	mu       sync.Mutex
+	version  uint64
+	machines map[string]trackedStatus
+}
+
+// statusChange is a machine whose status changed from a known one
+type statusChange struct {
+	Name     string
+	Previous string
+	Status   string
+}
+
+// update records the statuses and returns the version after the update along
+// with the machines that changed, not counting the ones seen for the first time
+func (t *statusTracker) update(statuses map[string]string) (uint64, []statusChange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var changes []statusChange
+	for name, status := range statuses {
+		tracked, ok := t.machines[name]
+		if ok && tracked.Status == status {
+			continue
+		}
+		if ok {
+			changes = append(changes, statusChange{Name: name, Previous: tracked.Status, Status: status})
+		}
+		t.version++
+		t.machines[name] = trackedStatus{Status: status, Version: t.version}
+	}
+	return t.version, changes
+}
+
+// changedSince returns which of the statuses changed after the version
+func (t *statusTracker) changedSince(version uint64, statuses map[string]string) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changed := make(map[string]string)
+	for name, status := range statuses {
+		if tracked, ok := t.machines[name]; !ok || tracked.Version > version {
+			changed[name] = status
+		}
+	}
+	return changed
+}
+
+// statusEventID formats the id of an event sent at the version
+func statusEventID(version uint64) string {
+	return fmt.Sprintf("%s-%d", statusEpoch, version)
+}
+
+// parseStatusEventID returns the version of an event id sent by this process
+func parseStatusEventID(id string) (uint64, bool) {
+	epoch, version, ok := strings.Cut(id, "-")
+	if !ok || epoch != statusEpoch {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// statusDelta returns the statuses in current that differ from previous
+func statusDelta(previous, current map[string]string) map[string]string {
+	delta := make(map[string]string)
+	for name, status := range current {
+		if old, ok := previous[name]; !ok || old != status {
+			delta[name] = status
+		}
+	}
+	return delta
+}
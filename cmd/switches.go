@@ -0,0 +1,199 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/fdb"
+)
+
+// Defaults of the switch settings
+const (
+	defaultSwitchInterval = 5 * time.Minute
+	defaultSwitchTimeout  = 10 * time.Second
+)
+
+// switchLocationRetention is how long locations of MAC addresses are kept
+// after they aged out of the forwarding table, which happens soon after a
+// machine goes to sleep
+const switchLocationRetention = 7 * 24 * time.Hour
+
+// managedSwitch is a switch whose forwarding table is read to find the
+// ports machines are connected to
+type managedSwitch struct {
+	name     string
+	interval time.Duration
+	timeout  time.Duration
+	fdb      *fdb.Switch
+
+	mu sync.Mutex
+	// locations are where MAC addresses were last seen, by MAC address
+	locations map[string]switchLocation
+	// syncedAt is when the forwarding table was last read
+	syncedAt time.Time
+}
+
+// switchLocation is the port of a switch a MAC address was seen on
+type switchLocation struct {
+	// Switch is the name of the switch
+	Switch string
+	fdb.Entry
+	// SeenAt is when the address was last in the forwarding table
+	SeenAt time.Time
+	// PortMACs is the number of MAC addresses learned on the port, which is
+	// high for uplinks to other switches
+	PortMACs int
+}
+
+// switches are the configured switches
+var switches []*managedSwitch
+
+// setupSwitches validates the configured switches
+func setupSwitches() error {
+	seen := make(map[string]bool)
+	for i, c := range cfg.Switches {
+		if c.Name == "" {
+			return fmt.Errorf("invalid switch %d: name is required", i+1)
+		}
+		if seen[strings.ToLower(c.Name)] {
+			return fmt.Errorf("invalid switch %d: duplicate name %q", i+1, c.Name)
+		}
+		seen[strings.ToLower(c.Name)] = true
+		if c.Interval < 0 || c.Timeout < 0 {
+			return fmt.Errorf("invalid switch %s: interval and timeout must not be negative", c.Name)
+		}
+
+		s := &managedSwitch{
+			name:      c.Name,
+			interval:  c.Interval,
+			timeout:   c.Timeout,
+			locations: make(map[string]switchLocation),
+		}
+		if s.interval == 0 {
+			s.interval = defaultSwitchInterval
+		}
+		if s.timeout == 0 {
+			s.timeout = defaultSwitchTimeout
+		}
+		var err error
+		s.fdb, err = fdb.New(fdb.Config{
+			Address:         c.Address,
+			Version:         c.Version,
+			Community:       c.Community,
+			Username:        c.Username,
+			AuthProtocol:    c.AuthProtocol,
+			AuthPassword:    c.AuthPassword,
+			PrivacyProtocol: c.PrivacyProtocol,
+			PrivacyPassword: c.PrivacyPassword,
+			Timeout:         s.timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid switch %s: %w", c.Name, err)
+		}
+		switches = append(switches, s)
+	}
+	return nil
+}
+
+// runSwitches reads the forwarding tables of the switches every interval
+// until stop is closed
+func runSwitches(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for _, s := range switches {
+		go s.run(ctx)
+	}
+}
+
+// run reads the forwarding table every interval until the context is done
+func (s *managedSwitch) run(ctx context.Context) {
+	for {
+		s.sync(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// sync reads the forwarding table, keeping the locations of addresses that
+// aged out of it
+func (s *managedSwitch) sync(ctx context.Context) {
+	// Walking the tables of large switches takes many requests
+	syncCtx, cancel := context.WithTimeout(ctx, deviceSyncTimeout)
+	defer cancel()
+	entries, err := s.fdb.Entries(syncCtx)
+	// Shutting down
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading forwarding table of switch %s: %v", s.name, err)
+		return
+	}
+
+	portMACs := make(map[string]int)
+	for _, entry := range entries {
+		portMACs[entry.Port]++
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for mac, location := range s.locations {
+		if now.Sub(location.SeenAt) > switchLocationRetention {
+			delete(s.locations, mac)
+		}
+	}
+	for _, entry := range entries {
+		s.locations[entry.MAC] = switchLocation{Switch: s.name, Entry: entry, SeenAt: now, PortMACs: portMACs[entry.Port]}
+	}
+	s.syncedAt = now
+}
+
+// location returns where the MAC address was last seen, current is true if
+// it is in the last forwarding table read
+func (s *managedSwitch) location(mac string) (location switchLocation, ok, current bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	location, ok = s.locations[mac]
+	return location, ok, ok && location.SeenAt.Equal(s.syncedAt)
+}
+
+// locateMachine returns the switch port the machine was last seen on, false
+// if no switch has seen it. Addresses are learned on every switch between
+// the machine and the server, so the port with the fewest addresses wins
+// over uplinks.
+func locateMachine(machine config.Machine) (switchLocation, bool) {
+	mac, err := net.ParseMAC(machine.Mac)
+	if err != nil {
+		return switchLocation{}, false
+	}
+	var best switchLocation
+	var found, bestCurrent bool
+	for _, s := range switches {
+		location, ok, current := s.location(mac.String())
+		if !ok {
+			continue
+		}
+		better := !found ||
+			(current && !bestCurrent) ||
+			(current == bestCurrent && location.PortMACs < best.PortMACs) ||
+			(current == bestCurrent && location.PortMACs == best.PortMACs && location.SeenAt.After(best.SeenAt))
+		if better {
+			best, found, bestCurrent = location, true, current
+		}
+	}
+	return best, found
+}
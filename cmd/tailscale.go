@@ -0,0 +1,162 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/probe"
+	"github.com/trugamr/wol/tailscale"
+)
+
+// tailnet holds the nodes of the tailnet as of the last sync, nil if
+// Tailscale isn't enabled
+var tailnet *tailnetNodes
+
+func init() {
+	probe.Register("tailscale", newTailscaleProber)
+}
+
+// tailnetNodes holds the nodes of the tailnet by lowercase hostname and
+// MagicDNS name
+type tailnetNodes struct {
+	client   *tailscale.Client
+	interval time.Duration
+
+	mu       sync.Mutex
+	nodes    map[string]tailscale.Node
+	syncedAt time.Time
+}
+
+// setupTailscale syncs the nodes of the tailnet if Tailscale is enabled
+func setupTailscale() error {
+	c := cfg.Tailscale
+	if !c.Enabled {
+		return nil
+	}
+	if c.Interval <= 0 {
+		return errors.New("invalid tailscale settings: interval must be positive")
+	}
+
+	client := tailscale.NewLocal(c.Socket, deviceSyncTimeout)
+	if c.APIKey != "" {
+		var err error
+		client, err = tailscale.NewAPI(c.APIKey, c.Tailnet, deviceSyncTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid tailscale settings: %w", err)
+		}
+	}
+	tailnet = &tailnetNodes{client: client, interval: c.Interval}
+	// Machines are mapped to their nodes before the first status check
+	tailnet.sync()
+	return nil
+}
+
+// runTailscale syncs the nodes in the background until stop is closed
+func runTailscale(stop <-chan struct{}) {
+	if tailnet == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(tailnet.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				tailnet.sync()
+			}
+		}
+	}()
+}
+
+// sync fetches the nodes, keeping the previous ones if that fails
+func (t *tailnetNodes) sync() {
+	ctx, cancel := context.WithTimeout(context.Background(), deviceSyncTimeout)
+	defer cancel()
+
+	list, err := t.client.Nodes(ctx)
+	if err != nil {
+		log.Printf("Error syncing nodes from Tailscale: %v", err)
+		return
+	}
+	nodes := make(map[string]tailscale.Node, 2*len(list))
+	for _, node := range list {
+		nodes[strings.ToLower(node.Name)] = node
+		if node.DNSName != "" {
+			nodes[strings.ToLower(node.DNSName)] = node
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nodes = nodes
+	t.syncedAt = time.Now()
+}
+
+// node returns the node with the hostname or MagicDNS name, fresh is false
+// if the nodes weren't synced recently enough to tell whether it is online
+func (t *tailnetNodes) node(name string) (node tailscale.Node, ok, fresh bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node, ok = t.nodes[strings.ToLower(strings.TrimSuffix(name, "."))]
+	return node, ok, time.Since(t.syncedAt) < 3*t.interval
+}
+
+// tailscaleAddress returns the Tailscale IP of the machine's node, false if
+// the machine isn't mapped to a known node
+func tailscaleAddress(machine config.Machine) (string, bool) {
+	if tailnet == nil || machine.Tailscale == "" {
+		return "", false
+	}
+	node, ok, _ := tailnet.node(machine.Tailscale)
+	if !ok || len(node.IPs) == 0 {
+		return "", false
+	}
+	return node.IPs[0], true
+}
+
+// preferTailscaleAddress returns the Tailscale IP of the machine if it is
+// used instead of the configured IP, see tailscale.prefer_ip
+func preferTailscaleAddress(machine config.Machine) (string, bool) {
+	if !cfg.Tailscale.PreferIP && machine.IP != nil {
+		return "", false
+	}
+	return tailscaleAddress(machine)
+}
+
+// tailscaleProber checks whether the machine's node is connected to the
+// tailnet instead of contacting it
+type tailscaleProber struct {
+	node string
+}
+
+func newTailscaleProber(config probe.Config) (probe.Prober, error) {
+	if tailnet == nil {
+		return nil, errors.New("tailscale.enabled is required")
+	}
+	machine, ok := findMachine(config.Name)
+	if !ok || machine.Tailscale == "" {
+		return nil, errors.New("the machine's tailscale node is required")
+	}
+	return &tailscaleProber{node: machine.Tailscale}, nil
+}
+
+func (p *tailscaleProber) Probe(ctx context.Context) (probe.Result, error) {
+	node, ok, fresh := tailnet.node(p.node)
+	if !fresh {
+		return probe.Result{}, errors.New("no recent nodes from Tailscale")
+	}
+	if !ok {
+		return probe.Result{}, fmt.Errorf("node %s is not in the tailnet", p.node)
+	}
+	return probe.Result{Online: node.Online}, nil
+}
@@ -0,0 +1,98 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/trugamr/wol/preferences"
+)
+
+const (
+	preferencesFilename = "preferences.json"
+	themeCookieName     = "theme"
+)
+
+// themes lists the themes users can pick, auto follows the browser's setting
+var themes = []string{"auto", "light", "dark"}
+
+// accentColorPattern matches colors accepted as server.accent_color
+var accentColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// preferenceStore holds the preferences of logged in users
+var preferenceStore *preferences.Store
+
+// setupTheme checks the theme settings and opens the preferences of users
+func setupTheme() error {
+	if !slices.Contains(themes, cfg.Server.Theme) {
+		return fmt.Errorf("unknown server.theme %q, must be auto, light or dark", cfg.Server.Theme)
+	}
+	if cfg.Server.AccentColor != "" && !accentColorPattern.MatchString(cfg.Server.AccentColor) {
+		return fmt.Errorf("invalid server.accent_color %q, must be a hex color such as #16a34a", cfg.Server.AccentColor)
+	}
+	preferenceStore = preferences.NewStore(filepath.Join(cfg.DataDir, preferencesFilename))
+	return nil
+}
+
+// requestTheme returns the theme picked by the logged in user, or else the
+// one stored in a cookie by the browser, or else the configured default
+func requestTheme(r *http.Request) string {
+	if user := interactiveUser(r); user != "" && preferenceStore != nil {
+		prefs, err := preferenceStore.Get(user)
+		if err != nil {
+			log.Printf("Error reading preferences: %v", err)
+		} else if prefs.Theme != "" {
+			return prefs.Theme
+		}
+	}
+	cookie, err := r.Cookie(themeCookieName)
+	if err == nil && slices.Contains(themes, cookie.Value) {
+		return cookie.Value
+	}
+	return cfg.Server.Theme
+}
+
+// handleTheme stores the theme picked by the user and returns to the page
+// the form was submitted from, it's public so the login page can switch too
+func handleTheme(w http.ResponseWriter, r *http.Request) {
+	theme := r.PostFormValue("theme")
+	if !slices.Contains(themes, theme) {
+		http.Error(w, "Bad Request: theme must be auto, light or dark", http.StatusBadRequest)
+		return
+	}
+
+	// Logged in users get the theme on every browser they use
+	if !cfg.Auth.Disabled {
+		p, ok := authenticate(r)
+		if ok && (p.SessionID != "" || p.Proxy) {
+			prefs, err := preferenceStore.Get(p.Username)
+			if err == nil {
+				prefs.Theme = theme
+				err = preferenceStore.Set(p.Username, prefs)
+			}
+			if err != nil {
+				log.Printf("Error saving preferences: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// The cookie keeps the theme after logging out and without authentication
+	http.SetCookie(w, &http.Cookie{
+		Name:     themeCookieName,
+		Value:    theme,
+		Path:     appURL("/"),
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, appURL(safeRedirect(r.PostFormValue("next"))), http.StatusSeeOther)
+}
@@ -0,0 +1,114 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval limits how often the certificate files are checked for changes
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves a certificate loaded from files and reloads it when the
+// files change, e.g. after being renewed by certbot
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// newCertReloader loads the certificate and key from the files
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the certificate and key files, callers must hold the lock or own r
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.modTime = r.latestModTime()
+	return nil
+}
+
+// latestModTime returns the most recent modification time of the files
+func (r *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// GetCertificate returns the current certificate, reloading it if the files changed
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.checkedAt) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checkedAt = now
+
+	if r.latestModTime().Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	// Keep serving the old certificate if the new one can't be loaded, e.g.
+	// when only one of the files has been written yet
+	err := r.load()
+	if err != nil {
+		log.Printf("Error reloading certificate, keeping the previous one: %v", err)
+		return r.cert, nil
+	}
+	log.Printf("Reloaded certificate from %s", r.certFile)
+
+	return r.cert, nil
+}
+
+// serverTLSConfig returns the TLS config for the server or nil if TLS is not configured
+func serverTLSConfig() (*tls.Config, error) {
+	if len(cfg.Server.TLS.ACME.Domains) > 0 {
+		if cfg.Server.TLS.CertFile != "" || cfg.Server.TLS.KeyFile != "" {
+			return nil, fmt.Errorf("server.tls.acme can't be combined with certificate files")
+		}
+		return acmeTLSConfig()
+	}
+	if cfg.Server.TLS.CertFile == "" && cfg.Server.TLS.KeyFile == "" {
+		return nil, nil
+	}
+	if cfg.Server.TLS.CertFile == "" || cfg.Server.TLS.KeyFile == "" {
+		return nil, fmt.Errorf("both server.tls.cert_file and server.tls.key_file must be set")
+	}
+
+	reloader, err := newCertReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
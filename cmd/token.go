@@ -0,0 +1,109 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/trugamr/wol/auth"
+)
+
+const tokensFilename = "tokens.json"
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+
+	tokenCreateCmd.Flags().StringP("name", "n", "", "Name describing what the token is used for")
+	tokenCreateCmd.Flags().StringP("user", "u", "", "User the token acts as")
+	_ = tokenCreateCmd.MarkFlagRequired("name")
+	_ = tokenCreateCmd.MarkFlagRequired("user")
+}
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens",
+	Long:  "Create, list and revoke API tokens accepted as Authorization: Bearer by the web server",
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a new API token",
+	Long:  "Create a new API token for a configured user, the token is only shown once",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		name, _ := cmd.Flags().GetString("name")
+		username, _ := cmd.Flags().GetString("user")
+
+		if !userExists(username) {
+			cobra.CheckErr(fmt.Errorf("user %q not found in auth.users", username))
+		}
+
+		plaintext, token, err := newTokenStore().Create(name, auth.Identity{Username: username})
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Created token %s (%s), store it now as it won't be shown again\n", token.ID, token.Name)
+		fmt.Println(plaintext)
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Long:  "Show a list of all the API tokens without their secret values",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		list, err := newTokenStore().List()
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		if len(list) == 0 {
+			fmt.Println("No tokens created")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tName\tUser\tCreated")
+		for _, token := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", token.ID, token.Name, token.Username, token.CreatedAt.Format("2006-01-02 15:04"))
+		}
+		w.Flush()
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an API token",
+	Long:  "Revoke an API token so it is no longer accepted by the web server",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := newTokenStore().Revoke(args[0])
+		if err != nil {
+			cobra.CheckErr(err)
+		}
+		fmt.Printf("Revoked token %s\n", args[0])
+	},
+}
+
+// newTokenStore returns the token store located in the data directory
+func newTokenStore() *auth.Tokens {
+	return auth.NewTokens(filepath.Join(cfg.DataDir, tokensFilename))
+}
+
+// userExists reports whether a user with the username is configured
+func userExists(username string) bool {
+	for _, user := range cfg.Auth.Users {
+		if user.Username == username {
+			return true
+		}
+	}
+	return false
+}
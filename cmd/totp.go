@@ -0,0 +1,198 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/trugamr/wol/auth"
+	"rsc.io/qr"
+)
+
+const (
+	totpFilename        = "totp.json"
+	challengeCookieName = "login_challenge"
+)
+
+var (
+	// secondFactor manages TOTP enrollments of users
+	secondFactor *auth.TOTPStore
+	// challenges keeps track of logins waiting for a TOTP code
+	challenges *auth.Challenges
+)
+
+// requiresSecondFactor reports whether the user has to enter a TOTP code to log in,
+// errors are treated as enabled so a broken store doesn't skip the second factor
+func requiresSecondFactor(username string) bool {
+	enabled, err := secondFactor.Enabled(username)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
+		return true
+	}
+	return enabled
+}
+
+// startChallenge asks the user for a TOTP code after a valid password
+func startChallenge(w http.ResponseWriter, r *http.Request, identity auth.Identity, next string) {
+	id, err := challenges.Create(identity, next)
+	if err != nil {
+		log.Printf("Error creating login challenge: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookieName,
+		Value:    id,
+		Path:     appURL("/login"),
+		HttpOnly: true,
+		Secure:   secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	renderTemplate(w, r, "login_totp.html", map[string]interface{}{})
+}
+
+func handleLoginTOTP(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(challengeCookieName)
+	if err != nil {
+		http.Redirect(w, r, appURL("/login"), http.StatusSeeOther)
+		return
+	}
+
+	challenge, ok := challenges.Get(cookie.Value)
+	if !ok {
+		http.Redirect(w, r, appURL("/login"), http.StatusSeeOther)
+		return
+	}
+
+	if rateLimited(w, r) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		renderTemplate(w, r, "login_totp.html", map[string]interface{}{
+			"Error": "Too many failed attempts, wait a moment and try again",
+		})
+		return
+	}
+
+	username := challenge.Identity.Username
+	if !secondFactor.Verify(username, r.FormValue("code")) {
+		authFailed(r, "Failed two-factor login for user %q", username)
+		recordAuditAs(r, username, "login.totp", "", errors.New("invalid code"))
+		if !challenges.Fail(cookie.Value) {
+			// Too many wrong codes, start over with the password
+			http.Redirect(w, r, appURL("/login"), http.StatusSeeOther)
+			return
+		}
+
+		w.WriteHeader(http.StatusUnauthorized)
+		renderTemplate(w, r, "login_totp.html", map[string]interface{}{
+			"Error": "Invalid code",
+		})
+		return
+	}
+
+	recordAuditAs(r, username, "login.totp", "", nil)
+	challenges.Delete(cookie.Value)
+	http.SetCookie(w, &http.Cookie{Name: challengeCookieName, Value: "", Path: appURL("/login"), MaxAge: -1})
+
+	startSession(w, r, challenge.Identity, challenge.Next)
+}
+
+func handleTOTP(w http.ResponseWriter, r *http.Request) {
+	renderTOTP(w, r, nil, "")
+}
+
+// renderTOTP shows the two-factor settings of the user, recovery codes are
+// shown once after enabling
+func renderTOTP(w http.ResponseWriter, r *http.Request, recoveryCodes []string, message string) {
+	username := interactiveUser(r)
+
+	enabled, err := secondFactor.Enabled(username)
+	if err != nil {
+		log.Printf("Error checking two-factor authentication: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Enabled":       enabled,
+		"RecoveryCodes": recoveryCodes,
+		"CodesLeft":     secondFactor.RecoveryCodesLeft(username),
+		"Error":         message,
+		"FlashMessage":  consumeFlashMessage(w, r),
+	}
+
+	secret, uri, pending := secondFactor.Pending(username)
+	if pending {
+		image, err := qrDataURL(uri)
+		if err != nil {
+			log.Printf("Error rendering QR code: %v", err)
+		}
+		data["Secret"] = secret
+		data["QRCode"] = image
+	}
+
+	renderTemplate(w, r, "totp.html", data)
+}
+
+func handleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	_, _, err := secondFactor.Begin(interactiveUser(r))
+	if err != nil {
+		log.Printf("Error starting two-factor enrollment: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, appURL("/account/2fa"), http.StatusSeeOther)
+}
+
+func handleTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	username := interactiveUser(r)
+
+	codes, err := secondFactor.Confirm(username, r.FormValue("code"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTOTP(w, r, nil, "Invalid code, check the time on your device and try again")
+		return
+	}
+	log.Printf("User %q enabled two-factor authentication", username)
+	recordAudit(r, "2fa.enable", username, nil)
+
+	renderTOTP(w, r, codes, "")
+}
+
+func handleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	username := interactiveUser(r)
+
+	// Require a valid code so a hijacked session can't remove the second factor
+	if !secondFactor.Verify(username, r.FormValue("code")) {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTOTP(w, r, nil, "Invalid code")
+		return
+	}
+
+	err := secondFactor.Disable(username)
+	if err != nil {
+		log.Printf("Error disabling two-factor authentication: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %q disabled two-factor authentication", username)
+	recordAudit(r, "2fa.disable", username, nil)
+
+	setFlashMessage(w, "Two-factor authentication disabled.")
+	http.Redirect(w, r, appURL("/account/2fa"), http.StatusSeeOther)
+}
+
+// qrDataURL renders text as a QR code PNG embedded in a data URL
+func qrDataURL(text string) (template.URL, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())), nil
+}
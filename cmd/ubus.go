@@ -0,0 +1,173 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/ubus"
+)
+
+// ubusObject is the name of the object registered on ubus
+const ubusObject = "wol"
+
+// ubusUser is who wakes requested over ubus are recorded as
+const ubusUser = "ubus"
+
+// ubusMachine represents a machine in the replies of the list method
+type ubusMachine struct {
+	Name   string   `json:"name"`
+	Mac    string   `json:"mac"`
+	IP     *string  `json:"ip,omitempty"`
+	Group  string   `json:"group,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Status string   `json:"status"`
+}
+
+// ubusMethods are the methods of the object, callers aren't authenticated
+// beyond the ACLs of ubusd and rpcd
+var ubusMethods = map[string]ubus.Method{
+	"list": {Handler: handleUbusList},
+	"status": {
+		Args:    map[string]ubus.Type{"name": ubus.TypeString},
+		Handler: handleUbusStatus,
+	},
+	"wake": {
+		Args:    map[string]ubus.Type{"name": ubus.TypeString},
+		Handler: handleUbusWake,
+	},
+}
+
+// setupUbus registers the object on ubus in the background when running on
+// OpenWrt, registering again whenever the connection to ubusd is lost
+func setupUbus(stop <-chan struct{}) {
+	if cfg.Ubus.Disabled {
+		return
+	}
+	if _, err := os.Stat(cfg.Ubus.Socket); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	go func() {
+		for {
+			err := serveUbus(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error serving ubus, retrying in 10s: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(10 * time.Second):
+			}
+		}
+	}()
+}
+
+// serveUbus registers the object and runs the methods called until the
+// connection to ubusd is lost or ctx is done
+func serveUbus(ctx context.Context) error {
+	conn, err := ubus.Dial(cfg.Ubus.Socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.Register(ubusObject, ubusMethods)
+	if err != nil {
+		return err
+	}
+	log.Printf("Registered %s on ubus", ubusObject)
+	return conn.Serve(ctx)
+}
+
+// ubusMachineArg returns the machine named by the name argument
+func ubusMachineArg(args map[string]interface{}) (config.Machine, error) {
+	name, ok := args["name"].(string)
+	if !ok || name == "" {
+		return config.Machine{}, ubus.StatusInvalidArgument
+	}
+	machine, ok := findMachine(name)
+	if !ok {
+		return config.Machine{}, ubus.StatusNotFound
+	}
+	return machine, nil
+}
+
+func handleUbusList(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	machines := allMachines()
+	statuses, _ := poller.snapshot(machines)
+
+	list := make([]ubusMachine, 0, len(machines))
+	for _, machine := range machines {
+		status, ok := statuses[machine.Name]
+		if !ok {
+			status = "unknown"
+		}
+		list = append(list, ubusMachine{
+			Name:   machine.Name,
+			Mac:    machine.Mac,
+			IP:     machine.IP,
+			Group:  machine.Group,
+			Tags:   machine.Tags,
+			Status: status,
+		})
+	}
+	return map[string]interface{}{"machines": list}, nil
+}
+
+// handleUbusStatus replies with the status of the machine given with name,
+// or of all machines without it
+func handleUbusStatus(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	machines := allMachines()
+	_, single := args["name"]
+	if single {
+		machine, err := ubusMachineArg(args)
+		if err != nil {
+			return nil, err
+		}
+		machines = []config.Machine{machine}
+	}
+
+	statuses := poller.current(machines)
+	checked := poller.checked(machines)
+	response := make([]apiMachineStatus, 0, len(machines))
+	for _, machine := range machines {
+		checkedAt, ok := checked[machine.Name]
+		response = append(response, newAPIMachineStatus(machine.Name, statuses[machine.Name], checkedAt, ok))
+	}
+	if single {
+		return response[0], nil
+	}
+	return map[string]interface{}{"machines": response}, nil
+}
+
+func handleUbusWake(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	machine, err := ubusMachineArg(args)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := tracer.Start(ctx, "ubus.wake")
+	defer span.End()
+
+	err = wakeAsService(ctx, ubusUser, machine)
+	if errors.Is(err, errReadOnly) {
+		log.Printf("Ignoring ubus wake of %s: %s", machine.Name, readOnlyReason())
+		return nil, ubus.StatusPermissionDenied
+	}
+	if err != nil {
+		log.Printf("Error waking machine %s over ubus: %v", machine.Name, err)
+		return nil, err
+	}
+	return apiWakeResult{Name: machine.Name, Sent: true}, nil
+}
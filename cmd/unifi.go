@@ -0,0 +1,61 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trugamr/wol/probe"
+	"github.com/trugamr/wol/unifi"
+)
+
+// unifiSource syncs the clients of the UniFi controller while serving, nil
+// if none is configured
+var unifiSource *deviceSource
+
+func init() {
+	probe.Register("unifi", func(config probe.Config) (probe.Prober, error) {
+		return newSourceProber(unifiSource, "unifi.url", config)
+	})
+}
+
+// setupUniFi adds the UniFi controller as a device source if one is configured
+func setupUniFi() error {
+	c := cfg.UniFi
+	if c.URL == "" {
+		return nil
+	}
+	client, err := unifi.New(unifi.Config{
+		URL:                c.URL,
+		Site:               c.Site,
+		Username:           c.Username,
+		Password:           c.Password,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		Timeout:            deviceSyncTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("invalid unifi settings: %w", err)
+	}
+
+	// Only clients connected right now are listed
+	unifiSource, err = addDeviceSource("UniFi controller", c.Interval, func(ctx context.Context) ([]networkDevice, error) {
+		stations, err := client.Stations(ctx)
+		if err != nil {
+			return nil, err
+		}
+		devices := make([]networkDevice, 0, len(stations))
+		for _, station := range stations {
+			hostname := station.Name
+			if hostname == "" {
+				hostname = station.Hostname
+			}
+			devices = append(devices, networkDevice{MAC: station.MAC, IP: station.IP, Hostname: hostname, Online: true})
+		}
+		return devices, nil
+	})
+	if err != nil {
+		return fmt.Errorf("invalid unifi settings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/trugamr/wol/history"
+)
+
+// bootTimeout is how long after a wake a machine coming online counts as booted by it
+const bootTimeout = 10 * time.Minute
+
+// Height and width of the bars of availability charts in SVG units
+const (
+	sparkHeight   = 24
+	sparkBarWidth = 4
+)
+
+// availabilityPeriods are the periods availability is shown for on the machine page
+var availabilityPeriods = []struct {
+	label  string
+	length time.Duration
+	slots  int
+}{
+	{"24 hours", 24 * time.Hour, 48},
+	{"7 days", 7 * 24 * time.Hour, 42},
+}
+
+// sparkBar is a bar of an availability chart
+type sparkBar struct {
+	X, Y, Height float64
+	Known        bool
+	// Down is set when the machine was offline for the whole slot
+	Down  bool
+	Title string
+}
+
+// availabilityView is the uptime of a machine over a period
+type availabilityView struct {
+	Label string
+	// Uptime is the percentage of time the machine was online, when Known
+	Uptime float64
+	Known  bool
+	Width  int
+	Bars   []sparkBar
+}
+
+// uptimeView contains the statistics shown on the machine page
+type uptimeView struct {
+	Periods []availabilityView
+	// AverageBoot is how long the machine took to come online after a wake on
+	// average, over Boots wakes
+	AverageBoot time.Duration
+	Boots       int
+}
+
+// uptimeStats computes the statistics from the wakes and the transitions
+// since the start of the longest period, oldest first
+func uptimeStats(wakes []history.Wake, transitions []history.Transition, now time.Time) uptimeView {
+	var view uptimeView
+	for _, period := range availabilityPeriods {
+		from := now.Add(-period.length)
+		uptime, known := history.Uptime(transitions, from, now)
+		availability := availabilityView{
+			Label:  period.label,
+			Uptime: uptime * 100,
+			Known:  known,
+			Width:  period.slots * sparkBarWidth,
+		}
+
+		length := period.length / time.Duration(period.slots)
+		for i, slot := range history.Slots(transitions, from, now, period.slots) {
+			bar := sparkBar{
+				X:      float64(i * sparkBarWidth),
+				Height: 2,
+				Known:  slot.Known,
+				Title:  slot.Start.Format("2006-01-02 15:04") + " - " + slot.Start.Add(length).Format("15:04") + ": unknown",
+			}
+			if slot.Known {
+				bar.Height = max(2, slot.Uptime*sparkHeight)
+				bar.Down = slot.Uptime == 0
+				bar.Title = fmt.Sprintf("%s - %s: %.1f%%", slot.Start.Format("2006-01-02 15:04"), slot.Start.Add(length).Format("15:04"), slot.Uptime*100)
+			}
+			bar.Y = sparkHeight - bar.Height
+			availability.Bars = append(availability.Bars, bar)
+		}
+		view.Periods = append(view.Periods, availability)
+	}
+
+	boots := history.BootTimes(wakes, transitions, bootTimeout)
+	var total time.Duration
+	for _, boot := range boots {
+		total += boot
+	}
+	if len(boots) > 0 {
+		view.AverageBoot = (total / time.Duration(len(boots))).Round(time.Second)
+		view.Boots = len(boots)
+	}
+	return view
+}
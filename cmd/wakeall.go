@@ -0,0 +1,117 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/i18n"
+)
+
+// wakeResult is the outcome of waking a single machine of many
+type wakeResult struct {
+	Machine config.Machine
+	Err     error
+}
+
+// wakeMachinesAs wakes the machines on behalf of the user making the request,
+// they are woken concurrently as some wake methods take a while
+func wakeMachinesAs(r *http.Request, machines []config.Machine) []wakeResult {
+	results := make([]wakeResult, len(machines))
+	var wg sync.WaitGroup
+	for i, machine := range machines {
+		wg.Add(1)
+		go func(i int, machine config.Machine) {
+			defer wg.Done()
+			woken, err := wakeAs(r, machine.Name)
+			results[i] = wakeResult{Machine: woken, Err: err}
+		}(i, machine)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// wakeResultsMessage summarizes the results as a flash message
+func wakeResultsMessage(tr i18n.Translator, results []wakeResult) string {
+	if len(results) == 0 {
+		return tr.T("No machines to wake.")
+	}
+
+	var woken, failed []string
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", result.Machine.Name, result.Err))
+		} else {
+			woken = append(woken, result.Machine.Name)
+		}
+	}
+
+	if len(woken) == 0 {
+		return tr.T("Failed to wake %s.", strings.Join(failed, ", "))
+	}
+	message := tr.T("Wake-up signal sent to %s.", strings.Join(woken, ", "))
+	if len(failed) > 0 {
+		message += " " + tr.T("Failed to wake %s.", strings.Join(failed, ", "))
+	}
+	return message
+}
+
+// canWakeAll reports whether the user making the request may wake all machines at once
+func canWakeAll(r *http.Request) bool {
+	return requestPermissions(r).HasRole(wakeAllRole)
+}
+
+// wakeAllAs wakes every machine the user making the request is allowed to
+// wake, or only the ones reported offline if offlineOnly is set
+func wakeAllAs(r *http.Request, offlineOnly bool) ([]wakeResult, error) {
+	if readOnly() {
+		return nil, errReadOnly
+	}
+	if !canWakeAll(r) {
+		return nil, errPermission
+	}
+
+	permissions := requestPermissions(r)
+	var machines []config.Machine
+	for _, machine := range visibleMachines(r) {
+		if permissions.CanWake(machine.Name, machine.Group) {
+			machines = append(machines, machine)
+		}
+	}
+
+	if offlineOnly {
+		statuses := poller.current(machines)
+		offline := machines[:0]
+		for _, machine := range machines {
+			if statuses[machine.Name] == "offline" {
+				offline = append(offline, machine)
+			}
+		}
+		machines = offline
+	}
+
+	return wakeMachinesAs(r, machines), nil
+}
+
+func handleWakeAll(w http.ResponseWriter, r *http.Request) {
+	offlineOnly := r.FormValue("offline") != ""
+
+	results, err := wakeAllAs(r, offlineOnly)
+	switch {
+	case errors.Is(err, errReadOnly):
+		http.Error(w, readOnlyReason()+", waking machines is disabled", http.StatusForbidden)
+		return
+	case errors.Is(err, errPermission):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	setFlashMessage(w, wakeResultsMessage(requestTranslator(r), results))
+	http.Redirect(w, r, appURL("/"), http.StatusSeeOther)
+}
@@ -0,0 +1,53 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+)
+
+// statusWaking is reported for woken machines until they come online or the
+// wake timed out
+const statusWaking = "waking"
+
+// wakeTracker overrides the probed status of machines that were woken
+type wakeTracker struct {
+	mu    sync.Mutex
+	wakes map[string]time.Time
+}
+
+var wakeProgress = &wakeTracker{wakes: make(map[string]time.Time)}
+
+// start tracks the wake of the machine if its status can be checked and asks
+// for the status right away so clients see the progress
+func (t *wakeTracker) start(machine config.Machine) {
+	if !checkable(machine) {
+		return
+	}
+
+	t.mu.Lock()
+	t.wakes[machine.Name] = time.Now()
+	t.mu.Unlock()
+
+	if poller != nil {
+		poller.triggerRefresh()
+	}
+}
+
+// apply replaces the probed statuses of woken machines, a wake is done once
+// the machine is online or after the wake timeout
+func (t *wakeTracker) apply(statuses map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for name, startedAt := range t.wakes {
+		if statuses[name] == "online" || time.Since(startedAt) > cfg.Server.WakeTimeout {
+			delete(t.wakes, name)
+			continue
+		}
+		statuses[name] = statusWaking
+	}
+}
@@ -0,0 +1,155 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/webhook"
+)
+
+// webhookAttempts is how often sending an event is tried before giving up
+const webhookAttempts = 3
+
+// webhooks are notified of wakes and status changes while serving
+var webhooks []*webhook.Hook
+
+// setupWebhooks checks the webhooks of the config file
+func setupWebhooks() error {
+	for i, c := range cfg.Webhooks {
+		hook, err := webhook.New(webhook.Config{
+			URL:         c.URL,
+			Events:      c.Events,
+			Secret:      c.Secret,
+			Payload:     c.Payload,
+			ContentType: c.ContentType,
+			Headers:     c.Headers,
+			Timeout:     c.Timeout,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid webhook %d: %w", i+1, err)
+		}
+		webhooks = append(webhooks, hook)
+	}
+	return nil
+}
+
+// sendWebhooks sends the event to the webhooks that want it in the background
+func sendWebhooks(event webhook.Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, hook := range webhooks {
+		if hook.Wants(event.Event) {
+			go deliverWebhook(hook, event)
+		}
+	}
+}
+
+// deliverWebhook sends the event to the webhook, retrying with a growing delay
+func deliverWebhook(hook *webhook.Hook, event webhook.Event) {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := hook.Send(context.Background(), event)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			log.Printf("Error sending %s event of %s to webhook on %s: %v", event.Event, event.Machine, hook.Host(), err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// pendingWake is a wake waiting for the machine to come online
+type pendingWake struct {
+	user     string
+	deadline time.Time
+}
+
+// wakeVerifier keeps track of woken machines until they come online or time out
+type wakeVerifier struct {
+	mu      sync.Mutex
+	pending map[string]pendingWake
+}
+
+var wakeChecks = &wakeVerifier{pending: make(map[string]pendingWake)}
+
+// expect starts waiting for the machine to come online
+func (v *wakeVerifier) expect(name, user string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.pending[name] = pendingWake{user: user, deadline: time.Now().Add(cfg.Server.WakeTimeout)}
+}
+
+// check sends events for woken machines that are online or timed out
+func (v *wakeVerifier) check(statuses map[string]string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	for name, wake := range v.pending {
+		event := webhook.Event{Machine: name, User: wake.user}
+		switch {
+		case statuses[name] == "online":
+			event.Event = webhook.EventWakeSucceeded
+		case now.After(wake.deadline):
+			event.Event = webhook.EventWakeFailed
+			event.Error = fmt.Sprintf("not online after %s", cfg.Server.WakeTimeout)
+		default:
+			continue
+		}
+		delete(v.pending, name)
+		sendEvent(event)
+	}
+}
+
+// sendEvent sends the event to the webhooks and notification services
+func sendEvent(event webhook.Event) {
+	sendWebhooks(event)
+	sendNotifications(event)
+}
+
+// notifyWake tells the webhooks and notification services about an attempt
+// to wake the machine, machines with an IP are watched to tell whether the
+// wake succeeded
+func notifyWake(user string, machine config.Machine, err error) {
+	if len(webhooks) == 0 && len(notifiers) == 0 {
+		return
+	}
+
+	event := webhook.Event{Event: webhook.EventWake, Machine: machine.Name, User: user}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	sendEvent(event)
+
+	if err == nil && checkable(machine) {
+		wakeChecks.expect(machine.Name, user)
+	}
+}
+
+// notifyStatusChanges tells the webhooks and notification services about the
+// changes of a sweep and whether woken machines came online
+func notifyStatusChanges(statuses map[string]string, changes []statusChange) {
+	if len(webhooks) == 0 && len(notifiers) == 0 {
+		return
+	}
+
+	for _, change := range changes {
+		sendEvent(webhook.Event{
+			Event:          webhook.EventStatus,
+			Machine:        change.Name,
+			Status:         change.Status,
+			PreviousStatus: change.Previous,
+		})
+	}
+	wakeChecks.check(statuses)
+}
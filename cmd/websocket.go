@@ -0,0 +1,152 @@
+//go:build !noserve
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsMessage is exchanged in both directions over the status WebSocket
+//
+// Clients send {"type": "refresh"} to get the status right away and
+// {"type": "wake", "name": "desktop"} to wake a machine. The server sends
+// {"type": "status", "statuses": {...}, "checked_at": {...}} with the same
+// statuses as the event stream and when they were checked,
+// {"type": "wake", "name": "desktop"} once a machine was woken and
+// {"type": "error", "error": "..."} if a request failed.
+type wsMessage struct {
+	Type      string               `json:"type"`
+	Name      string               `json:"name,omitempty"`
+	Statuses  map[string]string    `json:"statuses,omitempty"`
+	CheckedAt map[string]time.Time `json:"checked_at,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+func handleWebSocketStatus(w http.ResponseWriter, r *http.Request) {
+	err := checkWebSocketOrigin(r)
+	if err != nil {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	// The origin has been checked already
+	server := websocket.Server{Handler: serveStatusSocket}
+	server.ServeHTTP(w, r)
+}
+
+// checkWebSocketOrigin prevents other websites from opening a socket with the
+// credentials of the user, browsers don't apply the same-origin policy to them
+func checkWebSocketOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	if allowed := corsOrigin(origin); allowed != "" && allowed != "*" {
+		return nil
+	}
+
+	log.Printf("Rejected WebSocket from origin %s", origin)
+	return fmt.Errorf("origin %s not allowed", origin)
+}
+
+// serveStatusSocket sends status updates periodically and handles requests from the client
+func serveStatusSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	r := ws.Request()
+	// The read timeout of the server still applies to the taken over connection
+	ws.SetReadDeadline(time.Time{})
+
+	// Requests are read in the background so the socket is only written from here
+	requests := make(chan wsMessage)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var msg wsMessage
+			err := websocket.JSON.Receive(ws, &msg)
+			if err != nil {
+				return
+			}
+			select {
+			case requests <- msg:
+			case <-shuttingDown:
+				return
+			}
+		}
+	}()
+
+	send := func(msg wsMessage) bool {
+		err := websocket.JSON.Send(ws, msg)
+		if err != nil {
+			log.Printf("Error writing status: %v", err)
+			return false
+		}
+		return true
+	}
+
+	// Sends the current status of the machines visible to the user
+	machines := visibleMachines(r)
+	sendMachinesStatus := func() bool {
+		current, _ := poller.snapshot(machines)
+		return send(wsMessage{Type: "status", Statuses: current, CheckedAt: poller.checked(machines)})
+	}
+
+	// The shared poller notifies about every sweep
+	updates, unsubscribe := poller.subscribe(machines)
+	defer unsubscribe()
+
+	for {
+		var ok bool
+		select {
+		case <-done:
+			return
+		case <-shuttingDown:
+			return
+		case <-updates:
+			ok = sendMachinesStatus()
+		case msg := <-requests:
+			ok = send(handleSocketRequest(r, msg))
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// handleSocketRequest handles a request received over the status WebSocket
+func handleSocketRequest(r *http.Request, msg wsMessage) wsMessage {
+	switch msg.Type {
+	case "refresh":
+		// The statuses are sent once the sweep is done
+		poller.triggerRefresh()
+		return wsMessage{Type: "refresh"}
+	case "wake":
+		machine, err := wakeAs(r, msg.Name)
+		switch {
+		case errors.Is(err, errMachineNotFound):
+			return wsMessage{Type: "error", Name: msg.Name, Error: "Machine not found"}
+		case errors.Is(err, errReadOnly):
+			return wsMessage{Type: "error", Name: machine.Name, Error: readOnlyReason() + ", waking machines is disabled"}
+		case errors.Is(err, errPermission):
+			return wsMessage{Type: "error", Name: machine.Name, Error: "Not allowed to wake this machine"}
+		case err != nil:
+			return wsMessage{Type: "error", Name: machine.Name, Error: err.Error()}
+		}
+		return wsMessage{Type: "wake", Name: machine.Name}
+	default:
+		return wsMessage{Type: "error", Error: fmt.Sprintf("unknown request type %q", msg.Type)}
+	}
+}
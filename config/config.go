@@ -1,55 +1,1063 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/knadh/koanf/maps"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/providers/structs"
 	"github.com/knadh/koanf/v2"
+	"github.com/robfig/cron/v3"
 )
 
 const (
 	koanfDelimiter = "."
 	koanfTag       = "koanf"
 	configFilename = "config.yaml"
+	// envPrefix is the prefix of environment variables holding settings
+	envPrefix = "WOL_"
+	// envDelimiter separates the keys of nested settings in environment
+	// variable names, as single underscores are part of keys
+	envDelimiter = "__"
 )
 
+// envReserved are environment variables with the prefix that aren't settings
+var envReserved = map[string]bool{
+	"WOL_CONFIG": true,
+	// Used by the client commands
+	"WOL_SERVER": true,
+	"WOL_TOKEN":  true,
+}
+
 var k = koanf.New(koanfDelimiter)
 
 // Machine represents a machine to wake up
 type Machine struct {
 	// Name of the machine
-	Name string `koanf:"name"`
-	// MAC address of the machine
-	Mac string `koanf:"mac"`
+	Name string `koanf:"name" json:"name"`
+	// Type of the machine, empty for machines woken by magic packets, proxmox
+	// for virtual machines started through the Proxmox API, libvirt for
+	// libvirt domains, container for Docker and Podman containers, ipmi or
+	// redfish for servers powered on by their BMC or amt for computers with
+	// Intel AMT
+	Type string `koanf:"type" json:"type,omitempty"`
+	// MAC address of the machine, optional for machines with a type
+	Mac string `koanf:"mac" json:"mac"`
 	// Hostname or IP address of the machine (optional)
-	IP *string `koanf:"ip"`
+	IP *string `koanf:"ip" json:"ip,omitempty"`
+	// Group the machine belongs to (optional)
+	Group string `koanf:"group" json:"group,omitempty"`
+	// Tags are free-form labels used to filter machines (optional)
+	Tags []string `koanf:"tags" json:"tags,omitempty"`
+	// SSH enables shutting down and rebooting the machine (optional)
+	SSH *MachineSSH `koanf:"ssh" json:"ssh,omitempty"`
+	// Check replaces ping as the way the status of the machine is checked (optional)
+	Check *Check `koanf:"check" json:"check,omitempty"`
+	// Tailscale is the hostname or MagicDNS name of the machine's node in the
+	// tailnet (optional)
+	Tailscale string `koanf:"tailscale" json:"tailscale,omitempty"`
+	// Proxmox represents the virtual machine of proxmox machines
+	Proxmox *MachineProxmox `koanf:"proxmox" json:"proxmox,omitempty"`
+	// Libvirt represents the domain of libvirt machines
+	Libvirt *MachineLibvirt `koanf:"libvirt" json:"libvirt,omitempty"`
+	// Container represents the container of container machines
+	Container *MachineContainer `koanf:"container" json:"container,omitempty"`
+	// IPMI represents the BMC of ipmi machines
+	IPMI *MachineIPMI `koanf:"ipmi" json:"ipmi,omitempty"`
+	// Redfish represents the BMC of redfish machines
+	Redfish *MachineRedfish `koanf:"redfish" json:"redfish,omitempty"`
+	// AMT represents Intel AMT of amt machines
+	AMT *MachineAMT `koanf:"amt" json:"amt,omitempty"`
+	// ConfirmWake asks before waking the machine from the web interface,
+	// server.confirm_wake applies if unset (optional)
+	ConfirmWake *bool `koanf:"confirm_wake" json:"confirm_wake,omitempty"`
+	// ARPProxy answers ARP requests for the machine while it sleeps and wakes
+	// it when a host tries to reach it, needs arp_proxy and an IP address (optional)
+	ARPProxy bool `koanf:"arp_proxy" json:"arp_proxy,omitempty"`
+	// DNSNames wake the machine when looked up through the DNS forwarder,
+	// e.g. nas.home.arpa, as does a hostname configured as its IP (optional)
+	DNSNames []string `koanf:"dns_names" json:"dns_names,omitempty"`
+	// Peer is the name of the peer server the machine belongs to, only set
+	// for machines of peers
+	Peer string `koanf:"-" json:"-"`
+}
+
+// MachineSSH represents how to log in to a machine to shut it down or reboot it
+type MachineSSH struct {
+	// User to log in as
+	User string `koanf:"user" json:"user"`
+	// Port of the SSH server, defaults to 22
+	Port int `koanf:"port" json:"port,omitempty"`
+	// KeyFile is the path of the private key used to log in, optional with Agent
+	KeyFile string `koanf:"key_file" json:"key_file,omitempty"`
+	// Agent logs in with the keys of the SSH agent at SSH_AUTH_SOCK
+	Agent bool `koanf:"agent" json:"agent,omitempty"`
+	// Become runs the commands as root with sudo or doas, which must not ask
+	// for a password (optional)
+	Become string `koanf:"become" json:"become,omitempty"`
+	// ShutdownCommand powers off the machine, defaults to "shutdown -h now"
+	ShutdownCommand string `koanf:"shutdown_command" json:"shutdown_command,omitempty"`
+	// RebootCommand reboots the machine, defaults to "reboot"
+	RebootCommand string `koanf:"reboot_command" json:"reboot_command,omitempty"`
+}
+
+// Machine types
+const (
+	MachineTypeProxmox   = "proxmox"
+	MachineTypeLibvirt   = "libvirt"
+	MachineTypeContainer = "container"
+	MachineTypeIPMI      = "ipmi"
+	MachineTypeRedfish   = "redfish"
+	MachineTypeAMT       = "amt"
+)
+
+// MachineProxmox represents a virtual machine on a Proxmox VE node
+type MachineProxmox struct {
+	// URL of the API, e.g. https://pve.lan:8006
+	URL string `koanf:"url" json:"url"`
+	// Node the virtual machine runs on
+	Node string `koanf:"node" json:"node"`
+	// VMID of the virtual machine
+	VMID int `koanf:"vmid" json:"vmid"`
+	// TokenID of the API token, e.g. root@pam!wol
+	TokenID string `koanf:"token_id" json:"token_id"`
+	// TokenSecret is the secret of the API token
+	TokenSecret string `koanf:"token_secret" json:"token_secret"`
+	// InsecureSkipVerify accepts the self-signed certificate of the node
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+// MachineLibvirt represents a libvirt domain, e.g. a KVM virtual machine
+type MachineLibvirt struct {
+	// URI of the connection, defaults to qemu:///system
+	URI string `koanf:"uri" json:"uri,omitempty"`
+	// Domain is the name of the domain
+	Domain string `koanf:"domain" json:"domain"`
+}
+
+// MachineContainer represents a Docker or Podman container
+type MachineContainer struct {
+	// Endpoint of the API, unix:///path or tcp://host:port, defaults to
+	// unix:///var/run/docker.sock
+	Endpoint string `koanf:"endpoint" json:"endpoint,omitempty"`
+	// Name or ID of the container
+	Name string `koanf:"name" json:"name"`
+}
+
+// MachineIPMI represents the baseboard management controller of a server
+type MachineIPMI struct {
+	// Address of the BMC, a hostname or IP address
+	Address string `koanf:"address" json:"address"`
+	// Username of a BMC account allowed to control power
+	Username string `koanf:"username" json:"username"`
+	// Password of the account
+	Password string `koanf:"password" json:"password"`
+}
+
+// MachineRedfish represents a BMC with a Redfish API, e.g. iDRAC or iLO
+type MachineRedfish struct {
+	// URL of the BMC, e.g. https://idrac.lan
+	URL string `koanf:"url" json:"url"`
+	// System is the path of the computer system, only needed if the BMC
+	// manages more than one (optional)
+	System string `koanf:"system" json:"system,omitempty"`
+	// Username of a BMC account allowed to control power
+	Username string `koanf:"username" json:"username"`
+	// Password of the account
+	Password string `koanf:"password" json:"password"`
+	// InsecureSkipVerify accepts the self-signed certificate of the BMC
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+// MachineAMT represents Intel AMT (vPro) of a computer
+type MachineAMT struct {
+	// Address of the computer, defaults to the machine's IP
+	Address string `koanf:"address" json:"address,omitempty"`
+	// Username of an AMT account, defaults to admin
+	Username string `koanf:"username" json:"username,omitempty"`
+	// Password of the account
+	Password string `koanf:"password" json:"password"`
+	// TLS connects to port 16993 instead of 16992
+	TLS bool `koanf:"tls" json:"tls,omitempty"`
+	// InsecureSkipVerify accepts the self-signed certificate of AMT
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+}
+
+// Check represents how the status of a machine is checked
+type Check struct {
+	// Type of the check: ping, tcp, http, arp, ssh, snmp, exec, netbios, unifi, firewall or tailscale, defaults to ping
+	Type string `koanf:"type" json:"type,omitempty"`
+	// Address checked instead of the machine's IP, may include a port (optional)
+	Address string `koanf:"address" json:"address,omitempty"`
+	// Port connected to by tcp, ssh and snmp checks if the address has none
+	Port int `koanf:"port" json:"port,omitempty"`
+	// Timeout of a single check, defaults to 2s
+	Timeout time.Duration `koanf:"timeout" json:"timeout,omitempty"`
+	// URL requested by http checks
+	URL string `koanf:"url" json:"url,omitempty"`
+	// Status the response of http checks must have, any 2xx status if unset
+	Status int `koanf:"status" json:"status,omitempty"`
+	// Contains is text the response of http checks must contain (optional)
+	Contains string `koanf:"contains" json:"contains,omitempty"`
+	// InsecureSkipVerify accepts any certificate for https URLs
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify" json:"insecure_skip_verify,omitempty"`
+	// Login makes ssh checks log in with the machine's SSH settings instead of
+	// only waiting for the server's version
+	Login bool `koanf:"login" json:"login,omitempty"`
+	// SNMP configures snmp checks
+	SNMP *CheckSNMP `koanf:"snmp" json:"snmp,omitempty"`
+	// Command run by exec checks, the program followed by its arguments. It
+	// runs without a shell, exit code 0 means online and 1 offline
+	Command []string `koanf:"command" json:"command,omitempty"`
+	// Refresh makes arp checks send a packet first so the neighbor table is
+	// updated instead of relying on cached entries
+	Refresh bool `koanf:"refresh" json:"refresh,omitempty"`
+	// OnlineAfter is how many online results in a row it takes for the machine
+	// to be shown online, server.online_after applies if unset
+	OnlineAfter int `koanf:"online_after" json:"online_after,omitempty"`
+	// OfflineAfter is how many offline results in a row it takes for the
+	// machine to be shown offline, server.offline_after applies if unset
+	OfflineAfter int `koanf:"offline_after" json:"offline_after,omitempty"`
+}
+
+// CheckSNMP represents the request of an snmp check
+type CheckSNMP struct {
+	// Version of the protocol: 1, 2c or 3, defaults to 2c
+	Version string `koanf:"version" json:"version,omitempty"`
+	// Community of version 1 and 2c requests, defaults to public
+	Community string `koanf:"community" json:"community,omitempty"`
+	// OID requested, defaults to sysUpTime (1.3.6.1.2.1.1.3.0)
+	OID string `koanf:"oid" json:"oid,omitempty"`
+	// Value the OID must have, any value if empty
+	Value string `koanf:"value" json:"value,omitempty"`
+	// Username of version 3 requests
+	Username string `koanf:"username" json:"username,omitempty"`
+	// AuthProtocol of version 3 requests, e.g. SHA256, no authentication if empty
+	AuthProtocol string `koanf:"auth_protocol" json:"auth_protocol,omitempty"`
+	// AuthPassword of version 3 requests
+	AuthPassword string `koanf:"auth_password" json:"auth_password,omitempty"`
+	// PrivacyProtocol of version 3 requests, e.g. AES, no encryption if empty
+	PrivacyProtocol string `koanf:"privacy_protocol" json:"privacy_protocol,omitempty"`
+	// PrivacyPassword of version 3 requests
+	PrivacyPassword string `koanf:"privacy_password" json:"privacy_password,omitempty"`
+}
+
+// Validate checks that the machine has a name and a valid MAC address, which
+// virtual machines may leave out
+func (m Machine) Validate() error {
+	if strings.TrimSpace(m.Name) == "" {
+		return errors.New("name is required")
+	}
+	if m.Name != strings.TrimSpace(m.Name) {
+		return errors.New("name must not start or end with spaces")
+	}
+	if strings.ContainsAny(m.Name, "/?#") {
+		return errors.New("name must not contain /, ? or #")
+	}
+	switch m.Type {
+	case "":
+	case MachineTypeProxmox:
+		p := m.Proxmox
+		if p == nil || p.URL == "" || p.Node == "" || p.TokenID == "" || p.TokenSecret == "" {
+			return errors.New("proxmox url, node, token_id and token_secret are required")
+		}
+		if p.VMID <= 0 {
+			return errors.New("proxmox vmid must be positive")
+		}
+	case MachineTypeLibvirt:
+		if m.Libvirt == nil || m.Libvirt.Domain == "" {
+			return errors.New("libvirt domain is required")
+		}
+	case MachineTypeContainer:
+		if m.Container == nil || m.Container.Name == "" {
+			return errors.New("container name is required")
+		}
+	case MachineTypeIPMI:
+		if m.IPMI == nil || m.IPMI.Address == "" || m.IPMI.Username == "" {
+			return errors.New("ipmi address and username are required")
+		}
+	case MachineTypeRedfish:
+		if m.Redfish == nil || m.Redfish.URL == "" || m.Redfish.Username == "" {
+			return errors.New("redfish url and username are required")
+		}
+	case MachineTypeAMT:
+		if m.AMT == nil || m.AMT.Password == "" {
+			return errors.New("amt password is required")
+		}
+		if m.AMT.Address == "" && m.IP == nil {
+			return errors.New("amt address or IP address is required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", m.Type)
+	}
+	if m.Type == "" || m.Mac != "" {
+		_, err := net.ParseMAC(m.Mac)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address %q", m.Mac)
+		}
+	}
+	if m.IP != nil && strings.TrimSpace(*m.IP) == "" {
+		return errors.New("IP address must not be empty if set")
+	}
+	if m.SSH != nil {
+		if m.IP == nil {
+			return errors.New("IP address is required for SSH")
+		}
+		if m.SSH.User == "" || (m.SSH.KeyFile == "" && !m.SSH.Agent) {
+			return errors.New("SSH user and key file or agent are required")
+		}
+		if m.SSH.Become != "" && m.SSH.Become != "sudo" && m.SSH.Become != "doas" {
+			return fmt.Errorf("unknown SSH become method %q, must be sudo or doas", m.SSH.Become)
+		}
+	}
+	for _, tag := range m.Tags {
+		if strings.TrimSpace(tag) == "" || strings.Contains(tag, ",") {
+			return fmt.Errorf("invalid tag %q, tags must not be empty or contain commas", tag)
+		}
+	}
+	return nil
+}
+
+// Schedule actions
+const (
+	ScheduleWake     = "wake"
+	ScheduleShutdown = "shutdown"
+)
+
+// Schedule represents an action run on machines at recurring times
+type Schedule struct {
+	// Name of the schedule
+	Name string `koanf:"name" json:"name"`
+	// Cron expression of when to run, e.g. "30 7 * * 1-5", optionally
+	// prefixed with CRON_TZ=Europe/Berlin to use another time zone
+	Cron string `koanf:"cron" json:"cron"`
+	// Action is either wake or shutdown, defaults to wake
+	Action string `koanf:"action" json:"action,omitempty"`
+	// Machines the action runs on by name
+	Machines []string `koanf:"machines" json:"machines,omitempty"`
+	// Groups the action runs on, all of their machines
+	Groups []string `koanf:"groups" json:"groups,omitempty"`
+	// Disabled keeps the schedule from running until enabled in the web interface
+	Disabled bool `koanf:"disabled" json:"disabled,omitempty"`
+}
+
+// Validate checks that the schedule has a name, a valid cron expression and
+// action and at least one machine or group
+func (s Schedule) Validate() error {
+	if strings.TrimSpace(s.Name) == "" {
+		return errors.New("name is required")
+	}
+	if s.Name != strings.TrimSpace(s.Name) {
+		return errors.New("name must not start or end with spaces")
+	}
+	if strings.ContainsAny(s.Name, "/?#") {
+		return errors.New("name must not contain /, ? or #")
+	}
+	_, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return fmt.Errorf("invalid cron expression %q: %w", s.Cron, err)
+	}
+	switch s.Action {
+	case "", ScheduleWake, ScheduleShutdown:
+	default:
+		return fmt.Errorf("unknown action %q, must be wake or shutdown", s.Action)
+	}
+	if len(s.Machines) == 0 && len(s.Groups) == 0 {
+		return errors.New("at least one machine or group is required")
+	}
+	return nil
+}
+
+// Webhook represents an HTTP endpoint notified of wakes and status changes
+type Webhook struct {
+	// URL the events are posted to
+	URL string `koanf:"url"`
+	// Events sent, any of wake, wake.succeeded, wake.failed and status, all if empty
+	Events []string `koanf:"events"`
+	// Secret signs the body with HMAC-SHA256 in the X-Wol-Signature header if set
+	Secret string `koanf:"secret"`
+	// Payload is a Go template rendering the body, the event as JSON if empty
+	Payload string `koanf:"payload"`
+	// ContentType of the body, defaults to application/json
+	ContentType string `koanf:"content_type"`
+	// Headers added to the requests, e.g. for authentication
+	Headers map[string]string `koanf:"headers"`
+	// Timeout of a single request, defaults to 10s
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// Peer represents another wol server whose machines are shown and woken
+// through this one
+type Peer struct {
+	// Name of the peer, appended to the names of its machines, e.g. desktop@home
+	Name string `koanf:"name"`
+	// URL of the peer's web interface, e.g. https://wol.example.com
+	URL string `koanf:"url"`
+	// Token is an API token created on the peer
+	Token string `koanf:"token"`
+	// Interval between updates of the peer's list of machines, defaults to 1m
+	Interval time.Duration `koanf:"interval"`
+	// Timeout of a single request, defaults to 10s
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// Switch represents a managed switch whose forwarding table is read over
+// SNMP to find the ports machines are connected to
+type Switch struct {
+	// Name of the switch shown with the ports
+	Name string `koanf:"name"`
+	// Address of the switch's agent, a host with an optional port
+	Address string `koanf:"address"`
+	// Version of the protocol: 1, 2c or 3, defaults to 2c
+	Version string `koanf:"version"`
+	// Community of version 1 and 2c requests, defaults to public
+	Community string `koanf:"community"`
+	// Username of version 3 requests
+	Username string `koanf:"username"`
+	// AuthProtocol of version 3 requests, e.g. SHA256, no authentication if empty
+	AuthProtocol string `koanf:"auth_protocol"`
+	// AuthPassword of version 3 requests
+	AuthPassword string `koanf:"auth_password"`
+	// PrivacyProtocol of version 3 requests, e.g. AES, no encryption if empty
+	PrivacyProtocol string `koanf:"privacy_protocol"`
+	// PrivacyPassword of version 3 requests
+	PrivacyPassword string `koanf:"privacy_password"`
+	// Interval between reads of the forwarding table, defaults to 5m
+	Interval time.Duration `koanf:"interval"`
+	// Timeout of a single request, defaults to 10s
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// Gateway represents a port the server listens on for a machine, waking it
+// on the first connection and forwarding connections to it once it booted
+type Gateway struct {
+	// Machine connections are forwarded to
+	Machine string `koanf:"machine"`
+	// Listen is the address connections are accepted on, e.g. :445
+	Listen string `koanf:"listen"`
+	// Port of the machine, defaults to the port of the listen address
+	Port int `koanf:"port"`
+	// BootTimeout is how long connections are held while the machine boots,
+	// defaults to 3m
+	BootTimeout time.Duration `koanf:"boot_timeout"`
+}
+
+// Agent represents a relay agent allowed to connect, agents wake machines on
+// networks the server can't broadcast to
+type Agent struct {
+	// Name of the agent
+	Name string `koanf:"name"`
+	// Token the agent authenticates with, e.g. generated with openssl rand -hex 32
+	Token string `koanf:"token"`
+}
+
+// Notification represents a chat or push notification service told when
+// machines come online, go offline unexpectedly or fail to wake
+type Notification struct {
+	// Provider is one of ntfy, telegram, slack, discord and apprise
+	Provider string `koanf:"provider"`
+	// Events sent, any of online, offline and wake.failed, all if empty
+	Events []string `koanf:"events"`
+	// URL of the ntfy topic, the Slack or Discord webhook, the Telegram Bot API server or the Apprise API notify endpoint
+	URL string `koanf:"url"`
+	// Token is the ntfy access token, the Telegram bot token or a bearer token for the Apprise API
+	Token string `koanf:"token"`
+	// ChatID is the Telegram chat messages are sent to
+	ChatID string `koanf:"chat_id"`
+	// URLs are the Apprise URLs of the services messages are sent to, for the stateless Apprise API endpoint
+	URLs []string `koanf:"urls"`
+	// Timeout of a single request, defaults to 10s
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// MQTT represents the connection to an MQTT broker
+type MQTT struct {
+	// Broker URL, e.g. tcp://broker:1883, ssl://broker:8883 or ws://broker:9001, disabled when empty
+	Broker string `koanf:"broker"`
+	// ClientID identifies the connection to the broker
+	ClientID string `koanf:"client_id"`
+	// Username to log in to the broker with
+	Username string `koanf:"username"`
+	// Password to log in to the broker with
+	Password string `koanf:"password"`
+	// TopicPrefix is prepended to all topics
+	TopicPrefix string `koanf:"topic_prefix"`
+	// QoS of published messages and subscriptions, 0, 1 or 2
+	QoS byte `koanf:"qos"`
+	// TLS represents how the broker is verified and how to authenticate with a client certificate
+	TLS MQTTTLS `koanf:"tls"`
+	// Availability represents the retained topic telling whether wol itself is running
+	Availability MQTTAvailability `koanf:"availability"`
+	// HomeAssistant represents the MQTT discovery of machines by Home Assistant
+	HomeAssistant HomeAssistant `koanf:"home_assistant"`
+}
+
+// HomeAssistant represents the MQTT discovery of machines by Home Assistant
+type HomeAssistant struct {
+	// Discovery publishes a switch and a connectivity sensor per machine
+	Discovery bool `koanf:"discovery"`
+	// DiscoveryPrefix is the topic prefix Home Assistant watches
+	DiscoveryPrefix string `koanf:"discovery_prefix"`
+}
+
+// MQTTAvailability represents the retained topic telling whether wol itself is
+// running, set when connecting and through the last will when the connection drops
+type MQTTAvailability struct {
+	// Topic below the prefix
+	Topic string `koanf:"topic"`
+	// PayloadOnline is published when connecting
+	PayloadOnline string `koanf:"payload_online"`
+	// PayloadOffline is published when shutting down and by the broker when the connection drops
+	PayloadOffline string `koanf:"payload_offline"`
+}
+
+// MQTTTLS represents the TLS configuration of the connection to an MQTT broker
+type MQTTTLS struct {
+	// CAFile contains the certificates the broker is verified against, the system ones if empty
+	CAFile string `koanf:"ca_file"`
+	// CertFile is the client certificate
+	CertFile string `koanf:"cert_file"`
+	// KeyFile is the private key of the client certificate
+	KeyFile string `koanf:"key_file"`
+	// InsecureSkipVerify disables verifying the certificate of the broker
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify"`
+}
+
+// UniFi represents the UniFi Network controller clients are synced from
+type UniFi struct {
+	// URL of the controller, e.g. https://192.168.1.1, disabled when empty
+	URL string `koanf:"url"`
+	// Site whose clients are synced
+	Site string `koanf:"site"`
+	// Username of a local account of the controller
+	Username string `koanf:"username"`
+	// Password of the account
+	Password string `koanf:"password"`
+	// InsecureSkipVerify accepts the self-signed certificate of the controller
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify"`
+	// Interval between syncs, defaults to 1m
+	Interval time.Duration `koanf:"interval"`
+}
+
+// Firewall represents the pfSense or OPNsense firewall whose ARP table and
+// DHCP leases are synced
+type Firewall struct {
+	// Type of the firewall, opnsense or pfsense
+	Type string `koanf:"type"`
+	// URL of the web interface, e.g. https://192.168.1.1, disabled when empty
+	URL string `koanf:"url"`
+	// APIKey is the key of the API user
+	APIKey string `koanf:"api_key"`
+	// APISecret is the secret of the API key, OPNsense only
+	APISecret string `koanf:"api_secret"`
+	// InsecureSkipVerify accepts the self-signed certificate of the firewall
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify"`
+	// Interval between syncs, defaults to 1m
+	Interval time.Duration `koanf:"interval"`
+}
+
+// Tailscale represents the tailnet whose nodes machines are mapped to
+type Tailscale struct {
+	// Enabled syncs the nodes of the tailnet
+	Enabled bool `koanf:"enabled"`
+	// Socket of the local tailscaled, used unless an API key is set
+	Socket string `koanf:"socket"`
+	// APIKey asks the Tailscale API instead of the local tailscaled
+	APIKey string `koanf:"api_key"`
+	// Tailnet of the API key, defaults to the tailnet the key belongs to
+	Tailnet string `koanf:"tailnet"`
+	// PreferIP makes wakes and checks use the Tailscale IP of machines even
+	// if they have an IP configured, for servers outside the machines' network
+	PreferIP bool `koanf:"prefer_ip"`
+	// Interval between syncs, defaults to 1m
+	Interval time.Duration `koanf:"interval"`
+}
+
+// MDNS represents browsing the network for devices advertising services
+// through Bonjour or Avahi
+type MDNS struct {
+	// Enabled browses the network while serving
+	Enabled bool `koanf:"enabled"`
+	// Services browsed for, e.g. _smb._tcp, computers, file servers and
+	// printers if empty
+	Services []string `koanf:"services"`
+	// Interval between browses, defaults to 5m
+	Interval time.Duration `koanf:"interval"`
+}
+
+// NetBIOS represents naming devices that have no hostname by asking them
+// for their NetBIOS name
+type NetBIOS struct {
+	// Enabled names devices while serving
+	Enabled bool `koanf:"enabled"`
+	// Interval between namings, defaults to 5m
+	Interval time.Duration `koanf:"interval"`
+}
+
+// SleepProxy represents answering for sleeping Macs and other Bonjour
+// devices as a Bonjour Sleep Proxy
+type SleepProxy struct {
+	// Enabled advertises the sleep proxy while serving
+	Enabled bool `koanf:"enabled"`
+	// Name of the proxy shown to devices, defaults to wol
+	Name string `koanf:"name"`
+	// Port devices register on, defaults to 53535
+	Port int `koanf:"port"`
+}
+
+// ARPProxy represents answering ARP requests for sleeping machines to wake
+// them on demand
+type ARPProxy struct {
+	// Enabled answers requests while serving
+	Enabled bool `koanf:"enabled"`
+	// Interface requests are answered on, e.g. eth0
+	Interface string `koanf:"interface"`
+}
+
+// DNSForwarder represents forwarding DNS queries and waking the machines
+// whose names are looked up
+type DNSForwarder struct {
+	// Enabled forwards queries while serving
+	Enabled bool `koanf:"enabled"`
+	// Listen is the address queries are accepted on over UDP and TCP, defaults to 127.0.0.1:53
+	Listen string `koanf:"listen"`
+	// AllowedIPs are the clients allowed to send queries, server.allowed_ips
+	// if empty and private addresses if both are empty
+	AllowedIPs []string `koanf:"allowed_ips"`
+	// Upstream is the DNS server queries are forwarded to, e.g. 192.168.1.1
+	Upstream string `koanf:"upstream"`
+	// Timeout of a forwarded query, defaults to 2s
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// LeaseFile represents the lease file of a DHCP server
+type LeaseFile struct {
+	// Path of the lease file
+	Path string `koanf:"path"`
+	// Format of the lease file, dnsmasq, odhcpd or kea
+	Format string `koanf:"format"`
+}
+
+// Ubus represents the wol object registered on ubus when running on OpenWrt
+type Ubus struct {
+	// Disabled keeps the object from being registered even if ubusd is running
+	Disabled bool `koanf:"disabled"`
+	// Socket is where ubusd listens, the object is only registered if it exists
+	Socket string `koanf:"socket"`
+}
+
+// TLS represents the HTTPS configuration of the server
+type TLS struct {
+	// CertFile is the path to the PEM encoded certificate chain
+	CertFile string `koanf:"cert_file"`
+	// KeyFile is the path to the PEM encoded private key
+	KeyFile string `koanf:"key_file"`
+	// ACME represents automatic certificate management, e.g. with Let's Encrypt
+	ACME ACME `koanf:"acme"`
+}
+
+// ACME represents the configuration for obtaining certificates automatically
+type ACME struct {
+	// Domains to obtain a certificate for, enables ACME when set
+	Domains []string `koanf:"domains"`
+	// Email used to register the account, the CA sends expiry notices to it
+	Email string `koanf:"email"`
+	// DirectoryURL of the certificate authority, defaults to Let's Encrypt
+	DirectoryURL string `koanf:"directory_url"`
+	// Challenge used to prove control over the domains, http-01 or dns-01
+	Challenge string `koanf:"challenge"`
+	// HTTPListen is the address answering HTTP-01 challenges and redirecting to HTTPS
+	HTTPListen string `koanf:"http_listen"`
+	// DNS represents the provider creating TXT records for DNS-01 challenges
+	DNS ACMEDNS `koanf:"dns"`
+}
+
+// ACMEDNS represents the DNS provider used for DNS-01 challenges
+type ACMEDNS struct {
+	// Provider managing the records, only exec is supported
+	Provider string `koanf:"provider"`
+	// Command is run as "<command> present|cleanup <name> <value>" by the exec provider
+	Command string `koanf:"command"`
+	// PropagationDelay is how long to wait for the record to propagate before validation
+	PropagationDelay time.Duration `koanf:"propagation_delay"`
+}
+
+// SSH represents the settings shared by all SSH connections to machines
+type SSH struct {
+	// KnownHostsFile contains the host keys machines are verified against
+	KnownHostsFile string `koanf:"known_hosts_file"`
+	// HostKeyPolicy is strict to only accept known host keys, accept-new to
+	// add the keys of unknown machines to the file or insecure to accept any
+	HostKeyPolicy string `koanf:"host_key_policy"`
+	// Timeout of establishing a connection
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// History represents the recording of status changes and wakes
+type History struct {
+	// Retention is how long status changes and wakes are kept, zero keeps them forever
+	Retention time.Duration `koanf:"retention"`
 }
 
 // Server represents the server configuration
 type Server struct {
-	// Listen address for the server
+	// Listen addresses for the server, a single address can be given as a string
+	// and unix:/path/to.sock listens on a Unix domain socket
+	Listen []string `koanf:"listen"`
+	// SocketMode is the octal file mode of the Unix domain socket
+	SocketMode string `koanf:"socket_mode"`
+	// SocketGroup owns the Unix domain socket if set, e.g. the group of the web server
+	SocketGroup string `koanf:"socket_group"`
+	// BasePath is the path prefix the web interface is served under, e.g. /wol
+	BasePath string `koanf:"base_path"`
+	// PublicURL is where other devices reach the web interface, e.g.
+	// https://wol.example.com/wol, guessed from requests if empty
+	PublicURL string `koanf:"public_url"`
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For and X-Real-IP headers are used to determine the client IP
+	TrustedProxies []string `koanf:"trusted_proxies"`
+	// AllowedIPs are the addresses or CIDR ranges of clients allowed to use
+	// the server, all if empty
+	AllowedIPs []string `koanf:"allowed_ips"`
+	// DeniedIPs are the addresses or CIDR ranges of clients that are turned
+	// away, even if they are in AllowedIPs
+	DeniedIPs []string `koanf:"denied_ips"`
+	// StatusInterval is how often the status of the machines is checked and sent to clients
+	StatusInterval time.Duration `koanf:"status_interval"`
+	// BackgroundInterval is how often all machines are checked, machines
+	// shown to clients are checked every StatusInterval, zero disables it
+	BackgroundInterval time.Duration `koanf:"background_interval"`
+	// StatusTTL is how old the shared statuses can be before the API, the
+	// machine page and filters check the machines again, defaults to StatusInterval
+	StatusTTL time.Duration `koanf:"status_ttl"`
+	// TemplatesDir holds templates replacing or adding to the bundled ones (optional)
+	TemplatesDir string `koanf:"templates_dir"`
+	// StaticDir holds files served under /static/ replacing or adding to the bundled ones (optional)
+	StaticDir string `koanf:"static_dir"`
+	// Language of the web interface, e.g. de, picked from the browser's preferences if empty
+	Language string `koanf:"language"`
+	// Theme of the web interface unless users pick their own: auto, light or dark
+	Theme string `koanf:"theme"`
+	// AccentColor replaces the color of buttons and links, e.g. #16a34a (optional)
+	AccentColor string `koanf:"accent_color"`
+	// ConfirmWake asks before waking a machine from the web interface
+	ConfirmWake bool `koanf:"confirm_wake"`
+	// PageSize is the number of machines per dashboard page, zero shows all
+	PageSize int `koanf:"page_size"`
+	// ProbeConcurrency is the maximum number of machines checked at the same time
+	ProbeConcurrency int `koanf:"probe_concurrency"`
+	// OnlineAfter is how many online results in a row it takes for a machine
+	// shown offline to be shown online
+	OnlineAfter int `koanf:"online_after"`
+	// OfflineAfter is how many offline results in a row it takes for a
+	// machine shown online to be shown offline
+	OfflineAfter int `koanf:"offline_after"`
+	// ShutdownTimeout is how long to wait for requests to finish when stopping
+	ShutdownTimeout time.Duration `koanf:"shutdown_timeout"`
+	// ReadHeaderTimeout is how long clients have to send the headers of a request
+	ReadHeaderTimeout time.Duration `koanf:"read_header_timeout"`
+	// ReadTimeout is how long clients have to send a whole request, status
+	// streams and WebSockets aren't limited once they are open
+	ReadTimeout time.Duration `koanf:"read_timeout"`
+	// IdleTimeout is how long idle keep-alive connections are kept open
+	IdleTimeout time.Duration `koanf:"idle_timeout"`
+	// MaxBodySize is the largest request body accepted in bytes
+	MaxBodySize int64 `koanf:"max_body_size"`
+	// MaxConnections is the number of connections served at the same time
+	// per listen address, further clients wait until one is closed, 0 for
+	// no limit
+	MaxConnections int `koanf:"max_connections"`
+	// MaxStatusClients is the number of status streams served at the same
+	// time, further clients are turned away until one disconnects, 0 for no
+	// limit
+	MaxStatusClients int `koanf:"max_status_clients"`
+	// StatusWriteTimeout is how long a status stream client has to accept an
+	// event before it is disconnected
+	StatusWriteTimeout time.Duration `koanf:"status_write_timeout"`
+	// WakeTimeout is how long a woken machine has to come online before the
+	// wake is reported as failed
+	WakeTimeout time.Duration `koanf:"wake_timeout"`
+	// ReadOnly shows machines and their status but rejects waking them
+	ReadOnly bool `koanf:"read_only"`
+	// TLS represents the HTTPS configuration of the server
+	TLS TLS `koanf:"tls"`
+	// AccessLog represents the logging of HTTP requests
+	AccessLog AccessLog `koanf:"access_log"`
+	// Compression compresses responses with gzip or deflate if the client supports it
+	Compression bool `koanf:"compression"`
+	// APIDocs serves an interactive Swagger UI page for the JSON API at /api/docs
+	APIDocs bool `koanf:"api_docs"`
+	// CORS represents which other origins can call the API from a browser
+	CORS CORS `koanf:"cors"`
+	// SecurityHeaders represents the headers protecting the web interface in browsers
+	SecurityHeaders SecurityHeaders `koanf:"security_headers"`
+	// GRPC represents the gRPC API
+	GRPC GRPC `koanf:"grpc"`
+	// HTTP2 represents the HTTP/2 support of the web interface and API
+	HTTP2 HTTP2 `koanf:"http2"`
+}
+
+// GRPC represents the configuration of the gRPC API
+type GRPC struct {
+	// Listen address of the gRPC server, disabled when empty
 	Listen string `koanf:"listen"`
 }
 
+// HTTP2 represents the HTTP/2 support of the web interface and API
+type HTTP2 struct {
+	// Enabled serves HTTP/2 over TLS to clients that support it
+	Enabled bool `koanf:"enabled"`
+	// H2C serves HTTP/2 without TLS to clients that know the server
+	// supports it, e.g. a reverse proxy terminating TLS
+	H2C bool `koanf:"h2c"`
+	// MaxConcurrentStreams is how many requests, such as status streams, a
+	// client can have open on one connection, defaults to 250
+	MaxConcurrentStreams uint32 `koanf:"max_concurrent_streams"`
+}
+
+// SecurityHeaders represents the headers protecting the web interface in browsers
+type SecurityHeaders struct {
+	// Enabled adds the headers to all responses
+	Enabled bool `koanf:"enabled"`
+	// ContentSecurityPolicy limits where pages load scripts, styles and
+	// images from, not sent if empty
+	ContentSecurityPolicy string `koanf:"content_security_policy"`
+	// HSTSMaxAge is how long browsers only use HTTPS after a visit over
+	// HTTPS, 0 to not send Strict-Transport-Security
+	HSTSMaxAge time.Duration `koanf:"hsts_max_age"`
+	// FrameOptions is DENY or SAMEORIGIN, not sent if empty to allow
+	// embedding the web interface in other sites
+	FrameOptions string `koanf:"frame_options"`
+	// ReferrerPolicy decides what other sites learn about the page linking
+	// to them, not sent if empty
+	ReferrerPolicy string `koanf:"referrer_policy"`
+}
+
+// CORS represents the cross-origin resource sharing configuration of the API
+type CORS struct {
+	// AllowedOrigins can call the API, e.g. https://dashboard.example.com or * for any
+	AllowedOrigins []string `koanf:"allowed_origins"`
+	// AllowedMethods can be used in cross-origin requests
+	AllowedMethods []string `koanf:"allowed_methods"`
+	// AllowedHeaders can be sent in cross-origin requests
+	AllowedHeaders []string `koanf:"allowed_headers"`
+	// AllowCredentials lets browsers send cookies and basic auth credentials
+	AllowCredentials bool `koanf:"allow_credentials"`
+	// MaxAge is how long browsers may cache the result of a preflight request
+	MaxAge time.Duration `koanf:"max_age"`
+}
+
+// AccessLog represents the configuration of the HTTP access log
+type AccessLog struct {
+	// Enabled logs every HTTP request
+	Enabled bool `koanf:"enabled"`
+	// Format of the log lines, text or json
+	Format string `koanf:"format"`
+}
+
 // Ping represents the ping configuration
 type Ping struct {
 	// Privileged determines if privileged ping should be used
 	Privileged bool `koanf:"privileged"`
 }
 
+// User represents a user allowed to access the web interface
+type User struct {
+	// Username used to log in
+	Username string `koanf:"username"`
+	// Bcrypt hash of the user's password, generate one with `wol hash-password`
+	PasswordHash string `koanf:"password_hash"`
+	// Role of the user, one of admin, operator or viewer, defaults to auth.default_role
+	Role string `koanf:"role"`
+	// Machines the role applies to by name, all machines if both lists are empty
+	Machines []string `koanf:"machines"`
+	// MachineGroups the role applies to by group
+	MachineGroups []string `koanf:"machine_groups"`
+}
+
+// RoleMapping grants a role to members of a group reported by SSO, LDAP or a proxy
+type RoleMapping struct {
+	// Group of the user as reported by the provider
+	Group string `koanf:"group"`
+	// Role granted, one of admin, operator or viewer
+	Role string `koanf:"role"`
+	// Machines the role applies to by name, all machines if both lists are empty
+	Machines []string `koanf:"machines"`
+	// MachineGroups the role applies to by group
+	MachineGroups []string `koanf:"machine_groups"`
+}
+
+// Session represents the login session configuration
+type Session struct {
+	// IdleTimeout after which an unused session expires
+	IdleTimeout time.Duration `koanf:"idle_timeout"`
+	// SecureCookie forces the Secure cookie flag, useful behind a TLS terminating proxy
+	SecureCookie bool `koanf:"secure_cookie"`
+	// Persistent keeps sessions in the data directory so users stay logged
+	// in when the server restarts
+	Persistent bool `koanf:"persistent"`
+}
+
+// OIDC represents the OpenID Connect single sign-on configuration
+type OIDC struct {
+	// Issuer URL of the provider, enables single sign-on when set
+	Issuer string `koanf:"issuer"`
+	// ClientID registered at the provider
+	ClientID string `koanf:"client_id"`
+	// ClientSecret registered at the provider
+	ClientSecret string `koanf:"client_secret"`
+	// RedirectURL must point to /oidc/callback on this server
+	RedirectURL string `koanf:"redirect_url"`
+	// Scopes requested from the provider
+	Scopes []string `koanf:"scopes"`
+	// UsernameClaim is the ID token claim used as username
+	UsernameClaim string `koanf:"username_claim"`
+	// GroupsClaim is the ID token claim holding the user's groups
+	GroupsClaim string `koanf:"groups_claim"`
+	// AllowedGroups restricts login to members of these groups if not empty
+	AllowedGroups []string `koanf:"allowed_groups"`
+}
+
+// LDAP represents the LDAP / Active Directory authentication configuration
+type LDAP struct {
+	// URL of the server, e.g. ldaps://dc.example.com, enables LDAP when set
+	URL string `koanf:"url"`
+	// StartTLS upgrades plain ldap:// connections to TLS
+	StartTLS bool `koanf:"start_tls"`
+	// InsecureSkipVerify disables certificate verification
+	InsecureSkipVerify bool `koanf:"insecure_skip_verify"`
+	// Timeout for connecting and each operation
+	Timeout time.Duration `koanf:"timeout"`
+	// BindDN templates tried in order to bind as the user, {username} is replaced
+	BindDN []string `koanf:"bind_dn"`
+	// UserBaseDN is searched for the user entry to resolve its DN and memberOf
+	UserBaseDN string `koanf:"user_base_dn"`
+	// UserFilter finds the user entry, {username} is replaced
+	UserFilter string `koanf:"user_filter"`
+	// GroupBaseDN is searched for groups the user is a member of
+	GroupBaseDN string `koanf:"group_base_dn"`
+	// GroupFilter finds the user's groups, {dn} and {username} are replaced
+	GroupFilter string `koanf:"group_filter"`
+	// GroupNameAttribute holds the name of a group
+	GroupNameAttribute string `koanf:"group_name_attribute"`
+	// AllowedGroups restricts login to members of these groups if not empty
+	AllowedGroups []string `koanf:"allowed_groups"`
+}
+
+// ProxyAuth represents the configuration for trusting identity headers set by a reverse proxy
+type ProxyAuth struct {
+	// TrustedProxies are the addresses or CIDR ranges allowed to set identity
+	// headers, enables proxy auth when set. Peers on a Unix socket are trusted too.
+	TrustedProxies []string `koanf:"trusted_proxies"`
+	// UserHeaders are checked in order for the username
+	UserHeaders []string `koanf:"user_headers"`
+	// GroupsHeader holds a comma separated list of the user's groups
+	GroupsHeader string `koanf:"groups_header"`
+}
+
+// RateLimit represents the configuration for slowing down failed logins
+type RateLimit struct {
+	// Enabled determines if failed logins are rate limited per client IP
+	Enabled bool `koanf:"enabled"`
+	// BaseDelay is the wait after the first failure, doubled on each further failure
+	BaseDelay time.Duration `koanf:"base_delay"`
+	// MaxDelay caps the wait between attempts
+	MaxDelay time.Duration `koanf:"max_delay"`
+	// LockoutAfter consecutive failures locks the client out, zero disables lockouts
+	LockoutAfter int `koanf:"lockout_after"`
+	// LockoutDuration is how long a locked out client has to wait
+	LockoutDuration time.Duration `koanf:"lockout_duration"`
+}
+
+// Auth represents the authentication configuration
+type Auth struct {
+	// Disabled turns off authentication entirely, only use on trusted networks
+	Disabled bool `koanf:"disabled"`
+	// Basic determines if HTTP basic auth is accepted in addition to the login page
+	Basic bool `koanf:"basic"`
+	// Users allowed to access the web interface
+	Users []User `koanf:"users"`
+	// Session represents the login session configuration
+	Session Session `koanf:"session"`
+	// OIDC represents the OpenID Connect single sign-on configuration
+	OIDC OIDC `koanf:"oidc"`
+	// LDAP represents the LDAP / Active Directory authentication configuration
+	LDAP LDAP `koanf:"ldap"`
+	// ProxyAuth represents the configuration for trusting identity headers set by a reverse proxy
+	ProxyAuth ProxyAuth `koanf:"proxy_auth"`
+	// DefaultRole is given to configured users without a role and to all external users
+	DefaultRole string `koanf:"default_role"`
+	// RoleMappings grant roles to members of external groups
+	RoleMappings []RoleMapping `koanf:"role_mappings"`
+	// WakeAllRole is the minimum role allowed to wake all machines at once
+	WakeAllRole string `koanf:"wake_all_role"`
+	// RateLimit represents the configuration for slowing down failed logins
+	RateLimit RateLimit `koanf:"rate_limit"`
+}
+
 // Config represents the configuration for the application
 type Config struct {
 	// Machines represents the list of machines to wake up
 	Machines []Machine `koanf:"machines"`
+	// Schedules represents actions run on machines at recurring times
+	Schedules []Schedule `koanf:"schedules"`
+	// Webhooks represents HTTP endpoints notified of wakes and status changes
+	Webhooks []Webhook `koanf:"webhooks"`
+	// Notifications represents services notified when machines come online, go offline or fail to wake
+	Notifications []Notification `koanf:"notifications"`
+	// MQTT represents the connection to an MQTT broker
+	MQTT MQTT `koanf:"mqtt"`
+	// Ubus represents the wol object registered on ubus when running on OpenWrt
+	Ubus Ubus `koanf:"ubus"`
+	// DHCPLeases represents the lease files machines' addresses and unconfigured devices are taken from
+	DHCPLeases []LeaseFile `koanf:"dhcp_leases"`
+	// UniFi represents the UniFi Network controller clients are synced from
+	UniFi UniFi `koanf:"unifi"`
+	// Firewall represents the pfSense or OPNsense firewall whose ARP table and DHCP leases are synced
+	Firewall Firewall `koanf:"firewall"`
+	// Tailscale represents the tailnet whose nodes machines are mapped to
+	Tailscale Tailscale `koanf:"tailscale"`
+	// MDNS represents browsing the network for devices advertising services
+	MDNS MDNS `koanf:"mdns"`
+	// NetBIOS represents naming devices that have no hostname with NetBIOS
+	NetBIOS NetBIOS `koanf:"netbios"`
+	// SleepProxy represents answering for sleeping devices as a Bonjour Sleep Proxy
+	SleepProxy SleepProxy `koanf:"sleep_proxy"`
+	// ARPProxy represents answering ARP requests for sleeping machines
+	ARPProxy ARPProxy `koanf:"arp_proxy"`
+	// DNSForwarder represents forwarding DNS queries and waking the machines looked up
+	DNSForwarder DNSForwarder `koanf:"dns_forwarder"`
+	// Peers represents other wol servers whose machines are shown on the dashboard
+	Peers []Peer `koanf:"peers"`
+	// Gateways represents the ports forwarded to machines that are woken on demand
+	Gateways []Gateway `koanf:"gateways"`
+	// Switches represents the managed switches whose forwarding tables are read
+	Switches []Switch `koanf:"switches"`
+	// Agents represents the relay agents allowed to connect
+	Agents []Agent `koanf:"agents"`
 	// Server represents the server configuration
 	Server Server `koanf:"server"`
 	// Ping represents the ping configuration
 	Ping Ping `koanf:"ping"`
+	// Auth represents the authentication configuration
+	Auth Auth `koanf:"auth"`
+	// SSH represents the settings shared by all SSH connections to machines
+	SSH SSH `koanf:"ssh"`
+	// History represents the recording of status changes and wakes
+	History History `koanf:"history"`
+	// DataDir is where state such as API tokens is stored
+	DataDir string `koanf:"data_dir"`
 }
 
 // NewConfig creates a new Config instance
@@ -67,26 +1075,155 @@ func NewConfig() *Config {
 //   - ./config.yaml
 //
 // 3. Environment variable `WOL_CONFIG` containing full YAML config
+// 4. Environment variables for single settings, e.g. `WOL_SERVER__LISTEN`
 func (c *Config) Load() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+
 	// Load defaults first
 	defaults := &Config{
 		Server: Server{
-			Listen: ":7777",
+			Listen:             []string{":7777"},
+			SocketMode:         "0660",
+			ShutdownTimeout:    10 * time.Second,
+			ReadHeaderTimeout:  10 * time.Second,
+			ReadTimeout:        time.Minute,
+			IdleTimeout:        2 * time.Minute,
+			MaxBodySize:        1 << 20,
+			MaxConnections:     1024,
+			MaxStatusClients:   256,
+			StatusWriteTimeout: 10 * time.Second,
+			StatusInterval:     5 * time.Second,
+			BackgroundInterval: 5 * time.Minute,
+			PageSize:           50,
+			Theme:              "auto",
+			ProbeConcurrency:   16,
+			OnlineAfter:        1,
+			OfflineAfter:       1,
+			WakeTimeout:        5 * time.Minute,
+			AccessLog: AccessLog{
+				Format: "text",
+			},
+			Compression: true,
+			CORS: CORS{
+				AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+				AllowedHeaders: []string{"Authorization", "Content-Type", "X-CSRF-Token"},
+				MaxAge:         10 * time.Minute,
+			},
+			HTTP2: HTTP2{
+				Enabled: true,
+			},
+			SecurityHeaders: SecurityHeaders{
+				Enabled:               true,
+				ContentSecurityPolicy: "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; object-src 'none'; base-uri 'self'",
+				HSTSMaxAge:            365 * 24 * time.Hour,
+				FrameOptions:          "DENY",
+				ReferrerPolicy:        "same-origin",
+			},
+			TLS: TLS{
+				ACME: ACME{
+					DirectoryURL: "https://acme-v02.api.letsencrypt.org/directory",
+					Challenge:    "http-01",
+					HTTPListen:   ":80",
+					DNS: ACMEDNS{
+						Provider:         "exec",
+						PropagationDelay: 30 * time.Second,
+					},
+				},
+			},
 		},
 		Ping: Ping{
 			Privileged: false,
 		},
+		Auth: Auth{
+			Basic:       true,
+			DefaultRole: "viewer",
+			WakeAllRole: "admin",
+			Session: Session{
+				IdleTimeout: 12 * time.Hour,
+				Persistent:  true,
+			},
+			OIDC: OIDC{
+				Scopes:        []string{"openid", "profile", "email"},
+				UsernameClaim: "preferred_username",
+				GroupsClaim:   "groups",
+			},
+			LDAP: LDAP{
+				Timeout:            10 * time.Second,
+				UserFilter:         "(uid={username})",
+				GroupFilter:        "(member={dn})",
+				GroupNameAttribute: "cn",
+			},
+			ProxyAuth: ProxyAuth{
+				UserHeaders:  []string{"Remote-User", "X-Forwarded-User"},
+				GroupsHeader: "Remote-Groups",
+			},
+			RateLimit: RateLimit{
+				Enabled:         true,
+				BaseDelay:       time.Second,
+				MaxDelay:        5 * time.Minute,
+				LockoutAfter:    0,
+				LockoutDuration: 15 * time.Minute,
+			},
+		},
+		SSH: SSH{
+			KnownHostsFile: filepath.Join(home, ".ssh", "known_hosts"),
+			HostKeyPolicy:  "strict",
+			Timeout:        10 * time.Second,
+		},
+		MQTT: MQTT{
+			ClientID:    "wol",
+			TopicPrefix: "wol",
+			QoS:         1,
+			Availability: MQTTAvailability{
+				Topic:          "status",
+				PayloadOnline:  "online",
+				PayloadOffline: "offline",
+			},
+			HomeAssistant: HomeAssistant{
+				DiscoveryPrefix: "homeassistant",
+			},
+		},
+		UniFi: UniFi{
+			Site:     "default",
+			Interval: time.Minute,
+		},
+		Firewall: Firewall{
+			Interval: time.Minute,
+		},
+		MDNS: MDNS{
+			Interval: 5 * time.Minute,
+		},
+		NetBIOS: NetBIOS{
+			Interval: 5 * time.Minute,
+		},
+		SleepProxy: SleepProxy{
+			Name: "wol",
+			Port: 53535,
+		},
+		DNSForwarder: DNSForwarder{
+			Listen:  "127.0.0.1:53",
+			Timeout: 2 * time.Second,
+		},
+		Tailscale: Tailscale{
+			Socket:   "/var/run/tailscale/tailscaled.sock",
+			Interval: time.Minute,
+		},
+		Ubus: Ubus{
+			Socket: "/var/run/ubus/ubus.sock",
+		},
+		History: History{
+			Retention: 90 * 24 * time.Hour,
+		},
+		DataDir: filepath.Join(home, ".wol"),
 	}
-	err := k.Load(structs.Provider(defaults, koanfTag), nil)
+	err = k.Load(structs.Provider(defaults, koanfTag), nil)
 	if err != nil {
 		return fmt.Errorf("failed to load defaults: %w", err)
 	}
 
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
-	}
-
 	// Order here matters as later values will override earlier ones
 	paths := []string{
 		filepath.Join("/etc", "wol", configFilename),
@@ -110,6 +1247,12 @@ func (c *Config) Load() error {
 		return fmt.Errorf("failed to load config from WOL_CONFIG: %w", err)
 	}
 
+	// Load single settings from environment variables
+	err = k.Load(envProvider{}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load config from environment variables: %w", err)
+	}
+
 	err = k.Unmarshal("", c)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal config: %w", err)
@@ -117,3 +1260,61 @@ func (c *Config) Load() error {
 
 	return nil
 }
+
+// envProvider provides settings from environment variables named after their
+// keys, upper-cased with `__` between nested keys and prefixed with `WOL_`,
+// e.g. `WOL_PING__PRIVILEGED=true`. Values starting with `[` or `{` are JSON,
+// so lists like machines can be set as well.
+type envProvider struct{}
+
+// ReadBytes isn't supported as the settings are read as a map
+func (envProvider) ReadBytes() ([]byte, error) {
+	return nil, errors.New("environment provider does not support ReadBytes")
+}
+
+// Read returns the settings of all environment variables with the prefix
+func (envProvider) Read() (map[string]interface{}, error) {
+	settings := make(map[string]interface{})
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, envPrefix) || envReserved[name] {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, envPrefix))
+		key = strings.ReplaceAll(key, envDelimiter, koanfDelimiter)
+		if key == "" {
+			continue
+		}
+		if !isSetting(reflect.TypeOf(Config{}), strings.Split(key, koanfDelimiter)) {
+			log.Printf("Ignoring environment variable %s, %s isn't a setting", name, key)
+			continue
+		}
+
+		var setting interface{} = value
+		if trimmed := strings.TrimSpace(value); strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+			if err := json.Unmarshal([]byte(trimmed), &setting); err != nil {
+				return nil, fmt.Errorf("invalid JSON in %s: %w", name, err)
+			}
+		}
+		settings[key] = setting
+	}
+	return maps.Unflatten(settings, koanfDelimiter), nil
+}
+
+// isSetting reports whether the keys name a field of the type, following
+// nested structs. Anything below lists and maps is left to their values.
+func isSetting(t reflect.Type, keys []string) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if len(keys) == 0 || t.Kind() != reflect.Struct {
+		return true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.IsExported() && field.Tag.Get(koanfTag) == keys[0] {
+			return isSetting(field.Type, keys[1:])
+		}
+	}
+	return false
+}
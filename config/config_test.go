@@ -0,0 +1,77 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDNSForwarderListensOnLoopbackByDefault(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("WOL_CONFIG", "")
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	os.Chdir(t.TempDir())
+
+	c := NewConfig()
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	host, _, err := net.SplitHostPort(c.DNSForwarder.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !addr.IsLoopback() {
+		t.Errorf("dns_forwarder.listen defaults to %q, want a loopback address", c.DNSForwarder.Listen)
+	}
+}
+
+func TestEnvProviderIgnoresUnknownSettings(t *testing.T) {
+	t.Setenv("WOL_SERVER__LISTEN", ":7777")
+	t.Setenv("WOL_SERVER__LISTNE", ":8888")
+	t.Setenv("WOL_DNS_FORWARDER__ALLOWED_IPS", `["10.0.0.0/8"]`)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	settings, err := envProvider{}.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := settings["server"].(map[string]interface{})
+	if server["listen"] != ":7777" {
+		t.Errorf("server.listen is %v, want :7777", server["listen"])
+	}
+	if _, ok := server["listne"]; ok {
+		t.Error("unknown setting server.listne was loaded")
+	}
+	if !strings.Contains(logged.String(), "WOL_SERVER__LISTNE") {
+		t.Errorf("unknown setting wasn't logged, got %q", logged.String())
+	}
+	if strings.Contains(logged.String(), "WOL_SERVER__LISTEN ") || strings.Contains(logged.String(), "WOL_DNS_FORWARDER") {
+		t.Errorf("known settings were logged as unknown, got %q", logged.String())
+	}
+}
+
+func TestDefaultRoleIsViewer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("WOL_CONFIG", "")
+	wd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(wd) })
+	os.Chdir(t.TempDir())
+
+	// External users without role mappings get the default role
+	c := NewConfig()
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if c.Auth.DefaultRole != "viewer" {
+		t.Errorf("auth.default_role defaults to %q, want viewer", c.Auth.DefaultRole)
+	}
+}
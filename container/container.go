@@ -0,0 +1,166 @@
+// Package container starts, stops and checks containers through the API of
+// Docker or Podman, which implements the same API
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultEndpoint is the socket of the local Docker daemon, the one of
+// rootless Podman is e.g. unix:///run/user/1000/podman/podman.sock
+const DefaultEndpoint = "unix:///var/run/docker.sock"
+
+// Config describes how to reach a container
+type Config struct {
+	// Endpoint of the API, unix:///path for a socket or tcp://host:port,
+	// DefaultEndpoint if empty
+	Endpoint string
+	// Name or ID of the container
+	Name string
+	// Timeout of a single request, stopping a container included
+	Timeout time.Duration
+}
+
+// State is the state of a container
+type State struct {
+	// Running is true if the container is started and not paused
+	Running bool
+	// Paused is true if the container is started but paused
+	Paused bool
+}
+
+// Container is a container of an endpoint
+type Container struct {
+	base   string
+	path   string
+	client *http.Client
+}
+
+// New checks the configuration and returns the container
+func New(config Config) (*Container, error) {
+	if config.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	base := "http://" + u.Host
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		// The host is ignored when dialing the socket
+		base = "http://localhost"
+	case "tcp", "http":
+	case "https":
+		base = "https://" + u.Host
+	default:
+		return nil, fmt.Errorf("invalid endpoint %q, must start with unix://, tcp://, http:// or https://", endpoint)
+	}
+	return &Container{
+		base:   base,
+		path:   "/containers/" + url.PathEscape(config.Name),
+		client: &http.Client{Timeout: config.Timeout, Transport: transport},
+	}, nil
+}
+
+// State returns the state of the container
+func (c *Container) State(ctx context.Context) (State, error) {
+	var inspect struct {
+		State struct {
+			Running bool `json:"Running"`
+			Paused  bool `json:"Paused"`
+		} `json:"State"`
+	}
+	err := c.do(ctx, http.MethodGet, "/json", &inspect)
+	if err != nil {
+		return State{}, err
+	}
+	if inspect.State.Paused {
+		return State{Paused: true}, nil
+	}
+	return State{Running: inspect.State.Running}, nil
+}
+
+// Start starts the container, or unpauses it if paused
+func (c *Container) Start(ctx context.Context) error {
+	state, err := c.State(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case state.Running:
+		return nil
+	case state.Paused:
+		return c.do(ctx, http.MethodPost, "/unpause", nil)
+	default:
+		return c.do(ctx, http.MethodPost, "/start", nil)
+	}
+}
+
+// Stop stops the container, killing it if it doesn't stop within the
+// container's stop timeout
+func (c *Container) Stop(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/stop", nil)
+}
+
+// Restart stops and starts the container
+func (c *Container) Restart(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/restart", nil)
+}
+
+// do sends a request for the container and decodes the response into v if not nil
+func (c *Container) do(ctx context.Context, method, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+c.path+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusNotModified:
+		// Already started or stopped
+		return nil
+	default:
+		var message struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &message) == nil && message.Message != "" {
+			return errors.New(message.Message)
+		}
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
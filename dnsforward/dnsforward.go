@@ -0,0 +1,276 @@
+// Package dnsforward is a small DNS forwarder telling about queries for the
+// names of machines, so looking a machine up can wake it. Answers for those
+// names are kept and returned while the upstream server has none, e.g.
+// because the machine's DHCP lease expired while it was asleep.
+package dnsforward
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// staleTTL is the TTL of kept and made up answers, short so clients ask
+// again once the machine is up
+const staleTTL = 30
+
+// tcpIdleTimeout is how long TCP connections of clients are kept open
+// without a query
+const tcpIdleTimeout = 10 * time.Second
+
+// Forwarder forwards queries to an upstream server
+type Forwarder struct {
+	// Listen is the address queries are accepted on over UDP and TCP, e.g. :53
+	Listen string
+	// Upstream is the address of the server queries are forwarded to
+	Upstream string
+	// Timeout of a forwarded query
+	Timeout time.Duration
+	// Allowed reports whether the client may send queries, queries of other
+	// clients are dropped so the forwarder isn't an open resolver. All
+	// clients are allowed if nil.
+	Allowed func(addr netip.Addr) bool
+	// Queried is called for every name asked for, without the trailing dot.
+	// It reports whether the name is one of a machine and the machine's
+	// address if known, which is answered with when nothing else is.
+	Queried func(name string) (addr netip.Addr, machine bool)
+
+	mu    sync.Mutex
+	cache map[cacheKey]dnsmessage.Message
+}
+
+// cacheKey identifies the answer to a question
+type cacheKey struct {
+	name string
+	typ  dnsmessage.Type
+}
+
+// Serve answers queries until the context is done. Queries are accepted
+// over UDP and over TCP, which clients retry with when an answer was
+// truncated, and forwarded the same way they came in.
+func (f *Forwarder) Serve(ctx context.Context) error {
+	f.cache = make(map[cacheKey]dnsmessage.Message)
+	var lc net.ListenConfig
+	conn, err := lc.ListenPacket(ctx, "udp", f.Listen)
+	if err != nil {
+		return err
+	}
+	listener, err := lc.Listen(ctx, "tcp", f.Listen)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+		listener.Close()
+	}()
+
+	errs := make(chan error, 2)
+	go func() { errs <- f.serveUDP(ctx, conn) }()
+	go func() { errs <- f.serveTCP(ctx, listener) }()
+	err = <-errs
+	// Both stop once either failed
+	conn.Close()
+	listener.Close()
+	<-errs
+	return err
+}
+
+// serveUDP answers queries sent over UDP
+func (f *Forwarder) serveUDP(ctx context.Context, conn net.PacketConn) error {
+	buf := make([]byte, 4096)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !f.allowed(from) {
+			continue
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if response := f.answer(ctx, "udp", query); response != nil {
+				conn.WriteTo(response, from)
+			}
+		}()
+	}
+}
+
+// serveTCP answers queries sent over TCP connections
+func (f *Forwarder) serveTCP(ctx context.Context, listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		if !f.allowed(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+		go f.handleTCP(ctx, conn)
+	}
+}
+
+// handleTCP answers the queries of a TCP connection one after another until
+// the client closes it or stays idle
+func (f *Forwarder) handleTCP(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+		response := f.answer(ctx, "tcp", query)
+		if response == nil {
+			return
+		}
+		if err := writeTCPMessage(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// readTCPMessage reads a message prefixed with its length as sent over TCP
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage writes a message prefixed with its length as sent over TCP
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(msg)), uint16(len(msg))))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	return err
+}
+
+// allowed reports whether the client with the address may send queries
+func (f *Forwarder) allowed(from net.Addr) bool {
+	if f.Allowed == nil {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(from.String())
+	if err != nil {
+		return false
+	}
+	return f.Allowed(addrPort.Addr().Unmap())
+}
+
+// answer returns the response to the query received over the network, udp
+// or tcp, nil if there is none
+func (f *Forwarder) answer(ctx context.Context, network string, query []byte) []byte {
+	var q dnsmessage.Message
+	if err := q.Unpack(query); err != nil || q.Header.Response || len(q.Questions) != 1 {
+		return f.exchange(ctx, network, query)
+	}
+	question := q.Questions[0]
+	name := strings.TrimSuffix(question.Name.String(), ".")
+	addr, machine := f.Queried(name)
+	response := f.exchange(ctx, network, query)
+	if !machine {
+		return response
+	}
+
+	key := cacheKey{name: strings.ToLower(name), typ: question.Type}
+	var upstream dnsmessage.Message
+	if response != nil && upstream.Unpack(response) == nil && upstream.Header.RCode == dnsmessage.RCodeSuccess && len(upstream.Answers) > 0 {
+		f.mu.Lock()
+		f.cache[key] = upstream
+		f.mu.Unlock()
+		return response
+	}
+
+	f.mu.Lock()
+	cached, ok := f.cache[key]
+	f.mu.Unlock()
+	if !ok {
+		if !addr.Is4() || question.Type != dnsmessage.TypeA {
+			return response
+		}
+		cached.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.AResource{A: addr.As4()},
+		}}
+	}
+	stale := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.Header.ID, Response: true, RecursionDesired: q.Header.RecursionDesired, RecursionAvailable: true},
+		Questions: q.Questions,
+	}
+	for _, answer := range cached.Answers {
+		answer.Header.TTL = staleTTL
+		stale.Answers = append(stale.Answers, answer)
+	}
+	packed, err := stale.Pack()
+	if err != nil {
+		return response
+	}
+	return packed
+}
+
+// exchange forwards the query to the upstream server over the network, udp
+// or tcp, nil if it didn't answer
+func (f *Forwarder) exchange(ctx context.Context, network string, query []byte) []byte {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, f.Upstream)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil
+		}
+		response, err := readTCPMessage(conn)
+		if err != nil {
+			return nil
+		}
+		return response
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil
+		}
+		// Responses to other queries, e.g. late ones, are skipped
+		if n >= 2 && len(query) >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+			return buf[:n]
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package dnsforward
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// freeUDPAddress returns a local address nothing listens on
+func freeUDPAddress(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+// fakeUpstream answers every query with an empty successful response over
+// UDP and TCP, the UDP ones are truncated if truncate is set
+func fakeUpstream(t *testing.T, truncate bool) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	respond := func(query []byte, truncated bool) []byte {
+		var q dnsmessage.Message
+		if q.Unpack(query) != nil {
+			return nil
+		}
+		q.Header.Response = true
+		q.Header.Truncated = truncated
+		response, _ := q.Pack()
+		return response
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := respond(buf[:n], truncate); response != nil {
+				conn.WriteTo(response, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			query, err := readTCPMessage(c)
+			if err == nil {
+				writeTCPMessage(c, respond(query, false))
+			}
+			c.Close()
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// query sends a query to the forwarder and reports whether it was answered
+func query(t *testing.T, address string) bool {
+	t.Helper()
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	packed, _ := msg.Pack()
+	buf := make([]byte, 512)
+	// Retried as the forwarder may not be listening yet
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		conn.Write(packed)
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := conn.Read(buf); err == nil {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestServeDropsQueriesOfClientsNotAllowed(t *testing.T) {
+	for _, allowed := range []bool{true, false} {
+		ctx, cancel := context.WithCancel(context.Background())
+		f := &Forwarder{
+			Listen:   freeUDPAddress(t),
+			Upstream: fakeUpstream(t, false),
+			Timeout:  time.Second,
+			Queried:  func(string) (netip.Addr, bool) { return netip.Addr{}, false },
+			Allowed:  func(netip.Addr) bool { return allowed },
+		}
+		go f.Serve(ctx)
+
+		if answered := query(t, f.Listen); answered != allowed {
+			t.Errorf("query of client allowed %v was answered %v", allowed, answered)
+		}
+		cancel()
+	}
+}
+
+func TestServeForwardsTruncatedAnswersOverTCP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := &Forwarder{
+		Listen:   freeUDPAddress(t),
+		Upstream: fakeUpstream(t, true),
+		Timeout:  time.Second,
+		Queried:  func(string) (netip.Addr, bool) { return netip.Addr{}, false },
+	}
+	go f.Serve(ctx)
+	if !query(t, f.Listen) {
+		t.Fatal("query over UDP wasn't answered")
+	}
+
+	var conn net.Conn
+	var err error
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		conn, err = net.Dial("tcp", f.Listen)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 43, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("example.com."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}},
+	}
+	packed, _ := msg.Pack()
+	if err := writeTCPMessage(conn, packed); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := readTCPMessage(conn)
+	if err != nil {
+		t.Fatalf("query over TCP wasn't answered: %v", err)
+	}
+	var response dnsmessage.Message
+	if err := response.Unpack(raw); err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.ID != 43 || response.Header.Truncated {
+		t.Errorf("answer over TCP has ID %d and truncated %v, want 43 and false", response.Header.ID, response.Header.Truncated)
+	}
+}
@@ -0,0 +1,342 @@
+// Package fdb reads the forwarding tables of managed switches over SNMP to
+// find the ports MAC addresses were learned on, using the Q-BRIDGE-MIB and
+// falling back to the BRIDGE-MIB for switches without VLANs
+package fdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// Port is where agents listen unless the address has another one
+const Port = 161
+
+// OIDs of the tables that are walked
+const (
+	// dot1qTpFdbPort is the bridge port by FDB ID and MAC address
+	dot1qTpFdbPort = ".1.3.6.1.2.1.17.7.1.2.2.1.2"
+	// dot1qVlanFdbId is the FDB ID by time mark and VLAN
+	dot1qVlanFdbId = ".1.3.6.1.2.1.17.7.1.4.2.1.4"
+	// dot1dTpFdbPort is the bridge port by MAC address
+	dot1dTpFdbPort = ".1.3.6.1.2.1.17.4.3.1.2"
+	// dot1dBasePortIfIndex is the interface by bridge port
+	dot1dBasePortIfIndex = ".1.3.6.1.2.1.17.1.4.1.2"
+	// ifName is the short name of an interface, e.g. Gi1/0/5
+	ifName = ".1.3.6.1.2.1.31.1.1.1.1"
+	// ifDescr is the description of an interface for agents without ifName
+	ifDescr = ".1.3.6.1.2.1.2.2.1.2"
+	// ifAlias is the description of an interface given by an administrator
+	ifAlias = ".1.3.6.1.2.1.31.1.1.1.18"
+)
+
+// authProtocols maps the names of authentication protocols to their values
+var authProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"":       gosnmp.NoAuth,
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+// privacyProtocols maps the names of privacy protocols to their values
+var privacyProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"":        gosnmp.NoPriv,
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
+// Config represents the agent of a switch
+type Config struct {
+	// Address of the agent, a host with an optional port
+	Address string
+	// Version of the protocol: 1, 2c or 3, defaults to 2c
+	Version string
+	// Community of version 1 and 2c requests, defaults to public
+	Community string
+	// Username of version 3 requests
+	Username string
+	// AuthProtocol of version 3 requests, e.g. SHA256, no authentication if empty
+	AuthProtocol string
+	// AuthPassword of version 3 requests
+	AuthPassword string
+	// PrivacyProtocol of version 3 requests, e.g. AES, no encryption if empty
+	PrivacyProtocol string
+	// PrivacyPassword of version 3 requests
+	PrivacyPassword string
+	// Timeout of a single request
+	Timeout time.Duration
+}
+
+// Entry is a MAC address learned by the switch
+type Entry struct {
+	// MAC address, formatted by net.HardwareAddr
+	MAC string
+	// Port is the name of the interface the address was learned on
+	Port string
+	// Description of the interface, empty if none was given
+	Description string
+	// VLAN the address was learned in, 0 if the switch doesn't tell
+	VLAN int
+}
+
+// Switch reads the forwarding table of a switch
+type Switch struct {
+	host   string
+	port   uint16
+	config Config
+}
+
+// New returns a switch reading its forwarding table with the config
+func New(config Config) (*Switch, error) {
+	if config.Address == "" {
+		return nil, errors.New("address is required")
+	}
+	host, port := config.Address, uint64(Port)
+	if h, p, err := net.SplitHostPort(config.Address); err == nil {
+		host = h
+		port, err = strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", p)
+		}
+	}
+
+	if config.Version == "" {
+		config.Version = "2c"
+	}
+	if config.Community == "" {
+		config.Community = "public"
+	}
+	config.AuthProtocol = strings.ToUpper(config.AuthProtocol)
+	config.PrivacyProtocol = strings.ToUpper(config.PrivacyProtocol)
+	switch config.Version {
+	case "1", "2c":
+	case "3":
+		if config.Username == "" {
+			return nil, errors.New("username is required for version 3")
+		}
+		if _, ok := authProtocols[config.AuthProtocol]; !ok {
+			return nil, fmt.Errorf("unknown auth protocol %q", config.AuthProtocol)
+		}
+		if _, ok := privacyProtocols[config.PrivacyProtocol]; !ok {
+			return nil, fmt.Errorf("unknown privacy protocol %q", config.PrivacyProtocol)
+		}
+		if config.PrivacyProtocol != "" && config.AuthProtocol == "" {
+			return nil, errors.New("privacy protocol needs an auth protocol")
+		}
+	default:
+		return nil, fmt.Errorf("unknown version %q, must be 1, 2c or 3", config.Version)
+	}
+	return &Switch{host: host, port: uint16(port), config: config}, nil
+}
+
+// client returns the client of a single read
+func (s *Switch) client(ctx context.Context) *gosnmp.GoSNMP {
+	c := s.config
+	client := &gosnmp.GoSNMP{
+		Target:    s.host,
+		Port:      s.port,
+		Community: c.Community,
+		Timeout:   c.Timeout,
+		Retries:   1,
+		Context:   ctx,
+		MaxOids:   gosnmp.MaxOids,
+		// Forwarding tables of large switches take many requests otherwise
+		MaxRepetitions: 50,
+	}
+	switch c.Version {
+	case "1":
+		client.Version = gosnmp.Version1
+	case "2c":
+		client.Version = gosnmp.Version2c
+	case "3":
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = gosnmp.NoAuthNoPriv
+		if c.AuthProtocol != "" {
+			client.MsgFlags = gosnmp.AuthNoPriv
+		}
+		if c.PrivacyProtocol != "" {
+			client.MsgFlags = gosnmp.AuthPriv
+		}
+		client.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 c.Username,
+			AuthenticationProtocol:   authProtocols[c.AuthProtocol],
+			AuthenticationPassphrase: c.AuthPassword,
+			PrivacyProtocol:          privacyProtocols[c.PrivacyProtocol],
+			PrivacyPassphrase:        c.PrivacyPassword,
+		}
+	}
+	return client
+}
+
+// Entries returns the MAC addresses in the forwarding table with the ports
+// they were learned on. Addresses of the switch itself are left out.
+func (s *Switch) Entries(ctx context.Context) ([]Entry, error) {
+	client := s.client(ctx)
+	err := client.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Conn.Close()
+
+	type learned struct {
+		mac    string
+		port   int
+		fdbID  int
+		hasFDB bool
+	}
+	var table []learned
+	pdus, err := walk(client, dot1qTpFdbPort)
+	if err != nil {
+		return nil, fmt.Errorf("reading forwarding table: %w", err)
+	}
+	for _, pdu := range pdus {
+		index := suffix(pdu.Name, dot1qTpFdbPort)
+		if len(index) != 7 {
+			continue
+		}
+		table = append(table, learned{mac: mac(index[1:]), port: value(pdu), fdbID: index[0], hasFDB: true})
+	}
+	// Switches without VLANs only have the forwarding table of the BRIDGE-MIB
+	if len(table) == 0 {
+		pdus, err = walk(client, dot1dTpFdbPort)
+		if err != nil {
+			return nil, fmt.Errorf("reading forwarding table: %w", err)
+		}
+		for _, pdu := range pdus {
+			index := suffix(pdu.Name, dot1dTpFdbPort)
+			if len(index) != 6 {
+				continue
+			}
+			table = append(table, learned{mac: mac(index), port: value(pdu)})
+		}
+	}
+	if len(table) == 0 {
+		return nil, nil
+	}
+
+	// FDB IDs usually are the VLAN IDs, unless the switch maps them
+	vlans := make(map[int]int)
+	pdus, _ = walk(client, dot1qVlanFdbId)
+	for _, pdu := range pdus {
+		if index := suffix(pdu.Name, dot1qVlanFdbId); len(index) == 2 {
+			vlans[value(pdu)] = index[1]
+		}
+	}
+	interfaces, err := walkInts(client, dot1dBasePortIfIndex)
+	if err != nil {
+		return nil, fmt.Errorf("reading bridge ports: %w", err)
+	}
+	names := walkStrings(client, ifName)
+	if len(names) == 0 {
+		names = walkStrings(client, ifDescr)
+	}
+	aliases := walkStrings(client, ifAlias)
+
+	entries := make([]Entry, 0, len(table))
+	for _, l := range table {
+		// Port 0 holds the addresses of the switch itself
+		if l.port == 0 {
+			continue
+		}
+		entry := Entry{MAC: l.mac, Port: strconv.Itoa(l.port)}
+		if ifIndex, ok := interfaces[l.port]; ok {
+			if name := names[ifIndex]; name != "" {
+				entry.Port = name
+			}
+			entry.Description = aliases[ifIndex]
+		}
+		if l.hasFDB {
+			entry.VLAN = l.fdbID
+			if vlan, ok := vlans[l.fdbID]; ok {
+				entry.VLAN = vlan
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// walk returns the values of the subtree, with bulk requests unless the
+// agent only speaks version 1
+func walk(client *gosnmp.GoSNMP, oid string) ([]gosnmp.SnmpPDU, error) {
+	if client.Version == gosnmp.Version1 {
+		return client.WalkAll(oid)
+	}
+	return client.BulkWalkAll(oid)
+}
+
+// walkInts returns the integer values of a table by their index
+func walkInts(client *gosnmp.GoSNMP, oid string) (map[int]int, error) {
+	pdus, err := walk(client, oid)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[int]int, len(pdus))
+	for _, pdu := range pdus {
+		if index := suffix(pdu.Name, oid); len(index) == 1 {
+			values[index[0]] = value(pdu)
+		}
+	}
+	return values, nil
+}
+
+// walkStrings returns the text values of a table by their index, none if
+// the agent doesn't have the table
+func walkStrings(client *gosnmp.GoSNMP, oid string) map[int]string {
+	pdus, _ := walk(client, oid)
+	values := make(map[int]string, len(pdus))
+	for _, pdu := range pdus {
+		index := suffix(pdu.Name, oid)
+		if b, ok := pdu.Value.([]byte); ok && len(index) == 1 {
+			values[index[0]] = strings.TrimSpace(string(b))
+		}
+	}
+	return values
+}
+
+// suffix returns the index of a value of the table, nil if the value isn't
+// part of it or the index isn't numeric
+func suffix(name, oid string) []int {
+	rest, ok := strings.CutPrefix("."+strings.TrimPrefix(name, "."), oid+".")
+	if !ok {
+		return nil
+	}
+	parts := strings.Split(rest, ".")
+	index := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		index[i] = n
+	}
+	return index
+}
+
+// value returns the integer value of a variable
+func value(pdu gosnmp.SnmpPDU) int {
+	return int(gosnmp.ToBigInt(pdu.Value).Int64())
+}
+
+// mac returns the MAC address encoded in the six parts of an index
+func mac(index []int) string {
+	addr := make(net.HardwareAddr, 6)
+	for i, n := range index {
+		addr[i] = byte(n)
+	}
+	return addr.String()
+}
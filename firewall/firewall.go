@@ -0,0 +1,292 @@
+// Package firewall reads the ARP table and DHCP leases of pfSense and
+// OPNsense firewalls through their APIs, which tells the addresses of devices
+// and whether they are connected from outside their network
+package firewall
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Types of firewalls
+const (
+	// TypeOPNsense uses the API of OPNsense with a key and secret
+	TypeOPNsense = "opnsense"
+	// TypePfSense uses the API of the pfSense REST API package with a key
+	TypePfSense = "pfsense"
+)
+
+// Config describes how to connect to a firewall
+type Config struct {
+	// Type of the firewall, TypeOPNsense or TypePfSense
+	Type string
+	// URL of the web interface, e.g. https://192.168.1.1
+	URL string
+	// Key of the API user
+	Key string
+	// Secret of the API key, OPNsense only
+	Secret string
+	// InsecureSkipVerify accepts the self-signed certificate firewalls come with
+	InsecureSkipVerify bool
+	// Timeout of a single request
+	Timeout time.Duration
+}
+
+// Neighbor is a device known to the firewall
+type Neighbor struct {
+	// MAC address, formatted by net.HardwareAddr
+	MAC string
+	// IP address of the device
+	IP string
+	// Hostname of the device, empty if unknown
+	Hostname string
+	// Present is true if the device is in the ARP table, which keeps
+	// entries for a while after devices stop answering
+	Present bool
+}
+
+// Client talks to a firewall
+type Client struct {
+	config Config
+	base   string
+	client *http.Client
+}
+
+// New checks the configuration and returns a client
+func New(config Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", config.URL)
+	}
+	switch config.Type {
+	case TypeOPNsense:
+		if config.Key == "" || config.Secret == "" {
+			return nil, errors.New("key and secret are required")
+		}
+	case TypePfSense:
+		if config.Key == "" {
+			return nil, errors.New("key is required")
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q, must be opnsense or pfsense", config.Type)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	return &Client{
+		config: config,
+		base:   base.String(),
+		client: &http.Client{Timeout: config.Timeout, Transport: transport},
+	}, nil
+}
+
+// Neighbors returns the devices in the ARP table or with a DHCP lease, the
+// addresses of the ARP table win as they are current
+func (c *Client) Neighbors(ctx context.Context) ([]Neighbor, error) {
+	var arp, leases []Neighbor
+	var err error
+	if c.config.Type == TypeOPNsense {
+		arp, err = c.opnsenseARP(ctx)
+		if err == nil {
+			leases, err = c.opnsenseLeases(ctx)
+		}
+	} else {
+		arp, err = c.pfsenseARP(ctx)
+		if err == nil {
+			leases, err = c.pfsenseLeases(ctx)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var order []string
+	neighbors := make(map[string]Neighbor)
+	for _, list := range [][]Neighbor{leases, arp} {
+		for _, n := range list {
+			mac, err := net.ParseMAC(n.MAC)
+			if err != nil {
+				continue
+			}
+			n.MAC = mac.String()
+			existing, ok := neighbors[n.MAC]
+			if !ok {
+				order = append(order, n.MAC)
+			}
+			if n.Hostname == "" {
+				n.Hostname = existing.Hostname
+			}
+			if n.IP == "" {
+				n.IP = existing.IP
+			}
+			neighbors[n.MAC] = n
+		}
+	}
+
+	result := make([]Neighbor, 0, len(order))
+	for _, mac := range order {
+		result = append(result, neighbors[mac])
+	}
+	return result, nil
+}
+
+// opnsenseARP reads the ARP table of OPNsense, permanent entries are the
+// firewall's own interfaces
+func (c *Client) opnsenseARP(ctx context.Context) ([]Neighbor, error) {
+	var entries []struct {
+		MAC       string `json:"mac"`
+		IP        string `json:"ip"`
+		Hostname  string `json:"hostname"`
+		Expired   bool   `json:"expired"`
+		Permanent bool   `json:"permanent"`
+	}
+	err := c.get(ctx, "/api/diagnostics/interface/getArp", &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ARP table: %w", err)
+	}
+
+	var neighbors []Neighbor
+	for _, e := range entries {
+		if e.Permanent {
+			continue
+		}
+		neighbors = append(neighbors, Neighbor{MAC: e.MAC, IP: e.IP, Hostname: e.Hostname, Present: !e.Expired})
+	}
+	return neighbors, nil
+}
+
+// opnsenseLeases reads the leases of the ISC DHCP server of OPNsense, or of
+// Kea if the ISC server isn't installed
+func (c *Client) opnsenseLeases(ctx context.Context) ([]Neighbor, error) {
+	var isc struct {
+		Rows []struct {
+			Address  string `json:"address"`
+			MAC      string `json:"mac"`
+			Hostname string `json:"hostname"`
+		} `json:"rows"`
+	}
+	err := c.get(ctx, "/api/dhcpv4/leases/searchLease", &isc)
+	if err == nil {
+		var neighbors []Neighbor
+		for _, row := range isc.Rows {
+			neighbors = append(neighbors, Neighbor{MAC: row.MAC, IP: row.Address, Hostname: row.Hostname})
+		}
+		return neighbors, nil
+	}
+	if !errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("failed to read DHCP leases: %w", err)
+	}
+
+	var kea struct {
+		Rows []struct {
+			Address  string `json:"address"`
+			MAC      string `json:"hwaddr"`
+			Hostname string `json:"hostname"`
+		} `json:"rows"`
+	}
+	err = c.get(ctx, "/api/kea/leases4/search", &kea)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DHCP leases: %w", err)
+	}
+	var neighbors []Neighbor
+	for _, row := range kea.Rows {
+		neighbors = append(neighbors, Neighbor{MAC: row.MAC, IP: row.Address, Hostname: strings.TrimSuffix(row.Hostname, ".")})
+	}
+	return neighbors, nil
+}
+
+// pfsenseARP reads the ARP table of pfSense, permanent entries are the
+// firewall's own interfaces
+func (c *Client) pfsenseARP(ctx context.Context) ([]Neighbor, error) {
+	var response struct {
+		Data []struct {
+			MAC      string `json:"mac_address"`
+			IP       string `json:"ip_address"`
+			Hostname string `json:"hostname"`
+			Expires  string `json:"expires"`
+		} `json:"data"`
+	}
+	err := c.get(ctx, "/api/v2/diagnostics/arp_table", &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ARP table: %w", err)
+	}
+
+	var neighbors []Neighbor
+	for _, e := range response.Data {
+		if strings.Contains(strings.ToLower(e.Expires), "permanent") {
+			continue
+		}
+		hostname := e.Hostname
+		if hostname == "?" {
+			hostname = ""
+		}
+		neighbors = append(neighbors, Neighbor{MAC: e.MAC, IP: e.IP, Hostname: hostname, Present: true})
+	}
+	return neighbors, nil
+}
+
+// pfsenseLeases reads the leases of the DHCP server of pfSense
+func (c *Client) pfsenseLeases(ctx context.Context) ([]Neighbor, error) {
+	var response struct {
+		Data []struct {
+			MAC      string `json:"mac"`
+			IP       string `json:"ip"`
+			Hostname string `json:"hostname"`
+		} `json:"data"`
+	}
+	err := c.get(ctx, "/api/v2/status/dhcp_server/leases", &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DHCP leases: %w", err)
+	}
+
+	var neighbors []Neighbor
+	for _, lease := range response.Data {
+		neighbors = append(neighbors, Neighbor{MAC: lease.MAC, IP: lease.IP, Hostname: lease.Hostname})
+	}
+	return neighbors, nil
+}
+
+// errNotFound is returned for endpoints the firewall doesn't have, e.g.
+// because a plugin isn't installed
+var errNotFound = errors.New("not found")
+
+// get requests the path and decodes the JSON response into v
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.config.Type == TypeOPNsense {
+		req.SetBasicAuth(c.config.Key, c.config.Secret)
+	} else {
+		req.Header.Set("X-API-Key", c.config.Key)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errNotFound
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("invalid API key or missing privileges: %s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
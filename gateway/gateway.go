@@ -0,0 +1,148 @@
+// Package gateway forwards TCP connections to a machine that may be asleep,
+// waking it on the first connection and holding connections until it has
+// booted, so clients don't notice it was asleep
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// dialTimeout is how long connecting to the machine may take before it
+	// is considered asleep
+	dialTimeout = 2 * time.Second
+	// retryInterval is how often connecting is retried while the machine boots
+	retryInterval = 2 * time.Second
+)
+
+// Gateway listens for connections on behalf of a machine
+type Gateway struct {
+	// Name of the gateway used in logs, e.g. the machine's name and port
+	Name string
+	// Listen is the address connections are accepted on, e.g. :445
+	Listen string
+	// Target returns the address of the machine connections are forwarded to
+	Target func() (string, error)
+	// Wake starts waking the machine
+	Wake func(ctx context.Context) error
+	// BootTimeout is how long connections are held while the machine boots
+	BootTimeout time.Duration
+
+	mu      sync.Mutex
+	wokenAt time.Time
+}
+
+// Serve accepts connections until the context is done
+func (g *Gateway) Serve(ctx context.Context) error {
+	var lc net.ListenConfig
+	listener, err := lc.Listen(ctx, "tcp", g.Listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		go g.forward(ctx, conn)
+	}
+}
+
+// forward connects the client to the machine, waking it first if it doesn't
+// accept the connection
+func (g *Gateway) forward(ctx context.Context, client net.Conn) {
+	defer client.Close()
+
+	upstream, err := g.dial(ctx)
+	if err != nil {
+		g.wake(ctx)
+		upstream, err = g.waitForBoot(ctx)
+	}
+	if err != nil {
+		log.Printf("Gateway %s dropped connection from %s: %v", g.Name, client.RemoteAddr(), err)
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go pipe(upstream, client, done)
+	go pipe(client, upstream, done)
+	// Both directions are done once the other side closed as well
+	select {
+	case <-done:
+		<-done
+	case <-ctx.Done():
+	}
+}
+
+// dial connects to the machine
+func (g *Gateway) dial(ctx context.Context) (net.Conn, error) {
+	target, err := g.Target()
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Timeout: dialTimeout}
+	return d.DialContext(ctx, "tcp", target)
+}
+
+// wake wakes the machine unless it was woken less than the boot timeout ago,
+// so concurrent connections wake it once
+func (g *Gateway) wake(ctx context.Context) {
+	g.mu.Lock()
+	if time.Since(g.wokenAt) < g.BootTimeout {
+		g.mu.Unlock()
+		return
+	}
+	g.wokenAt = time.Now()
+	g.mu.Unlock()
+
+	if err := g.Wake(ctx); err != nil {
+		log.Printf("Gateway %s failed to wake the machine: %v", g.Name, err)
+	}
+}
+
+// waitForBoot retries connecting to the machine until the boot timeout
+func (g *Gateway) waitForBoot(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.BootTimeout)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.New("machine didn't accept connections in time")
+		case <-time.After(retryInterval):
+		}
+		conn, err := g.dial(ctx)
+		if err == nil {
+			return conn, nil
+		}
+	}
+}
+
+// pipe copies from src to dst, closing dst for writing when src is done
+func pipe(dst, src net.Conn, done chan<- struct{}) {
+	io.Copy(dst, src)
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	done <- struct{}{}
+}
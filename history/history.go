@@ -0,0 +1,301 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Results of a wake attempt
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	machinesBucket    = []byte("machines")
+	transitionsBucket = []byte("transitions")
+	wakesBucket       = []byte("wakes")
+	statusKey         = []byte("status")
+	lastSeenKey       = []byte("last_seen")
+)
+
+// Transition is a change of the status of a machine
+type Transition struct {
+	// Time the new status was first seen
+	Time time.Time `json:"time"`
+	// Status of the machine from then on
+	Status string `json:"status"`
+}
+
+// Wake is an attempt to wake a machine
+type Wake struct {
+	// Time of the attempt
+	Time time.Time `json:"time"`
+	// User who woke the machine
+	User string `json:"user,omitempty"`
+	// Result of the attempt, either success or failure
+	Result string `json:"result"`
+	// Message with details, e.g. the error of a failed attempt
+	Message string `json:"message,omitempty"`
+}
+
+// Store keeps the status history of machines in a bbolt database, so it
+// survives restarts
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the database at path, creating it if needed
+func Open(path string) (*Store, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// Only one process can have the database open, don't wait forever for it
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(machinesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize history database: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// machineKey returns the key of a machine, names are case insensitive
+func machineKey(name string) []byte {
+	return []byte(strings.ToLower(name))
+}
+
+// machineBucket returns the bucket of the machine, creating it if needed
+func machineBucket(tx *bolt.Tx, name string) (*bolt.Bucket, error) {
+	b, err := tx.Bucket(machinesBucket).CreateBucketIfNotExists(machineKey(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range [][]byte{transitionsBucket, wakesBucket} {
+		if _, err := b.CreateBucketIfNotExists(sub); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// entryKey returns a key sorting entries by time, the sequence keeps entries
+// recorded at the same time apart
+func entryKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// timeKey returns the smallest key of entries recorded at t or later
+func timeKey(t time.Time) []byte {
+	return entryKey(t, 0)
+}
+
+// put stores the value in the bucket under a new key for the time
+func put(b *bolt.Bucket, t time.Time, v any) error {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(entryKey(t, seq), data)
+}
+
+// RecordStatuses records the statuses of machines probed at the given time,
+// a transition is only stored when the status of a machine changed
+func (s *Store) RecordStatuses(statuses map[string]string, at time.Time) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for name, status := range statuses {
+			b, err := machineBucket(tx, name)
+			if err != nil {
+				return err
+			}
+			if status == "online" {
+				seen, err := at.MarshalBinary()
+				if err != nil {
+					return err
+				}
+				if err := b.Put(lastSeenKey, seen); err != nil {
+					return err
+				}
+			}
+			if string(b.Get(statusKey)) == status {
+				continue
+			}
+			if err := b.Put(statusKey, []byte(status)); err != nil {
+				return err
+			}
+			err = put(b.Bucket(transitionsBucket), at, Transition{Time: at, Status: status})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record statuses: %w", err)
+	}
+	return nil
+}
+
+// RecordWake records an attempt to wake the machine, the time is set if missing
+func (s *Store) RecordWake(name string, w Wake) error {
+	if w.Time.IsZero() {
+		w.Time = time.Now()
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := machineBucket(tx, name)
+		if err != nil {
+			return err
+		}
+		return put(b.Bucket(wakesBucket), w.Time, w)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record wake: %w", err)
+	}
+	return nil
+}
+
+// Transitions returns the status changes of the machine since the given time,
+// oldest first. The last change before it is included as well, so callers
+// know the status the machine had at that time
+func (s *Store) Transitions(name string, since time.Time) ([]Transition, error) {
+	var transitions []Transition
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(machinesBucket).Bucket(machineKey(name))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Bucket(transitionsBucket).Cursor()
+		k, v := c.Seek(timeKey(since))
+		if k == nil {
+			// Everything is older, the latest entry is still the current status
+			k, v = c.Last()
+		} else if pk, pv := c.Prev(); pk != nil {
+			k, v = pk, pv
+		} else {
+			k, v = c.First()
+		}
+		for ; k != nil; k, v = c.Next() {
+			var t Transition
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			transitions = append(transitions, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transitions: %w", err)
+	}
+	return transitions, nil
+}
+
+// Wakes returns the most recent attempts to wake the machine, newest first,
+// a limit of zero returns all of them
+func (s *Store) Wakes(name string, limit int) ([]Wake, error) {
+	var wakes []Wake
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(machinesBucket).Bucket(machineKey(name))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Bucket(wakesBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(wakes) >= limit {
+				break
+			}
+			var w Wake
+			if err := json.Unmarshal(v, &w); err != nil {
+				return err
+			}
+			wakes = append(wakes, w)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wakes: %w", err)
+	}
+	return wakes, nil
+}
+
+// LastSeen returns when the machine was last online, the zero time if never
+func (s *Store) LastSeen(name string) (time.Time, error) {
+	var seen time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(machinesBucket).Bucket(machineKey(name))
+		if b == nil {
+			return nil
+		}
+		if v := b.Get(lastSeenKey); v != nil {
+			return seen.UnmarshalBinary(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read last seen: %w", err)
+	}
+	return seen, nil
+}
+
+// Prune deletes transitions and wakes older than the given time. The latest
+// transition of each machine is kept so its current status stays known
+func (s *Store) Prune(before time.Time) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(machinesBucket).ForEachBucket(func(name []byte) error {
+			b := tx.Bucket(machinesBucket).Bucket(name)
+			end := timeKey(before)
+			for _, sub := range [][]byte{transitionsBucket, wakesBucket} {
+				var stale [][]byte
+				c := b.Bucket(sub).Cursor()
+				for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+					stale = append(stale, slices.Clone(k))
+				}
+				if slices.Equal(sub, transitionsBucket) && len(stale) > 0 {
+					if last, _ := c.Last(); last != nil && slices.Equal(last, stale[len(stale)-1]) {
+						stale = stale[:len(stale)-1]
+					}
+				}
+				for _, k := range stale {
+					if err := b.Bucket(sub).Delete(k); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune history: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package history
+
+import (
+	"time"
+)
+
+// Statuses counted when computing uptime, other statuses such as unknown are
+// left out as the machine may have been up or down
+const (
+	statusOnline  = "online"
+	statusOffline = "offline"
+)
+
+// Uptime returns the fraction of time between from and to the machine was
+// online, out of the time its status was known. The transitions must be
+// oldest first and include the last one before from, as returned by
+// Transitions. ok is false when the status was never known in that period
+func Uptime(transitions []Transition, from, to time.Time) (uptime float64, ok bool) {
+	var online, known time.Duration
+	for i, t := range transitions {
+		start := t.Time
+		if start.Before(from) {
+			start = from
+		}
+		end := to
+		if i+1 < len(transitions) && transitions[i+1].Time.Before(to) {
+			end = transitions[i+1].Time
+		}
+		if !end.After(start) {
+			continue
+		}
+
+		switch t.Status {
+		case statusOnline:
+			online += end.Sub(start)
+			known += end.Sub(start)
+		case statusOffline:
+			known += end.Sub(start)
+		}
+	}
+	if known == 0 {
+		return 0, false
+	}
+	return float64(online) / float64(known), true
+}
+
+// Slot is the uptime of a part of a period
+type Slot struct {
+	// Start of the slot
+	Start time.Time
+	// Uptime of the machine in the slot, see Uptime
+	Uptime float64
+	// Known is false when the status was never known in the slot
+	Known bool
+}
+
+// Slots splits the period between from and to into n slots of equal length
+// and returns the uptime of each, e.g. to draw a chart
+func Slots(transitions []Transition, from, to time.Time, n int) []Slot {
+	slots := make([]Slot, n)
+	length := to.Sub(from) / time.Duration(n)
+	for i := range slots {
+		start := from.Add(time.Duration(i) * length)
+		uptime, ok := Uptime(transitions, start, start.Add(length))
+		slots[i] = Slot{Start: start, Uptime: uptime, Known: ok}
+	}
+	return slots
+}
+
+// BootTimes returns how long the machine took to come online after each
+// successful wake while it was offline. Wakes without the machine coming
+// online within the timeout are left out. The transitions must be oldest
+// first and cover the wakes
+func BootTimes(wakes []Wake, transitions []Transition, timeout time.Duration) []time.Duration {
+	var durations []time.Duration
+	for _, wake := range wakes {
+		if wake.Result != ResultSuccess {
+			continue
+		}
+
+		// Find the status at the time of the wake and the first time it was online after
+		status := ""
+		for _, t := range transitions {
+			if !t.Time.After(wake.Time) {
+				status = t.Status
+				continue
+			}
+			if status == statusOnline {
+				break
+			}
+			if t.Status == statusOnline {
+				if boot := t.Time.Sub(wake.Time); boot <= timeout {
+					durations = append(durations, boot)
+				}
+				break
+			}
+		}
+	}
+	return durations
+}
@@ -0,0 +1,118 @@
+// Package i18n translates the text of the web interface. Translations are
+// looked up by the English text, which is shown when there is none.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// English is the language the text is written in
+const English = "en"
+
+//go:embed locales/*.json
+var locales embed.FS
+
+var (
+	// catalogs holds the translations of each language by English text
+	catalogs = map[string]map[string]string{English: nil}
+	// languages are the codes of the bundled languages, English first
+	languages []string
+	matcher   language.Matcher
+)
+
+func init() {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		data, err := locales.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		err = json.Unmarshal(data, &catalog)
+		if err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog %s: %v", file.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(file.Name(), ".json")] = catalog
+	}
+
+	for lang := range catalogs {
+		if lang != English {
+			languages = append(languages, lang)
+		}
+	}
+	sort.Strings(languages)
+	languages = append([]string{English}, languages...)
+
+	tags := make([]language.Tag, len(languages))
+	for i, lang := range languages {
+		tags[i] = language.Make(lang)
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+// Languages returns the codes of the bundled languages, English first
+func Languages() []string {
+	return append([]string(nil), languages...)
+}
+
+// Supported reports whether the language is bundled
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Match returns the bundled language best matching an Accept-Language
+// header, English if none does
+func Match(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return English
+	}
+	_, i, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return English
+	}
+	return languages[i]
+}
+
+// Translator translates text to a language
+type Translator struct {
+	lang    string
+	catalog map[string]string
+}
+
+// New returns a translator to the language, English if it isn't bundled
+func New(lang string) Translator {
+	catalog, ok := catalogs[lang]
+	if !ok {
+		lang = English
+	}
+	return Translator{lang: lang, catalog: catalog}
+}
+
+// Lang returns the code of the language
+func (t Translator) Lang() string {
+	return t.lang
+}
+
+// T returns the translation of the English text, formatted with fmt.Sprintf
+// if there are any args
+func (t Translator) T(text string, args ...interface{}) string {
+	if translated, ok := t.catalog[text]; ok && translated != "" {
+		text = translated
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
@@ -0,0 +1,72 @@
+// Package jsonfile stores state in JSON files that are safe to share between
+// the server and CLI commands
+package jsonfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// Read decodes the file at path into v if it changed since modTime and
+// returns the new modification time, a missing file resets v to its zero value
+func Read(path string, modTime time.Time, v interface{}) (time.Time, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, json.Unmarshal([]byte("null"), v)
+	}
+	if err != nil {
+		return modTime, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.ModTime().Equal(modTime) {
+		return modTime, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return modTime, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// Start from scratch as decoding into a map would merge with old entries
+	reflect.ValueOf(v).Elem().SetZero()
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return modTime, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return info.ModTime(), nil
+}
+
+// Write atomically replaces the file at path with v encoded as JSON, readable
+// only by the owner as some of these files contain secrets
+func Write(path string, v interface{}) (time.Time, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to marshal %s: %w", path, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o600)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return info.ModTime(), nil
+}
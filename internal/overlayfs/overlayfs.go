@@ -0,0 +1,64 @@
+// Package overlayfs layers a file system over another one, files of the upper
+// file system take precedence and the lower one provides the rest
+package overlayfs
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// FS is a file system reading from Upper first and from Lower if a file
+// doesn't exist there, directories list the files of both
+type FS struct {
+	Upper fs.FS
+	Lower fs.FS
+}
+
+// New returns a file system layering upper over lower
+func New(upper, lower fs.FS) *FS {
+	return &FS{Upper: upper, Lower: lower}
+}
+
+// Open opens the file of the upper file system if it exists, otherwise the
+// one of the lower file system
+func (o *FS) Open(name string) (fs.File, error) {
+	f, err := o.Upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.Lower.Open(name)
+}
+
+// ReadDir lists the files of the directory in both file systems, sorted by
+// name, entries of the upper file system replace the ones of the lower one
+func (o *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(o.Upper, name)
+	if upperErr != nil && !errors.Is(upperErr, fs.ErrNotExist) {
+		return nil, upperErr
+	}
+	lower, lowerErr := fs.ReadDir(o.Lower, name)
+	if lowerErr != nil && !errors.Is(lowerErr, fs.ErrNotExist) {
+		return nil, lowerErr
+	}
+	if upperErr != nil && lowerErr != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make(map[string]fs.DirEntry, len(upper)+len(lower))
+	for _, entry := range lower {
+		entries[entry.Name()] = entry
+	}
+	for _, entry := range upper {
+		entries[entry.Name()] = entry
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
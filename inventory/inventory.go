@@ -0,0 +1,142 @@
+// Package inventory persists the machines managed from the web interface and
+// API, separately from the ones defined in the config file
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/internal/jsonfile"
+)
+
+// Errors returned when changing machines
+var (
+	ErrNotFound = errors.New("machine not found")
+	ErrExists   = errors.New("machine already exists")
+)
+
+// Store manages machines persisted in a JSON file
+type Store struct {
+	mu       sync.Mutex
+	path     string
+	machines []config.Machine
+	modTime  time.Time
+}
+
+// NewStore creates a new Store backed by the file at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// List returns all stored machines
+func (s *Store) List() ([]config.Machine, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]config.Machine(nil), s.machines...), nil
+}
+
+// Add stores a new machine, names are unique regardless of case
+func (s *Store) Add(machine config.Machine) error {
+	err := machine.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.reload()
+	if err != nil {
+		return err
+	}
+
+	if s.index(machine.Name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrExists, machine.Name)
+	}
+	s.machines = append(s.machines, machine)
+	return s.save()
+}
+
+// Update replaces the machine with the given name, which may be renamed
+func (s *Store) Update(name string, machine config.Machine) error {
+	err := machine.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.reload()
+	if err != nil {
+		return err
+	}
+
+	i := s.index(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if j := s.index(machine.Name); j >= 0 && j != i {
+		return fmt.Errorf("%w: %s", ErrExists, machine.Name)
+	}
+	s.machines[i] = machine
+	return s.save()
+}
+
+// Delete removes the machine with the given name
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return err
+	}
+
+	i := s.index(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	s.machines = append(s.machines[:i:i], s.machines[i+1:]...)
+	return s.save()
+}
+
+// index returns the position of the machine with the given name or -1, callers must hold the lock
+func (s *Store) index(name string) int {
+	for i, machine := range s.machines {
+		if strings.EqualFold(machine.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// reload reads the machines file if it changed since it was last read, callers must hold the lock
+func (s *Store) reload() error {
+	modTime, err := jsonfile.Read(s.path, s.modTime, &s.machines)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
+
+// save writes the machines file, callers must hold the lock
+func (s *Store) save() error {
+	modTime, err := jsonfile.Write(s.path, s.machines)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
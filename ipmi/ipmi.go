@@ -0,0 +1,94 @@
+// Package ipmi controls the chassis power of servers through their baseboard
+// management controller (BMC) over IPMI, with ipmitool
+package ipmi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// waitDelay is how long ipmitool may keep its output open after being killed
+const waitDelay = time.Second
+
+// Chassis power actions
+const (
+	// PowerOn turns the chassis on
+	PowerOn = "on"
+	// PowerSoft asks the operating system to shut down through ACPI
+	PowerSoft = "soft"
+	// PowerCycle turns the chassis off and on again after a second
+	PowerCycle = "cycle"
+)
+
+// BMC is the baseboard management controller of a server
+type BMC struct {
+	// Address of the BMC, a hostname or IP address
+	Address string
+	// Username of a BMC account allowed to control power
+	Username string
+	// Password of the account, passed to ipmitool in the environment
+	Password string
+	// Timeout of a single command
+	Timeout time.Duration
+}
+
+// PoweredOn reports whether the chassis is on
+func (b BMC) PoweredOn(ctx context.Context) (bool, error) {
+	output, err := b.ipmitool(ctx, "chassis", "power", "status")
+	if err != nil {
+		return false, err
+	}
+	// e.g. "Chassis Power is on"
+	switch {
+	case strings.HasSuffix(output, " on"):
+		return true, nil
+	case strings.HasSuffix(output, " off"):
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected power status %q", output)
+}
+
+// Power runs the chassis power action, PowerOn, PowerSoft or PowerCycle
+func (b BMC) Power(ctx context.Context, action string) error {
+	_, err := b.ipmitool(ctx, "chassis", "power", action)
+	return err
+}
+
+// ipmitool runs ipmitool with the arguments over IPMI v2.0 and returns its
+// trimmed output
+func (b BMC) ipmitool(ctx context.Context, args ...string) (string, error) {
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+
+	// -E reads the password from IPMI_PASSWORD so it isn't visible in ps
+	args = append([]string{"-I", "lanplus", "-H", b.Address, "-U", b.Username, "-E"}, args...)
+	cmd := exec.CommandContext(ctx, "ipmitool", args...)
+	cmd.Env = append(os.Environ(), "IPMI_PASSWORD="+b.Password)
+	cmd.WaitDelay = waitDelay
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return "", errors.New("ipmitool timed out")
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.New("ipmitool is not installed")
+	}
+	if err != nil {
+		message := strings.ReplaceAll(strings.TrimSpace(stderr.String()), "\n", ": ")
+		if message == "" {
+			message = err.Error()
+		}
+		return "", fmt.Errorf("ipmitool failed: %s", message)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
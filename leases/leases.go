@@ -0,0 +1,296 @@
+// Package leases reads the lease files of DHCP servers to find the addresses
+// handed out to devices on the network
+package leases
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of lease files
+const (
+	// FormatDnsmasq is the lease file of dnsmasq, e.g. /tmp/dhcp.leases on OpenWrt
+	FormatDnsmasq = "dnsmasq"
+	// FormatOdhcpd is the lease file of odhcpd, e.g. /tmp/hosts/odhcpd on OpenWrt
+	FormatOdhcpd = "odhcpd"
+	// FormatKea is the CSV lease file of the Kea DHCPv4 server
+	FormatKea = "kea"
+)
+
+// Lease is an address handed out to a device
+type Lease struct {
+	// MAC is the hardware address of the device, formatted by net.HardwareAddr
+	MAC string
+	// IP is the address handed out
+	IP string
+	// Hostname is the name the device sent, empty if it didn't
+	Hostname string
+	// Expires is when the lease ends, zero if it doesn't
+	Expires time.Time
+}
+
+// Expired reports whether the lease ended before now
+func (l Lease) Expired(now time.Time) bool {
+	return !l.Expires.IsZero() && l.Expires.Before(now)
+}
+
+// Parse reads the leases of a file in the given format, expired leases and
+// leases of IPv6 addresses are left out
+func Parse(format string, r io.Reader) ([]Lease, error) {
+	var leases []Lease
+	var err error
+	switch format {
+	case FormatDnsmasq:
+		leases, err = parseDnsmasq(r)
+	case FormatOdhcpd:
+		leases, err = parseOdhcpd(r)
+	case FormatKea:
+		leases, err = parseKea(r)
+	default:
+		return nil, fmt.Errorf("unknown lease file format %q, must be dnsmasq, odhcpd or kea", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := leases[:0]
+	for _, lease := range leases {
+		if !lease.Expired(now) {
+			active = append(active, lease)
+		}
+	}
+	return active, nil
+}
+
+// parseMAC formats a hardware address like net.HardwareAddr, false if it
+// isn't one
+func parseMAC(s string) (string, bool) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return "", false
+	}
+	return mac.String(), true
+}
+
+// parseExpiry converts a Unix time to when a lease expires, zero and
+// negative times never expire
+func parseExpiry(s string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q", s)
+	}
+	if seconds <= 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// parseDnsmasq reads lines of "<expiry> <mac> <ip> <hostname> <client id>",
+// the lines of DHCPv6 leases have no MAC and are skipped
+func parseDnsmasq(r io.Reader) ([]Lease, error) {
+	var leases []Lease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		mac, ok := parseMAC(fields[1])
+		if !ok {
+			continue
+		}
+		expires, err := parseExpiry(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		lease := Lease{MAC: mac, IP: fields[2], Expires: expires}
+		if fields[3] != "*" {
+			lease.Hostname = fields[3]
+		}
+		leases = append(leases, lease)
+	}
+	return leases, scanner.Err()
+}
+
+// parseOdhcpd reads lines of "# <interface> <mac> ipv4 <hostname> <expiry>
+// <id> <prefix length> <ip>/<prefix length>", DHCPv6 leases have a DUID
+// instead of ipv4 and are skipped
+func parseOdhcpd(r io.Reader) ([]Lease, error) {
+	var leases []Lease
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 9 || fields[0] != "#" || fields[3] != "ipv4" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 6 {
+			continue
+		}
+		expires, err := parseExpiry(fields[5])
+		if err != nil {
+			return nil, err
+		}
+		ip, _, _ := strings.Cut(fields[8], "/")
+		lease := Lease{MAC: net.HardwareAddr(raw).String(), IP: ip, Expires: expires}
+		if fields[4] != "-" {
+			lease.Hostname = fields[4]
+		}
+		leases = append(leases, lease)
+	}
+	return leases, scanner.Err()
+}
+
+// keaStateDefault is the state of leases in use
+const keaStateDefault = "0"
+
+// parseKea reads the CSV memfile of Kea, which is only appended to: the last
+// row of an address is its current lease and released leases have no
+// lifetime left
+func parseKea(r io.Reader) ([]Lease, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"address", "hwaddr", "expire", "hostname", "state"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var order []string
+	current := make(map[string]*Lease)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < len(header) {
+			continue
+		}
+		address := record[columns["address"]]
+		if _, ok := current[address]; !ok {
+			order = append(order, address)
+		}
+		current[address] = nil
+
+		mac, ok := parseMAC(record[columns["hwaddr"]])
+		if !ok || record[columns["state"]] != keaStateDefault {
+			continue
+		}
+		if i, ok := columns["valid_lifetime"]; ok && record[i] == "0" {
+			continue
+		}
+		expires, err := parseExpiry(record[columns["expire"]])
+		if err != nil {
+			return nil, err
+		}
+		current[address] = &Lease{
+			MAC:      mac,
+			IP:       address,
+			Hostname: strings.TrimSuffix(record[columns["hostname"]], "."),
+			Expires:  expires,
+		}
+	}
+
+	var leases []Lease
+	for _, address := range order {
+		if lease := current[address]; lease != nil {
+			leases = append(leases, *lease)
+		}
+	}
+	return leases, nil
+}
+
+// File is a lease file read again whenever it changed
+type File struct {
+	mu      sync.Mutex
+	path    string
+	format  string
+	leases  []Lease
+	modTime time.Time
+}
+
+// NewFile creates a File reading the file at path in the given format
+func NewFile(path, format string) (*File, error) {
+	switch format {
+	case FormatDnsmasq, FormatOdhcpd, FormatKea:
+	default:
+		return nil, fmt.Errorf("unknown lease file format %q, must be dnsmasq, odhcpd or kea", format)
+	}
+	if path == "" {
+		return nil, errors.New("path is required")
+	}
+	return &File{path: path, format: format}, nil
+}
+
+// Path returns the path of the file
+func (f *File) Path() string {
+	return f.path
+}
+
+// Leases returns the leases of the file that didn't expire, reading it again
+// if it changed since it was last read. A file that doesn't exist has no
+// leases.
+func (f *File) Leases() ([]Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		f.leases, f.modTime = nil, time.Time{}
+		return nil, nil
+	}
+	if err != nil {
+		return f.active(), err
+	}
+	if !info.ModTime().Equal(f.modTime) {
+		file, err := os.Open(f.path)
+		if err != nil {
+			return f.active(), err
+		}
+		defer file.Close()
+		leases, err := Parse(f.format, file)
+		if err != nil {
+			return f.active(), fmt.Errorf("failed to parse %s: %w", f.path, err)
+		}
+		f.leases, f.modTime = leases, info.ModTime()
+	}
+	return f.active(), nil
+}
+
+// active returns the leases that didn't expire since the file was read,
+// callers must hold the lock
+func (f *File) active() []Lease {
+	now := time.Now()
+	var leases []Lease
+	for _, lease := range f.leases {
+		if !lease.Expired(now) {
+			leases = append(leases, lease)
+		}
+	}
+	return leases
+}
@@ -0,0 +1,130 @@
+// Package libvirt starts, stops and checks libvirt domains, e.g. KVM virtual
+// machines, with virsh so that any connection URI virsh supports works
+package libvirt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultURI connects to the system instance of QEMU on the local host
+const DefaultURI = "qemu:///system"
+
+// waitDelay is how long virsh may keep its output open after being killed
+const waitDelay = time.Second
+
+// State is the state of a domain as reported by virsh domstate
+type State string
+
+// States of domains, others include "in shutdown" and "crashed"
+const (
+	StateRunning   State = "running"
+	StateBlocked   State = "idle"
+	StatePaused    State = "paused"
+	StateSuspended State = "pmsuspended"
+	StateShutOff   State = "shut off"
+)
+
+// Domain is a domain of a libvirt connection
+type Domain struct {
+	// URI of the connection, DefaultURI if empty. Remote hosts are reached
+	// with URIs such as qemu+ssh://root@kvm.lan/system
+	URI string
+	// Name of the domain
+	Name string
+	// Timeout of a single virsh command
+	Timeout time.Duration
+}
+
+// State returns the state of the domain
+func (d Domain) State(ctx context.Context) (State, error) {
+	output, err := d.virsh(ctx, "domstate", d.Name)
+	if err != nil {
+		return "", err
+	}
+	return State(output), nil
+}
+
+// Start starts the domain, resumes it if paused or wakes it if suspended
+func (d Domain) Start(ctx context.Context) error {
+	state, err := d.State(ctx)
+	if err != nil {
+		return err
+	}
+	var command string
+	switch state {
+	case StateRunning, StateBlocked:
+		return nil
+	case StatePaused:
+		command = "resume"
+	case StateSuspended:
+		command = "dompmwakeup"
+	default:
+		command = "start"
+	}
+	_, err = d.virsh(ctx, command, d.Name)
+	return err
+}
+
+// Shutdown asks the guest to shut down
+func (d Domain) Shutdown(ctx context.Context) error {
+	_, err := d.virsh(ctx, "shutdown", d.Name)
+	return err
+}
+
+// Reboot asks the guest to reboot
+func (d Domain) Reboot(ctx context.Context) error {
+	_, err := d.virsh(ctx, "reboot", d.Name)
+	return err
+}
+
+// virsh runs virsh with the arguments on the connection and returns its
+// trimmed output
+func (d Domain) virsh(ctx context.Context, args ...string) (string, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	uri := d.URI
+	if uri == "" {
+		uri = DefaultURI
+	}
+
+	cmd := exec.CommandContext(ctx, "virsh", append([]string{"--quiet", "--connect", uri}, args...)...)
+	cmd.WaitDelay = waitDelay
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("virsh %s timed out", args[0])
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errors.New("virsh is not installed")
+	}
+	if err != nil {
+		return "", fmt.Errorf("virsh %s failed: %s", args[0], virshError(stderr.String(), err))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// virshError returns the messages virsh printed, one per line prefixed with
+// "error:", or err if it printed none
+func virshError(stderr string, err error) string {
+	var messages []string
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "error:"))
+		if line != "" {
+			messages = append(messages, line)
+		}
+	}
+	if len(messages) == 0 {
+		return err.Error()
+	}
+	return strings.Join(messages, ": ")
+}
@@ -0,0 +1,71 @@
+// Package maintenance persists whether the server was put into maintenance
+// mode, in which machines are shown but can't be woken or shut down
+package maintenance
+
+import (
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/internal/jsonfile"
+)
+
+// State describes the maintenance mode
+type State struct {
+	// Enabled is true while the server is in maintenance mode
+	Enabled bool `json:"enabled"`
+	// Message tells users why, e.g. "Electrical work until 18:00" (optional)
+	Message string `json:"message,omitempty"`
+	// By is the user who last changed the maintenance mode
+	By string `json:"by,omitempty"`
+	// Since is when the maintenance mode was last changed
+	Since time.Time `json:"since"`
+}
+
+// Store manages the maintenance state persisted in a JSON file
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	state   State
+	modTime time.Time
+}
+
+// NewStore creates a new Store backed by the file at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Get returns the current maintenance state
+func (s *Store) Get() (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return State{}, err
+	}
+	return s.state, nil
+}
+
+// Set replaces the maintenance state
+func (s *Store) Set(state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	modTime, err := jsonfile.Write(s.path, state)
+	if err != nil {
+		return err
+	}
+	s.state = state
+	s.modTime = modTime
+	return nil
+}
+
+// reload reads the maintenance file if it changed since it was last read, callers must hold the lock
+func (s *Store) reload() error {
+	modTime, err := jsonfile.Read(s.path, s.modTime, &s.state)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
@@ -0,0 +1,239 @@
+// Package mdns browses the local network with multicast DNS for devices that
+// advertise services through Bonjour or Avahi, e.g. Macs, printers and NAS
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultServices are browsed for unless others are given, they cover
+// computers, file servers and printers
+var DefaultServices = []string{
+	"_workstation._tcp",
+	"_device-info._tcp",
+	"_smb._tcp",
+	"_afpovertls._tcp",
+	"_ssh._tcp",
+	"_sftp-ssh._tcp",
+	"_rfb._tcp",
+	"_http._tcp",
+	"_ipp._tcp",
+	"_printer._tcp",
+	"_companion-link._tcp",
+}
+
+// group is the IPv4 multicast address of mDNS
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// workstationMAC matches the MAC address Avahi appends to the names of
+// _workstation._tcp services, e.g. "nas [00:11:22:33:44:55]"
+var workstationMAC = regexp.MustCompile(`\s*\[([0-9a-fA-F:]{17})\]$`)
+
+// Host is a device that answered
+type Host struct {
+	// Name is the friendly name of the device, e.g. "Alice's MacBook Pro"
+	Name string
+	// Hostname is the name of the device without .local
+	Hostname string
+	// IP is the IPv4 address of the device
+	IP string
+	// MAC address if the device announced it, formatted by net.HardwareAddr
+	MAC string
+	// Services are the types of services the device offers, e.g. _smb._tcp
+	Services []string
+	// Model is the model of the device from _device-info._tcp, e.g. MacBookPro18,1
+	Model string
+}
+
+// Browse asks for the services and returns the hosts that answered within
+// the timeout. Answers are requested by unicast so no privileges are needed
+// to receive them.
+func Browse(ctx context.Context, services []string, timeout time.Duration) ([]Host, error) {
+	if len(services) == 0 {
+		services = DefaultServices
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query, err := browseQuery(services)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.WriteTo(query, group)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	r := newRecords()
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.add(buf[:n])
+	}
+	return r.hosts(), nil
+}
+
+// browseQuery returns a query for PTR records of the services
+func browseQuery(services []string) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	b.EnableCompression()
+	err := b.StartQuestions()
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		name, err := dnsmessage.NewName(strings.TrimSuffix(service, ".") + ".local.")
+		if err != nil {
+			return nil, err
+		}
+		err = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// records collects the records of all answers
+type records struct {
+	// instances are the service instances by service type
+	instances map[string][]string
+	// targets are the hostnames of service instances
+	targets map[string]string
+	// models are the models of devices by lowercase name
+	models map[string]string
+	// addresses are the IPv4 addresses of hostnames
+	addresses map[string]string
+}
+
+func newRecords() *records {
+	return &records{
+		instances: make(map[string][]string),
+		targets:   make(map[string]string),
+		models:    make(map[string]string),
+		addresses: make(map[string]string),
+	}
+}
+
+// add parses an answer, ignoring it if invalid
+func (r *records) add(packet []byte) {
+	var p dnsmessage.Parser
+	_, err := p.Start(packet)
+	if err != nil {
+		return
+	}
+	p.SkipAllQuestions()
+	var resources []dnsmessage.Resource
+	answers, _ := p.AllAnswers()
+	resources = append(resources, answers...)
+	p.SkipAllAuthorities()
+	additionals, _ := p.AllAdditionals()
+	resources = append(resources, additionals...)
+
+	for _, resource := range resources {
+		name := strings.ToLower(resource.Header.Name.String())
+		switch body := resource.Body.(type) {
+		case *dnsmessage.PTRResource:
+			instance := body.PTR.String()
+			if !slices.Contains(r.instances[name], instance) {
+				r.instances[name] = append(r.instances[name], instance)
+			}
+		case *dnsmessage.SRVResource:
+			r.targets[name] = strings.ToLower(body.Target.String())
+		case *dnsmessage.TXTResource:
+			device, ok := strings.CutSuffix(name, "._device-info._tcp.local.")
+			if !ok {
+				continue
+			}
+			for _, txt := range body.TXT {
+				if model, ok := strings.CutPrefix(txt, "model="); ok {
+					r.models[device] = model
+				}
+			}
+		case *dnsmessage.AResource:
+			r.addresses[name] = net.IP(body.A[:]).String()
+		}
+	}
+}
+
+// hosts groups the service instances by the host offering them, sorted by
+// hostname. Hosts without an IPv4 address are left out.
+func (r *records) hosts() []Host {
+	byHostname := make(map[string]*Host)
+	for service, instances := range r.instances {
+		serviceType := strings.TrimSuffix(service, ".local.")
+		for _, instance := range instances {
+			// Device info instances have no SRV record and are skipped
+			target, ok := r.targets[strings.ToLower(instance)]
+			if !ok || r.addresses[target] == "" {
+				continue
+			}
+			host, ok := byHostname[target]
+			if !ok {
+				host = &Host{
+					Hostname: strings.TrimSuffix(target, ".local."),
+					IP:       r.addresses[target],
+				}
+				byHostname[target] = host
+			}
+			if !slices.Contains(host.Services, serviceType) {
+				host.Services = append(host.Services, serviceType)
+			}
+
+			name := instanceName(instance, service)
+			if match := workstationMAC.FindStringSubmatch(name); match != nil {
+				if mac, err := net.ParseMAC(match[1]); err == nil {
+					host.MAC = mac.String()
+				}
+				name = workstationMAC.ReplaceAllString(name, "")
+			}
+			// Names of other services are the friendlier ones
+			if host.Name == "" || serviceType != "_workstation._tcp" {
+				host.Name = name
+			}
+			if model := r.models[strings.ToLower(name)]; model != "" {
+				host.Model = model
+			}
+		}
+	}
+
+	hosts := make([]Host, 0, len(byHostname))
+	for _, host := range byHostname {
+		slices.Sort(host.Services)
+		hosts = append(hosts, *host)
+	}
+	slices.SortFunc(hosts, func(a, b Host) int { return strings.Compare(a.Hostname, b.Hostname) })
+	return hosts
+}
+
+// instanceName returns the name of a service instance without the service
+func instanceName(instance, service string) string {
+	if strings.HasSuffix(strings.ToLower(instance), "."+service) {
+		return instance[:len(instance)-len(service)-1]
+	}
+	return instance
+}
@@ -0,0 +1,258 @@
+// Package netbios asks Windows machines for their names and finds them by
+// name with NetBIOS and LLMNR, which they answer even when they block ping
+// and don't register their names in DNS
+package netbios
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Port of the NetBIOS name service
+const Port = 137
+
+// ErrNoAnswer is returned when nothing answered within the timeout
+var ErrNoAnswer = errors.New("no answer")
+
+// Record types of the NetBIOS name service
+const (
+	typeNB     = 0x0020
+	typeNBSTAT = 0x0021
+	classIN    = 0x0001
+)
+
+// llmnrGroup is the IPv4 multicast address of LLMNR
+var llmnrGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 252), Port: 5355}
+
+// Status is the answer of a machine to a node status request
+type Status struct {
+	// Name is the computer name of the machine, e.g. DESKTOP-1A2B3C
+	Name string
+	// Group is the workgroup or domain of the machine
+	Group string
+	// MAC address of the machine, nil if it didn't tell
+	MAC net.HardwareAddr
+}
+
+// NodeStatus asks the machine at the IP address for its names
+func NodeStatus(ctx context.Context, ip netip.Addr, timeout time.Duration) (Status, error) {
+	id := transactionID()
+	request := append(header(id, 0x0000), encodeName("*", 0x00)...)
+	request = binary.BigEndian.AppendUint16(request, typeNBSTAT)
+	request = binary.BigEndian.AppendUint16(request, classIN)
+
+	var status Status
+	err := exchange(ctx, netip.AddrPortFrom(ip, Port), request, timeout, func(response []byte) bool {
+		var ok bool
+		status, ok = parseNodeStatus(id, response)
+		return ok
+	})
+	return status, err
+}
+
+// Resolve returns the IPv4 address of the machine with the name, asking with
+// LLMNR first and then with a NetBIOS broadcast for half the timeout each
+func Resolve(ctx context.Context, name string, timeout time.Duration) (netip.Addr, error) {
+	addr, err := resolveLLMNR(ctx, name, timeout/2)
+	if err == nil {
+		return addr, nil
+	}
+	return resolveNetBIOS(ctx, name, timeout/2)
+}
+
+// resolveLLMNR asks for the name with LLMNR, which Windows answers since Vista
+func resolveLLMNR(ctx context.Context, name string, timeout time.Duration) (netip.Addr, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	id := binary.BigEndian.Uint16(transactionID())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: qname, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	request, err := b.Finish()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	var addr netip.Addr
+	err = exchange(ctx, llmnrGroup.AddrPort(), request, timeout, func(response []byte) bool {
+		var p dnsmessage.Parser
+		h, err := p.Start(response)
+		if err != nil || h.ID != id || !h.Response {
+			return false
+		}
+		p.SkipAllQuestions()
+		answers, _ := p.AllAnswers()
+		for _, answer := range answers {
+			if a, ok := answer.Body.(*dnsmessage.AResource); ok {
+				addr = netip.AddrFrom4(a.A)
+				return true
+			}
+		}
+		return false
+	})
+	return addr, err
+}
+
+// resolveNetBIOS asks for the name with a NetBIOS broadcast, for machines
+// older than LLMNR or with it disabled
+func resolveNetBIOS(ctx context.Context, name string, timeout time.Duration) (netip.Addr, error) {
+	id := transactionID()
+	// Recursion desired and broadcast
+	request := append(header(id, 0x0110), encodeName(strings.ToUpper(name), 0x00)...)
+	request = binary.BigEndian.AppendUint16(request, typeNB)
+	request = binary.BigEndian.AppendUint16(request, classIN)
+
+	var addr netip.Addr
+	broadcast := netip.AddrPortFrom(netip.AddrFrom4([4]byte{255, 255, 255, 255}), Port)
+	err := exchange(ctx, broadcast, request, timeout, func(response []byte) bool {
+		rdata, ok := answer(id, response, typeNB)
+		// Flags followed by the address, for each address of the machine
+		if !ok || len(rdata) < 6 {
+			return false
+		}
+		addr = netip.AddrFrom4([4]byte(rdata[2:6]))
+		return true
+	})
+	return addr, err
+}
+
+// exchange sends the request and passes answers to handle until it returns
+// true, ErrNoAnswer is returned if none did within the timeout
+func exchange(ctx context.Context, to netip.AddrPort, request []byte, timeout time.Duration, handle func([]byte) bool) error {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	_, err = conn.WriteToUDPAddrPort(request, to)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDPAddrPort(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return ErrNoAnswer
+		}
+		if err != nil {
+			return err
+		}
+		if handle(buf[:n]) {
+			return nil
+		}
+	}
+}
+
+// header returns the header of a request with one question
+func header(id []byte, flags uint16) []byte {
+	h := append([]byte(nil), id...)
+	h = binary.BigEndian.AppendUint16(h, flags)
+	return append(h, 0, 1, 0, 0, 0, 0, 0, 0)
+}
+
+// encodeName returns the first-level encoding of a NetBIOS name, padded to
+// 15 characters and followed by the suffix
+func encodeName(name string, suffix byte) []byte {
+	padding := byte(' ')
+	if name == "*" {
+		padding = 0
+	}
+	raw := bytes.Repeat([]byte{padding}, 16)
+	copy(raw[:15], name)
+	raw[15] = suffix
+
+	encoded := []byte{32}
+	for _, c := range raw {
+		encoded = append(encoded, 'A'+c>>4, 'A'+c&0x0f)
+	}
+	return append(encoded, 0)
+}
+
+// answer returns the data of the first answer of a response to the request
+// with the ID, which has to be of the type
+func answer(id, response []byte, typ uint16) ([]byte, bool) {
+	if len(response) < 12 || !bytes.Equal(response[:2], id) || response[2]&0x80 == 0 {
+		return nil, false
+	}
+	if binary.BigEndian.Uint16(response[6:8]) == 0 {
+		return nil, false
+	}
+	// The name is repeated from the question, uncompressed
+	i := 12
+	for i < len(response) && response[i] != 0 {
+		i += int(response[i]) + 1
+	}
+	i++
+	if i+10 > len(response) || binary.BigEndian.Uint16(response[i:i+2]) != typ {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(response[i+8 : i+10]))
+	i += 10
+	if i+length > len(response) {
+		return nil, false
+	}
+	return response[i : i+length], true
+}
+
+// parseNodeStatus parses the names and the MAC address of a node status
+// response to the request with the ID
+func parseNodeStatus(id, response []byte) (Status, bool) {
+	rdata, ok := answer(id, response, typeNBSTAT)
+	if !ok || len(rdata) < 1 {
+		return Status{}, false
+	}
+	count := int(rdata[0])
+	names := rdata[1:]
+	if len(names) < count*18 {
+		return Status{}, false
+	}
+
+	var status Status
+	for i := 0; i < count; i++ {
+		entry := names[i*18 : i*18+18]
+		name := strings.TrimRight(string(entry[:15]), " \x00")
+		suffix := entry[15]
+		group := entry[16]&0x80 != 0
+		switch {
+		case suffix == 0x00 && !group && status.Name == "":
+			status.Name = name
+		case suffix == 0x00 && group && status.Group == "":
+			status.Group = name
+		}
+	}
+	// The unit ID of the statistics following the names is the MAC address
+	if statistics := names[count*18:]; len(statistics) >= 6 {
+		mac := net.HardwareAddr(append([]byte(nil), statistics[:6]...))
+		// Samba answers with zeros
+		if !bytes.Equal(mac, make([]byte, 6)) {
+			status.MAC = mac
+		}
+	}
+	return status, true
+}
+
+// transactionID returns a random ID matching responses to requests
+func transactionID() []byte {
+	id := make([]byte, 2)
+	rand.Read(id)
+	return id
+}
@@ -0,0 +1,172 @@
+// Package notify sends short messages about machines to chat and push
+// notification services
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Events notifications are sent for
+const (
+	// EventOnline is sent when a machine came online
+	EventOnline = "online"
+	// EventOffline is sent when an online machine went offline without being shut down
+	EventOffline = "offline"
+	// EventWakeFailed is sent when a magic packet couldn't be sent or the
+	// machine didn't come online in time
+	EventWakeFailed = "wake.failed"
+)
+
+// Events lists all events
+var Events = []string{EventOnline, EventOffline, EventWakeFailed}
+
+// Message is a notification
+type Message struct {
+	// Event is one of Events
+	Event string
+	// Title is a short summary, not shown by all providers
+	Title string
+	// Text is the body of the notification
+	Text string
+}
+
+// Config describes where notifications are sent
+type Config struct {
+	// Provider is the name of a registered provider
+	Provider string
+	// Events sent, all if empty
+	Events []string
+	// URL of the service, its meaning depends on the provider
+	URL string
+	// Token authenticating with the service
+	Token string
+	// ChatID the messages are sent to, for providers that need one
+	ChatID string
+	// URLs of the services a gateway passes the messages on to, for providers that support them
+	URLs []string
+	// Timeout of a single request
+	Timeout time.Duration
+}
+
+// Provider sends messages to a service
+type Provider interface {
+	Send(ctx context.Context, client *http.Client, msg Message) error
+}
+
+// providers creates providers by name
+var providers = map[string]func(Config) (Provider, error){}
+
+// Register makes a provider available under the name, it panics if the name
+// is already taken
+func Register(name string, create func(Config) (Provider, error)) {
+	if _, ok := providers[name]; ok {
+		panic("notify: provider registered twice: " + name)
+	}
+	providers[name] = create
+}
+
+// Providers returns the names of all registered providers
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Notifier sends the events it wants to a provider
+type Notifier struct {
+	config   Config
+	provider Provider
+	client   *http.Client
+}
+
+// New checks the configuration and returns a notifier
+func New(config Config) (*Notifier, error) {
+	create, ok := providers[config.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, must be one of %s", config.Provider, strings.Join(Providers(), ", "))
+	}
+	for _, event := range config.Events {
+		if !slices.Contains(Events, event) {
+			return nil, fmt.Errorf("unknown event %q, must be one of %s", event, strings.Join(Events, ", "))
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	provider, err := create(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s configuration: %w", config.Provider, err)
+	}
+	return &Notifier{config: config, provider: provider, client: &http.Client{Timeout: config.Timeout}}, nil
+}
+
+// Provider returns the name of the provider, logged instead of URLs which
+// may contain secrets
+func (n *Notifier) Provider() string {
+	return n.config.Provider
+}
+
+// Wants reports whether the event is sent
+func (n *Notifier) Wants(event string) bool {
+	return len(n.config.Events) == 0 || slices.Contains(n.config.Events, event)
+}
+
+// Send sends the message
+func (n *Notifier) Send(ctx context.Context, msg Message) error {
+	return n.provider.Send(ctx, n.client, msg)
+}
+
+// post sends a request and treats any response other than 2xx as an error,
+// including the start of the body which usually explains what went wrong
+func post(ctx context.Context, client *http.Client, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "wol")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL may contain a token
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// postJSON sends the value encoded as JSON
+func postJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return post(ctx, client, url, "application/json", body, nil)
+}
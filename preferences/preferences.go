@@ -0,0 +1,79 @@
+// Package preferences persists settings of the web interface chosen by each
+// user, such as the theme
+package preferences
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/internal/jsonfile"
+)
+
+// Preferences are the settings of a single user
+type Preferences struct {
+	// Theme of the web interface: auto, light or dark, the server default if empty
+	Theme string `json:"theme,omitempty"`
+}
+
+// Store manages preferences persisted in a JSON file
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	users   map[string]Preferences
+	modTime time.Time
+}
+
+// NewStore creates a new Store backed by the file at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Get returns the preferences of the user, usernames are case insensitive
+func (s *Store) Get(username string) (Preferences, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return Preferences{}, err
+	}
+	return s.users[strings.ToLower(username)], nil
+}
+
+// Set replaces the preferences of the user
+func (s *Store) Set(username string, prefs Preferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return err
+	}
+
+	if s.users == nil {
+		s.users = make(map[string]Preferences)
+	}
+	s.users[strings.ToLower(username)] = prefs
+	return s.save()
+}
+
+// reload reads the preferences file if it changed since it was last read, callers must hold the lock
+func (s *Store) reload() error {
+	modTime, err := jsonfile.Read(s.path, s.modTime, &s.users)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
+
+// save writes the preferences file, callers must hold the lock
+func (s *Store) save() error {
+	modTime, err := jsonfile.Write(s.path, s.users)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
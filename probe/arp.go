@@ -0,0 +1,176 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// arpTable is the kernel's IPv4 neighbor table on Linux
+const arpTable = "/proc/net/arp"
+
+// arpComplete is the flag of entries whose hardware address is known
+const arpComplete = 0x2
+
+// refreshPort receives the packet making the kernel resolve the address, it's
+// the discard port so machines ignore it
+const refreshPort = "9"
+
+func init() {
+	Register("arp", newARP)
+}
+
+// arp looks the machine up in the neighbor table, which works without
+// privileges and finds machines that drop everything they receive
+type arp struct {
+	host    string
+	mac     net.HardwareAddr
+	refresh bool
+	timeout time.Duration
+}
+
+func newARP(config Config) (Prober, error) {
+	err := requireAddress(config)
+	if err != nil {
+		return nil, err
+	}
+	a := &arp{host: hostOnly(config.Address), refresh: config.Refresh, timeout: config.Timeout}
+	if config.MAC != "" {
+		a.mac, err = net.ParseMAC(config.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAC address %q", config.MAC)
+		}
+	}
+	return a, nil
+}
+
+func (a *arp) Probe(ctx context.Context) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", a.host)
+	if err != nil {
+		return Result{}, err
+	}
+	addr := addrs[0].Unmap()
+
+	if !a.refresh {
+		hw, err := Neighbor(ctx, addr)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{Online: a.matches(hw)}, nil
+	}
+
+	// Sending anything makes the kernel resolve the address, entries show up
+	// once the machine answered
+	conn, err := net.Dial("udp", net.JoinHostPort(addr.String(), refreshPort))
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to send packet: %w", err)
+	}
+	start := time.Now()
+	conn.Write([]byte{0})
+	conn.Close()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		hw, err := Neighbor(ctx, addr)
+		if err != nil {
+			return Result{}, err
+		}
+		if a.matches(hw) {
+			return Result{Online: true, Latency: time.Since(start)}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return Result{}, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// matches reports whether the hardware address of an entry is the machine's,
+// any address matches if the machine's isn't known
+func (a *arp) matches(hw net.HardwareAddr) bool {
+	if hw == nil {
+		return false
+	}
+	return a.mac == nil || bytes.Equal(hw, a.mac)
+}
+
+// Neighbor returns the hardware address of a reachable entry of the
+// neighbor table, nil if there is none
+func Neighbor(ctx context.Context, addr netip.Addr) (net.HardwareAddr, error) {
+	if addr.Is4() {
+		return arpNeighbor(addr)
+	}
+	return ndpNeighbor(ctx, addr)
+}
+
+// arpNeighbor looks up an IPv4 address in the ARP table
+func arpNeighbor(addr netip.Addr) (net.HardwareAddr, error) {
+	f, err := os.Open(arpTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read neighbor table, arp checks need Linux: %w", err)
+	}
+	defer f.Close()
+
+	// IP address, HW type, Flags, HW address, Mask, Device
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] != addr.String() {
+			continue
+		}
+		flags, err := strconv.ParseUint(fields[2], 0, 32)
+		if err != nil || flags&arpComplete == 0 {
+			continue
+		}
+		hw, err := net.ParseMAC(fields[3])
+		if err == nil {
+			return hw, nil
+		}
+	}
+	return nil, scanner.Err()
+}
+
+// ndpNeighbor looks up an IPv6 address with ip(8), the kernel doesn't expose
+// the table in /proc
+func ndpNeighbor(ctx context.Context, addr netip.Addr) (net.HardwareAddr, error) {
+	out, err := exec.CommandContext(ctx, "ip", "-6", "neigh", "show", "to", addr.String()).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read neighbor table: %w", err)
+	}
+
+	// fe80::1 dev eth0 lladdr 00:11:22:33:44:55 router REACHABLE
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[len(fields)-1] {
+		case "FAILED", "INCOMPLETE", "NONE":
+			continue
+		}
+		for i, field := range fields[:len(fields)-1] {
+			if field != "lladdr" {
+				continue
+			}
+			hw, err := net.ParseMAC(fields[i+1])
+			if err == nil {
+				return hw, nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,65 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+func init() {
+	Register("exec", newExec)
+}
+
+// execWaitDelay is how long a check waits for the output after the command
+// was killed, in case it started processes that keep the output open
+const execWaitDelay = time.Second
+
+// execCommand runs a command, for checks wol doesn't know such as reading
+// sensors with a vendor tool. Exit code 0 means online and 1 offline, other
+// codes or a command that can't run or times out leave the status unknown.
+type execCommand struct {
+	command []string
+	env     []string
+	timeout time.Duration
+}
+
+func newExec(config Config) (Prober, error) {
+	if len(config.Command) == 0 || config.Command[0] == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	env := []string{
+		"WOL_NAME=" + config.Name,
+		"WOL_MAC=" + config.MAC,
+		"WOL_ADDRESS=" + config.Address,
+	}
+	if config.Port != 0 {
+		env = append(env, "WOL_PORT="+strconv.Itoa(config.Port))
+	}
+	return &execCommand{command: config.Command, env: env, timeout: config.Timeout}, nil
+}
+
+func (e *execCommand) Probe(ctx context.Context) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Env = append(os.Environ(), e.env...)
+	cmd.WaitDelay = execWaitDelay
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return Result{Online: true}, nil
+	}
+	if ctx.Err() != nil {
+		return Result{}, fmt.Errorf("%s timed out after %s", e.command[0], e.timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return Result{}, nil
+	}
+	return Result{}, fmt.Errorf("failed to run %s: %w: %s", e.command[0], err, bytes.TrimSpace(output))
+}
@@ -0,0 +1,87 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxBodySize limits how much of a response is searched for the expected text
+const maxBodySize = 1 << 20
+
+func init() {
+	Register("http", newHTTP)
+}
+
+// httpCheck requests a URL, for machines that are only useful while a
+// service is up rather than whenever they answer ping
+type httpCheck struct {
+	url      string
+	status   int
+	contains []byte
+	client   *http.Client
+}
+
+func newHTTP(config Config) (Prober, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("url must be an http or https URL")
+	}
+	if config.Status != 0 && (config.Status < 100 || config.Status > 599) {
+		return nil, fmt.Errorf("invalid status %d", config.Status)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	// Connections aren't reused so every check finds out if the service is still up
+	transport.DisableKeepAlives = true
+	return &httpCheck{
+		url:      config.URL,
+		status:   config.Status,
+		contains: []byte(config.Contains),
+		client:   &http.Client{Timeout: config.Timeout, Transport: transport},
+	}, nil
+}
+
+func (h *httpCheck) Probe(ctx context.Context) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url, nil)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "wol")
+
+	start := time.Now()
+	resp, err := h.client.Do(req)
+	if err != nil {
+		// Names that don't resolve leave the status unknown, a service that
+		// can't be reached is offline
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return Result{}, err
+		}
+		return Result{}, nil
+	}
+	defer resp.Body.Close()
+	latency := time.Since(start)
+
+	if h.status != 0 && resp.StatusCode != h.status {
+		return Result{}, nil
+	}
+	if h.status == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return Result{}, nil
+	}
+	if len(h.contains) > 0 {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+		if err != nil || !bytes.Contains(body, h.contains) {
+			return Result{}, nil
+		}
+	}
+	return Result{Online: true, Latency: latency}, nil
+}
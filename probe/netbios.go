@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/trugamr/wol/netbios"
+)
+
+func init() {
+	Register("netbios", newNetBIOS)
+}
+
+// netbiosProbe asks the machine for its NetBIOS names, which Windows answers
+// on private networks even when it blocks ping. Names that DNS doesn't know
+// are resolved with LLMNR and NetBIOS.
+type netbiosProbe struct {
+	host    string
+	mac     net.HardwareAddr
+	timeout time.Duration
+}
+
+func newNetBIOS(config Config) (Prober, error) {
+	err := requireAddress(config)
+	if err != nil {
+		return nil, err
+	}
+	n := &netbiosProbe{host: hostOnly(config.Address), timeout: config.Timeout}
+	if config.MAC != "" {
+		n.mac, err = net.ParseMAC(config.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAC address %q", config.MAC)
+		}
+	}
+	return n, nil
+}
+
+func (n *netbiosProbe) Probe(ctx context.Context) (Result, error) {
+	addr, err := n.resolve(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+
+	start := time.Now()
+	status, err := netbios.NodeStatus(ctx, addr, n.timeout)
+	if errors.Is(err, netbios.ErrNoAnswer) {
+		return Result{}, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	// Another machine may have taken over the address
+	if n.mac != nil && status.MAC != nil && !bytes.Equal(n.mac, status.MAC) {
+		return Result{}, nil
+	}
+	return Result{Online: true, Latency: time.Since(start)}, nil
+}
+
+// resolve returns the IPv4 address of the host, asking DNS first
+func (n *netbiosProbe) resolve(ctx context.Context) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(n.host); err == nil {
+		return addr.Unmap(), nil
+	}
+	dnsCtx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(dnsCtx, "ip4", n.host)
+	if err == nil && len(addrs) > 0 {
+		return addrs[0].Unmap(), nil
+	}
+	addr, err := netbios.Resolve(ctx, n.host, n.timeout)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to resolve %s with DNS, LLMNR and NetBIOS", n.host)
+	}
+	return addr, nil
+}
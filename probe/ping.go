@@ -0,0 +1,51 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+
+	probing "github.com/prometheus-community/pro-bing"
+)
+
+func init() {
+	Register("ping", newPing)
+}
+
+// ping sends a single ICMP echo request
+type ping struct {
+	config Config
+}
+
+func newPing(config Config) (Prober, error) {
+	err := requireAddress(config)
+	if err != nil {
+		return nil, err
+	}
+	// Ports don't apply, machines may have one for unicast magic packets
+	config.Address = hostOnly(config.Address)
+	return &ping{config: config}, nil
+}
+
+func (p *ping) Probe(ctx context.Context) (Result, error) {
+	pinger, err := probing.NewPinger(p.config.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("error creating pinger: %v", err)
+	}
+	pinger.SetPrivileged(p.config.Privileged)
+
+	// We only want to ping once and wait for a response until the timeout
+	pinger.Timeout = p.config.Timeout
+	pinger.Count = 1
+
+	err = pinger.RunWithContext(ctx)
+	if err != nil {
+		return Result{}, fmt.Errorf("error pinging: %v", err)
+	}
+
+	// If we receive even a single packet, the address is reachable
+	stats := pinger.Statistics()
+	if stats.PacketsRecv == 0 {
+		return Result{}, nil
+	}
+	return Result{Online: true, Latency: stats.AvgRtt}, nil
+}
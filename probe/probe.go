@@ -0,0 +1,163 @@
+// Package probe checks whether machines are online, with checks such as ping
+// or connecting to a TCP port registered by type
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trugamr/wol/remote"
+)
+
+// DefaultTimeout is how long a check waits for an answer unless configured
+const DefaultTimeout = 2 * time.Second
+
+// Result is the outcome of a check that didn't fail
+type Result struct {
+	// Online is true if the machine answered
+	Online bool
+	// Latency is how long the answer took, zero if not measured
+	Latency time.Duration
+}
+
+// Config describes a check
+type Config struct {
+	// Type is the name of a registered check
+	Type string
+	// Name of the machine, passed to exec checks
+	Name string
+	// Address of the machine, a hostname or IP address optionally with a port
+	Address string
+	// MAC address of the machine, arp checks only accept entries with it
+	MAC string
+	// Port checked when the address has none, for checks that need one
+	Port int
+	// Timeout of a single check
+	Timeout time.Duration
+	// Privileged sends ping with raw sockets instead of unprivileged ICMP
+	Privileged bool
+	// URL requested by http checks
+	URL string
+	// Status expected by http checks, any 2xx status if zero
+	Status int
+	// Contains is text the body of the response has to contain for http checks
+	Contains string
+	// InsecureSkipVerify accepts any certificate for https URLs
+	InsecureSkipVerify bool
+	// Login makes ssh checks log in instead of only waiting for the server's
+	// version, nil to skip logging in
+	Login *remote.SSH
+	// SNMP configures snmp checks
+	SNMP SNMP
+	// Command run by exec checks, the program followed by its arguments
+	Command []string
+	// Refresh makes arp checks send a packet first so the kernel asks the
+	// machine for its hardware address instead of relying on the cache
+	Refresh bool
+}
+
+// SNMP describes the request of an snmp check
+type SNMP struct {
+	// Version of the protocol: 1, 2c or 3, defaults to 2c
+	Version string
+	// Community of version 1 and 2c requests, defaults to public
+	Community string
+	// OID requested, defaults to sysUpTime
+	OID string
+	// Value the OID must have, any value if empty
+	Value string
+	// Username of version 3 requests
+	Username string
+	// AuthProtocol of version 3 requests: MD5, SHA, SHA224, SHA256, SHA384 or
+	// SHA512, no authentication if empty
+	AuthProtocol string
+	// AuthPassword of version 3 requests
+	AuthPassword string
+	// PrivacyProtocol of version 3 requests: DES, AES, AES192, AES256,
+	// AES192C or AES256C, no encryption if empty
+	PrivacyProtocol string
+	// PrivacyPassword of version 3 requests
+	PrivacyPassword string
+}
+
+// Prober checks whether a machine is online, errors mean the status is unknown
+type Prober interface {
+	Probe(ctx context.Context) (Result, error)
+}
+
+// types creates probers by name
+var types = map[string]func(Config) (Prober, error){}
+
+// Register makes a check available under the name, it panics if the name is
+// already taken
+func Register(name string, create func(Config) (Prober, error)) {
+	if _, ok := types[name]; ok {
+		panic("probe: type registered twice: " + name)
+	}
+	types[name] = create
+}
+
+// Types returns the names of all registered checks
+func Types() []string {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New checks the configuration and returns a prober
+func New(config Config) (Prober, error) {
+	create, ok := types[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown check type %q, must be one of %s", config.Type, strings.Join(Types(), ", "))
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	prober, err := create(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s check: %w", config.Type, err)
+	}
+	return prober, nil
+}
+
+// requireAddress returns an error if the config has no address, for checks
+// that connect to the machine itself
+func requireAddress(config Config) error {
+	if config.Address == "" {
+		return fmt.Errorf("address is required, set the machine's IP or the check's address")
+	}
+	return nil
+}
+
+// hostPort returns the address with the port of the config added if it has
+// none, an error if neither has a port
+func hostPort(config Config) (string, error) {
+	if err := requireAddress(config); err != nil {
+		return "", err
+	}
+	if _, _, err := net.SplitHostPort(config.Address); err == nil {
+		return config.Address, nil
+	}
+	if config.Port <= 0 || config.Port > 65535 {
+		return "", fmt.Errorf("port is required unless the address has one")
+	}
+	return net.JoinHostPort(config.Address, strconv.Itoa(config.Port)), nil
+}
+
+// hostOnly returns the address without its port if it has one
+func hostOnly(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
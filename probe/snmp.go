@@ -0,0 +1,201 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	// snmpPort is where agents listen unless configured otherwise
+	snmpPort = 161
+	// sysUpTime is answered by every agent
+	sysUpTime = "1.3.6.1.2.1.1.3.0"
+)
+
+// snmpAuthProtocols maps the names of authentication protocols to their values
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"":       gosnmp.NoAuth,
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+// snmpPrivacyProtocols maps the names of privacy protocols to their values
+var snmpPrivacyProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"":        gosnmp.NoPriv,
+	"DES":     gosnmp.DES,
+	"AES":     gosnmp.AES,
+	"AES192":  gosnmp.AES192,
+	"AES256":  gosnmp.AES256,
+	"AES192C": gosnmp.AES192C,
+	"AES256C": gosnmp.AES256C,
+}
+
+func init() {
+	Register("snmp", newSNMP)
+}
+
+// snmpCheck requests an OID, for network gear and NAS devices whose agent
+// tells best whether they're up
+type snmpCheck struct {
+	host   string
+	port   uint16
+	config Config
+}
+
+func newSNMP(config Config) (Prober, error) {
+	if config.Port == 0 {
+		config.Port = snmpPort
+	}
+	address, err := hostPort(config)
+	if err != nil {
+		return nil, err
+	}
+	host, portString, _ := net.SplitHostPort(address)
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portString)
+	}
+
+	c := &config.SNMP
+	if c.Version == "" {
+		c.Version = "2c"
+	}
+	if c.Community == "" {
+		c.Community = "public"
+	}
+	if c.OID == "" {
+		c.OID = sysUpTime
+	}
+	c.AuthProtocol = strings.ToUpper(c.AuthProtocol)
+	c.PrivacyProtocol = strings.ToUpper(c.PrivacyProtocol)
+	if !slices.Contains([]string{"1", "2c", "3"}, c.Version) {
+		return nil, fmt.Errorf("unknown version %q, must be 1, 2c or 3", c.Version)
+	}
+	if c.Version == "3" {
+		if c.Username == "" {
+			return nil, errors.New("username is required for version 3")
+		}
+		if _, ok := snmpAuthProtocols[c.AuthProtocol]; !ok {
+			return nil, fmt.Errorf("unknown auth protocol %q", c.AuthProtocol)
+		}
+		if _, ok := snmpPrivacyProtocols[c.PrivacyProtocol]; !ok {
+			return nil, fmt.Errorf("unknown privacy protocol %q", c.PrivacyProtocol)
+		}
+		if c.PrivacyProtocol != "" && c.AuthProtocol == "" {
+			return nil, errors.New("privacy protocol needs an auth protocol")
+		}
+	}
+	return &snmpCheck{host: host, port: uint16(port), config: config}, nil
+}
+
+// client returns the client of a single check
+func (s *snmpCheck) client(ctx context.Context) *gosnmp.GoSNMP {
+	c := s.config.SNMP
+	client := &gosnmp.GoSNMP{
+		Target:    s.host,
+		Port:      s.port,
+		Community: c.Community,
+		Timeout:   s.config.Timeout,
+		Retries:   1,
+		Context:   ctx,
+		MaxOids:   gosnmp.MaxOids,
+	}
+	switch c.Version {
+	case "1":
+		client.Version = gosnmp.Version1
+	case "2c":
+		client.Version = gosnmp.Version2c
+	case "3":
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.MsgFlags = gosnmp.NoAuthNoPriv
+		if c.AuthProtocol != "" {
+			client.MsgFlags = gosnmp.AuthNoPriv
+		}
+		if c.PrivacyProtocol != "" {
+			client.MsgFlags = gosnmp.AuthPriv
+		}
+		client.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 c.Username,
+			AuthenticationProtocol:   snmpAuthProtocols[c.AuthProtocol],
+			AuthenticationPassphrase: c.AuthPassword,
+			PrivacyProtocol:          snmpPrivacyProtocols[c.PrivacyProtocol],
+			PrivacyPassphrase:        c.PrivacyPassword,
+		}
+	}
+	return client
+}
+
+func (s *snmpCheck) Probe(ctx context.Context) (Result, error) {
+	client := s.client(ctx)
+	err := client.Connect()
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return Result{}, err
+		}
+		return Result{}, nil
+	}
+	defer client.Conn.Close()
+
+	start := time.Now()
+	packet, err := client.Get([]string{s.config.SNMP.OID})
+	if err != nil {
+		// Agents that don't answer are offline, other errors such as wrong
+		// credentials leave the status unknown
+		if unanswered(err) {
+			return Result{}, nil
+		}
+		return Result{}, fmt.Errorf("snmp request failed: %w", err)
+	}
+	if packet.Error != gosnmp.NoError {
+		return Result{}, fmt.Errorf("snmp request failed: %s", packet.Error)
+	}
+	if len(packet.Variables) == 0 {
+		return Result{}, errors.New("snmp response has no value")
+	}
+
+	variable := packet.Variables[0]
+	switch variable.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		return Result{}, fmt.Errorf("agent has no value for %s", s.config.SNMP.OID)
+	}
+	if s.config.SNMP.Value != "" && snmpValue(variable) != s.config.SNMP.Value {
+		return Result{}, nil
+	}
+	return Result{Online: true, Latency: time.Since(start)}, nil
+}
+
+// unanswered reports whether the error means the agent didn't answer
+func unanswered(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		strings.Contains(err.Error(), "timeout")
+}
+
+// snmpValue returns the value of a variable as text to compare it
+func snmpValue(variable gosnmp.SnmpPDU) string {
+	switch value := variable.Value.(type) {
+	case []byte:
+		return string(value)
+	case string:
+		return value
+	}
+	if variable.Type == gosnmp.ObjectIdentifier {
+		return strings.TrimPrefix(fmt.Sprint(variable.Value), ".")
+	}
+	return gosnmp.ToBigInt(variable.Value).String()
+}
@@ -0,0 +1,92 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/trugamr/wol/remote"
+)
+
+const (
+	// sshPort is where SSH servers listen unless configured otherwise
+	sshPort = 22
+	// maxBannerLines is how many lines are read looking for the server's version
+	maxBannerLines = 20
+)
+
+func init() {
+	Register("ssh", newSSH)
+}
+
+// sshCheck waits for the version an SSH server sends first, or logs in, so
+// machines only count as online once they're ready to accept work
+type sshCheck struct {
+	address string
+	timeout time.Duration
+	login   *remote.SSH
+}
+
+func newSSH(config Config) (Prober, error) {
+	if config.Port == 0 {
+		config.Port = sshPort
+	}
+	address, err := hostPort(config)
+	if err != nil {
+		return nil, err
+	}
+	check := &sshCheck{address: address, timeout: config.Timeout}
+	if config.Login != nil {
+		login := *config.Login
+		login.Address = address
+		login.Timeout = config.Timeout
+		check.login = &login
+	}
+	return check, nil
+}
+
+func (s *sshCheck) Probe(ctx context.Context) (Result, error) {
+	start := time.Now()
+	if s.login != nil {
+		err := s.login.Check(ctx)
+		// Mistakes of the setup would otherwise show the machine as offline forever
+		var setupErr *remote.SetupError
+		var dnsErr *net.DNSError
+		if errors.As(err, &setupErr) || errors.As(err, &dnsErr) {
+			return Result{}, err
+		}
+		if err != nil {
+			return Result{}, nil
+		}
+		return Result{Online: true, Latency: time.Since(start)}, nil
+	}
+
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return Result{}, err
+		}
+		return Result{}, nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	// Servers send their version, e.g. SSH-2.0-OpenSSH_9.6, possibly after a
+	// few other lines
+	reader := bufio.NewReader(conn)
+	for i := 0; i < maxBannerLines; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return Result{}, nil
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			return Result{Online: true, Latency: time.Since(start)}, nil
+		}
+	}
+	return Result{}, nil
+}
@@ -0,0 +1,45 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+func init() {
+	Register("tcp", newTCP)
+}
+
+// tcp connects to a port, for machines that don't answer ping but always run
+// a service such as SSH, RDP or SMB
+type tcp struct {
+	address string
+	timeout time.Duration
+}
+
+func newTCP(config Config) (Prober, error) {
+	address, err := hostPort(config)
+	if err != nil {
+		return nil, err
+	}
+	return &tcp{address: address, timeout: config.Timeout}, nil
+}
+
+func (t *tcp) Probe(ctx context.Context) (Result, error) {
+	dialer := net.Dialer{Timeout: t.timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", t.address)
+	if err != nil {
+		// Names that don't resolve leave the status unknown, anything else
+		// such as a timeout or a refused connection means offline
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return Result{}, err
+		}
+		return Result{}, nil
+	}
+	latency := time.Since(start)
+	conn.Close()
+	return Result{Online: true, Latency: latency}, nil
+}
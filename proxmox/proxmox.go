@@ -0,0 +1,156 @@
+// Package proxmox starts, stops and checks virtual machines through the API of
+// Proxmox VE with an API token
+package proxmox
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes how to reach a virtual machine
+type Config struct {
+	// URL of the API, e.g. https://pve.lan:8006
+	URL string
+	// Node the virtual machine runs on
+	Node string
+	// VMID of the virtual machine
+	VMID int
+	// TokenID of the API token, e.g. root@pam!wol
+	TokenID string
+	// TokenSecret is the secret of the API token
+	TokenSecret string
+	// InsecureSkipVerify accepts the self-signed certificate nodes come with
+	InsecureSkipVerify bool
+	// Timeout of a single request
+	Timeout time.Duration
+}
+
+// Status is the state of a virtual machine
+type Status struct {
+	// Running is true if the virtual machine is started and not paused
+	Running bool
+	// Paused is true if the virtual machine is started but paused or suspended
+	Paused bool
+}
+
+// VM is a virtual machine
+type VM struct {
+	config Config
+	path   string
+	client *http.Client
+}
+
+// New checks the configuration and returns the virtual machine
+func New(config Config) (*VM, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", config.URL)
+	}
+	if !strings.Contains(config.TokenID, "!") {
+		return nil, fmt.Errorf("invalid token ID %q, must look like user@realm!name", config.TokenID)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	return &VM{
+		config: config,
+		path:   base.String() + "/api2/json/nodes/" + url.PathEscape(config.Node) + "/qemu/" + strconv.Itoa(config.VMID),
+		client: &http.Client{Timeout: config.Timeout, Transport: transport},
+	}, nil
+}
+
+// Status returns the state of the virtual machine
+func (v *VM) Status(ctx context.Context) (Status, error) {
+	var current struct {
+		Status    string `json:"status"`
+		QMPStatus string `json:"qmpstatus"`
+	}
+	err := v.do(ctx, http.MethodGet, "/status/current", &current)
+	if err != nil {
+		return Status{}, err
+	}
+	if current.Status != "running" {
+		return Status{}, nil
+	}
+	// The QEMU state tells paused and suspended machines apart
+	if current.QMPStatus != "" && current.QMPStatus != "running" {
+		return Status{Paused: true}, nil
+	}
+	return Status{Running: true}, nil
+}
+
+// Start starts the virtual machine, or resumes it if paused or suspended
+func (v *VM) Start(ctx context.Context) error {
+	status, err := v.Status(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case status.Running:
+		return nil
+	case status.Paused:
+		return v.do(ctx, http.MethodPost, "/status/resume", nil)
+	default:
+		return v.do(ctx, http.MethodPost, "/status/start", nil)
+	}
+}
+
+// Shutdown asks the guest to shut down through ACPI or the guest agent
+func (v *VM) Shutdown(ctx context.Context) error {
+	return v.do(ctx, http.MethodPost, "/status/shutdown", nil)
+}
+
+// Reboot asks the guest to reboot
+func (v *VM) Reboot(ctx context.Context) error {
+	return v.do(ctx, http.MethodPost, "/status/reboot", nil)
+}
+
+// do sends a request for the virtual machine and decodes the data of the
+// response into v if not nil. Actions return the ID of the task they started,
+// which isn't waited for.
+func (v *VM) do(ctx context.Context, method, path string, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, v.path+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "PVEAPIToken="+v.config.TokenID+"="+v.config.TokenSecret)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return fmt.Errorf("invalid API token")
+	case resp.StatusCode != http.StatusOK:
+		// The reason is in the status line, e.g. "500 VM 100 not running"
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if data == nil {
+		return nil
+	}
+
+	var result struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	err = json.Unmarshal(result.Data, data)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+// Package redfish controls the power of servers through the Redfish API of
+// their BMC, e.g. iDRAC or iLO
+package redfish
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Reset types
+const (
+	ResetOn               = "On"
+	ResetGracefulShutdown = "GracefulShutdown"
+	ResetGracefulRestart  = "GracefulRestart"
+	ResetForceRestart     = "ForceRestart"
+)
+
+// Config describes how to reach a BMC
+type Config struct {
+	// URL of the BMC, e.g. https://idrac.lan
+	URL string
+	// System is the path of the computer system, e.g.
+	// /redfish/v1/Systems/System.Embedded.1, the only system of the BMC if empty
+	System string
+	// Username of a BMC account allowed to control power
+	Username string
+	// Password of the account
+	Password string
+	// InsecureSkipVerify accepts the self-signed certificate BMCs come with
+	InsecureSkipVerify bool
+	// Timeout of a single request
+	Timeout time.Duration
+}
+
+// system is a computer system as returned by the BMC
+type system struct {
+	PowerState string `json:"PowerState"`
+	Actions    struct {
+		Reset struct {
+			Target  string   `json:"target"`
+			Allowed []string `json:"ResetType@Redfish.AllowableValues"`
+		} `json:"#ComputerSystem.Reset"`
+	} `json:"Actions"`
+}
+
+// BMC is the BMC of a server
+type BMC struct {
+	config Config
+	base   string
+	client *http.Client
+}
+
+// New checks the configuration and returns the BMC
+func New(config Config) (*BMC, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", config.URL)
+	}
+	if config.Username == "" {
+		return nil, errors.New("username is required")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	return &BMC{
+		config: config,
+		base:   base.Scheme + "://" + base.Host,
+		client: &http.Client{Timeout: config.Timeout, Transport: transport},
+	}, nil
+}
+
+// PoweredOn reports whether the system is on, systems powering on count as
+// on and systems powering off as off
+func (b *BMC) PoweredOn(ctx context.Context) (bool, error) {
+	s, err := b.system(ctx)
+	if err != nil {
+		return false, err
+	}
+	switch s.PowerState {
+	case "On", "PoweringOn":
+		return true, nil
+	case "Off", "PoweringOff":
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected power state %q", s.PowerState)
+}
+
+// PowerOn turns the system on unless it already is
+func (b *BMC) PowerOn(ctx context.Context) error {
+	s, err := b.system(ctx)
+	if err != nil {
+		return err
+	}
+	if s.PowerState == "On" || s.PowerState == "PoweringOn" {
+		return nil
+	}
+	return b.reset(ctx, s, ResetOn)
+}
+
+// Reset resets the system with the first of the reset types it allows, the
+// first type is used if it doesn't tell which it allows
+func (b *BMC) Reset(ctx context.Context, types ...string) error {
+	s, err := b.system(ctx)
+	if err != nil {
+		return err
+	}
+	return b.reset(ctx, s, types...)
+}
+
+// reset resets the system with the first of the reset types it allows
+func (b *BMC) reset(ctx context.Context, s system, types ...string) error {
+	target := s.Actions.Reset.Target
+	if target == "" {
+		return errors.New("system can't be reset")
+	}
+	resetType := types[0]
+	if len(s.Actions.Reset.Allowed) > 0 {
+		i := slices.IndexFunc(types, func(t string) bool { return slices.Contains(s.Actions.Reset.Allowed, t) })
+		if i < 0 {
+			return fmt.Errorf("system doesn't allow %s", strings.Join(types, " or "))
+		}
+		resetType = types[i]
+	}
+
+	body, _ := json.Marshal(map[string]string{"ResetType": resetType})
+	return b.do(ctx, http.MethodPost, target, body, nil)
+}
+
+// system returns the computer system, finding it first unless configured
+func (b *BMC) system(ctx context.Context) (system, error) {
+	path := b.config.System
+	if path == "" {
+		var systems struct {
+			Members []struct {
+				ID string `json:"@odata.id"`
+			} `json:"Members"`
+		}
+		err := b.do(ctx, http.MethodGet, "/redfish/v1/Systems", nil, &systems)
+		if err != nil {
+			return system{}, err
+		}
+		if len(systems.Members) != 1 {
+			return system{}, fmt.Errorf("BMC has %d systems, one has to be chosen", len(systems.Members))
+		}
+		path = systems.Members[0].ID
+	}
+
+	var s system
+	err := b.do(ctx, http.MethodGet, path, nil, &s)
+	return s, err
+}
+
+// do sends a request to the BMC with the body as JSON if not nil and decodes
+// the response into v if not nil
+func (b *BMC) do(ctx context.Context, method, path string, body []byte, v interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.config.Username, b.config.Password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return errors.New("invalid username or password")
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("unexpected status %s%s", resp.Status, redfishMessage(resp.Body))
+	}
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// redfishMessage returns the first message of the error in the body prefixed
+// with ": ", empty if there is none
+func redfishMessage(body io.Reader) string {
+	var response struct {
+		Error struct {
+			Message  string `json:"message"`
+			Extended []struct {
+				Message string `json:"Message"`
+			} `json:"@Message.ExtendedInfo"`
+		} `json:"error"`
+	}
+	if json.NewDecoder(io.LimitReader(body, 65536)).Decode(&response) != nil {
+		return ""
+	}
+	if len(response.Error.Extended) > 0 && response.Error.Extended[0].Message != "" {
+		return ": " + response.Error.Extended[0].Message
+	}
+	if response.Error.Message != "" {
+		return ": " + response.Error.Message
+	}
+	return ""
+}
@@ -0,0 +1,265 @@
+// Package remote runs commands on machines, e.g. to shut them down or reboot them
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key policies, how servers are verified against the known hosts file
+const (
+	// HostKeyStrict only accepts servers whose host key is known
+	HostKeyStrict = "strict"
+	// HostKeyAcceptNew adds the host keys of unknown servers to the file,
+	// but rejects changed ones
+	HostKeyAcceptNew = "accept-new"
+	// HostKeyInsecure accepts any host key
+	HostKeyInsecure = "insecure"
+)
+
+// HostKeyPolicies lists all host key policies
+var HostKeyPolicies = []string{HostKeyStrict, HostKeyAcceptNew, HostKeyInsecure}
+
+// Become methods, how commands are run as root
+const (
+	BecomeSudo = "sudo"
+	BecomeDoas = "doas"
+)
+
+// knownHostsMu serializes adding host keys to known hosts files
+var knownHostsMu sync.Mutex
+
+// SSH runs commands on a machine over SSH using public key authentication
+type SSH struct {
+	// Address of the SSH server as host:port
+	Address string
+	// User to log in as
+	User string
+	// KeyFile is the path of the private key, optional with Agent
+	KeyFile string
+	// Agent logs in with the keys of the agent at SSH_AUTH_SOCK
+	Agent bool
+	// Become is the method commands are run as root with, none if empty
+	Become string
+	// KnownHostsFile contains the host keys the server is verified against
+	KnownHostsFile string
+	// HostKeyPolicy is one of HostKeyPolicies, strict if empty
+	HostKeyPolicy string
+	// Timeout of establishing the connection
+	Timeout time.Duration
+}
+
+// SetupError is returned for problems of the local setup rather than the
+// machine, e.g. a key that can't be read or a host key that doesn't match
+type SetupError struct {
+	Err error
+}
+
+func (e *SetupError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SetupError) Unwrap() error {
+	return e.Err
+}
+
+// Check logs in and out again, e.g. to find out whether the machine is ready
+func (s SSH) Check(ctx context.Context) error {
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+// Run runs the command and returns an error including its output if it
+// failed, a connection closed before the command exited counts as success as
+// that is what shutting down or rebooting usually looks like
+func (s SSH) Run(ctx context.Context, command string) error {
+	switch s.Become {
+	case "":
+	case BecomeSudo, BecomeDoas:
+		// Without a terminal a password prompt would hang, fail instead
+		command = s.Become + " -n " + command
+	default:
+		return &SetupError{fmt.Errorf("unknown become method %q", s.Become)}
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open session: %w", err)
+	}
+	defer session.Close()
+
+	// Commands can hang, e.g. waiting for a sudo password
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	output, err := session.CombinedOutput(command)
+	var missing *ssh.ExitMissingError
+	if errors.As(err, &missing) {
+		return nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("failed to run %q: %w", command, ctx.Err())
+		}
+		return fmt.Errorf("failed to run %q: %w: %s", command, err, bytes.TrimSpace(output))
+	}
+
+	return nil
+}
+
+// signers returns the keys to log in with, the key file's before the
+// agent's, and a function closing the connection to the agent
+func (s SSH) signers() ([]ssh.Signer, func(), error) {
+	var signers []ssh.Signer
+	if s.KeyFile != "" {
+		key, err := os.ReadFile(s.KeyFile)
+		if err != nil {
+			return nil, nil, &SetupError{fmt.Errorf("failed to read key: %w", err)}
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, &SetupError{fmt.Errorf("failed to parse key %s: %w", s.KeyFile, err)}
+		}
+		signers = append(signers, signer)
+	}
+	if !s.Agent {
+		return signers, func() {}, nil
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, &SetupError{errors.New("no SSH agent, SSH_AUTH_SOCK is not set")}
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, &SetupError{fmt.Errorf("failed to connect to SSH agent: %w", err)}
+	}
+	agentSigners, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, &SetupError{fmt.Errorf("failed to get keys of SSH agent: %w", err)}
+	}
+	return append(signers, agentSigners...), func() { conn.Close() }, nil
+}
+
+// hostKeyCallback verifies the server's host key according to the policy
+func (s SSH) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	switch s.HostKeyPolicy {
+	case "", HostKeyStrict:
+		hostKeys, err := knownhosts.New(s.KnownHostsFile)
+		if err != nil {
+			return nil, &SetupError{fmt.Errorf("failed to read known hosts: %w", err)}
+		}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			err := hostKeys(hostname, remote, key)
+			if err != nil {
+				return &SetupError{err}
+			}
+			return nil
+		}, nil
+	case HostKeyAcceptNew:
+		return s.acceptNewHostKey, nil
+	case HostKeyInsecure:
+		return ssh.InsecureIgnoreHostKey(), nil
+	default:
+		return nil, &SetupError{fmt.Errorf("unknown host key policy %q", s.HostKeyPolicy)}
+	}
+}
+
+// acceptNewHostKey verifies the host key against the known hosts file and
+// adds it if the server isn't in there yet
+func (s SSH) acceptNewHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+
+	hostKeys, err := knownhosts.New(s.KnownHostsFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// Created below
+	case err != nil:
+		return &SetupError{fmt.Errorf("failed to read known hosts: %w", err)}
+	default:
+		err = hostKeys(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		// Servers with other keys than the known ones are rejected
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return &SetupError{err}
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.KnownHostsFile), 0o700)
+	if err != nil {
+		return &SetupError{fmt.Errorf("failed to add host key: %w", err)}
+	}
+	f, err := os.OpenFile(s.KnownHostsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return &SetupError{fmt.Errorf("failed to add host key: %w", err)}
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if err != nil {
+		return &SetupError{fmt.Errorf("failed to add host key: %w", err)}
+	}
+	return nil
+}
+
+// dial connects and authenticates to the server
+func (s SSH) dial(ctx context.Context) (*ssh.Client, error) {
+	signers, closeAgent, err := s.signers()
+	if err != nil {
+		return nil, err
+	}
+	// The agent is only needed while logging in
+	defer closeAgent()
+	hostKeyCallback, err := s.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            s.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         s.Timeout,
+	}
+
+	dialer := net.Dialer{Timeout: s.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", s.Address, err)
+	}
+	if s.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.Address, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to log in to %s: %w", s.Address, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,161 @@
+// Package schedule persists the schedules managed from the web interface and
+// whether each schedule, including the ones of the config file, is enabled
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trugamr/wol/config"
+	"github.com/trugamr/wol/internal/jsonfile"
+)
+
+// Errors returned when changing schedules
+var (
+	ErrNotFound = errors.New("schedule not found")
+	ErrExists   = errors.New("schedule already exists")
+)
+
+// state is the content of the schedules file
+type state struct {
+	// Schedules added from the web interface
+	Schedules []config.Schedule `json:"schedules"`
+	// Enabled overrides whether schedules run by lower case name
+	Enabled map[string]bool `json:"enabled,omitempty"`
+}
+
+// Store manages schedules persisted in a JSON file
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	state   state
+	modTime time.Time
+}
+
+// NewStore creates a new Store backed by the file at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// List returns all stored schedules
+func (s *Store) List() ([]config.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]config.Schedule(nil), s.state.Schedules...), nil
+}
+
+// Add stores a new schedule, names are unique regardless of case
+func (s *Store) Add(schedule config.Schedule) error {
+	err := schedule.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.reload()
+	if err != nil {
+		return err
+	}
+
+	if s.index(schedule.Name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrExists, schedule.Name)
+	}
+	s.state.Schedules = append(s.state.Schedules, schedule)
+	delete(s.state.Enabled, strings.ToLower(schedule.Name))
+	return s.save()
+}
+
+// Delete removes the schedule with the given name
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return err
+	}
+
+	i := s.index(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	s.state.Schedules = append(s.state.Schedules[:i:i], s.state.Schedules[i+1:]...)
+	delete(s.state.Enabled, strings.ToLower(name))
+	return s.save()
+}
+
+// Enabled reports whether the schedule runs, schedules that were never
+// toggled run unless they are disabled
+func (s *Store) Enabled(schedule config.Schedule) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return false, err
+	}
+
+	if enabled, ok := s.state.Enabled[strings.ToLower(schedule.Name)]; ok {
+		return enabled, nil
+	}
+	return !schedule.Disabled, nil
+}
+
+// SetEnabled sets whether the schedule with the given name runs, it may be
+// defined anywhere so its existence isn't checked
+func (s *Store) SetEnabled(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.reload()
+	if err != nil {
+		return err
+	}
+
+	if s.state.Enabled == nil {
+		s.state.Enabled = make(map[string]bool)
+	}
+	s.state.Enabled[strings.ToLower(name)] = enabled
+	return s.save()
+}
+
+// index returns the position of the schedule with the given name or -1, callers must hold the lock
+func (s *Store) index(name string) int {
+	for i, schedule := range s.state.Schedules {
+		if strings.EqualFold(schedule.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// reload reads the schedules file if it changed since it was last read, callers must hold the lock
+func (s *Store) reload() error {
+	modTime, err := jsonfile.Read(s.path, s.modTime, &s.state)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
+
+// save writes the schedules file, callers must hold the lock
+func (s *Store) save() error {
+	modTime, err := jsonfile.Write(s.path, s.state)
+	if err != nil {
+		return err
+	}
+	s.modTime = modTime
+	return nil
+}
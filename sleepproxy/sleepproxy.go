@@ -0,0 +1,491 @@
+// Package sleepproxy implements a Bonjour Sleep Proxy. Macs and other
+// devices using mDNSResponder register their records with the proxy before
+// going to sleep, the proxy answers for them while they sleep and wakes them
+// when their services are requested.
+package sleepproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// MaxLease is the longest registration granted, devices renew it by
+	// waking up briefly
+	MaxLease = 2 * time.Hour
+	// wakeInterval is how long to wait before waking a device again whose
+	// services are still requested
+	wakeInterval = time.Minute
+	// sleepGrace is how long after registering a device may still answer
+	// itself while falling asleep
+	sleepGrace = 10 * time.Second
+	// opCodeUpdate is the operation code of DNS Update messages
+	opCodeUpdate = 5
+	// EDNS0 options of registrations
+	optionUpdateLease = 2
+	optionOwner       = 4
+)
+
+// group is the IPv4 multicast address of mDNS
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// serviceType is the type devices browse for to find sleep proxies
+const serviceType = "_sleep-proxy._udp.local."
+
+// Registration is a sleeping device that registered with the proxy
+type Registration struct {
+	// MAC address magic packets are sent to
+	MAC net.HardwareAddr
+	// Password of SecureOn magic packets, nil if the device has none
+	Password []byte
+	// IP address the device registered from
+	IP netip.Addr
+	// Hostname of the device without .local, e.g. Alices-MacBook-Pro
+	Hostname string
+	// Services are the names of the service instances of the device
+	Services []string
+}
+
+// registration is a registration with the records the proxy answers with
+type registration struct {
+	Registration
+	records      []dnsmessage.Resource
+	registeredAt time.Time
+	expires      time.Time
+	wokenAt      time.Time
+}
+
+// Server is a sleep proxy
+type Server struct {
+	// Name of the proxy shown to devices, e.g. wol
+	Name string
+	// Port devices send their registrations to
+	Port int
+	// Wake is called when a service of a sleeping device is requested
+	Wake func(Registration)
+
+	hostname string
+	instance string
+
+	mu            sync.Mutex
+	registrations map[string]*registration
+}
+
+// Serve advertises the proxy and answers for sleeping devices until the
+// context is done
+func (s *Server) Serve(ctx context.Context) error {
+	s.registrations = make(map[string]*registration)
+	s.hostname = localHostname()
+	// Devices prefer the proxy with the lowest metrics, those of a dedicated
+	// proxy that is always on
+	s.instance = fmt.Sprintf("10-34-10-70 %s.%s", s.Name, serviceType)
+
+	mconn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return fmt.Errorf("listening for mdns: %w", err)
+	}
+	defer mconn.Close()
+	pc := ipv4.NewPacketConn(mconn)
+	for _, iface := range multicastInterfaces() {
+		// The default interface was joined already
+		pc.JoinGroup(&iface, group)
+	}
+	pc.SetControlMessage(ipv4.FlagInterface, true)
+	pc.SetMulticastTTL(255)
+
+	uconn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: s.Port})
+	if err != nil {
+		return fmt.Errorf("listening for registrations: %w", err)
+	}
+	defer uconn.Close()
+
+	go func() {
+		<-ctx.Done()
+		mconn.Close()
+		uconn.Close()
+	}()
+	go s.serveUpdates(uconn)
+	s.announce(pc)
+
+	buf := make([]byte, 9000)
+	for {
+		n, cm, from, err := pc.ReadFrom(buf)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok || cm == nil {
+			continue
+		}
+		s.handleQuery(pc, buf[:n], addr, cm.IfIndex)
+	}
+}
+
+// serveUpdates accepts registrations until the connection is closed
+func (s *Server) serveUpdates(conn *net.UDPConn) {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+		response, err := s.handleUpdate(buf[:n], from.Addr().Unmap())
+		if err != nil {
+			log.Printf("Invalid sleep proxy registration from %s: %v", from.Addr(), err)
+		}
+		if response != nil {
+			conn.WriteToUDPAddrPort(response, from)
+		}
+	}
+}
+
+// handleUpdate stores the records of a device going to sleep and returns the
+// response, nil if the message isn't a registration
+func (s *Server) handleUpdate(packet []byte, from netip.Addr) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(packet)
+	if err != nil || h.Response || h.OpCode != opCodeUpdate {
+		return nil, err
+	}
+	p.SkipAllQuestions()
+	p.SkipAllAnswers()
+	updates, err := p.AllAuthorities()
+	if err != nil {
+		return nil, err
+	}
+	additionals, err := p.AllAdditionals()
+	if err != nil {
+		return nil, err
+	}
+
+	var lease time.Duration
+	var owner []byte
+	for _, additional := range additionals {
+		opt, ok := additional.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+		for _, option := range opt.Options {
+			switch {
+			case option.Code == optionUpdateLease && len(option.Data) >= 4:
+				lease = time.Duration(binary.BigEndian.Uint32(option.Data)) * time.Second
+			case option.Code == optionOwner && len(option.Data) >= 8:
+				owner = option.Data
+			}
+		}
+	}
+	if owner == nil {
+		return reply(h.ID, dnsmessage.RCodeRefused, 0)
+	}
+	if lease <= 0 || lease > MaxLease {
+		lease = MaxLease
+	}
+
+	// The owner option holds a version, a sequence number and the primary
+	// MAC address, optionally followed by the MAC address to wake and the
+	// SecureOn password
+	now := time.Now()
+	reg := &registration{registeredAt: now, expires: now.Add(lease)}
+	reg.IP = from
+	reg.MAC = net.HardwareAddr(append([]byte(nil), owner[2:8]...))
+	if len(owner) >= 14 {
+		reg.MAC = net.HardwareAddr(append([]byte(nil), owner[8:14]...))
+	}
+	if len(owner) >= 18 {
+		reg.Password = append([]byte(nil), owner[14:]...)
+	}
+	goodbye := true
+	for _, update := range updates {
+		if update.Header.TTL > 0 {
+			goodbye = false
+		}
+		switch update.Body.(type) {
+		case *dnsmessage.AResource:
+			reg.Hostname = strings.TrimSuffix(update.Header.Name.String(), ".local.")
+		case *dnsmessage.SRVResource:
+			reg.Services = append(reg.Services, update.Header.Name.String())
+		case *dnsmessage.AAAAResource, *dnsmessage.PTRResource, *dnsmessage.TXTResource:
+		default:
+			// Other records, such as NSEC, aren't answered for
+			continue
+		}
+		reg.records = append(reg.records, update)
+	}
+
+	s.mu.Lock()
+	key := reg.MAC.String()
+	if goodbye {
+		if _, ok := s.registrations[key]; ok {
+			log.Printf("Sleep proxy registration of %s removed", reg.MAC)
+		}
+		delete(s.registrations, key)
+	} else {
+		log.Printf("Sleep proxy registration of %s (%s) for %s", reg.Hostname, reg.MAC, lease)
+		s.registrations[key] = reg
+	}
+	s.mu.Unlock()
+	return reply(h.ID, dnsmessage.RCodeSuccess, lease)
+}
+
+// reply returns the response to a registration, granting the lease
+func reply(id uint16, rcode dnsmessage.RCode, lease time.Duration) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, OpCode: opCodeUpdate, RCode: rcode})
+	b.StartAdditionals()
+	var rh dnsmessage.ResourceHeader
+	rh.SetEDNS0(1440, dnsmessage.RCodeSuccess, false)
+	var options []dnsmessage.Option
+	if lease > 0 {
+		options = append(options, dnsmessage.Option{
+			Code: optionUpdateLease,
+			Data: binary.BigEndian.AppendUint32(nil, uint32(lease/time.Second)),
+		})
+	}
+	err := b.OPTResource(rh, dnsmessage.OPTResource{Options: options})
+	if err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// handleQuery answers questions about the proxy and the sleeping devices,
+// waking devices whose services or hostnames are asked for
+func (s *Server) handleQuery(pc *ipv4.PacketConn, packet []byte, from *net.UDPAddr, ifIndex int) {
+	var p dnsmessage.Parser
+	h, err := p.Start(packet)
+	if err != nil {
+		return
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+	if h.Response {
+		// Goodbyes of devices falling asleep have a TTL of 0
+		answers, _ := p.AllAnswers()
+		for _, answer := range answers {
+			if answer.Header.TTL > 0 {
+				fromAddr, _ := netip.AddrFromSlice(from.IP.To4())
+				s.awake(fromAddr)
+				break
+			}
+		}
+		return
+	}
+
+	var answers []dnsmessage.Resource
+	var unicast bool
+	now := time.Now()
+	s.mu.Lock()
+	for _, q := range questions {
+		unicast = unicast || q.Class&0x8000 != 0
+		answers = append(answers, matching(s.ownRecords(ifIndex), q)...)
+		for key, reg := range s.registrations {
+			if now.After(reg.expires) {
+				delete(s.registrations, key)
+				continue
+			}
+			records := matching(reg.records, q)
+			answers = append(answers, records...)
+			// Browsing for service types and reverse lookups don't wake devices
+			if len(records) > 0 && q.Type != dnsmessage.TypePTR && now.Sub(reg.wokenAt) > wakeInterval {
+				reg.wokenAt = now
+				go s.Wake(reg.Registration)
+			}
+		}
+	}
+	s.mu.Unlock()
+	if len(answers) == 0 {
+		return
+	}
+
+	// Legacy unicast queries from other ports get a regular DNS response
+	legacy := from.Port != group.Port
+	header := dnsmessage.Header{Response: true, Authoritative: true}
+	if legacy {
+		header.ID = h.ID
+	}
+	response, err := build(header, questions, legacy, answers)
+	if err != nil {
+		return
+	}
+	to := group
+	if legacy || unicast {
+		to = from
+	}
+	pc.WriteTo(response, &ipv4.ControlMessage{IfIndex: ifIndex}, to)
+}
+
+// awake removes the registration of a device that answers itself again
+func (s *Server) awake(ip netip.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, reg := range s.registrations {
+		if reg.IP == ip && time.Since(reg.registeredAt) > sleepGrace {
+			log.Printf("Sleep proxy registration of %s (%s) ended, it is awake", reg.Hostname, reg.MAC)
+			delete(s.registrations, key)
+		}
+	}
+}
+
+// announce tells the networks about the proxy once started
+func (s *Server) announce(pc *ipv4.PacketConn) {
+	for _, iface := range multicastInterfaces() {
+		records := s.ownRecords(iface.Index)
+		if len(records) == 0 {
+			continue
+		}
+		response, err := build(dnsmessage.Header{Response: true, Authoritative: true}, nil, false, records)
+		if err != nil {
+			continue
+		}
+		pc.WriteTo(response, &ipv4.ControlMessage{IfIndex: iface.Index}, group)
+	}
+}
+
+// ownRecords returns the records advertising the proxy on the interface,
+// none if it has no IPv4 address
+func (s *Server) ownRecords(ifIndex int) []dnsmessage.Resource {
+	ip, ok := interfaceAddr(ifIndex)
+	if !ok {
+		return nil
+	}
+	ptr := dnsmessage.MustNewName(serviceType)
+	instance, err := dnsmessage.NewName(s.instance)
+	if err != nil {
+		return nil
+	}
+	host, err := dnsmessage.NewName(s.hostname + ".local.")
+	if err != nil {
+		return nil
+	}
+	header := func(name dnsmessage.Name, t dnsmessage.Type, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: t, Class: class, TTL: 120}
+	}
+	// Unique records have the cache flush bit set
+	unique := dnsmessage.ClassINET | 0x8000
+	return []dnsmessage.Resource{
+		{Header: header(ptr, dnsmessage.TypePTR, dnsmessage.ClassINET), Body: &dnsmessage.PTRResource{PTR: instance}},
+		{Header: header(instance, dnsmessage.TypeSRV, unique), Body: &dnsmessage.SRVResource{Target: host, Port: uint16(s.Port)}},
+		{Header: header(instance, dnsmessage.TypeTXT, unique), Body: &dnsmessage.TXTResource{TXT: []string{""}}},
+		{Header: header(host, dnsmessage.TypeA, unique), Body: &dnsmessage.AResource{A: ip.As4()}},
+	}
+}
+
+// matching returns the records answering the question
+func matching(records []dnsmessage.Resource, q dnsmessage.Question) []dnsmessage.Resource {
+	var matches []dnsmessage.Resource
+	for _, record := range records {
+		if !strings.EqualFold(record.Header.Name.String(), q.Name.String()) {
+			continue
+		}
+		if q.Type == dnsmessage.TypeALL || q.Type == record.Header.Type {
+			matches = append(matches, record)
+		}
+	}
+	return matches
+}
+
+// build returns a response with the answers, repeating the questions for
+// legacy unicast queries
+func build(header dnsmessage.Header, questions []dnsmessage.Question, legacy bool, answers []dnsmessage.Resource) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, header)
+	b.EnableCompression()
+	if legacy {
+		b.StartQuestions()
+		for _, q := range questions {
+			q.Class &^= 0x8000
+			if err := b.Question(q); err != nil {
+				return nil, err
+			}
+		}
+	}
+	b.StartAnswers()
+	for _, answer := range answers {
+		if legacy {
+			// The cache flush bit is only meaningful in mDNS
+			answer.Header.Class &^= 0x8000
+		}
+		if err := addResource(&b, answer); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// addResource adds a record of one of the types the proxy answers with
+func addResource(b *dnsmessage.Builder, r dnsmessage.Resource) error {
+	switch body := r.Body.(type) {
+	case *dnsmessage.AResource:
+		return b.AResource(r.Header, *body)
+	case *dnsmessage.AAAAResource:
+		return b.AAAAResource(r.Header, *body)
+	case *dnsmessage.PTRResource:
+		return b.PTRResource(r.Header, *body)
+	case *dnsmessage.SRVResource:
+		return b.SRVResource(r.Header, *body)
+	case *dnsmessage.TXTResource:
+		return b.TXTResource(r.Header, *body)
+	}
+	return errors.New("unsupported record type")
+}
+
+// multicastInterfaces returns the interfaces mDNS is used on
+func multicastInterfaces() []net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var multicast []net.Interface
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			multicast = append(multicast, iface)
+		}
+	}
+	return multicast
+}
+
+// interfaceAddr returns the IPv4 address of the interface
+func interfaceAddr(ifIndex int) (netip.Addr, bool) {
+	iface, err := net.InterfaceByIndex(ifIndex)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipNet.IP.To4()); ok {
+				return ip, true
+			}
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// localHostname returns the first label of the hostname, which the proxy is
+// advertised at
+func localHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "wol"
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	return hostname
+}
@@ -0,0 +1,173 @@
+// Package tailscale lists the nodes of a tailnet with their addresses and
+// whether they are online, either from the local tailscaled or from the
+// Tailscale API
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultSocket is the socket of tailscaled on Linux
+const DefaultSocket = "/var/run/tailscale/tailscaled.sock"
+
+// apiURL is the base URL of the Tailscale API
+const apiURL = "https://api.tailscale.com/api/v2"
+
+// Node is a device of the tailnet
+type Node struct {
+	// Name is the hostname of the node, e.g. desktop
+	Name string
+	// DNSName is the MagicDNS name of the node, e.g. desktop.tail1234.ts.net
+	DNSName string
+	// IPs are the Tailscale addresses of the node, IPv4 first
+	IPs []string
+	// Online is true if the node is connected to the tailnet
+	Online bool
+}
+
+// Client lists the nodes of a tailnet
+type Client struct {
+	nodes func(ctx context.Context) ([]Node, error)
+}
+
+// NewLocal returns a client asking tailscaled through its socket, which only
+// knows the nodes the local node can see
+func NewLocal(socket string, timeout time.Duration) *Client {
+	if socket == "" {
+		socket = DefaultSocket
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", socket)
+	}
+	client := &http.Client{Timeout: timeout, Transport: transport}
+	return &Client{nodes: func(ctx context.Context) ([]Node, error) {
+		return localNodes(ctx, client)
+	}}
+}
+
+// NewAPI returns a client asking the Tailscale API with an API key or OAuth
+// access token, tailnet is - for the tailnet of the key
+func NewAPI(key, tailnet string, timeout time.Duration) (*Client, error) {
+	if key == "" {
+		return nil, errors.New("API key is required")
+	}
+	if tailnet == "" {
+		tailnet = "-"
+	}
+	client := &http.Client{Timeout: timeout}
+	return &Client{nodes: func(ctx context.Context) ([]Node, error) {
+		return apiNodes(ctx, client, key, tailnet)
+	}}, nil
+}
+
+// Nodes returns the nodes of the tailnet
+func (c *Client) Nodes(ctx context.Context) ([]Node, error) {
+	return c.nodes(ctx)
+}
+
+// localNodes returns the local node and its peers as reported by tailscaled
+func localNodes(ctx context.Context, client *http.Client) ([]Node, error) {
+	type peer struct {
+		HostName     string   `json:"HostName"`
+		DNSName      string   `json:"DNSName"`
+		TailscaleIPs []string `json:"TailscaleIPs"`
+		Online       bool     `json:"Online"`
+	}
+	var status struct {
+		Self *peer           `json:"Self"`
+		Peer map[string]peer `json:"Peer"`
+	}
+	// The host is required by tailscaled but not used
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://local-tailscaled.sock/localapi/v0/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = getJSON(client, req, &status)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []Node
+	if status.Self != nil {
+		nodes = append(nodes, Node{Name: status.Self.HostName, DNSName: status.Self.DNSName, IPs: status.Self.TailscaleIPs, Online: true})
+	}
+	for _, p := range status.Peer {
+		nodes = append(nodes, Node{Name: p.HostName, DNSName: p.DNSName, IPs: p.TailscaleIPs, Online: p.Online})
+	}
+	return cleanNodes(nodes), nil
+}
+
+// apiNodes returns the devices of the tailnet as reported by the API
+func apiNodes(ctx context.Context, client *http.Client, key, tailnet string) ([]Node, error) {
+	var result struct {
+		Devices []struct {
+			Hostname           string   `json:"hostname"`
+			Name               string   `json:"name"`
+			Addresses          []string `json:"addresses"`
+			ConnectedToControl bool     `json:"connectedToControl"`
+		} `json:"devices"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+"/tailnet/"+url.PathEscape(tailnet)+"/devices?fields=all", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	err = getJSON(client, req, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(result.Devices))
+	for _, d := range result.Devices {
+		nodes = append(nodes, Node{Name: d.Hostname, DNSName: d.Name, IPs: d.Addresses, Online: d.ConnectedToControl})
+	}
+	return cleanNodes(nodes), nil
+}
+
+// cleanNodes removes the trailing dot of DNS names and sorts IPv4 addresses first
+func cleanNodes(nodes []Node) []Node {
+	for i := range nodes {
+		nodes[i].DNSName = strings.TrimSuffix(nodes[i].DNSName, ".")
+		var v4, v6 []string
+		for _, ip := range nodes[i].IPs {
+			if strings.Contains(ip, ":") {
+				v6 = append(v6, ip)
+			} else {
+				v4 = append(v4, ip)
+			}
+		}
+		nodes[i].IPs = append(v4, v6...)
+	}
+	return nodes
+}
+
+// getJSON sends the request and decodes the JSON response into v
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("access denied: %s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package ubus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Type is the type of a blobmsg value
+type Type int
+
+// Types of blobmsg values, booleans are sent as TypeInt8
+const (
+	TypeUnspec Type = iota
+	TypeArray
+	TypeTable
+	TypeString
+	TypeInt64
+	TypeInt32
+	TypeInt16
+	TypeInt8
+	TypeDouble
+)
+
+// TypeBool is how booleans are sent
+const TypeBool = TypeInt8
+
+// blobExtended marks attributes carrying a name, as blobmsg attributes do
+const blobExtended = 0x80000000
+
+// blobAttr holds a decoded blob attribute
+type blobAttr struct {
+	id   int
+	name string
+	data []byte
+}
+
+// blobPad rounds n up to the alignment of blob attributes
+func blobPad(n int) int {
+	return (n + 3) &^ 3
+}
+
+// putBlob encodes an attribute, padded so the next one is aligned
+func putBlob(id int, extended bool, payload []byte) []byte {
+	n := 4 + len(payload)
+	b := make([]byte, blobPad(n))
+	header := uint32(id)<<24 | uint32(n)
+	if extended {
+		header |= blobExtended
+	}
+	binary.BigEndian.PutUint32(b, header)
+	copy(b[4:], payload)
+	return b
+}
+
+// putBlobmsg encodes a named attribute of the given type
+func putBlobmsg(typ Type, name string, payload []byte) []byte {
+	header := make([]byte, blobPad(2+len(name)+1))
+	binary.BigEndian.PutUint16(header, uint16(len(name)))
+	copy(header[2:], name)
+	return putBlob(int(typ), true, append(header, payload...))
+}
+
+// putUint32 encodes a 32 bit integer as blobs do
+func putUint32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// putString encodes a string as blobs do, terminated by a NUL byte
+func putString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// parseBlobs decodes the attributes of a container
+func parseBlobs(b []byte) ([]blobAttr, error) {
+	var attrs []blobAttr
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, fmt.Errorf("truncated attribute")
+		}
+		header := binary.BigEndian.Uint32(b)
+		n := int(header & 0xffffff)
+		if n < 4 || n > len(b) {
+			return nil, fmt.Errorf("invalid attribute length %d", n)
+		}
+		attr := blobAttr{id: int(header>>24) & 0x7f, data: b[4:n]}
+		if header&blobExtended != 0 {
+			if len(attr.data) < 2 {
+				return nil, fmt.Errorf("truncated attribute name")
+			}
+			nameLen := int(binary.BigEndian.Uint16(attr.data))
+			offset := blobPad(2 + nameLen + 1)
+			if offset > len(attr.data) {
+				return nil, fmt.Errorf("truncated attribute name")
+			}
+			attr.name = string(attr.data[2 : 2+nameLen])
+			attr.data = attr.data[offset:]
+		}
+		attrs = append(attrs, attr)
+		b = b[min(blobPad(n), len(b)):]
+	}
+	return attrs, nil
+}
+
+// getString decodes a string, dropping the terminating NUL byte
+func getString(b []byte) string {
+	return string(bytes.TrimRight(b, "\x00"))
+}
+
+// getUint32 decodes a 32 bit integer, zero if it's too short
+func getUint32(b []byte) uint32 {
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// decodeTable decodes the blobmsg attributes of a table
+func decodeTable(b []byte) (map[string]interface{}, error) {
+	attrs, err := parseBlobs(b)
+	if err != nil {
+		return nil, err
+	}
+	table := make(map[string]interface{}, len(attrs))
+	for _, attr := range attrs {
+		table[attr.name], err = decodeValue(Type(attr.id), attr.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", attr.name, err)
+		}
+	}
+	return table, nil
+}
+
+// decodeValue decodes a blobmsg value to the types encoding/json uses,
+// except for integers which are decoded to int64
+func decodeValue(typ Type, b []byte) (interface{}, error) {
+	size := map[Type]int{TypeInt64: 8, TypeInt32: 4, TypeInt16: 2, TypeInt8: 1, TypeDouble: 8}[typ]
+	if len(b) < size {
+		return nil, fmt.Errorf("truncated value")
+	}
+
+	switch typ {
+	case TypeUnspec:
+		return nil, nil
+	case TypeTable:
+		return decodeTable(b)
+	case TypeArray:
+		attrs, err := parseBlobs(b)
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, 0, len(attrs))
+		for _, attr := range attrs {
+			v, err := decodeValue(Type(attr.id), attr.data)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, v)
+		}
+		return array, nil
+	case TypeString:
+		return getString(b), nil
+	case TypeInt64:
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case TypeInt32:
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case TypeInt16:
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case TypeInt8:
+		return b[0] != 0, nil
+	case TypeDouble:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, fmt.Errorf("unknown type %d", typ)
+}
+
+// marshalTable encodes v as the attributes of a table, v is encoded like
+// encoding/json does and has to be encoded to an object
+func marshalTable(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var table map[string]interface{}
+	err = decoder.Decode(&table)
+	if err != nil {
+		return nil, fmt.Errorf("result must be an object: %w", err)
+	}
+	return encodeTable(table), nil
+}
+
+// encodeTable encodes the entries of a table sorted by name
+func encodeTable(table map[string]interface{}) []byte {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b []byte
+	for _, name := range names {
+		b = append(b, encodeValue(name, table[name])...)
+	}
+	return b
+}
+
+// encodeValue encodes a value decoded by encoding/json as a named attribute
+func encodeValue(name string, v interface{}) []byte {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return putBlobmsg(TypeTable, name, encodeTable(v))
+	case []interface{}:
+		var b []byte
+		for _, item := range v {
+			b = append(b, encodeValue("", item)...)
+		}
+		return putBlobmsg(TypeArray, name, b)
+	case string:
+		return putBlobmsg(TypeString, name, putString(v))
+	case bool:
+		var b byte
+		if v {
+			b = 1
+		}
+		return putBlobmsg(TypeBool, name, []byte{b})
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return putBlobmsg(TypeInt64, name, binary.BigEndian.AppendUint64(nil, uint64(i)))
+		}
+		f, _ := v.Float64()
+		return putBlobmsg(TypeDouble, name, binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	}
+	return putBlobmsg(TypeUnspec, name, nil)
+}
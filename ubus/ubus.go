@@ -0,0 +1,302 @@
+// Package ubus registers objects on ubus, the message bus of OpenWrt, so
+// their methods can be called with the ubus command, by rpcd and by LuCI
+package ubus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+)
+
+// maxMessageSize is the largest message ubusd accepts
+const maxMessageSize = 1 << 20
+
+// Types of messages
+const (
+	msgHello     = 0
+	msgStatus    = 1
+	msgData      = 2
+	msgInvoke    = 5
+	msgAddObject = 6
+)
+
+// Attributes of messages
+const (
+	attrStatus    = 1
+	attrObjPath   = 2
+	attrObjID     = 3
+	attrMethod    = 4
+	attrSignature = 6
+	attrData      = 7
+	attrNoReply   = 10
+)
+
+// Status is the result of a call, methods return it as an error to fail with
+// a specific status
+type Status uint32
+
+// Statuses of calls as defined by libubus
+const (
+	StatusOK Status = iota
+	StatusInvalidCommand
+	StatusInvalidArgument
+	StatusMethodNotFound
+	StatusNotFound
+	StatusNoData
+	StatusPermissionDenied
+	StatusTimeout
+	StatusNotSupported
+	StatusUnknownError
+	StatusConnectionFailed
+)
+
+var statusText = map[Status]string{
+	StatusOK:               "Success",
+	StatusInvalidCommand:   "Invalid command",
+	StatusInvalidArgument:  "Invalid argument",
+	StatusMethodNotFound:   "Method not found",
+	StatusNotFound:         "Not found",
+	StatusNoData:           "No response",
+	StatusPermissionDenied: "Permission denied",
+	StatusTimeout:          "Request timed out",
+	StatusNotSupported:     "Operation not supported",
+	StatusUnknownError:     "Unknown error",
+	StatusConnectionFailed: "Connection failed",
+}
+
+func (s Status) Error() string {
+	if text, ok := statusText[s]; ok {
+		return text
+	}
+	return fmt.Sprintf("status %d", uint32(s))
+}
+
+// Handler runs a method with the arguments it was called with, the result
+// is encoded like encoding/json does and has to be encoded to an object.
+// Errors other than a Status fail the call with StatusUnknownError.
+type Handler func(ctx context.Context, args map[string]interface{}) (interface{}, error)
+
+// Method is a method of an object
+type Method struct {
+	// Args maps the names of the arguments to their types, shown by ubus -v list
+	Args map[string]Type
+	// Handler runs the method
+	Handler Handler
+}
+
+// header is the header of every message
+type header struct {
+	typ  uint8
+	seq  uint16
+	peer uint32
+}
+
+// Conn is a connection to ubusd
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// mu serializes writes as methods reply concurrently
+	mu  sync.Mutex
+	seq uint16
+
+	// objects holds the methods of the registered objects by their ID
+	objects map[uint32]map[string]Method
+}
+
+// Dial connects to ubusd listening on the socket
+func Dial(socket string) (*Conn, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		objects: make(map[uint32]map[string]Method),
+	}
+
+	// ubusd greets clients first
+	h, _, err := c.read()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read hello: %w", err)
+	}
+	if h.typ != msgHello {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected message type %d instead of hello", h.typ)
+	}
+	return c, nil
+}
+
+// Close closes the connection, which removes the registered objects
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Register adds an object with the methods, it must be called before Serve
+func (c *Conn) Register(name string, methods map[string]Method) error {
+	names := make([]string, 0, len(methods))
+	for method := range methods {
+		names = append(names, method)
+	}
+	sort.Strings(names)
+
+	var signature []byte
+	for _, method := range names {
+		var args []byte
+		for arg, typ := range methods[method].Args {
+			args = append(args, putBlobmsg(TypeInt32, arg, putUint32(uint32(typ)))...)
+		}
+		signature = append(signature, putBlobmsg(TypeTable, method, args)...)
+	}
+
+	c.mu.Lock()
+	c.seq++
+	seq := c.seq
+	c.mu.Unlock()
+	err := c.write(header{typ: msgAddObject, seq: seq},
+		putBlob(attrObjPath, false, putString(name)),
+		putBlob(attrSignature, false, signature),
+	)
+	if err != nil {
+		return err
+	}
+
+	// ubusd replies with the ID of the object followed by the status
+	var id uint32
+	for {
+		h, attrs, err := c.read()
+		if err != nil {
+			return err
+		}
+		if h.seq != seq {
+			continue
+		}
+		switch h.typ {
+		case msgData:
+			id = getUint32(attrs[attrObjID])
+		case msgStatus:
+			status := Status(getUint32(attrs[attrStatus]))
+			if status != StatusOK {
+				return fmt.Errorf("failed to add object %s: %w", name, status)
+			}
+			if id == 0 {
+				return fmt.Errorf("failed to add object %s: no object ID", name)
+			}
+			c.objects[id] = methods
+			return nil
+		}
+	}
+}
+
+// Serve runs the methods called until the connection is lost or ctx is done,
+// in which case it returns nil
+func (c *Conn) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	defer stop()
+
+	for {
+		h, attrs, err := c.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if h.typ == msgInvoke {
+			go c.invoke(ctx, h, attrs)
+		}
+	}
+}
+
+// invoke runs the method called and replies with its result
+func (c *Conn) invoke(ctx context.Context, h header, attrs map[int][]byte) {
+	id := getUint32(attrs[attrObjID])
+	method, ok := c.objects[id][getString(attrs[attrMethod])]
+
+	var data []byte
+	status := StatusMethodNotFound
+	if ok {
+		status = StatusOK
+		args, err := decodeTable(attrs[attrData])
+		if err == nil {
+			var result interface{}
+			result, err = method.Handler(ctx, args)
+			if err == nil {
+				data, err = marshalTable(result)
+			}
+		}
+		if err != nil && !errors.As(err, &status) {
+			status = StatusUnknownError
+		}
+	}
+
+	if noReply, ok := attrs[attrNoReply]; ok && len(noReply) > 0 && noReply[0] != 0 {
+		return
+	}
+	objID := putBlob(attrObjID, false, putUint32(id))
+	reply := header{seq: h.seq, peer: h.peer}
+	if status == StatusOK {
+		reply.typ = msgData
+		if c.write(reply, objID, putBlob(attrData, false, data)) != nil {
+			return
+		}
+	}
+	reply.typ = msgStatus
+	c.write(reply, putBlob(attrStatus, false, putUint32(uint32(status))), objID)
+}
+
+// write sends a message with the attributes
+func (c *Conn) write(h header, attrs ...[]byte) error {
+	msg := []byte{0, h.typ}
+	msg = binary.BigEndian.AppendUint16(msg, h.seq)
+	msg = binary.BigEndian.AppendUint32(msg, h.peer)
+	msg = append(msg, putBlob(0, false, bytes.Join(attrs, nil))...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// read receives a message and returns its attributes by ID
+func (c *Conn) read() (header, map[int][]byte, error) {
+	buf := make([]byte, 12)
+	_, err := io.ReadFull(c.reader, buf)
+	if err != nil {
+		return header{}, nil, err
+	}
+	h := header{
+		typ:  buf[1],
+		seq:  binary.BigEndian.Uint16(buf[2:]),
+		peer: binary.BigEndian.Uint32(buf[4:]),
+	}
+
+	n := int(binary.BigEndian.Uint32(buf[8:]) & 0xffffff)
+	if n < 4 || n > maxMessageSize {
+		return header{}, nil, fmt.Errorf("invalid message length %d", n)
+	}
+	data := make([]byte, n-4)
+	_, err = io.ReadFull(c.reader, data)
+	if err != nil {
+		return header{}, nil, err
+	}
+
+	blobs, err := parseBlobs(data)
+	if err != nil {
+		return header{}, nil, err
+	}
+	attrs := make(map[int][]byte, len(blobs))
+	for _, blob := range blobs {
+		attrs[blob.id] = blob.data
+	}
+	return h, attrs, nil
+}
@@ -0,0 +1,225 @@
+// Package unifi lists the clients connected to a UniFi Network controller,
+// either a standalone controller or one running on UniFi OS
+package unifi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSite is the site of controllers managing a single network
+const DefaultSite = "default"
+
+// Config describes how to connect to a controller
+type Config struct {
+	// URL of the controller, e.g. https://192.168.1.1 or https://unifi:8443
+	URL string
+	// Site whose clients are listed, DefaultSite if empty
+	Site string
+	// Username of a local account of the controller
+	Username string
+	// Password of the account
+	Password string
+	// InsecureSkipVerify accepts the self-signed certificate controllers come with
+	InsecureSkipVerify bool
+	// Timeout of a single request
+	Timeout time.Duration
+}
+
+// Station is a client connected to the network
+type Station struct {
+	// MAC address, formatted by net.HardwareAddr
+	MAC string
+	// IP address, empty if the controller doesn't know it
+	IP string
+	// Name given to the client in the controller, empty if none
+	Name string
+	// Hostname the client sent, empty if it didn't
+	Hostname string
+	// LastSeen is when the controller last saw the client
+	LastSeen time.Time
+}
+
+// station is a client as returned by the controller
+type station struct {
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	LastSeen int64  `json:"last_seen"`
+}
+
+// Client talks to a controller, logging in again when the session expired
+type Client struct {
+	config Config
+	base   *url.URL
+	client *http.Client
+
+	mu sync.Mutex
+	// prefix is prepended to the paths of the network application, which
+	// runs below /proxy/network on UniFi OS
+	prefix   string
+	loggedIn bool
+}
+
+// New checks the configuration and returns a client
+func New(config Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(config.URL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", config.URL)
+	}
+	if config.Username == "" || config.Password == "" {
+		return nil, errors.New("username and password are required")
+	}
+	if config.Site == "" {
+		config.Site = DefaultSite
+	}
+
+	jar, _ := cookiejar.New(nil)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	return &Client{
+		config: config,
+		base:   base,
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: transport,
+			Jar:       jar,
+			// Redirects tell standalone controllers from UniFi OS
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}, nil
+}
+
+// Stations returns the clients currently connected to the site
+func (c *Client) Stations(ctx context.Context) ([]Station, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loggedIn {
+		err := c.login(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	stations, err := c.stations(ctx)
+	if errors.Is(err, errUnauthorized) {
+		// Sessions expire, the login is tried once more
+		c.loggedIn = false
+		err = c.login(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stations, err = c.stations(ctx)
+	}
+	return stations, err
+}
+
+// errUnauthorized is returned when the session expired
+var errUnauthorized = errors.New("unauthorized")
+
+// login starts a session, UniFi OS answers requests of / itself while
+// standalone controllers redirect them to their web interface
+func (c *Client) login(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	resp.Body.Close()
+	path := "/api/login"
+	c.prefix = ""
+	if resp.StatusCode == http.StatusOK {
+		path = "/api/auth/login"
+		c.prefix = "/proxy/network"
+	}
+
+	body, _ := json.Marshal(map[string]string{"username": c.config.Username, "password": c.config.Password})
+	resp, err = c.do(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("failed to log in: invalid username or password")
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("failed to log in: %s", resp.Status)
+	}
+	c.loggedIn = true
+	return nil
+}
+
+// stations lists the clients connected to the site
+func (c *Client) stations(ctx context.Context) ([]Station, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.prefix+"/api/s/"+url.PathEscape(c.config.Site)+"/stat/sta", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list clients: %s", resp.Status)
+	}
+
+	var result struct {
+		Meta struct {
+			RC  string `json:"rc"`
+			Msg string `json:"msg"`
+		} `json:"meta"`
+		Data []station `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode clients: %w", err)
+	}
+	if result.Meta.RC != "ok" {
+		return nil, fmt.Errorf("failed to list clients: %s", result.Meta.Msg)
+	}
+
+	stations := make([]Station, 0, len(result.Data))
+	for _, s := range result.Data {
+		mac, err := net.ParseMAC(s.MAC)
+		if err != nil {
+			continue
+		}
+		stations = append(stations, Station{
+			MAC:      mac.String(),
+			IP:       s.IP,
+			Name:     s.Name,
+			Hostname: s.Hostname,
+			LastSeen: time.Unix(s.LastSeen, 0),
+		})
+	}
+	return stations, nil
+}
+
+// do sends a request to the controller, body is sent as JSON if not nil
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base.String()+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	return c.client.Do(req)
+}
@@ -0,0 +1,190 @@
+// Package webhook sends events to HTTP endpoints, optionally signed and with
+// a payload rendered from a template
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Events sent to webhooks
+const (
+	// EventWake is sent when a magic packet was sent or failed to be sent
+	EventWake = "wake"
+	// EventWakeSucceeded is sent when a woken machine came online
+	EventWakeSucceeded = "wake.succeeded"
+	// EventWakeFailed is sent when a woken machine didn't come online in time
+	EventWakeFailed = "wake.failed"
+	// EventStatus is sent when the status of a machine changed
+	EventStatus = "status"
+)
+
+// Events lists all events
+var Events = []string{EventWake, EventWakeSucceeded, EventWakeFailed, EventStatus}
+
+// Headers set on every request
+const (
+	EventHeader     = "X-Wol-Event"
+	SignatureHeader = "X-Wol-Signature"
+)
+
+// Event is what happened to a machine
+type Event struct {
+	// Event is one of Events
+	Event string `json:"event"`
+	// Time the event happened
+	Time time.Time `json:"time"`
+	// Machine the event is about
+	Machine string `json:"machine"`
+	// Status of the machine for status events
+	Status string `json:"status,omitempty"`
+	// PreviousStatus of the machine for status events
+	PreviousStatus string `json:"previous_status,omitempty"`
+	// User who woke the machine for wake events
+	User string `json:"user,omitempty"`
+	// Error explaining why a wake failed
+	Error string `json:"error,omitempty"`
+}
+
+// Config describes a webhook
+type Config struct {
+	// URL the events are posted to
+	URL string
+	// Events sent, all if empty
+	Events []string
+	// Secret signs the body with HMAC-SHA256 if set
+	Secret string
+	// Payload is a text/template rendering the body, the event encoded as JSON if empty
+	Payload string
+	// ContentType of the body
+	ContentType string
+	// Headers added to the requests
+	Headers map[string]string
+	// Timeout of a single request
+	Timeout time.Duration
+}
+
+// Hook posts events to a URL
+type Hook struct {
+	config  Config
+	payload *template.Template
+	client  *http.Client
+}
+
+// templateFuncs are the functions available in payload templates
+var templateFuncs = template.FuncMap{
+	// json encodes a value, e.g. a string with quotes escaped
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// New checks the configuration and returns a hook
+func New(config Config) (*Hook, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", config.URL)
+	}
+	for _, event := range config.Events {
+		if !slices.Contains(Events, event) {
+			return nil, fmt.Errorf("unknown event %q, must be one of %s", event, strings.Join(Events, ", "))
+		}
+	}
+	if config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	hook := &Hook{config: config, client: &http.Client{Timeout: config.Timeout}}
+	if config.Payload != "" {
+		hook.payload, err = template.New("payload").Funcs(templateFuncs).Option("missingkey=error").Parse(config.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload template: %w", err)
+		}
+	}
+	return hook, nil
+}
+
+// Host returns the host the hook posts to, URLs of services such as Slack
+// contain secrets so this is what is logged
+func (h *Hook) Host() string {
+	u, _ := url.Parse(h.config.URL)
+	return u.Host
+}
+
+// Wants reports whether the event is sent to the hook
+func (h *Hook) Wants(event string) bool {
+	return len(h.config.Events) == 0 || slices.Contains(h.config.Events, event)
+}
+
+// body renders the payload of the event
+func (h *Hook) body(event Event) ([]byte, error) {
+	if h.payload == nil {
+		return json.Marshal(event)
+	}
+
+	var buf bytes.Buffer
+	err := h.payload.Execute(&buf, event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render payload: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign returns the signature of the body sent in SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts the event, any response other than 2xx is an error
+func (h *Hook) Send(ctx context.Context, event Event) error {
+	body, err := h.body(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range h.config.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", h.config.ContentType)
+	req.Header.Set("User-Agent", "wol")
+	req.Header.Set(EventHeader, event.Event)
+	if h.config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(h.config.Secret, body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}